@@ -5,12 +5,22 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/websocket"
+	"github.com/rs/xid"
 
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// replyPrefix is the prefix of the topics on which the clients receive the replies to their requests
+const replyPrefix = "/replies/"
+
+// ErrNoReplyTo is returned when replying to a message which is not a request
+var ErrNoReplyTo = errors.New("The message has no reply-to topic.")
+
 var logger = log.WithFields(log.Fields{
 	"module": "client",
 })
@@ -41,11 +51,15 @@ type Client interface {
 	Close()
 
 	Subscribe(path string) error
+	SubscribeSince(path string, since time.Time) error
 	Unsubscribe(path string) error
 
 	Send(path string, body string, header string) error
 	SendBytes(path string, body []byte, header string) error
 
+	Request(ctx context.Context, path string, body []byte) (*protocol.Message, error)
+	Reply(request *protocol.Message, body []byte) error
+
 	WriteRawMessage(message []byte) error
 	Messages() chan *protocol.Message
 	StatusMessages() chan *protocol.NotificationMessage
@@ -69,6 +83,14 @@ type client struct {
 	wSConnectionFactory func(url string, origin string) (WSConnection, error)
 	// flag, to indicate if the client is connected
 	connected bool
+
+	// the topic on which the replies are received, and the pending requests by correlation id.
+	// repliesSubscribed is closed when the server confirms the subscription of the reply topic,
+	// and is nil while the reply topic is not subscribed on the current connection.
+	replyTo           protocol.Path
+	requests          map[string]chan *protocol.Message
+	repliesSubscribed chan struct{}
+	requestsMu        sync.Mutex
 }
 
 // Open is a shortcut for New() and Start()
@@ -88,6 +110,8 @@ func New(url, origin string, channelSize int, autoReconnect bool) Client {
 		origin:         origin,
 		shouldStopChan: make(chan bool, 1),
 		autoReconnect:  autoReconnect,
+		replyTo:        protocol.Path(replyPrefix + xid.New().String()),
+		requests:       make(map[string]chan *protocol.Message),
 	}
 }
 
@@ -146,6 +170,8 @@ func (c *client) startWithReconnect() {
 
 			time.Sleep(time.Millisecond * 50)
 		} else {
+			// the routes of the previous connection are gone, so the reply topic is subscribed again
+			c.resetReplies()
 			c.setIsConnected(true)
 			logger.Warn("Reconnected again")
 		}
@@ -195,8 +221,11 @@ func (c *client) handleIncomingMessage(msg []byte) {
 
 	switch message := parsed.(type) {
 	case *protocol.Message:
-		c.messages <- message
+		if !c.handleReply(message) {
+			c.messages <- message
+		}
 	case *protocol.NotificationMessage:
+		c.confirmReplies(message)
 		if message.IsError {
 			select {
 			case c.errors <- message:
@@ -220,6 +249,16 @@ func (c *client) Subscribe(path string) error {
 	return err
 }
 
+// SubscribeSince subscribes to the path, after receiving the stored messages published since the time
+func (c *client) SubscribeSince(path string, since time.Time) error {
+	cmd := &protocol.Cmd{
+		Name: protocol.CmdReceive,
+		Arg:  fmt.Sprintf("%s since=%d", path, since.Unix()),
+	}
+	err := c.ws.WriteMessage(websocket.BinaryMessage, cmd.Bytes())
+	return err
+}
+
 func (c *client) Unsubscribe(path string) error {
 	cmd := &protocol.Cmd{
 		Name: protocol.CmdCancel,
@@ -244,6 +283,116 @@ func (c *client) SendBytes(path string, body []byte, header string) error {
 	return c.WriteRawMessage(cmd.Bytes())
 }
 
+// Request publishes a request on the path, and returns its reply.
+// The replies are received on a topic of the client, which is subscribed on the first request of each connection.
+// The request is published once the server confirmed the subscription, so that no reply is missed.
+func (c *client) Request(ctx context.Context, path string, body []byte) (*protocol.Message, error) {
+	subscribed, err := c.subscribeReplies()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-subscribed:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	correlationID := xid.New().String()
+	replyC := make(chan *protocol.Message, 1)
+	c.requestsMu.Lock()
+	c.requests[correlationID] = replyC
+	c.requestsMu.Unlock()
+
+	defer func() {
+		c.requestsMu.Lock()
+		delete(c.requests, correlationID)
+		c.requestsMu.Unlock()
+	}()
+
+	if err := c.SendBytes(path, body, protocol.RequestHeaderJSON(c.replyTo, correlationID)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyC:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Reply publishes the reply to a request, on its reply-to topic
+func (c *client) Reply(request *protocol.Message, body []byte) error {
+	replyTo := request.ReplyTo()
+	if replyTo == "" {
+		return ErrNoReplyTo
+	}
+	return c.SendBytes(string(replyTo), body, protocol.ReplyHeaderJSON(request))
+}
+
+// subscribeReplies subscribes the reply topic, if it is not subscribed on the current connection,
+// and returns the channel closed when the subscription is confirmed
+func (c *client) subscribeReplies() (<-chan struct{}, error) {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	if c.repliesSubscribed != nil {
+		return c.repliesSubscribed, nil
+	}
+	if err := c.Subscribe(string(c.replyTo)); err != nil {
+		return nil, err
+	}
+	c.repliesSubscribed = make(chan struct{})
+	return c.repliesSubscribed, nil
+}
+
+// confirmReplies marks the reply topic as subscribed, if the notification confirms its subscription
+func (c *client) confirmReplies(notification *protocol.NotificationMessage) {
+	if notification.IsError || notification.Name != protocol.SUCCESS_SUBSCRIBED_TO || notification.Arg != string(c.replyTo) {
+		return
+	}
+
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	if c.repliesSubscribed == nil {
+		return
+	}
+	select {
+	case <-c.repliesSubscribed:
+	default:
+		close(c.repliesSubscribed)
+	}
+}
+
+// resetReplies marks the reply topic as not subscribed, e.g. after reconnecting
+func (c *client) resetReplies() {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	c.repliesSubscribed = nil
+}
+
+// handleReply passes a message received on the reply topic to its pending request.
+// It returns false if the message is not a reply.
+func (c *client) handleReply(message *protocol.Message) bool {
+	if message.Path != c.replyTo {
+		return false
+	}
+
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	replyC, ok := c.requests[message.CorrelationID()]
+	if !ok {
+		logger.WithField("correlationID", message.CorrelationID()).Debug("Dropping reply without pending request")
+		return true
+	}
+	delete(c.requests, message.CorrelationID())
+	replyC <- message
+	return true
+}
+
 func (c *client) WriteRawMessage(message []byte) error {
 	return c.ws.WriteMessage(websocket.BinaryMessage, message)
 }
@@ -1,13 +1,16 @@
 package client
 
 import (
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/testutil"
 
+	"context"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
@@ -269,6 +272,32 @@ func TestSendSubscribeMessage(t *testing.T) {
 	time.AfterFunc(time.Millisecond*200, func() { c.Close() })
 }
 
+func TestSendSubscribeSinceMessage(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	// given a client
+	c := New("url", "origin", 1, true)
+
+	// when expects a message
+	connMock := NewMockWSConnection(ctrl)
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo since=1420110000"))
+	connMock.EXPECT().
+		ReadMessage().
+		Return(websocket.BinaryMessage, []byte(aNormalMessage), nil).
+		Do(func() {
+			time.Sleep(time.Millisecond * 50)
+		}).
+		AnyTimes()
+	c.SetWSConnectionFactory(MockConnectionFactory(connMock))
+
+	c.Start()
+	c.SubscribeSince("/foo", time.Unix(1420110000, 0))
+
+	// stop client after 200ms
+	time.AfterFunc(time.Millisecond*200, func() { c.Close() })
+}
+
 func TestSendUnSubscribeMessage(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -294,3 +323,88 @@ func TestSendUnSubscribeMessage(t *testing.T) {
 	// stop client after 200ms
 	time.AfterFunc(time.Millisecond*200, func() { c.Close() })
 }
+
+func TestRequest(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// given a connected client
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	// which subscribes its reply topic once, and waits for the confirmation
+	confirmed := false
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ "+string(c.replyTo))).Do(func(_ int, _ []byte) error {
+		go func() {
+			confirmed = true
+			c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " " + string(c.replyTo)))
+		}()
+		return nil
+	})
+
+	// and publishes the request, which is answered
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, gomock.Any()).Do(func(_ int, data []byte) error {
+		a.True(confirmed)
+		cmd, err := protocol.ParseCmd(data)
+		a.NoError(err)
+		a.Equal("/orders", cmd.Arg)
+		a.Equal("question", string(cmd.Body))
+
+		request := &protocol.Message{Path: protocol.Path(cmd.Arg), HeaderJSON: cmd.HeaderJSON}
+		a.Equal(c.replyTo, request.ReplyTo())
+
+		other := &protocol.Message{ID: 1, Path: c.replyTo, HeaderJSON: `{"Correlation-Id":"other"}`, Body: []byte("other answer")}
+		reply := &protocol.Message{ID: 2, Path: c.replyTo, HeaderJSON: protocol.ReplyHeaderJSON(request), Body: []byte("answer")}
+		go func() {
+			c.handleIncomingMessage(other.Bytes())
+			c.handleIncomingMessage(reply.Bytes())
+		}()
+		return nil
+	})
+
+	// when requesting
+	reply, err := c.Request(context.Background(), "/orders", []byte("question"))
+
+	// then the reply is returned, and the replies are not passed as messages
+	a.NoError(err)
+	a.Equal("answer", string(reply.Body))
+	a.Empty(c.requests)
+	a.Len(c.Messages(), 0)
+
+	// and a request without a reply is cancelled with its context
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, gomock.Any())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.Request(ctx, "/orders", []byte("question"))
+	a.Equal(context.DeadlineExceeded, err)
+	a.Empty(c.requests)
+
+	// and after reconnecting, the reply topic is subscribed again,
+	// and a request is not published before the subscription is confirmed
+	c.resetReplies()
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ "+string(c.replyTo)))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.Request(ctx, "/orders", []byte("question"))
+	a.Equal(context.DeadlineExceeded, err)
+}
+
+func TestReply(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	// a message which is not a request cannot be answered
+	a.Equal(ErrNoReplyTo, c.Reply(&protocol.Message{Path: "/orders"}, []byte("answer")))
+
+	// and the reply to a request is published on its reply-to topic
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("> /replies/client01\n{\"Correlation-Id\":\"c1\"}\nanswer"))
+	request := &protocol.Message{Path: "/orders", HeaderJSON: protocol.RequestHeaderJSON("/replies/client01", "c1")}
+	a.NoError(c.Reply(request, []byte("answer")))
+}
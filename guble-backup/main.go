@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/backup"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/server/store/sqlstore"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	exportCmd        = kingpin.Command("export", "Export the messages of the partitions, or of all the partitions, of a message store")
+	exportPath       = exportCmd.Arg("storage-path", "The storage path of the guble server").Required().ExistingDir()
+	exportPartitions = exportCmd.Arg("partition", "The partitions to export").Strings()
+	exportOutput     = exportCmd.Flag("output", "The file to write, instead of the standard output").Short('o').String()
+
+	importCmd   = kingpin.Command("import", "Import exported messages into a message store")
+	importPath  = importCmd.Arg("storage-path", "The storage path of the guble server").Required().ExistingDir()
+	importInput = importCmd.Arg("file", "The file to read, instead of the standard input").ExistingFile()
+	importNode  = importCmd.Flag("node-id", "The id of the cluster node of the message store, for the new message ids").Uint8()
+
+	backend = kingpin.Flag("ms", "The message storage backend : file | sqlite").
+		Default("file").
+		Enum("file", "sqlite")
+
+	keyFile = kingpin.Flag("ms-key-file", "The key file of an encrypted file message store").
+		Envar("GUBLE_MS_KEY_FILE").
+		ExistingFile()
+
+	logLevel = kingpin.Flag("log", "Log level").
+			Short('l').
+			Default(log.ErrorLevel.String()).
+			Envar("GUBLE_LOG").
+			Enum(logLevels()...)
+)
+
+func logLevels() (levels []string) {
+	for _, level := range log.AllLevels {
+		levels = append(levels, level.String())
+	}
+	return
+}
+
+// This is a command line tool to export and import the messages of a message store, while the guble server is stopped
+func main() {
+	command := kingpin.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid log level")
+	}
+	log.SetLevel(level)
+
+	switch command {
+	case exportCmd.FullCommand():
+		out := os.Stdout
+		if *exportOutput != "" {
+			if out, err = os.Create(*exportOutput); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		code := runExport(out, os.Stderr, *backend, *exportPath, *exportPartitions)
+		out.Close()
+		os.Exit(code)
+	case importCmd.FullCommand():
+		in := os.Stdin
+		if *importInput != "" {
+			if in, err = os.Open(*importInput); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		code := runImport(in, os.Stderr, *backend, *importPath, *importNode)
+		in.Close()
+		os.Exit(code)
+	}
+}
+
+// messageStore is a message store which can be closed
+type messageStore interface {
+	store.MessageStore
+	Stop() error
+}
+
+// openMessageStore opens the message store of the backend in the storage path, as the guble server does
+func openMessageStore(backend string, storagePath string) (messageStore, error) {
+	if backend == "sqlite" {
+		db := sqlstore.NewSqliteMessageStore(path.Join(storagePath, "message-store.db"), true)
+		return db, db.Open()
+	}
+	fms := filestore.New(storagePath)
+	if *keyFile != "" {
+		keys, err := filestore.LoadKeys(*keyFile)
+		if err != nil {
+			return nil, err
+		}
+		fms.SetEncryption(keys)
+	}
+	return fms, nil
+}
+
+// runExport writes the messages of the partitions to out, and returns the exit code: 0 on success, 1 on errors
+func runExport(out io.Writer, report io.Writer, backend string, storagePath string, partitions []string) int {
+	ms, err := openMessageStore(backend, storagePath)
+	if err != nil {
+		fmt.Fprintf(report, "ERROR: %v\n", err)
+		return 1
+	}
+	defer ms.Stop()
+
+	count, err := backup.Export(out, ms, partitions)
+	if err != nil {
+		fmt.Fprintf(report, "ERROR: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(report, "%d messages exported\n", count)
+	return 0
+}
+
+// runImport stores the messages read from in, and returns the exit code: 0 on success, 1 on errors
+func runImport(in io.Reader, report io.Writer, backend string, storagePath string, nodeID uint8) int {
+	ms, err := openMessageStore(backend, storagePath)
+	if err != nil {
+		fmt.Fprintf(report, "ERROR: %v\n", err)
+		return 1
+	}
+	defer ms.Stop()
+
+	result, err := backup.Import(in, ms, nodeID)
+	fmt.Fprintf(report, "%d messages imported, %d with a new id\n", result.Messages, result.Renumbered)
+	if err != nil {
+		fmt.Fprintf(report, "ERROR: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store/filestore"
+)
+
+func Test_Run(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_backup_test")
+	defer os.RemoveAll(dir)
+	source, target := path.Join(dir, "source"), path.Join(dir, "target")
+	a.NoError(os.Mkdir(source, 0700))
+	a.NoError(os.Mkdir(target, 0700))
+
+	ms := filestore.New(source)
+	for _, p := range []protocol.Path{"/foo", "/foo", "/bar"} {
+		_, err := ms.StoreMessage(&protocol.Message{Path: p, Body: []byte("body")}, 0)
+		a.NoError(err)
+	}
+	a.NoError(ms.Stop())
+
+	export, report := &bytes.Buffer{}, &bytes.Buffer{}
+	a.Equal(0, runExport(export, report, "file", source, []string{"foo"}))
+	a.Equal("2 messages exported\n", report.String())
+
+	report.Reset()
+	a.Equal(0, runImport(export, report, "sqlite", target, 0))
+	a.Equal("2 messages imported, 0 with a new id\n", report.String())
+
+	report.Reset()
+	a.Equal(1, runImport(bytes.NewBufferString("{"), report, "sqlite", target, 0))
+	a.Equal("0 messages imported, 0 with a new id\nERROR: Invalid record 1: unexpected EOF\n", report.String())
+}
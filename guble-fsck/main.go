@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/server/store/filestore"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	verifyCmd  = kingpin.Command("verify", "Report the damaged segments of the file message store")
+	verifyPath = verifyCmd.Arg("storage-path", "The storage path of the guble server").Required().ExistingDir()
+
+	repairCmd  = kingpin.Command("repair", "Truncate the corrupted message files and rebuild the index files of the file message store")
+	repairPath = repairCmd.Arg("storage-path", "The storage path of the guble server").Required().ExistingDir()
+
+	reencryptCmd     = kingpin.Command("reencrypt", "Rewrite the message files which are not encrypted with the current key of the key file")
+	reencryptPath    = reencryptCmd.Arg("storage-path", "The storage path of the guble server").Required().ExistingDir()
+	reencryptKeyFile = reencryptCmd.Flag("key-file", "The key file of the file message store").Required().ExistingFile()
+
+	logLevel = kingpin.Flag("log", "Log level").
+			Short('l').
+			Default(log.ErrorLevel.String()).
+			Envar("GUBLE_LOG").
+			Enum(logLevels()...)
+)
+
+func logLevels() (levels []string) {
+	for _, level := range log.AllLevels {
+		levels = append(levels, level.String())
+	}
+	return
+}
+
+// This is a command line tool to check the integrity of the file message store, while the guble server is stopped
+func main() {
+	command := kingpin.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid log level")
+	}
+	log.SetLevel(level)
+
+	switch command {
+	case verifyCmd.FullCommand():
+		os.Exit(run(os.Stdout, *verifyPath, false))
+	case repairCmd.FullCommand():
+		os.Exit(run(os.Stdout, *repairPath, true))
+	case reencryptCmd.FullCommand():
+		os.Exit(runReencrypt(os.Stdout, *reencryptPath, *reencryptKeyFile))
+	}
+}
+
+// run verifies or repairs the storage path, and returns the exit code:
+// 0 if there is no damage left, 1 if there is, and 2 on errors
+func run(out io.Writer, storagePath string, repair bool) int {
+	damages, err := filestore.Verify(storagePath, repair)
+	for _, damage := range damages {
+		fmt.Fprintln(out, damage)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "ERROR: %v\n", err)
+		return 2
+	}
+
+	left := 0
+	for _, damage := range damages {
+		if !damage.Repaired {
+			left++
+		}
+	}
+	fmt.Fprintf(out, "%d damaged segments, %d repaired\n", len(damages), len(damages)-left)
+	if left > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runReencrypt encrypts the storage path with the current key, and returns the exit code: 0 on success, 2 on errors
+func runReencrypt(out io.Writer, storagePath string, keyFile string) int {
+	keys, err := filestore.LoadKeys(keyFile)
+	if err != nil {
+		fmt.Fprintf(out, "ERROR: %v\n", err)
+		return 2
+	}
+	rewritten, err := filestore.Reencrypt(storagePath, keys)
+	fmt.Fprintf(out, "%d segments re-encrypted\n", rewritten)
+	if err != nil {
+		fmt.Fprintf(out, "ERROR: %v\n", err)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Run(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_fsck_test")
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	a.Equal(0, run(out, dir, false))
+	a.Equal("0 damaged segments, 0 repaired\n", out.String())
+
+	// a segment without message file
+	a.NoError(os.Mkdir(path.Join(dir, "foo"), 0700))
+	a.NoError(ioutil.WriteFile(path.Join(dir, "foo", "foo-00000000000000000000.idx"), nil, 0666))
+
+	out.Reset()
+	a.Equal(1, run(out, dir, false))
+	a.Contains(out.String(), "the message file is missing (not repaired)")
+
+	out.Reset()
+	a.Equal(0, run(out, dir, true))
+	a.Contains(out.String(), "1 damaged segments, 1 repaired")
+
+	out.Reset()
+	a.Equal(2, run(out, path.Join(dir, "bar"), false))
+}
+
+func Test_RunReencrypt(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_fsck_test")
+	defer os.RemoveAll(dir)
+
+	fms := filestore.New(dir)
+	a.NoError(fms.Store("foo", 1, []byte("a message")))
+	a.NoError(fms.Stop())
+
+	keyFile := path.Join(dir, "keys")
+	a.NoError(ioutil.WriteFile(keyFile, []byte("1 MDEyMzQ1Njc4OWFiY2RlZg==\n"), 0600))
+
+	out := &bytes.Buffer{}
+	a.Equal(0, runReencrypt(out, dir, keyFile))
+	a.Equal("1 segments re-encrypted\n", out.String())
+
+	out.Reset()
+	a.Equal(0, runReencrypt(out, dir, keyFile))
+	a.Equal("0 segments re-encrypted\n", out.String())
+
+	out.Reset()
+	a.Equal(2, runReencrypt(out, dir, path.Join(dir, "missing")))
+	a.Contains(out.String(), "ERROR:")
+}
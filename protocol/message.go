@@ -6,10 +6,20 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+const (
+	// maxKeyLength is the maximum number of characters of an idempotency key or a compaction key
+	maxKeyLength = 128
+
+	// retainFlag is the last metadata field of a retained message
+	retainFlag = "retain"
+)
+
 // Message is a struct that represents a message in the guble protocol, as the server sends it to the client.
 type Message struct {
 
@@ -41,6 +51,24 @@ type Message struct {
 
 	// Used in cluster mode to identify a guble node
 	NodeID uint8
+
+	// The expiry time of the message, as Unix Timestamp date (optional).
+	// A message with an expiry time of 0 never expires.
+	Expires int64
+
+	// The key given by the publisher to deduplicate retried publishes (optional).
+	IdempotencyKey string
+
+	// Retain marks the message as the last value of its topic, which is delivered to the new subscribers
+	// of the topic. A retained message with an empty body clears the last value.
+	Retain bool
+
+	// The key by which the message store compacts the partition, keeping only the latest message of each key (optional).
+	CompactionKey string
+
+	// The time at which a scheduled message is delivered, as Unix Timestamp date (optional).
+	// It is not serialized, since the message is published only at this time.
+	DeliverAt int64
 }
 
 type MessageDeliveryCallback func(*Message)
@@ -60,6 +88,94 @@ func (msg *Message) BodyAsString() string {
 	return string(msg.Body)
 }
 
+// SetTTL sets the expiry time of the message to the current time plus the given time-to-live.
+// The time-to-live of a scheduled message starts at its delivery time, which has to be set before.
+// A ttl of 0 removes the expiry time.
+func (msg *Message) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		msg.Expires = 0
+		return
+	}
+	start := time.Now()
+	if msg.IsScheduled() {
+		start = time.Unix(msg.DeliverAt, 0)
+	}
+	msg.Expires = start.Add(ttl).Unix()
+}
+
+// ValidateIdempotencyKey returns an error if the idempotency key cannot be used,
+// because it is too long or it contains commas or whitespace
+func ValidateIdempotencyKey(key string) error {
+	return validateKey("idempotency key", key)
+}
+
+// ValidateCompactionKey returns an error if the compaction key cannot be used,
+// because it is too long or it contains commas or whitespace
+func ValidateCompactionKey(key string) error {
+	return validateKey("compaction key", key)
+}
+
+func validateKey(kind string, key string) error {
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("%s can have at most %d characters", kind, maxKeyLength)
+	}
+	if strings.IndexFunc(key, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) >= 0 {
+		return fmt.Errorf("%s cannot contain commas or whitespace, but was %q", kind, key)
+	}
+	return nil
+}
+
+// ParseTTL parses a time-to-live, given either as a duration (e.g. "90s", "5m") or as a number of seconds
+func ParseTTL(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("ttl has to be positive, but was %v", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("ttl has to be a duration or a number of seconds, but was %v", value)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("ttl has to be positive, but was %v", value)
+	}
+	return ttl, nil
+}
+
+// ParseDeliverAt parses a delivery time, given either as an RFC 3339 date (e.g. "2017-01-02T15:04:05Z")
+// or as a Unix Timestamp
+func ParseDeliverAt(value string) (int64, error) {
+	return parseTime("deliver-at", value)
+}
+
+// ParseSince parses the time from which messages are fetched, given either as an RFC 3339 date
+// or as a Unix Timestamp
+func ParseSince(value string) (int64, error) {
+	return parseTime("since", value)
+}
+
+func parseTime(kind string, value string) (int64, error) {
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil && timestamp > 0 {
+		return timestamp, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("%s has to be an RFC 3339 date or a unix timestamp, but was %v", kind, value)
+	}
+	return t.Unix(), nil
+}
+
+// IsExpired returns true if the message has an expiry time which already passed
+func (msg *Message) IsExpired() bool {
+	return msg.Expires > 0 && time.Now().Unix() > msg.Expires
+}
+
+// IsScheduled returns true if the message has a delivery time in the future
+func (msg *Message) IsScheduled() bool {
+	return msg.DeliverAt > time.Now().Unix()
+}
+
 // Bytes serializes the message into a byte slice
 func (msg *Message) Bytes() []byte {
 	buff := &bytes.Buffer{}
@@ -96,6 +212,24 @@ func (msg *Message) writeMetadata(buff *bytes.Buffer) {
 	buff.WriteString(strconv.FormatInt(msg.Time, 10))
 	buff.WriteString(",")
 	buff.WriteString(strconv.FormatUint(uint64(msg.NodeID), 10))
+	if msg.Expires != 0 || msg.IdempotencyKey != "" || msg.Retain || msg.CompactionKey != "" {
+		buff.WriteString(",")
+		buff.WriteString(strconv.FormatInt(msg.Expires, 10))
+	}
+	if msg.IdempotencyKey != "" || msg.Retain || msg.CompactionKey != "" {
+		buff.WriteString(",")
+		buff.WriteString(msg.IdempotencyKey)
+	}
+	if msg.Retain || msg.CompactionKey != "" {
+		buff.WriteString(",")
+		if msg.Retain {
+			buff.WriteString(retainFlag)
+		}
+	}
+	if msg.CompactionKey != "" {
+		buff.WriteString(",")
+		buff.WriteString(msg.CompactionKey)
+	}
 }
 
 func (msg *Message) encodeFilters() []byte {
@@ -137,6 +271,8 @@ const (
 	SUCCESS_SUBSCRIBED_TO = "subscribed-to"
 	SUCCESS_CANCELED      = "canceled"
 	ERROR_SUBSCRIBED_TO   = "error-subscribed-to"
+	ERROR_SEND            = "error-send"
+	ERROR_RATE_LIMITED    = "error-rate-limited"
 	ERROR_BAD_REQUEST     = "error-bad-request"
 	ERROR_INTERNAL_SERVER = "error-server-internal"
 )
@@ -195,10 +331,10 @@ func ParseMessage(message []byte) (*Message, error) {
 		return nil, fmt.Errorf("empty message")
 	}
 
-	meta := strings.Split(parts[0], ",")
+	meta := splitMetadata(parts[0])
 
-	if len(meta) != 7 {
-		return nil, fmt.Errorf("message metadata has to have 7 fields, but was %v", parts[0])
+	if len(meta) < 7 || len(meta) > 11 {
+		return nil, fmt.Errorf("message metadata has to have 7 to 11 fields, but was %v", parts[0])
 	}
 
 	if len(meta[0]) == 0 || meta[0][0] != '/' {
@@ -220,6 +356,14 @@ func ParseMessage(message []byte) (*Message, error) {
 		return nil, fmt.Errorf("message metadata to have an integer (nodeID) as seventh field, but was %v", meta[6])
 	}
 
+	var expires int64
+	if len(meta) >= 8 {
+		expires, err = strconv.ParseInt(meta[7], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("message metadata to have an integer (expiry time) as eighth field, but was %v", meta[7])
+		}
+	}
+
 	msg := &Message{
 		ID:            id,
 		Path:          Path(meta[0]),
@@ -227,6 +371,19 @@ func ParseMessage(message []byte) (*Message, error) {
 		ApplicationID: meta[3],
 		Time:          publishingTime,
 		NodeID:        uint8(nodeID),
+		Expires:       expires,
+	}
+	if len(meta) >= 9 {
+		msg.IdempotencyKey = meta[8]
+	}
+	if len(meta) >= 10 {
+		if meta[9] != retainFlag && meta[9] != "" {
+			return nil, fmt.Errorf("message metadata to have the retain flag as tenth field, but was %v", meta[9])
+		}
+		msg.Retain = meta[9] == retainFlag
+	}
+	if len(meta) == 11 {
+		msg.CompactionKey = meta[10]
 	}
 	msg.decodeFilters([]byte(meta[4]))
 
@@ -241,6 +398,27 @@ func ParseMessage(message []byte) (*Message, error) {
 	return msg, nil
 }
 
+// splitMetadata splits the metadata line into its fields.
+// The filters field is a JSON object, which can contain commas and braces itself,
+// so it ends where the JSON object ends, and not at a brace of the following fields, e.g. of a key.
+func splitMetadata(line string) []string {
+	meta := strings.SplitN(line, ",", 5)
+	if len(meta) < 5 || !strings.HasPrefix(meta[4], "{") {
+		return strings.Split(line, ",")
+	}
+	decoder := json.NewDecoder(strings.NewReader(meta[4]))
+	var object json.RawMessage
+	if err := decoder.Decode(&object); err != nil {
+		return strings.Split(line, ",")
+	}
+	end := decoder.InputOffset()
+	filters, rest := meta[4][:end], meta[4][end:]
+	if !strings.HasPrefix(rest, ",") {
+		return strings.Split(line, ",")
+	}
+	return append(append(meta[:4], filters), strings.Split(rest[1:], ",")...)
+}
+
 func parseNotificationMessage(message []byte) (*NotificationMessage, error) {
 	msg := &NotificationMessage{}
 
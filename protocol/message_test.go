@@ -102,6 +102,208 @@ func TestParsingAMinimalMessage(t *testing.T) {
 	assert.Equal("", string(msg.Body))
 }
 
+func TestSerializeAndParseAMessageWithExpiry(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:      uint64(42),
+		Path:    Path("/"),
+		Time:    unixTime.Unix(),
+		Expires: unixTime.Unix() + 60,
+	}
+	a.Equal(aMinimalMessage+",1420110060", string(msg.Bytes()))
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(unixTime.Unix()+60, parsed.Expires)
+
+	// a message without expiry time has no eighth field
+	parsed, err = ParseMessage([]byte(aMinimalMessage))
+	a.NoError(err)
+	a.Equal(int64(0), parsed.Expires)
+
+	_, err = ParseMessage([]byte(aMinimalMessage + ",soon"))
+	a.Error(err)
+}
+
+func TestSerializeAndParseAMessageWithIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:             uint64(42),
+		Path:           Path("/"),
+		Time:           unixTime.Unix(),
+		IdempotencyKey: "order-42",
+	}
+	a.Equal(aMinimalMessage+",0,order-42", string(msg.Bytes()))
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal("order-42", parsed.IdempotencyKey)
+	a.Equal(int64(0), parsed.Expires)
+
+	msg.Expires = unixTime.Unix() + 60
+	parsed, err = ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+
+	_, err = ParseMessage([]byte(aMinimalMessage + ",0,key,other"))
+	a.Error(err)
+}
+
+func TestValidateIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	a.NoError(ValidateIdempotencyKey("order-42:retry_1"))
+	a.Error(ValidateIdempotencyKey("order,42"))
+	a.Error(ValidateIdempotencyKey("order 42"))
+	a.Error(ValidateIdempotencyKey(strings.Repeat("k", 129)))
+}
+
+func TestMessage_IsExpired(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{}
+	a.False(msg.IsExpired())
+
+	msg.SetTTL(time.Minute)
+	a.False(msg.IsExpired())
+	a.InDelta(time.Now().Add(time.Minute).Unix(), msg.Expires, 1)
+
+	msg.Expires = time.Now().Add(-2 * time.Second).Unix()
+	a.True(msg.IsExpired())
+
+	msg.SetTTL(0)
+	a.Equal(int64(0), msg.Expires)
+	a.False(msg.IsExpired())
+
+	// the time-to-live of a scheduled message starts at its delivery time
+	msg.DeliverAt = time.Now().Add(time.Hour).Unix()
+	msg.SetTTL(time.Minute)
+	a.Equal(msg.DeliverAt+60, msg.Expires)
+}
+
+func TestParseTTL(t *testing.T) {
+	a := assert.New(t)
+
+	for value, expected := range map[string]time.Duration{
+		"30":   30 * time.Second,
+		"0":    0,
+		"90s":  90 * time.Second,
+		"5m":   5 * time.Minute,
+		"1h5m": time.Hour + 5*time.Minute,
+	} {
+		ttl, err := ParseTTL(value)
+		a.NoError(err, value)
+		a.Equal(expected, ttl, value)
+	}
+
+	for _, value := range []string{"", "-1", "-5s", "soon"} {
+		_, err := ParseTTL(value)
+		a.Error(err, value)
+	}
+}
+
+func TestParseDeliverAt(t *testing.T) {
+	a := assert.New(t)
+
+	for value, expected := range map[string]int64{
+		"1420110000":                1420110000,
+		"2015-01-01T12:00:00+01:00": 1420110000,
+		"2015-01-01T11:00:00Z":      1420110000,
+	} {
+		deliverAt, err := ParseDeliverAt(value)
+		a.NoError(err, value)
+		a.Equal(expected, deliverAt, value)
+	}
+
+	for _, value := range []string{"", "0", "-1", "tomorrow", "2015-01-01"} {
+		_, err := ParseDeliverAt(value)
+		a.Error(err, value)
+	}
+}
+
+func TestMessage_IsScheduled(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{}
+	a.False(msg.IsScheduled())
+
+	msg.DeliverAt = time.Now().Add(time.Minute).Unix()
+	a.True(msg.IsScheduled())
+
+	msg.DeliverAt = time.Now().Add(-time.Minute).Unix()
+	a.False(msg.IsScheduled())
+}
+
+func TestSerializeAndParseARetainedMessage(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:     uint64(42),
+		Path:   Path("/"),
+		Time:   unixTime.Unix(),
+		Retain: true,
+	}
+	a.Equal(aMinimalMessage+",0,,retain", string(msg.Bytes()))
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+
+	msg.Expires = unixTime.Unix() + 60
+	msg.IdempotencyKey = "state-1"
+	msg.Body = []byte("on")
+	parsed, err = ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+
+	_, err = ParseMessage([]byte(aMinimalMessage + ",0,,keep"))
+	a.Error(err)
+}
+
+func TestSerializeAndParseAMessageWithCompactionKey(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:            uint64(42),
+		Path:          Path("/"),
+		Time:          unixTime.Unix(),
+		CompactionKey: "device-1",
+	}
+	a.Equal(aMinimalMessage+",0,,,device-1", string(msg.Bytes()))
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+
+	msg.Retain = true
+	msg.Body = []byte("on")
+	parsed, err = ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+
+	a.NoError(ValidateCompactionKey("device-1"))
+	a.Error(ValidateCompactionKey("device 1"))
+}
+
+func TestSerializeAndParseAMessageWithFiltersAndBracesInKeys(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:             uint64(42),
+		Path:           Path("/"),
+		Time:           unixTime.Unix(),
+		Filters:        map[string]string{"user": "user}01", "device_id": "{ID_DEVICE}"},
+		IdempotencyKey: "order-{42}",
+		CompactionKey:  "device-}1",
+		Body:           []byte("on"),
+	}
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(*msg, *parsed)
+}
+
 func TestErrorsOnParsingMessages(t *testing.T) {
 	assert := assert.New(t)
 
@@ -209,6 +411,25 @@ func TestMessage_Filters(t *testing.T) {
 	a.JSONEq(`{"user": "user01","device_id":"ID_DEVICE"}`, string(msg.encodeFilters()))
 }
 
+func TestSerializeAndParseAMessageWithFilters(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:      uint64(42),
+		Path:    Path("/prices"),
+		Time:    unixTime.Unix(),
+		Expires: unixTime.Unix() + 60,
+	}
+	msg.SetFilter("symbol", "A")
+	msg.SetFilter("change", "2.5")
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(map[string]string{"symbol": "A", "change": "2.5"}, parsed.Filters)
+	a.Equal(unixTime.Unix(), parsed.Time)
+	a.Equal(unixTime.Unix()+60, parsed.Expires)
+}
+
 func TestMessage_decodeFilters(t *testing.T) {
 	a := assert.New(t)
 
@@ -224,3 +445,14 @@ func TestMessage_decodeFilters(t *testing.T) {
 	a.Equal(msg.Filters["user"], "user01")
 	a.Equal(msg.Filters["device_id"], "ID_DEVICE")
 }
+
+func TestParseSince(t *testing.T) {
+	a := assert.New(t)
+
+	since, err := ParseSince("2015-01-01T11:00:00Z")
+	a.NoError(err)
+	a.Equal(int64(1420110000), since)
+
+	_, err = ParseSince("yesterday")
+	a.EqualError(err, "since has to be an RFC 3339 date or a unix timestamp, but was yesterday")
+}
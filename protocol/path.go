@@ -2,6 +2,14 @@ package protocol
 
 import "strings"
 
+const (
+	// SingleLevelWildcard matches exactly one segment of a topic path.
+	SingleLevelWildcard = "*"
+
+	// MultiLevelWildcard matches any number of segments of a topic path, including none.
+	MultiLevelWildcard = "#"
+)
+
 // Path is the path of a topic
 type Path string
 
@@ -16,3 +24,62 @@ func (path Path) Partition() string {
 func (path Path) RemovePrefixSlash() string {
 	return strings.TrimPrefix(string(path), "/")
 }
+
+// Segments returns the segments of the path, without the leading slash.
+func (path Path) Segments() []string {
+	trimmed := strings.Trim(string(path), "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// HasWildcard returns true if at least one segment of the path is a wildcard.
+func (path Path) HasWildcard() bool {
+	for _, segment := range path.Segments() {
+		if isWildcard(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the topic is matched by the path, which may contain wildcards.
+// As for plain paths, all the sub-topics of a matched topic are matched too.
+// Example: `/orders/*/shipped` matches `/orders/42/shipped` and `/orders/42/shipped/eu`,
+// while `/orders/#/shipped` matches `/orders/shipped` and `/orders/eu/42/shipped`.
+func (path Path) Matches(topic Path) bool {
+	return matchSegments(path.Segments(), topic.Segments())
+}
+
+// MatchesPartition returns true if the partition can contain topics matched by the path.
+func (path Path) MatchesPartition(partition string) bool {
+	segments := path.Segments()
+	if len(segments) == 0 {
+		return true
+	}
+	return isWildcard(segments[0]) || segments[0] == partition
+}
+
+func isWildcard(segment string) bool {
+	return segment == SingleLevelWildcard || segment == MultiLevelWildcard
+}
+
+func matchSegments(pattern, topic []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	switch pattern[0] {
+	case MultiLevelWildcard:
+		for i := 0; i <= len(topic); i++ {
+			if matchSegments(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+		return false
+	case SingleLevelWildcard:
+		return len(topic) > 0 && matchSegments(pattern[1:], topic[1:])
+	default:
+		return len(topic) > 0 && pattern[0] == topic[0] && matchSegments(pattern[1:], topic[1:])
+	}
+}
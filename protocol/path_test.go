@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath_Partition(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("foo", Path("/foo/bar").Partition())
+	a.Equal("foo", Path("foo").Partition())
+	a.Equal("", Path("/").Partition())
+}
+
+func TestPath_HasWildcard(t *testing.T) {
+	a := assert.New(t)
+
+	a.False(Path("/foo/bar").HasWildcard())
+	a.False(Path("/foo*/bar#").HasWildcard())
+	a.True(Path("/foo/*/bar").HasWildcard())
+	a.True(Path("/foo/#").HasWildcard())
+	a.True(Path("/*").HasWildcard())
+}
+
+func TestPath_Matches(t *testing.T) {
+	for _, test := range []struct {
+		path    Path
+		topic   Path
+		matches bool
+	}{
+		{"/foo", "/foo", true},
+		{"/foo", "/foo/bar", true},
+		{"/foo", "/foobar", false},
+		{"/orders/*/shipped", "/orders/42/shipped", true},
+		{"/orders/*/shipped", "/orders/42/shipped/eu", true},
+		{"/orders/*/shipped", "/orders/42/created", false},
+		{"/orders/*/shipped", "/orders/shipped", false},
+		{"/orders/*/shipped", "/orders/eu/42/shipped", false},
+		{"/orders/#/shipped", "/orders/shipped", true},
+		{"/orders/#/shipped", "/orders/eu/42/shipped", true},
+		{"/orders/#/shipped", "/orders/eu/42/created", false},
+		{"/orders/#", "/orders", true},
+		{"/orders/#", "/orders/42", true},
+		{"/*/shipped", "/orders/shipped", true},
+		{"/*/shipped", "/invoices/shipped", true},
+		{"/*/shipped", "/orders/created", false},
+		{"/#", "/anything/at/all", true},
+	} {
+		assert.Equal(t, test.matches, test.path.Matches(test.topic),
+			"%q matching topic %q", test.path, test.topic)
+	}
+}
+
+func TestPath_MatchesPartition(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(Path("/orders/*/shipped").MatchesPartition("orders"))
+	a.False(Path("/orders/*/shipped").MatchesPartition("invoices"))
+	a.True(Path("/*/shipped").MatchesPartition("invoices"))
+	a.True(Path("/#").MatchesPartition("orders"))
+}
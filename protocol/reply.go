@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// The header fields linking a request message and its reply
+const (
+	// ReplyToHeader is the topic on which the reply to a request is published
+	ReplyToHeader = "Reply-To"
+
+	// CorrelationIDHeader identifies the request which a reply answers
+	CorrelationIDHeader = "Correlation-Id"
+)
+
+// Header returns the value of a string field of the header JSON, or an empty string
+func (msg *Message) Header(name string) string {
+	header := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(msg.HeaderJSON), &header); err != nil {
+		return ""
+	}
+	value, _ := header[name].(string)
+	return value
+}
+
+// ReplyTo returns the topic on which the reply to the message should be published
+func (msg *Message) ReplyTo() Path {
+	return Path(msg.Header(ReplyToHeader))
+}
+
+// CorrelationID returns the correlation id of a request or reply message
+func (msg *Message) CorrelationID() string {
+	return msg.Header(CorrelationIDHeader)
+}
+
+// RequestHeaderJSON returns the header JSON of a request, whose reply is expected on the replyTo topic
+func RequestHeaderJSON(replyTo Path, correlationID string) string {
+	header, _ := json.Marshal(map[string]string{
+		ReplyToHeader:       string(replyTo),
+		CorrelationIDHeader: correlationID,
+	})
+	return string(header)
+}
+
+// ReplyHeaderJSON returns the header JSON of the reply to a request
+func ReplyHeaderJSON(request *Message) string {
+	header, _ := json.Marshal(map[string]string{CorrelationIDHeader: request.CorrelationID()})
+	return string(header)
+}
@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Header(t *testing.T) {
+	a := assert.New(t)
+
+	msg, err := ParseMessage([]byte(aNormalMessage))
+	a.NoError(err)
+	a.Equal("text/plain", msg.Header("Content-Type"))
+	a.Equal("7sdks723ksgqn", msg.CorrelationID())
+	a.Equal("", msg.Header("Unknown"))
+	a.Equal(Path(""), msg.ReplyTo())
+
+	// a message without a valid header has no fields
+	a.Equal("", (&Message{}).Header("Content-Type"))
+	a.Equal("", (&Message{HeaderJSON: "{invalid"}).CorrelationID())
+	a.Equal("", (&Message{HeaderJSON: `{"Correlation-Id": 42}`}).CorrelationID())
+}
+
+func TestRequestAndReplyHeaderJSON(t *testing.T) {
+	a := assert.New(t)
+
+	request := &Message{HeaderJSON: RequestHeaderJSON("/replies/client01", "c1")}
+	a.Equal(Path("/replies/client01"), request.ReplyTo())
+	a.Equal("c1", request.CorrelationID())
+
+	reply := &Message{HeaderJSON: ReplyHeaderJSON(request)}
+	a.Equal("c1", reply.CorrelationID())
+	a.Equal(Path(""), reply.ReplyTo())
+}
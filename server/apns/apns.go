@@ -95,6 +95,11 @@ func (a *apns) startIntervalMetric(m metrics.Map, td time.Duration) {
 
 func (a *apns) HandleResponse(request connector.Request, responseIface interface{}, metadata *connector.Metadata, errSend error) error {
 	logger.Info("Handle APNS response")
+	if errSend == connector.ErrMessageExpired {
+		logger.WithField("messageID", request.Message().ID).Info("Skipped expired APNS notification")
+		mTotalExpiredMessages.Add(1)
+		return a.updateLastID(request)
+	}
 	if errSend != nil {
 		logger.WithField("error", errSend.Error()).WithField("error_type", errSend).Error("error when trying to send APNS notification")
 		mTotalSendErrors.Add(1)
@@ -108,12 +113,8 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 		mTotalResponseErrors.Add(1)
 		return fmt.Errorf("Response could not be converted to an APNS Response")
 	}
-	messageID := request.Message().ID
 	subscriber := request.Subscriber()
-	subscriber.SetLastID(messageID)
-	if err := a.Manager().Update(subscriber); err != nil {
-		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
-		mTotalResponseInternalErrors.Add(1)
+	if err := a.updateLastID(request); err != nil {
 		return err
 	}
 	if r.Sent() {
@@ -145,3 +146,14 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 	}
 	return nil
 }
+
+// updateLastID stores the ID of the request message as the last ID handled for the subscriber
+func (a *apns) updateLastID(request connector.Request) error {
+	request.Subscriber().SetLastID(request.Message().ID)
+	if err := a.Manager().Update(request.Subscriber()); err != nil {
+		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
+		mTotalResponseInternalErrors.Add(1)
+		return err
+	}
+	return nil
+}
@@ -45,6 +45,9 @@ func NewSenderUsingPusher(pusher Pusher, appTopic string) (connector.Sender, err
 }
 
 func (s sender) Send(request connector.Request) (interface{}, error) {
+	if request.Message().IsExpired() {
+		return nil, connector.ErrMessageExpired
+	}
 	deviceToken := request.Subscriber().Route().Get(deviceIDKey)
 	logger.WithField("deviceToken", deviceToken).Info("Trying to push a message to APNS")
 	push := func() (interface{}, error) {
@@ -4,10 +4,12 @@ import (
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNewSender_ErrorBytes(t *testing.T) {
@@ -88,6 +90,34 @@ func TestSender_Send(t *testing.T) {
 	a.Nil(rsp)
 }
 
+func TestSender_SendExpired(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// given
+	msg := &protocol.Message{
+		Body:    []byte("{}"),
+		Expires: time.Now().Add(-time.Minute).Unix(),
+	}
+
+	mRequest := NewMockRequest(testutil.MockCtrl)
+	mRequest.EXPECT().Message().Return(msg).AnyTimes()
+
+	// and a pusher which is never called
+	mPusher := NewMockPusher(testutil.MockCtrl)
+
+	s, err := NewSenderUsingPusher(mPusher, "com.myapp")
+	a.NoError(err)
+
+	// when
+	rsp, err := s.Send(mRequest)
+
+	// then
+	a.Equal(connector.ErrMessageExpired, err)
+	a.Nil(rsp)
+}
+
 func TestSender_Retry(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
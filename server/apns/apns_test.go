@@ -89,6 +89,33 @@ func TestConn_HandleResponse(t *testing.T) {
 	a.NoError(err)
 }
 
+func TestConn_HandleResponseOnExpiredMessage(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	//given
+	c, mKVS := newAPNSConnector(t)
+
+	mSubscriber := NewMockSubscriber(testutil.MockCtrl)
+	mSubscriber.EXPECT().SetLastID(uint64(42))
+	mSubscriber.EXPECT().Key().Return("key").AnyTimes()
+	mSubscriber.EXPECT().Encode().Return([]byte("{}"), nil).AnyTimes()
+	mKVS.EXPECT().Put(schema, "key", []byte("{}")).Times(2)
+
+	c.Manager().Add(mSubscriber)
+
+	mRequest := NewMockRequest(testutil.MockCtrl)
+	mRequest.EXPECT().Message().Return(&protocol.Message{ID: 42}).AnyTimes()
+	mRequest.EXPECT().Subscriber().Return(mSubscriber).AnyTimes()
+
+	//when
+	err := c.HandleResponse(mRequest, nil, nil, connector.ErrMessageExpired)
+
+	//then
+	a.NoError(err)
+}
+
 func TestNew_HandleResponseHandleSubscriber(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+const routerBenchBatchSize = 100
+
+type routerBenchParams struct {
+	*testing.B
+	shards        int // number of router dispatch loops
+	partitions    int // number of partitions on which the messages are published
+	subscriptions int // number of subscriptions on each partition
+}
+
+func BenchmarkRouter_1Shard8Partitions(b *testing.B) {
+	params := &routerBenchParams{
+		B:             b,
+		shards:        1,
+		partitions:    8,
+		subscriptions: 8,
+	}
+	params.throughputRouter()
+}
+
+func BenchmarkRouter_4Shards8Partitions(b *testing.B) {
+	params := &routerBenchParams{
+		B:             b,
+		shards:        4,
+		partitions:    8,
+		subscriptions: 8,
+	}
+	params.throughputRouter()
+}
+
+func BenchmarkRouter_CPUShards8Partitions(b *testing.B) {
+	params := &routerBenchParams{
+		B:             b,
+		shards:        runtime.NumCPU(),
+		partitions:    8,
+		subscriptions: 8,
+	}
+	params.throughputRouter()
+}
+
+func BenchmarkRouter_1Shard1Partition(b *testing.B) {
+	params := &routerBenchParams{
+		B:             b,
+		shards:        1,
+		partitions:    1,
+		subscriptions: 8,
+	}
+	params.throughputRouter()
+}
+
+func BenchmarkRouter_CPUShards1Partition(b *testing.B) {
+	params := &routerBenchParams{
+		B:             b,
+		shards:        runtime.NumCPU(),
+		partitions:    1,
+		subscriptions: 8,
+	}
+	params.throughputRouter()
+}
+
+// throughputRouter publishes b.N messages in each partition, in parallel, and waits until all the subscribed routes
+// received them. The messages are published in batches fitting in the route channels, so that no route is closed
+// because of a full channel.
+func (params *routerBenchParams) throughputRouter() {
+	a := assert.New(params)
+
+	kvs := kvstore.NewMemoryKVStore()
+	ms := dummystore.New(kvs)
+	r := router.New(auth.NewAllowAllAccessManager(true), ms, kvs, nil, router.Config{Shards: params.shards})
+	a.NoError(r.(service.Startable).Start())
+
+	routes := make([][]*router.Route, params.partitions)
+	for p := range routes {
+		for s := 0; s < params.subscriptions; s++ {
+			route, err := r.Subscribe(router.NewRoute(router.RouteConfig{
+				RouteParams: router.RouteParams{"application_id": fmt.Sprintf("app%d", s), "user_id": "user"},
+				Path:        partitionPath(p),
+				ChannelSize: routerBenchBatchSize,
+			}))
+			a.NoError(err)
+			routes[p] = append(routes[p], route)
+		}
+	}
+
+	params.ResetTimer()
+
+	var wg sync.WaitGroup
+	for p := range routes {
+		wg.Add(1)
+		go func(path protocol.Path, routes []*router.Route) {
+			defer wg.Done()
+			for sent := 0; sent < params.N; sent += routerBenchBatchSize {
+				batch := routerBenchBatchSize
+				if params.N-sent < batch {
+					batch = params.N - sent
+				}
+				for i := 0; i < batch; i++ {
+					a.NoError(r.HandleMessage(&protocol.Message{Path: path, Body: []byte("test-body")}))
+				}
+				for _, route := range routes {
+					for i := 0; i < batch; i++ {
+						_, open := <-route.MessagesChannel()
+						a.True(open)
+					}
+				}
+			}
+		}(partitionPath(p), routes[p])
+	}
+	wg.Wait()
+
+	params.StopTimer()
+	a.NoError(r.(service.Stopable).Stop())
+}
+
+func partitionPath(p int) protocol.Path {
+	return protocol.Path(fmt.Sprintf("/partition%d/topic", p))
+}
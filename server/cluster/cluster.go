@@ -54,6 +54,9 @@ type Cluster struct {
 	numUpdates int
 
 	synchronizer *synchronizer
+
+	groups   groupMembers
+	presence presenceTable
 }
 
 //New returns a new instance of the cluster, created using the given Config.
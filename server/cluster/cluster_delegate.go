@@ -37,6 +37,10 @@ func (cluster *Cluster) NotifyMsg(data []byte) {
 	case mtSyncMessageRequest:
 		// cluster node is requesting to receive messages for sync
 		cluster.handleSyncMessageRequest(cmsg)
+	case mtGroups:
+		cluster.handleGroups(cmsg)
+	case mtPresence:
+		cluster.handlePresence(cmsg)
 	}
 }
 
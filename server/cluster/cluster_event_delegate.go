@@ -15,11 +15,15 @@ func (cluster *Cluster) NotifyJoin(node *memberlist.Node) {
 	cluster.eventLog(node, "Cluster Node Join")
 
 	cluster.sendPartitions(node)
+	cluster.sendGroups(node)
+	cluster.sendPresence(node)
 }
 
 func (cluster *Cluster) NotifyLeave(node *memberlist.Node) {
 	cluster.numLeaves++
 	cluster.eventLog(node, "Cluster Node Leave")
+	cluster.removeGroups(node)
+	cluster.removePresence(node)
 }
 
 func (cluster *Cluster) NotifyUpdate(node *memberlist.Node) {
@@ -26,6 +26,12 @@ const (
 	mtSyncMessage
 
 	mtStringMessage
+
+	// Sent to announce the consumer groups having subscribers on the sending node ([]string)
+	mtGroups
+
+	// Sent to announce the users subscribed on the sending node, by topic (map[string][]string)
+	mtPresence
 )
 
 type encoder interface {
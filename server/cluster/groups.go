@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// groups are the keys of the consumer groups having subscribers on a node
+type groups []string
+
+func (g *groups) encode() ([]byte, error) {
+	return encode(g)
+}
+
+func (g *groups) decode(data []byte) error {
+	return decode(g, data)
+}
+
+// groupMembers holds the consumer groups having subscribers on this node, and on each of the other nodes
+type groupMembers struct {
+	local  groups
+	remote map[uint8]map[string]bool
+
+	sync.RWMutex
+}
+
+// BroadcastGroups announces to the other nodes the keys of the consumer groups having subscribers on this node.
+// The keys are sent again to the nodes joining later.
+func (cluster *Cluster) BroadcastGroups(keys []string) error {
+	g := groups(keys)
+
+	cluster.groups.Lock()
+	cluster.groups.local = g
+	cluster.groups.Unlock()
+
+	cmsg, err := cluster.newEncoderMessage(mtGroups, &g)
+	if err != nil {
+		return err
+	}
+	return cluster.broadcastClusterMessage(cmsg)
+}
+
+// GroupNodes returns the ids of the other nodes having subscribers in the consumer group, sorted
+func (cluster *Cluster) GroupNodes(key string) []uint8 {
+	cluster.groups.RLock()
+	defer cluster.groups.RUnlock()
+
+	var nodes []uint8
+	for nodeID, keys := range cluster.groups.remote {
+		if keys[key] {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
+
+// handles message received with type `mtGroups`, replacing the groups of the sender
+func (cluster *Cluster) handleGroups(cmsg *message) {
+	var g groups
+	if err := g.decode(cmsg.Body); err != nil {
+		logger.WithError(err).Error("Error decoding consumer groups")
+		return
+	}
+
+	keys := make(map[string]bool, len(g))
+	for _, key := range g {
+		keys[key] = true
+	}
+
+	cluster.groups.Lock()
+	defer cluster.groups.Unlock()
+
+	if cluster.groups.remote == nil {
+		cluster.groups.remote = make(map[uint8]map[string]bool)
+	}
+	cluster.groups.remote[cmsg.NodeID] = keys
+	logger.WithField("node", cmsg.NodeID).WithField("groups", g).Debug("Received consumer groups")
+}
+
+// sendGroups sends the consumer groups of this node to a joining node
+func (cluster *Cluster) sendGroups(node *memberlist.Node) {
+	cluster.groups.RLock()
+	g := cluster.groups.local
+	cluster.groups.RUnlock()
+
+	if len(g) == 0 || node.Name == cluster.name {
+		return
+	}
+	cmsg, err := cluster.newEncoderMessage(mtGroups, &g)
+	if err != nil {
+		logger.WithError(err).Error("Error encoding consumer groups")
+		return
+	}
+	if err := cluster.sendMessageToNode(node, cmsg); err != nil {
+		logger.WithField("node", node.Name).WithError(err).Error("Error sending consumer groups to node")
+	}
+}
+
+// removeGroups forgets the consumer groups of a node leaving the cluster
+func (cluster *Cluster) removeGroups(node *memberlist.Node) {
+	nodeID, err := strconv.ParseUint(node.Name, 10, 8)
+	if err != nil {
+		return
+	}
+
+	cluster.groups.Lock()
+	defer cluster.groups.Unlock()
+
+	delete(cluster.groups.remote, uint8(nodeID))
+}
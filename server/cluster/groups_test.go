@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+)
+
+func groupsMessage(a *assert.Assertions, nodeID uint8, keys ...string) []byte {
+	g := groups(keys)
+	body, err := g.encode()
+	a.NoError(err)
+	data, err := (&message{NodeID: nodeID, Type: mtGroups, Body: body}).encode()
+	a.NoError(err)
+	return data
+}
+
+func TestCluster_Groups(t *testing.T) {
+	a := assert.New(t)
+	cluster := &Cluster{Config: &Config{ID: 1}, name: "1"}
+
+	a.Empty(cluster.GroupNodes("workers /orders"))
+
+	// when the other nodes announce their groups
+	cluster.NotifyMsg(groupsMessage(a, 3, "workers /orders", "mailers /mails"))
+	cluster.NotifyMsg(groupsMessage(a, 2, "workers /orders"))
+
+	// then the nodes of each group are known
+	a.Equal([]uint8{2, 3}, cluster.GroupNodes("workers /orders"))
+	a.Equal([]uint8{3}, cluster.GroupNodes("mailers /mails"))
+	a.Empty(cluster.GroupNodes("workers /mails"))
+
+	// and an announcement replaces the previous groups of the node
+	cluster.NotifyMsg(groupsMessage(a, 3, "mailers /mails"))
+	a.Equal([]uint8{2}, cluster.GroupNodes("workers /orders"))
+
+	// and the groups of a leaving node are removed
+	cluster.removeGroups(&memberlist.Node{Name: "2"})
+	a.Empty(cluster.GroupNodes("workers /orders"))
+	a.Equal([]uint8{3}, cluster.GroupNodes("mailers /mails"))
+}
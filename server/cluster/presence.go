@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// topicUsers are the ids of the users subscribed to a topic
+type topicUsers struct {
+	Topic string
+	Users []string
+}
+
+// presence holds the users subscribed on a node, for each topic having subscribers
+type presence []topicUsers
+
+func (p *presence) encode() ([]byte, error) {
+	return encode(p)
+}
+
+func (p *presence) decode(data []byte) error {
+	return decode(p, data)
+}
+
+// presenceTable holds the users subscribed on this node, and the users of each of the other nodes by topic
+type presenceTable struct {
+	local  presence
+	remote map[uint8]map[string][]string
+
+	sync.RWMutex
+}
+
+// BroadcastPresence announces to the other nodes the users subscribed on this node, by topic.
+// The users are sent again to the nodes joining later.
+func (cluster *Cluster) BroadcastPresence(users map[string][]string) error {
+	p := make(presence, 0, len(users))
+	for topic, ids := range users {
+		p = append(p, topicUsers{Topic: topic, Users: ids})
+	}
+
+	cluster.presence.Lock()
+	cluster.presence.local = p
+	cluster.presence.Unlock()
+
+	cmsg, err := cluster.newEncoderMessage(mtPresence, &p)
+	if err != nil {
+		return err
+	}
+	return cluster.broadcastClusterMessage(cmsg)
+}
+
+// Presence returns the users subscribed on the other nodes, by topic.
+// The users of each topic are sorted, and listed once even if they are subscribed on several nodes.
+func (cluster *Cluster) Presence() map[string][]string {
+	cluster.presence.RLock()
+	defer cluster.presence.RUnlock()
+
+	seen := make(map[string]map[string]bool)
+	for _, nodeUsers := range cluster.presence.remote {
+		for topic, users := range nodeUsers {
+			if seen[topic] == nil {
+				seen[topic] = make(map[string]bool)
+			}
+			for _, user := range users {
+				seen[topic][user] = true
+			}
+		}
+	}
+
+	result := make(map[string][]string, len(seen))
+	for topic, users := range seen {
+		for user := range users {
+			result[topic] = append(result[topic], user)
+		}
+		sort.Strings(result[topic])
+	}
+	return result
+}
+
+// handles message received with type `mtPresence`, replacing the users of the sender
+func (cluster *Cluster) handlePresence(cmsg *message) {
+	var p presence
+	if err := p.decode(cmsg.Body); err != nil {
+		logger.WithError(err).Error("Error decoding presence")
+		return
+	}
+
+	users := make(map[string][]string, len(p))
+	for _, tu := range p {
+		users[tu.Topic] = tu.Users
+	}
+
+	cluster.presence.Lock()
+	defer cluster.presence.Unlock()
+
+	if cluster.presence.remote == nil {
+		cluster.presence.remote = make(map[uint8]map[string][]string)
+	}
+	cluster.presence.remote[cmsg.NodeID] = users
+	logger.WithField("node", cmsg.NodeID).WithField("topics", len(p)).Debug("Received presence")
+}
+
+// sendPresence sends the users subscribed on this node to a joining node
+func (cluster *Cluster) sendPresence(node *memberlist.Node) {
+	cluster.presence.RLock()
+	p := cluster.presence.local
+	cluster.presence.RUnlock()
+
+	if len(p) == 0 || node.Name == cluster.name {
+		return
+	}
+	cmsg, err := cluster.newEncoderMessage(mtPresence, &p)
+	if err != nil {
+		logger.WithError(err).Error("Error encoding presence")
+		return
+	}
+	if err := cluster.sendMessageToNode(node, cmsg); err != nil {
+		logger.WithField("node", node.Name).WithError(err).Error("Error sending presence to node")
+	}
+}
+
+// removePresence forgets the users of a node leaving the cluster
+func (cluster *Cluster) removePresence(node *memberlist.Node) {
+	nodeID, err := strconv.ParseUint(node.Name, 10, 8)
+	if err != nil {
+		return
+	}
+
+	cluster.presence.Lock()
+	defer cluster.presence.Unlock()
+
+	delete(cluster.presence.remote, uint8(nodeID))
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+)
+
+func presenceMessage(a *assert.Assertions, nodeID uint8, users map[string][]string) []byte {
+	var p presence
+	for topic, ids := range users {
+		p = append(p, topicUsers{Topic: topic, Users: ids})
+	}
+	body, err := p.encode()
+	a.NoError(err)
+	data, err := (&message{NodeID: nodeID, Type: mtPresence, Body: body}).encode()
+	a.NoError(err)
+	return data
+}
+
+func TestCluster_Presence(t *testing.T) {
+	a := assert.New(t)
+	cluster := &Cluster{Config: &Config{ID: 1}, name: "1"}
+
+	a.Empty(cluster.Presence())
+
+	// when the other nodes announce their users
+	cluster.NotifyMsg(presenceMessage(a, 2, map[string][]string{"/chat/room1": {"bob", "alice"}}))
+	cluster.NotifyMsg(presenceMessage(a, 3, map[string][]string{"/chat/room1": {"alice", "carol"}, "/chat/room2": {"dave"}}))
+
+	// then the users of all the nodes are known, once by topic
+	a.Equal(map[string][]string{
+		"/chat/room1": {"alice", "bob", "carol"},
+		"/chat/room2": {"dave"},
+	}, cluster.Presence())
+
+	// and an announcement replaces the previous users of the node
+	cluster.NotifyMsg(presenceMessage(a, 3, map[string][]string{"/chat/room2": {"dave"}}))
+	a.Equal([]string{"alice", "bob"}, cluster.Presence()["/chat/room1"])
+
+	// and the users of a leaving node are removed
+	cluster.removePresence(&memberlist.Node{Name: "2"})
+	a.Equal(map[string][]string{"/chat/room2": {"dave"}}, cluster.Presence())
+}
@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/smancke/guble/server/apns"
 	"github.com/smancke/guble/server/fcm"
@@ -50,6 +51,23 @@ type (
 		Password *string
 		DbName   *string
 	}
+	// RouterConfig is used for configuring the router.
+	RouterConfig struct {
+		Shards            *int
+		DeadLetterPrefix  *string
+		PresencePrefix    *string
+		ReplyPrefix       *string
+		UserRateLimit     *float64
+		AppRateLimit      *float64
+		RateLimitBurst    *int
+		IdempotencyWindow *time.Duration
+	}
+	// RetentionConfig is used for configuring the retention of the file message store.
+	RetentionConfig struct {
+		MaxAge      *time.Duration
+		MaxMessages *uint64
+		MaxBytes    *int64
+	}
 	// ClusterConfig is used for configuring the cluster component.
 	ClusterConfig struct {
 		NodeID   *uint8
@@ -64,10 +82,14 @@ type (
 		KVS             *string
 		MS              *string
 		StoragePath     *string
+		MSCompress      *bool
+		MSKeyFile       *string
 		HealthEndpoint  *string
 		MetricsEndpoint *string
 		Profile         *string
 		Postgres        PostgresConfig
+		Router          RouterConfig
+		Retention       RetentionConfig
 		FCM             fcm.Config
 		APNS            apns.Config
 		SMS             sms.Config
@@ -96,15 +118,21 @@ var (
 			Default(defaultKVSBackend).
 			Envar("GUBLE_KVS").
 			String(),
-		MS: kingpin.Flag("ms", "The message storage backend : file | memory").
+		MS: kingpin.Flag("ms", "The message storage backend : file | memory | sqlite | postgres").
 			Default(defaultMSBackend).
-			HintOptions("file", "memory").
+			HintOptions("file", "memory", "sqlite", "postgres").
 			Envar("GUBLE_MS").
 			String(),
 		StoragePath: kingpin.Flag("storage-path", "The path for storing messages and key-value data if 'file' is selected").
 			Default(defaultStoragePath).
 			Envar("GUBLE_STORAGE_PATH").
 			ExistingDir(),
+		MSCompress: kingpin.Flag("ms-compress", "Compress the messages written by the file message store").
+			Envar("GUBLE_MS_COMPRESS").
+			Bool(),
+		MSKeyFile: kingpin.Flag("ms-key-file", "The key file for encrypting the messages written by the file message store (default: no encryption)").
+			Envar("GUBLE_MS_KEY_FILE").
+			String(),
 		HealthEndpoint: kingpin.Flag("health-endpoint", `The health endpoint to be used by the HTTP server (value for disabling it: "")`).
 			Default(defaultHealthEndpoint).
 			Envar("GUBLE_HEALTH_ENDPOINT").
@@ -139,6 +167,51 @@ var (
 				Envar("GUBLE_PG_DBNAME").
 				String(),
 		},
+		Router: RouterConfig{
+			Shards: kingpin.Flag("router-shards", "The number of router dispatch loops, among which the partitions are distributed (default: number of CPUs)").
+				Default(strconv.Itoa(runtime.NumCPU())).
+				Envar("GUBLE_ROUTER_SHARDS").
+				Int(),
+			DeadLetterPrefix: kingpin.Flag("dead-letter-prefix", "The topic prefix under which undeliverable messages are republished, e.g. /dlq (default: disabled)").
+				Envar("GUBLE_DEAD_LETTER_PREFIX").
+				String(),
+			PresencePrefix: kingpin.Flag("presence-prefix", "The topic prefix under which the users joining and leaving topics are announced, e.g. /$presence (default: disabled)").
+				Envar("GUBLE_PRESENCE_PREFIX").
+				String(),
+			ReplyPrefix: kingpin.Flag("reply-prefix", "The topic prefix of the reply topics, whose messages are not stored, e.g. /replies (default: disabled)").
+				Envar("GUBLE_REPLY_PREFIX").
+				String(),
+			UserRateLimit: kingpin.Flag("rate-limit-user", "The number of messages per second each user can publish (default: unlimited)").
+				Default("0").
+				Envar("GUBLE_RATE_LIMIT_USER").
+				Float64(),
+			AppRateLimit: kingpin.Flag("rate-limit-app", "The number of messages per second each application can publish (default: unlimited)").
+				Default("0").
+				Envar("GUBLE_RATE_LIMIT_APP").
+				Float64(),
+			RateLimitBurst: kingpin.Flag("rate-limit-burst", "The number of messages which can be published at once, above the rate limits (default: the rate)").
+				Default("0").
+				Envar("GUBLE_RATE_LIMIT_BURST").
+				Int(),
+			IdempotencyWindow: kingpin.Flag("idempotency-window", "The time during which the idempotency keys of the published messages are remembered (0 to disable)").
+				Default("10m").
+				Envar("GUBLE_IDEMPOTENCY_WINDOW").
+				Duration(),
+		},
+		Retention: RetentionConfig{
+			MaxAge: kingpin.Flag("retention-max-age", "The maximum age of the messages kept in each partition of the file message store (default: unlimited)").
+				Default("0").
+				Envar("GUBLE_RETENTION_MAX_AGE").
+				Duration(),
+			MaxMessages: kingpin.Flag("retention-max-messages", "The maximum number of messages kept in each partition of the file message store (default: unlimited)").
+				Default("0").
+				Envar("GUBLE_RETENTION_MAX_MESSAGES").
+				Uint64(),
+			MaxBytes: kingpin.Flag("retention-max-bytes", "The maximum size in bytes of each partition of the file message store (default: unlimited)").
+				Default("0").
+				Envar("GUBLE_RETENTION_MAX_BYTES").
+				Int64(),
+		},
 		FCM: fcm.Config{
 			Enabled: kingpin.Flag("fcm", "Enable the Google Firebase Cloud Messaging connector").
 				Envar("GUBLE_FCM").
@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -24,6 +25,16 @@ const (
 var (
 	TopicParam     = "topic"
 	ConnectorParam = "connector"
+
+	// PolicyParam and DeadlineParam are the query parameters configuring the slow consumer policy of a subscription
+	PolicyParam   = "policy"
+	DeadlineParam = "deadline"
+
+	// FilterParam is the query parameter of a subscription with the filter expression of its messages
+	FilterParam = "filter"
+
+	// ErrMessageExpired is returned by a Sender which skipped an expired message, without sending it
+	ErrMessageExpired = errors.New("Message expired.")
 )
 
 type Sender interface {
@@ -109,10 +120,10 @@ func NewConnector(router router.Router, sender Sender, config Config) (Connector
 		config:  config,
 		sender:  sender,
 		manager: NewManager(config.Schema, kvs),
-		queue:   NewQueue(sender, config.Workers),
 		router:  router,
 		logger:  logger.WithField("name", config.Name),
 	}
+	c.queue = newQueue(sender, config.Workers, c.deadLetter)
 	c.initMuxRouter()
 	return c, nil
 }
@@ -186,8 +197,15 @@ func (c *connector) Post(w http.ResponseWriter, req *http.Request) {
 	}
 	delete(params, TopicParam)
 	params[ConnectorParam] = c.config.Name
+
+	options, err := subscriberOptions(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
 	c.logger.WithField("params", params).WithField("topic", topic).Info("Creating subscription")
-	subscriber, err := c.manager.Create(protocol.Path("/"+topic), params)
+	subscriber, err := c.manager.Create(protocol.Path("/"+topic), params, options)
 	if err != nil {
 		if err == ErrSubscriberExists {
 			fmt.Fprintf(w, `{"error":"subscription already exists"}`)
@@ -201,6 +219,19 @@ func (c *connector) Post(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, `{"subscribed":"/%v"}`, topic)
 }
 
+// subscriberOptions returns the options of a subscription from the query parameters of the request
+func subscriberOptions(req *http.Request) (options SubscriberOptions, err error) {
+	query := req.URL.Query()
+	options.SlowConsumer, err = router.ParseSlowConsumerConfig(query.Get(PolicyParam), query.Get(DeadlineParam))
+	if err != nil {
+		return
+	}
+	if filter, ok := query[FilterParam]; ok {
+		options.Filter, err = router.ParseFilterExpression(filter[0])
+	}
+	return
+}
+
 // Delete removes a subscriber
 func (c *connector) Delete(w http.ResponseWriter, req *http.Request) {
 	params := mux.Vars(req)
@@ -337,6 +368,21 @@ func (c *connector) restart(s Subscriber) error {
 	return nil
 }
 
+// deadLetter passes the request which could not be delivered to the router,
+// if it republishes undeliverable messages on dead-letter topics
+func (c *connector) deadLetter(request Request, err error) {
+	deadLetterer, ok := c.router.(router.DeadLetterer)
+	if !ok {
+		return
+	}
+	deadLetterer.DeadLetter(router.DeadLetter{
+		Message:       request.Message(),
+		Source:        c.config.Name,
+		Reason:        err.Error(),
+		SubscriberKey: request.Subscriber().Key(),
+	})
+}
+
 // Stop the connector (the context, the queue, the subscription loops)
 func (c *connector) Stop() error {
 	c.logger.Info("Stopping connector")
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -49,7 +50,7 @@ func TestConnector_PostSubscription(t *testing.T) {
 		"device_token": "device1",
 		"user_id":      "user1",
 		"connector":    "test",
-	})).Return(subscriber, nil)
+	}), gomock.Eq(SubscriberOptions{})).Return(subscriber, nil)
 
 	subscriber.EXPECT().Loop(gomock.Any(), gomock.Any())
 	r := router.NewRoute(router.RouteConfig{
@@ -106,6 +107,92 @@ func TestConnector_PostSubscriptionNoMocks(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestConnector_PostSubscriptionWithSlowConsumerPolicy(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	recorder := httptest.NewRecorder()
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, true, false)
+
+	subscriber := NewMockSubscriber(testutil.MockCtrl)
+	mocks.manager.EXPECT().Create(gomock.Eq(protocol.Path("/topic1")), gomock.Eq(router.RouteParams{
+		"device_token": "device1",
+		"user_id":      "user1",
+		"connector":    "test",
+	}), gomock.Eq(SubscriberOptions{
+		SlowConsumer: router.SlowConsumerConfig{
+			Policy:   router.PolicyBlock,
+			Deadline: 2 * time.Second,
+		},
+	})).Return(subscriber, nil)
+
+	subscriber.EXPECT().Loop(gomock.Any(), gomock.Any()).AnyTimes()
+	subscriber.EXPECT().Route().Return(router.NewRoute(router.RouteConfig{Path: protocol.Path("/topic1")})).AnyTimes()
+	mocks.router.EXPECT().Subscribe(gomock.Any()).AnyTimes()
+
+	req, err := http.NewRequest(http.MethodPost, "/connector/device1/user1/topic1?policy=block&deadline=2s", strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(`{"subscribed":"/topic1"}`, recorder.Body.String())
+	time.Sleep(100 * time.Millisecond)
+
+	// an unknown policy is rejected
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodPost, "/connector/device1/user1/topic1?policy=unknown", strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestConnector_PostSubscriptionWithFilter(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	recorder := httptest.NewRecorder()
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, true, false)
+
+	subscriber := NewMockSubscriber(testutil.MockCtrl)
+	mocks.manager.EXPECT().Create(gomock.Eq(protocol.Path("/prices")), gomock.Any(), gomock.Any()).
+		Do(func(topic protocol.Path, params router.RouteParams, options SubscriberOptions) {
+			if a.NotNil(options.Filter) {
+				a.Equal("symbol in (A,B) and change > 2", options.Filter.String())
+			}
+		}).Return(subscriber, nil)
+
+	subscriber.EXPECT().Loop(gomock.Any(), gomock.Any()).AnyTimes()
+	subscriber.EXPECT().Route().Return(router.NewRoute(router.RouteConfig{Path: protocol.Path("/prices")})).AnyTimes()
+	mocks.router.EXPECT().Subscribe(gomock.Any()).AnyTimes()
+
+	query := url.Values{FilterParam: []string{"symbol in (A,B) and change > 2"}}
+	req, err := http.NewRequest(http.MethodPost, "/connector/device1/user1/prices?"+query.Encode(), strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(`{"subscribed":"/prices"}`, recorder.Body.String())
+	time.Sleep(100 * time.Millisecond)
+
+	// an invalid filter expression is rejected
+	recorder = httptest.NewRecorder()
+	query = url.Values{FilterParam: []string{"symbol in"}}
+	req, err = http.NewRequest(http.MethodPost, "/connector/device1/user1/prices?"+query.Encode(), strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
 func TestConnector_DeleteSubscription(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -396,3 +483,82 @@ func getTestConnector(t *testing.T, config Config, mockManager bool, mockQueue b
 		mKVS,
 	}
 }
+
+func TestSubscriber_EncodeWithFilter(t *testing.T) {
+	a := assert.New(t)
+
+	filter, err := router.ParseFilterExpression("symbol in (A,B)")
+	a.NoError(err)
+
+	s := NewSubscriberFromData(SubscriberData{
+		Topic:             protocol.Path("/prices"),
+		Params:            router.RouteParams{"device_token": "device1"},
+		SubscriberOptions: SubscriberOptions{Filter: filter},
+	})
+	data, err := s.Encode()
+	a.NoError(err)
+
+	decoded, err := NewSubscriberFromJSON(data)
+	a.NoError(err)
+	if a.NotNil(decoded.Route().FilterExpression) {
+		a.Equal("symbol in (A,B)", decoded.Route().FilterExpression.String())
+	}
+}
+
+type deadLetterRouter struct {
+	*MockRouter
+	deadLetters []router.DeadLetter
+}
+
+func (r *deadLetterRouter) DeadLetter(dl router.DeadLetter) {
+	r.deadLetters = append(r.deadLetters, dl)
+}
+
+func TestConnector_DeadLetterOnSendError(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	mRouter := NewMockRouter(testutil.MockCtrl)
+	mRouter.EXPECT().KVStore().Return(NewMockKVStore(testutil.MockCtrl), nil)
+	dlRouter := &deadLetterRouter{MockRouter: mRouter}
+	mSender := NewMockSender(testutil.MockCtrl)
+
+	conn, err := NewConnector(dlRouter, mSender, Config{Name: "test", Schema: "test"})
+	a.NoError(err)
+	q := conn.(*connector).queue.(*queue)
+
+	mSubscriber := NewMockSubscriber(testutil.MockCtrl)
+	mSubscriber.EXPECT().Key().Return("subscriber-key").AnyTimes()
+	message := &protocol.Message{ID: 1, Path: "/topic", Body: []byte("body")}
+	request := NewRequest(mSubscriber, message)
+
+	// a successful send, and an expired message are not dead letters
+	mSender.EXPECT().Send(request).Return("ok", nil)
+	q.handle(request)
+	mSender.EXPECT().Send(request).Return(nil, ErrMessageExpired)
+	q.handle(request)
+	a.Empty(dlRouter.deadLetters)
+
+	// a failed send is passed to the router
+	mSender.EXPECT().Send(request).Return(nil, fmt.Errorf("unavailable"))
+	q.handle(request)
+	if a.Len(dlRouter.deadLetters, 1) {
+		a.Equal(router.DeadLetter{
+			Message:       message,
+			Source:        "test",
+			Reason:        "unavailable",
+			SubscriberKey: "subscriber-key",
+		}, dlRouter.deadLetters[0])
+	}
+
+	// and an error returned by the response handler too
+	mResponseHandler := NewMockResponseHandler(testutil.MockCtrl)
+	conn.SetResponseHandler(mResponseHandler)
+	mSender.EXPECT().Send(request).Return("not registered", nil)
+	mResponseHandler.EXPECT().HandleResponse(request, "not registered", gomock.Any(), nil).Return(fmt.Errorf("NotRegistered"))
+	q.handle(request)
+	if a.Len(dlRouter.deadLetters, 2) {
+		a.Equal("NotRegistered", dlRouter.deadLetters[1].Reason)
+	}
+}
@@ -14,7 +14,7 @@ type Manager interface {
 	Filter(map[string]string) []Subscriber
 	Find(string) Subscriber
 	Exists(string) bool
-	Create(protocol.Path, router.RouteParams) (Subscriber, error)
+	Create(protocol.Path, router.RouteParams, SubscriberOptions) (Subscriber, error)
 	Add(Subscriber) error
 	Update(Subscriber) error
 	Remove(Subscriber) error
@@ -58,7 +58,7 @@ func (m *manager) Find(key string) Subscriber {
 	return nil
 }
 
-func (m *manager) Create(topic protocol.Path, params router.RouteParams) (Subscriber, error) {
+func (m *manager) Create(topic protocol.Path, params router.RouteParams, options SubscriberOptions) (Subscriber, error) {
 	key := GenerateKey(string(topic), params)
 	//TODO MARIAN  remove this logs   when 503 is done.
 	logger.WithField("key", key).Info("Create generated key")
@@ -67,7 +67,11 @@ func (m *manager) Create(topic protocol.Path, params router.RouteParams) (Subscr
 		return nil, ErrSubscriberExists
 	}
 
-	s := NewSubscriber(topic, params, 0)
+	s := NewSubscriberFromData(SubscriberData{
+		Topic:             topic,
+		Params:            params,
+		SubscriberOptions: options,
+	})
 
 	logger.WithField("subscriber", s).Info("Created new subscriber")
 	err := m.Add(s)
@@ -229,15 +229,15 @@ func (_mr *_MockManagerRecorder) Add(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Add", arg0)
 }
 
-func (_m *MockManager) Create(_param0 protocol.Path, _param1 router.RouteParams) (Subscriber, error) {
-	ret := _m.ctrl.Call(_m, "Create", _param0, _param1)
+func (_m *MockManager) Create(_param0 protocol.Path, _param1 router.RouteParams, _param2 SubscriberOptions) (Subscriber, error) {
+	ret := _m.ctrl.Call(_m, "Create", _param0, _param1, _param2)
 	ret0, _ := ret[0].(Subscriber)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockManagerRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Create", arg0, arg1)
+func (_mr *_MockManagerRecorder) Create(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Create", arg0, arg1, arg2)
 }
 
 func (_m *MockManager) Exists(_param0 string) bool {
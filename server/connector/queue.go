@@ -24,17 +24,22 @@ type queue struct {
 	requestsC       chan Request
 	nWorkers        int
 	metrics         bool
+	deadLetter      func(Request, error) // called with the requests which could not be handled (optional)
 	wg              sync.WaitGroup
 }
 
 // NewQueue returns a new Queue (not started).
 func NewQueue(sender Sender, nWorkers int) Queue {
-	q := &queue{
-		sender:   sender,
-		nWorkers: nWorkers,
-		metrics:  true,
+	return newQueue(sender, nWorkers, nil)
+}
+
+func newQueue(sender Sender, nWorkers int, deadLetter func(Request, error)) *queue {
+	return &queue{
+		sender:     sender,
+		nWorkers:   nWorkers,
+		metrics:    true,
+		deadLetter: deadLetter,
 	}
-	return q
 }
 
 func (q *queue) SetResponseHandler(rh ResponseHandler) {
@@ -96,6 +101,9 @@ func (q *queue) handle(request Request) {
 	} else {
 		logger.WithField("error", err.Error()).Error("error while sending, and no response handler was set")
 	}
+	if err != nil && err != ErrMessageExpired && q.deadLetter != nil {
+		q.deadLetter(request, err)
+	}
 }
 
 func (q *queue) Push(request Request) error {
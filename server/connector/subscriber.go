@@ -35,10 +35,20 @@ type Subscriber interface {
 	Encode() ([]byte, error)
 }
 
+// SubscriberOptions are the optional settings of a subscription
+type SubscriberOptions struct {
+	// SlowConsumer defines how the route of the subscriber handles a slow consumer
+	SlowConsumer router.SlowConsumerConfig
+
+	// Filter if set selects the messages delivered to the subscriber, by the message filters
+	Filter *router.FilterExpression `json:",omitempty"`
+}
+
 type SubscriberData struct {
 	Topic  protocol.Path
 	Params router.RouteParams
 	LastID uint64
+	SubscriberOptions
 }
 
 func (sd *SubscriberData) newRoute() *router.Route {
@@ -47,9 +57,11 @@ func (sd *SubscriberData) newRoute() *router.Route {
 		fr = store.NewFetchRequest(sd.Topic.Partition(), sd.LastID, 0, store.DirectionForward, -1)
 	}
 	return router.NewRoute(router.RouteConfig{
-		Path:         sd.Topic,
-		RouteParams:  sd.Params,
-		FetchRequest: fr,
+		Path:               sd.Topic,
+		RouteParams:        sd.Params,
+		FetchRequest:       fr,
+		SlowConsumerConfig: sd.SlowConsumer,
+		FilterExpression:   sd.Filter,
 	})
 }
 
@@ -84,6 +84,11 @@ func (f *fcm) startIntervalMetric(m metrics.Map, td time.Duration) {
 }
 
 func (f *fcm) HandleResponse(request connector.Request, responseIface interface{}, metadata *connector.Metadata, err error) error {
+	if err == connector.ErrMessageExpired {
+		logger.WithField("messageID", request.Message().ID).Debug("Skipped expired message")
+		mTotalExpiredMessages.Add(1)
+		return f.updateLastID(request)
+	}
 	if err != nil && !isValidResponseError(err) {
 		logger.WithField("error", err.Error()).Error("Error sending message to FCM")
 		mTotalSendErrors.Add(1)
@@ -102,12 +107,8 @@ func (f *fcm) HandleResponse(request connector.Request, responseIface interface{
 	}
 
 	logger.WithField("messageID", message.ID).Debug("Delivered message to FCM")
-	subscriber.SetLastID(message.ID)
-	if err := f.Manager().Update(request.Subscriber()); err != nil {
-		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
-		mTotalResponseInternalErrors.Add(1)
-		return err
-	}
+	// failing to store the last id is not a delivery failure, the error is only logged
+	f.updateLastID(request)
 	if response.Ok() {
 		mTotalSentMessages.Add(1)
 		if *f.IntervalMetrics && metadata != nil {
@@ -132,10 +133,22 @@ func (f *fcm) HandleResponse(request connector.Request, responseIface interface{
 
 	if response.CanonicalIDs != 0 {
 		mTotalReplacedCanonicalErrors.Add(1)
+		// the message was delivered to the canonical id, so only a failed replacement of the subscription is returned.
 		// we only send to one receiver, so we know that we can replace the old id with the first registration id (=canonical id)
 		return f.replaceCanonical(request.Subscriber(), response.Results[0].RegistrationID)
 	}
 	mTotalResponseOtherErrors.Add(1)
+	return response.Error
+}
+
+// updateLastID stores the ID of the request message as the last ID handled for the subscriber
+func (f *fcm) updateLastID(request connector.Request) error {
+	request.Subscriber().SetLastID(request.Message().ID)
+	if err := f.Manager().Update(request.Subscriber()); err != nil {
+		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
+		mTotalResponseInternalErrors.Add(1)
+		return err
+	}
 	return nil
 }
 
@@ -151,7 +164,10 @@ func (f *fcm) replaceCanonical(subscriber connector.Subscriber, newToken string)
 
 	params[deviceTokenKey] = newToken
 
-	newSubscriber, err := manager.Create(topic, params)
+	newSubscriber, err := manager.Create(topic, params, connector.SubscriberOptions{
+		SlowConsumer: subscriber.Route().SlowConsumerConfig,
+		Filter:       subscriber.Route().FilterExpression,
+	})
 	go f.Run(newSubscriber)
 	return err
 }
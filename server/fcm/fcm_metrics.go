@@ -14,6 +14,7 @@ var (
 	mTotalResponseNotRegisteredErrors = ns.NewInt("total_response_not_registered_errors")
 	mTotalReplacedCanonicalErrors     = ns.NewInt("total_replaced_canonical_errors")
 	mTotalResponseOtherErrors         = ns.NewInt("total_response_other_errors")
+	mTotalExpiredMessages             = ns.NewInt("total_expired_messages")
 	mMinute                           = ns.NewMap("minute")
 	mHour                             = ns.NewMap("hour")
 	mDay                              = ns.NewMap("day")
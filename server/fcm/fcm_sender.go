@@ -30,6 +30,9 @@ func NewSender(apiKey string) *sender {
 }
 
 func (s *sender) Send(request connector.Request) (interface{}, error) {
+	if request.Message().IsExpired() {
+		return nil, connector.ErrMessageExpired
+	}
 	deviceToken := request.Subscriber().Route().Get(deviceTokenKey)
 	fcmMessage := fcmMessage(request.Message())
 	fcmMessage.To = deviceToken
@@ -29,9 +29,20 @@ var fullFCMMessage = `{
 }`
 
 type mocks struct {
-	router    *MockRouter
-	store     *MockMessageStore
-	gcmSender *MockSender
+	router      *MockRouter
+	store       *MockMessageStore
+	gcmSender   *MockSender
+	deadLetters chan router.DeadLetter
+}
+
+// deadLetterRouter is a mocked router recording the dead letters
+type deadLetterRouter struct {
+	*MockRouter
+	deadLetters chan router.DeadLetter
+}
+
+func (r *deadLetterRouter) DeadLetter(dl router.DeadLetter) {
+	r.deadLetters <- dl
 }
 
 func TestConnector_GetErrorMessageFromFCM(t *testing.T) {
@@ -90,6 +101,103 @@ func TestConnector_GetErrorMessageFromFCM(t *testing.T) {
 	// wait before closing the FCM connector
 	time.Sleep(100 * time.Millisecond)
 
+	// the message was delivered to the canonical id, so it is not a dead letter
+	a.Len(mocks.deadLetters, 0)
+
+	err = fcm.Stop()
+	a.NoError(err)
+}
+
+func TestConnector_DeadLetterOfErrorResponse(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+	fcm, mocks := testFCM(t, true)
+
+	err := fcm.Start()
+	a.NoError(err)
+
+	var route *router.Route
+	mocks.router.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) (*router.Route, error) {
+		route = r
+		return r, nil
+	})
+
+	postSubscription(t, fcm, "user01", "device01", "topic")
+	time.Sleep(100 * time.Millisecond)
+	a.NotNil(route)
+
+	// expect an error response without a canonical id
+	response := new(gcm.Response)
+	err = json.Unmarshal([]byte(InvalidRegistrationFCMResponse), response)
+	a.NoError(err)
+	mocks.gcmSender.EXPECT().Send(gomock.Any()).Return(response, nil)
+
+	route.Deliver(&protocol.Message{
+		ID:   uint64(4),
+		Path: "/topic",
+		Body: []byte("{id:id}"),
+	}, true)
+
+	// then the message is a dead letter of the connector
+	select {
+	case dl := <-mocks.deadLetters:
+		a.Equal(uint64(4), dl.Message.ID)
+		a.Equal("InvalidRegistration", dl.Reason)
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("No dead letter")
+	}
+
+	err = fcm.Stop()
+	a.NoError(err)
+}
+
+func TestConnector_SkipsExpiredMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+	fcm, mocks := testFCM(t, true)
+
+	err := fcm.Start()
+	a.NoError(err)
+
+	var route *router.Route
+	mocks.router.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) (*router.Route, error) {
+		route = r
+		return r, nil
+	})
+
+	postSubscription(t, fcm, "user01", "device01", "topic")
+	time.Sleep(100 * time.Millisecond)
+	a.NotNil(route)
+
+	// expect only the message which is not expired to be sent
+	response := new(gcm.Response)
+	err = json.Unmarshal([]byte(SuccessFCMResponse), response)
+	a.NoError(err)
+	mocks.gcmSender.EXPECT().Send(gomock.Any()).Do(func(m *gcm.Message) (*gcm.Response, error) {
+		a.Equal("valid", m.Data["message"])
+		return response, nil
+	}).Return(response, nil)
+
+	route.Deliver(&protocol.Message{
+		ID:      uint64(4),
+		Path:    "/topic",
+		Body:    []byte(`{"message":"expired"}`),
+		Expires: time.Now().Add(-time.Minute).Unix(),
+	}, true)
+	route.Deliver(&protocol.Message{
+		ID:      uint64(5),
+		Path:    "/topic",
+		Body:    []byte(`{"message":"valid"}`),
+		Expires: time.Now().Add(time.Minute).Unix(),
+	}, true)
+
+	// wait before closing the FCM connector
+	time.Sleep(100 * time.Millisecond)
+
 	err = fcm.Stop()
 	a.NoError(err)
 }
@@ -197,7 +305,8 @@ func testFCM(t *testing.T, mockStore bool) (connector.ResponsiveConnector, *mock
 	sender := NewSender(key)
 	sender.gcmSender = mcks.gcmSender
 
-	conn, err := New(mcks.router, sender, Config{
+	mcks.deadLetters = make(chan router.DeadLetter, 10)
+	conn, err := New(&deadLetterRouter{mcks.router, mcks.deadLetters}, sender, Config{
 		APIKey:          &key,
 		Workers:         &nWorkers,
 		Endpoint:        &endpoint,
@@ -38,6 +38,20 @@ const (
 	      }
 	   ]
 	}`
+
+	InvalidRegistrationFCMResponse = `{
+	   "multicast_id":3,
+	   "success":0,
+	   "failure":1,
+	   "error":"InvalidRegistration",
+	   "canonical_ids":0,
+	   "results":[
+	      {
+	         "message_id":"err",
+	         "error":"InvalidRegistration"
+	      }
+	   ]
+	}`
 )
 
 func NewSenderWithMock(gcmSender gcm.Sender) *sender {
@@ -13,11 +13,14 @@ import (
 	"github.com/smancke/guble/server/metrics"
 	"github.com/smancke/guble/server/rest"
 	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/scheduler"
 	"github.com/smancke/guble/server/service"
 	"github.com/smancke/guble/server/sms"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
 	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/server/store/sqlstore"
+	"github.com/smancke/guble/server/topics"
 	"github.com/smancke/guble/server/webserver"
 	"github.com/smancke/guble/server/websocket"
 
@@ -29,6 +32,7 @@ import (
 	"runtime"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/Bogh/gcm"
 	"github.com/pkg/profile"
@@ -36,7 +40,8 @@ import (
 )
 
 const (
-	fileOption = "file"
+	fileOption   = "file"
+	sqliteOption = "sqlite"
 )
 
 var AfterMessageDelivery = func(m *protocol.Message) {
@@ -46,7 +51,7 @@ var AfterMessageDelivery = func(m *protocol.Message) {
 // ValidateStoragePath validates the guble configuration with regard to the storagePath
 // (which can be used by MessageStore and/or KVStore implementations).
 var ValidateStoragePath = func() error {
-	if *Config.KVS == fileOption || *Config.MS == fileOption {
+	if *Config.KVS == fileOption || *Config.MS == fileOption || *Config.MS == sqliteOption {
 		testfile := path.Join(*Config.StoragePath, "write-test-file")
 		f, err := os.Create(testfile)
 		if err != nil {
@@ -78,18 +83,7 @@ var CreateKVStore = func() kvstore.KVStore {
 		}
 		return db
 	case "postgres":
-		db := kvstore.NewPostgresKVStore(kvstore.PostgresConfig{
-			ConnParams: map[string]string{
-				"host":     *Config.Postgres.Host,
-				"port":     strconv.Itoa(*Config.Postgres.Port),
-				"user":     *Config.Postgres.User,
-				"password": *Config.Postgres.Password,
-				"dbname":   *Config.Postgres.DbName,
-				"sslmode":  "disable",
-			},
-			MaxIdleConns: 1,
-			MaxOpenConns: runtime.GOMAXPROCS(0),
-		})
+		db := kvstore.NewPostgresKVStore(postgresConfig())
 		if err := db.Open(); err != nil {
 			logger.WithError(err).Panic("Could not open postgres database connection")
 		}
@@ -99,6 +93,22 @@ var CreateKVStore = func() kvstore.KVStore {
 	}
 }
 
+// postgresConfig returns the configuration of the Postgresql connections of the stores
+func postgresConfig() kvstore.PostgresConfig {
+	return kvstore.PostgresConfig{
+		ConnParams: map[string]string{
+			"host":     *Config.Postgres.Host,
+			"port":     strconv.Itoa(*Config.Postgres.Port),
+			"user":     *Config.Postgres.User,
+			"password": *Config.Postgres.Password,
+			"dbname":   *Config.Postgres.DbName,
+			"sslmode":  "disable",
+		},
+		MaxIdleConns: 1,
+		MaxOpenConns: runtime.GOMAXPROCS(0),
+	}
+}
+
 // CreateMessageStore is a func which returns a store.MessageStore implementation
 // (currently, based on guble configuration).
 var CreateMessageStore = func() store.MessageStore {
@@ -107,7 +117,28 @@ var CreateMessageStore = func() store.MessageStore {
 		return dummystore.New(kvstore.NewMemoryKVStore())
 	case "file":
 		logger.WithField("storagePath", *Config.StoragePath).Info("Using FileMessageStore in directory")
-		return filestore.New(*Config.StoragePath)
+		fms := filestore.New(*Config.StoragePath)
+		fms.SetCompression(*Config.MSCompress)
+		if *Config.MSKeyFile != "" {
+			keys, err := filestore.LoadKeys(*Config.MSKeyFile)
+			if err != nil {
+				logger.WithError(err).Panic("Could not load the keys of the file message store")
+			}
+			fms.SetEncryption(keys)
+		}
+		return fms
+	case sqliteOption:
+		db := sqlstore.NewSqliteMessageStore(path.Join(*Config.StoragePath, "message-store.db"), true)
+		if err := db.Open(); err != nil {
+			logger.WithError(err).Panic("Could not open sqlite message store")
+		}
+		return db
+	case "postgres":
+		db := sqlstore.NewPostgresMessageStore(postgresConfig())
+		if err := db.Open(); err != nil {
+			logger.WithError(err).Panic("Could not open postgres message store")
+		}
+		return db
 	default:
 		panic(fmt.Errorf("Unknown message-store backend: %q", *Config.MS))
 	}
@@ -126,6 +157,7 @@ var CreateModules = func(router router.Router) []interface{} {
 	}
 
 	modules = append(modules, rest.NewRestMessageAPI(router, "/api/"))
+	modules = append(modules, scheduler.New(router, "/admin/scheduled"))
 
 	if *Config.FCM.Enabled {
 		logger.Info("Firebase Cloud Messaging: enabled")
@@ -276,7 +308,43 @@ func StartService() *service.Service {
 		logger.Info("Starting in standalone-mode")
 	}
 
-	r := router.New(accessManager, messageStore, kvStore, cl)
+	topicRegistry := topics.NewRegistry(kvStore, "/admin/topics")
+
+	if fms, ok := messageStore.(*filestore.FileMessageStore); ok {
+		fms.SetRetention(filestore.Retention{
+			Default: filestore.RetentionPolicy{
+				MaxAge:      *Config.Retention.MaxAge,
+				MaxMessages: *Config.Retention.MaxMessages,
+				MaxBytes:    *Config.Retention.MaxBytes,
+			},
+			Partition: func(partition string) filestore.RetentionPolicy {
+				config := topicRegistry.Lookup(protocol.Path("/" + partition))
+				if config == nil {
+					return filestore.RetentionPolicy{}
+				}
+				return filestore.RetentionPolicy{
+					MaxAge:      time.Duration(config.StoreMaxAge),
+					MaxMessages: config.StoreMaxMessages,
+					MaxBytes:    config.StoreMaxBytes,
+					Compact:     config.StoreCompact,
+				}
+			},
+		})
+	}
+
+	r := router.New(accessManager, messageStore, kvStore, cl, router.Config{
+		Shards:           *Config.Router.Shards,
+		DeadLetterPrefix: *Config.Router.DeadLetterPrefix,
+		PresencePrefix:   *Config.Router.PresencePrefix,
+		ReplyPrefix:      *Config.Router.ReplyPrefix,
+		Topics:           topicRegistry,
+		RateLimit: router.RateLimitConfig{
+			UserRate:        *Config.Router.UserRateLimit,
+			ApplicationRate: *Config.Router.AppRateLimit,
+			Burst:           *Config.Router.RateLimitBurst,
+		},
+		IdempotencyWindow: *Config.Router.IdempotencyWindow,
+	})
 	websrv := webserver.New(*Config.HttpListen)
 
 	srv := service.New(r, websrv).
@@ -284,6 +352,7 @@ func StartService() *service.Service {
 		MetricsEndpoint(*Config.MetricsEndpoint)
 
 	srv.RegisterModules(0, 6, kvStore, messageStore)
+	srv.RegisterModules(1, 5, topicRegistry)
 	srv.RegisterModules(4, 3, CreateModules(r)...)
 
 	if err = srv.Start(); err != nil {
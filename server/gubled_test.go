@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/sqlstore"
 
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
@@ -47,6 +48,19 @@ func TestCreateKVStoreBackend(t *testing.T) {
 	a.Equal("*kvstore.SqliteKVStore", reflect.TypeOf(sqlite).String())
 }
 
+func TestCreateMessageStoreBackend(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_test")
+	defer os.RemoveAll(dir)
+
+	*Config.MS = "sqlite"
+	*Config.StoragePath = dir
+	sqlite := CreateMessageStore()
+	a.Equal("*sqlstore.SqliteMessageStore", reflect.TypeOf(sqlite).String())
+	a.NoError(sqlite.(*sqlstore.SqliteMessageStore).Check())
+}
+
 func TestFCMOnlyStartedIfEnabled(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -123,13 +137,13 @@ func TestStartServiceModules(t *testing.T) {
 	s := StartService()
 
 	// then the number and ordering of modules should be correct
-	a.Equal(6, len(s.ModulesSortedByStartOrder()))
+	a.Equal(8, len(s.ModulesSortedByStartOrder()))
 	var moduleNames []string
 	for _, iface := range s.ModulesSortedByStartOrder() {
 		name := reflect.TypeOf(iface).String()
 		moduleNames = append(moduleNames, name)
 	}
-	a.Equal("*kvstore.MemoryKVStore *filestore.FileMessageStore *router.router *webserver.WebServer *websocket.WSHandler *rest.RestMessageAPI",
+	a.Equal("*kvstore.MemoryKVStore *filestore.FileMessageStore *topics.Registry *router.router *webserver.WebServer *websocket.WSHandler *rest.RestMessageAPI *scheduler.Scheduler",
 		strings.Join(moduleNames, " "))
 }
 
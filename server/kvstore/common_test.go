@@ -39,6 +39,21 @@ func CommonTestPutGetDelete(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	assertGetNoExist(a, kvs1, "s2", "a")
 }
 
+func CommonTestDeleteIfExists(t *testing.T, kvs1 ExclusiveDeleter, kvs2 KVStore) {
+	a := assert.New(t)
+
+	a.NoError(kvs2.Put("s1", "a", test1))
+
+	deleted, err := kvs1.DeleteIfExists("s1", "a")
+	a.NoError(err)
+	a.True(deleted)
+	assertGetNoExist(a, kvs2, "s1", "a")
+
+	deleted, err = kvs1.DeleteIfExists("s1", "a")
+	a.NoError(err)
+	a.False(deleted)
+}
+
 func CommonTestIterate(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	a := assert.New(t)
 
@@ -109,3 +109,8 @@ func (store *kvStore) IterateKeys(schema string, keyPrefix string) chan string {
 func (store *kvStore) Delete(schema, key string) error {
 	return store.db.Delete(&kvEntry{Schema: schema, Key: key}).Error
 }
+
+func (store *kvStore) DeleteIfExists(schema, key string) (bool, error) {
+	result := store.db.Delete(&kvEntry{Schema: schema, Key: key})
+	return result.RowsAffected > 0, result.Error
+}
@@ -22,3 +22,11 @@ type KVStore interface {
 	// The keys will be sent to the channel, which is closed after the last entry.
 	IterateKeys(schema, keyPrefix string) (keys chan string)
 }
+
+// ExclusiveDeleter is implemented by the KVStores deleting entries atomically,
+// so that of several nodes sharing the store and deleting the same entry, only one deletes it.
+type ExclusiveDeleter interface {
+
+	// DeleteIfExists deletes an entry, and returns true if it existed, i.e. if this call deleted it
+	DeleteIfExists(schema, key string) (bool, error)
+}
@@ -47,6 +47,16 @@ func (kvStore *MemoryKVStore) Delete(schema, key string) error {
 	return nil
 }
 
+// DeleteIfExists implements the `kvstore` ExclusiveDeleter interface.
+func (kvStore *MemoryKVStore) DeleteIfExists(schema, key string) (bool, error) {
+	kvStore.mutex.Lock()
+	defer kvStore.mutex.Unlock()
+	s := kvStore.getSchema(schema)
+	_, exists := s[key]
+	delete(s, key)
+	return exists, nil
+}
+
 // Iterate iterates over the key-value pairs in the schema, with keys matching the keyPrefix.
 // TODO: this can lead to a deadlock, if the consumer modifies the store while receiving and the channel blocks
 func (kvStore *MemoryKVStore) Iterate(schema string, keyPrefix string) chan [2]string {
@@ -9,6 +9,11 @@ func TestMemoryPutGetDelete(t *testing.T) {
 	CommonTestPutGetDelete(t, mkvs, mkvs)
 }
 
+func TestMemoryDeleteIfExists(t *testing.T) {
+	mkvs := NewMemoryKVStore()
+	CommonTestDeleteIfExists(t, mkvs, mkvs)
+}
+
 func TestMemoryIterateKeys(t *testing.T) {
 	mkvs := NewMemoryKVStore()
 	CommonTestIterateKeys(t, mkvs, mkvs)
@@ -30,7 +30,7 @@ func (kvStore *PostgresKVStore) Open() error {
 	logger := kvStore.logger.WithField("config", kvStore.config)
 	logger.Info("Opening database")
 
-	gormdb, err := gorm.Open("postgres", kvStore.config.connectionString())
+	gormdb, err := gorm.Open("postgres", kvStore.config.ConnectionString())
 	if err != nil {
 		logger.WithField("err", err).Error("Error opening database")
 		return err
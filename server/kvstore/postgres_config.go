@@ -10,7 +10,8 @@ type PostgresConfig struct {
 	MaxOpenConns int
 }
 
-func (pc PostgresConfig) connectionString() string {
+// ConnectionString returns the connection parameters in the format of the Postgresql driver.
+func (pc PostgresConfig) ConnectionString() string {
 	var params []string
 	for key, value := range pc.ConnParams {
 		params = append(params, key+"="+value)
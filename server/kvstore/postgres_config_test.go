@@ -8,12 +8,12 @@ import (
 func TestPostgresConfig_String(t *testing.T) {
 	a := assert.New(t)
 	pc0 := PostgresConfig{map[string]string{}, 1, 1}
-	a.Equal(pc0.connectionString(), "")
+	a.Equal(pc0.ConnectionString(), "")
 
 	pc1 := PostgresConfig{map[string]string{"key": "value"}, 1, 1}
-	a.Equal(pc1.connectionString(), "key=value")
+	a.Equal(pc1.ConnectionString(), "key=value")
 
 	pc2 := PostgresConfig{map[string]string{"key": "value", "password": "secret"}, 1, 1}
-	s := pc2.connectionString()
+	s := pc2.ConnectionString()
 	a.True(s == "key=value password=secret" || s == "password=secret key=value")
 }
@@ -24,6 +24,15 @@ func TestSqlitePutGetDelete(t *testing.T) {
 	CommonTestPutGetDelete(t, db, db)
 }
 
+func TestSqliteDeleteIfExists(t *testing.T) {
+	f := tempFilename()
+	defer os.Remove(f)
+
+	db := NewSqliteKVStore(f, false)
+	db.Open()
+	CommonTestDeleteIfExists(t, db, db)
+}
+
 func TestSqliteIterate(t *testing.T) {
 	f := tempFilename()
 	defer os.Remove(f)
@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+const defaultFetchCount = 100
+
+// fetchedMessage is the JSON representation of a fetched message
+type fetchedMessage struct {
+	ID            uint64
+	Path          protocol.Path
+	UserID        string
+	ApplicationID string
+	Time          int64
+	HeaderJSON    string `json:",omitempty"`
+	Body          string
+}
+
+// serveFetch responds with the stored messages of the partition of the topic, published since the time
+// given by the `since` parameter, as a JSON array of at most `count` messages.
+// The user given by the `userId` parameter needs the read access to the topic.
+func (api *RestMessageAPI) serveFetch(w http.ResponseWriter, r *http.Request) {
+	topic, err := api.extractTopic(r.URL.Path, messagePrefix)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	path := protocol.Path(topic)
+	if path.HasWildcard() {
+		http.Error(w, router.ErrWildcardTopic.Error(), http.StatusBadRequest)
+		return
+	}
+
+	since, err := protocol.ParseSince(q(r, "since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := fetchCount(q(r, "count"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if status, err := api.checkReadAccess(q(r, "userId"), path); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	req := store.NewFetchRequest(path.Partition(), 0, 0, store.DirectionForward, count)
+	req.StartTime = since
+	req.Init()
+	if err := api.router.Fetch(req); err != nil {
+		log.WithError(err).WithField("topic", topic).Error("Fetching messages failed")
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	req.Ready()
+
+	messages := []fetchedMessage{}
+	for {
+		select {
+		case fetched, open := <-req.Messages():
+			if !open {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(messages)
+				return
+			}
+			msg, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				log.WithError(err).WithField("id", fetched.ID).Error("Error parsing fetched message")
+				continue
+			}
+			if msg.IsExpired() {
+				continue
+			}
+			messages = append(messages, fetchedMessage{
+				ID:            msg.ID,
+				Path:          msg.Path,
+				UserID:        msg.UserID,
+				ApplicationID: msg.ApplicationID,
+				Time:          msg.Time,
+				HeaderJSON:    msg.HeaderJSON,
+				Body:          string(msg.Body),
+			})
+		case err := <-req.Errors():
+			log.WithError(err).WithField("topic", topic).Error("Fetching messages failed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// checkReadAccess returns an error and its HTTP status code, if the user cannot read the topic
+func (api *RestMessageAPI) checkReadAccess(userID string, path protocol.Path) (int, error) {
+	if userID == "" {
+		return http.StatusBadRequest, fmt.Errorf("userId is required")
+	}
+	accessManager, err := api.router.AccessManager()
+	if err != nil {
+		return statusCode(err), err
+	}
+	if !accessManager.IsAllowed(auth.READ, userID, path) {
+		return http.StatusForbidden, &router.PermissionDeniedError{UserID: userID, AccessType: auth.READ, Path: path}
+	}
+	return 0, nil
+}
+
+// fetchCount returns the maximum number of fetched messages, given as a positive number
+func fetchCount(value string) (int, error) {
+	if value == "" {
+		return defaultFetchCount, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("count has to be a positive number, but was %v", value)
+	}
+	return count, nil
+}
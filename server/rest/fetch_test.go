@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRestMessageAPI_Fetch(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
+		a.Equal("orders", req.Partition)
+		a.Equal(int64(1420110000), req.StartTime)
+		a.Equal(store.DirectionForward, req.Direction)
+		a.Equal(2, req.Count)
+
+		expired := &protocol.Message{ID: 8, Path: "/orders", Time: 1420110000, Expires: time.Now().Add(-time.Minute).Unix()}
+		msg := &protocol.Message{ID: 9, Path: "/orders/eu", UserID: "marvin", ApplicationID: "app", Time: 1420110042,
+			HeaderJSON: `{"Key":"Value"}`, Body: []byte("Hello")}
+		go func() {
+			req.StartC <- 2
+			req.Push(expired.ID, expired.Bytes())
+			req.Push(msg.ID, msg.Bytes())
+			req.Done()
+		}()
+	})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/orders?since=2015-01-01T11:00:00Z&count=2&userId=marvin", nil)
+	api.ServeHTTP(recorder, req)
+
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal("application/json", recorder.Header().Get("Content-Type"))
+	var messages []fetchedMessage
+	a.NoError(json.Unmarshal(recorder.Body.Bytes(), &messages))
+	a.Equal([]fetchedMessage{{ID: 9, Path: "/orders/eu", UserID: "marvin", ApplicationID: "app", Time: 1420110042,
+		HeaderJSON: `{"Key":"Value"}`, Body: "Hello"}}, messages)
+}
+
+func TestRestMessageAPI_FetchErrors(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	for url, status := range map[string]int{
+		"http://localhost/api/message/orders":                          http.StatusBadRequest,
+		"http://localhost/api/message/orders?since=yesterday":          http.StatusBadRequest,
+		"http://localhost/api/message/orders?since=1420110000&count=0": http.StatusBadRequest,
+		"http://localhost/api/message/orders/*?since=1420110000":       http.StatusBadRequest,
+		"http://localhost/api/message/?since=1420110000":               http.StatusNotFound,
+		"http://localhost/api/message/orders?since=1420110000":         http.StatusBadRequest,
+	} {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		api.ServeHTTP(recorder, req)
+		a.Equal(status, recorder.Code, url)
+	}
+
+	// the user needs the read access to the topic
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(false), nil)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/orders?since=1420110000&userId=marvin", nil)
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusForbidden, recorder.Code)
+	a.Contains(recorder.Body.String(), "Access Denied for user=[marvin]")
+
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	routerMock.EXPECT().Fetch(gomock.Any()).Return(&router.ModuleStoppingError{Name: "Router"})
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost/api/message/orders?since=1420110000&userId=marvin", nil)
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusServiceUnavailable, recorder.Code)
+}
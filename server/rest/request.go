@@ -0,0 +1,137 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	requestPrefix = "/request"
+
+	// replyPrefix is the prefix of the temporary topics on which the replies to the requests are received.
+	// Their messages are routed without being stored if the router is configured with this reply prefix.
+	replyPrefix = "/replies/"
+
+	xHeaderReplyTo       = xHeaderPrefix + "reply-to"
+	xHeaderCorrelationID = xHeaderPrefix + "correlation-id"
+
+	defaultRequestTimeout = 10 * time.Second
+	maxRequestTimeout     = time.Minute
+)
+
+// serveRequest publishes a request message, and responds with the first reply having its correlation id.
+// The reply is received on a temporary route, which exists only while waiting for it.
+func (api *RestMessageAPI) serveRequest(w http.ResponseWriter, r *http.Request) {
+	timeout, err := requestTimeout(q(r, "timeout"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	correlationID := r.Header.Get(xHeaderCorrelationID)
+	if correlationID == "" {
+		correlationID = xid.New().String()
+		r.Header.Set(xHeaderCorrelationID, correlationID)
+	}
+	replyTo := protocol.Path(replyPrefix + xid.New().String())
+	r.Header.Set(xHeaderReplyTo, string(replyTo))
+
+	msg, ok := api.readMessage(w, r, requestPrefix)
+	if !ok {
+		return
+	}
+
+	route := router.NewRoute(router.RouteConfig{
+		RouteParams: router.RouteParams{"application_id": msg.ApplicationID, "user_id": msg.UserID},
+		Path:        replyTo,
+		ChannelSize: 10,
+	})
+	if _, err := api.router.Subscribe(route); err != nil {
+		log.WithError(err).WithField("replyTo", replyTo).Error("Subscribing the reply route failed")
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	defer api.router.Unsubscribe(route)
+
+	if err := api.router.HandleMessage(msg); err != nil {
+		log.WithError(err).WithField("topic", msg.Path).Error("Handling request failed")
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	reply, replyErr := waitForReply(route, correlationID, timeout)
+	if replyErr != nil {
+		log.WithError(replyErr).WithFields(log.Fields{
+			"topic":         msg.Path,
+			"correlationID": correlationID,
+		}).Info("No reply to request")
+		http.Error(w, replyErr.Error(), replyErr.status)
+		return
+	}
+	writeReply(w, reply)
+}
+
+// replyError is returned when no reply was received
+type replyError struct {
+	status int
+	reason string
+}
+
+func (e *replyError) Error() string {
+	return e.reason
+}
+
+// waitForReply returns the first message received by the route, which has the correlation id
+func waitForReply(route *router.Route, correlationID string, timeout time.Duration) (*protocol.Message, *replyError) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case reply, opened := <-route.MessagesChannel():
+			if !opened {
+				return nil, &replyError{http.StatusServiceUnavailable, "Reply route closed."}
+			}
+			if reply.CorrelationID() == correlationID {
+				return reply, nil
+			}
+		case <-timer.C:
+			return nil, &replyError{http.StatusGatewayTimeout, fmt.Sprintf("No reply received within %v.", timeout)}
+		}
+	}
+}
+
+// writeReply responds with the body of the reply, and its header fields as `X-Guble-` headers
+func writeReply(w http.ResponseWriter, reply *protocol.Message) {
+	header := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(reply.HeaderJSON), &header); err == nil {
+		for name, value := range header {
+			if s, ok := value.(string); ok {
+				w.Header().Set(xHeaderPrefix+name, s)
+			}
+		}
+	}
+	w.Header().Set(xHeaderMessageID, strconv.FormatUint(reply.ID, 10))
+	w.Write(reply.Body)
+}
+
+// requestTimeout parses the time to wait for a reply, given as a duration or as a number of seconds
+func requestTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultRequestTimeout, nil
+	}
+	timeout, err := protocol.ParseTTL(value)
+	if err != nil || timeout <= 0 || timeout > maxRequestTimeout {
+		return 0, fmt.Errorf("timeout has to be positive and at most %v, but was %v", maxRequestTimeout, value)
+	}
+	return timeout, nil
+}
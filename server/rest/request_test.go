@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRestMessageAPI_Request(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/request/orders?userId=user01", bytes.NewBufferString("question"))
+	a.NoError(err)
+	req.Header.Set("x-guble-correlation-id", "c1")
+	recorder := httptest.NewRecorder()
+
+	var route *router.Route
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) (*router.Route, error) {
+		route = r
+		a.Equal("user01", r.Get("user_id"))
+		a.Contains(string(r.Path), "/replies/")
+		return r, nil
+	})
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal(protocol.Path("/orders"), msg.Path)
+		a.Equal("question", string(msg.Body))
+		a.Equal("c1", msg.CorrelationID())
+		a.Equal(route.Path, msg.ReplyTo())
+
+		// a reply to another request is ignored
+		a.NoError(route.Deliver(&protocol.Message{
+			ID:         1,
+			Path:       route.Path,
+			HeaderJSON: `{"Correlation-Id": "c0"}`,
+			Body:       []byte("other answer"),
+		}, false))
+		a.NoError(route.Deliver(&protocol.Message{
+			ID:         2,
+			Path:       route.Path,
+			HeaderJSON: `{"Correlation-Id": "c1", "Status": "done"}`,
+			Body:       []byte("answer"),
+		}, false))
+		return nil
+	})
+	routerMock.EXPECT().Unsubscribe(gomock.Any())
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal("answer", recorder.Body.String())
+	a.Equal("2", recorder.Header().Get("x-guble-message-id"))
+	a.Equal("c1", recorder.Header().Get("x-guble-correlation-id"))
+	a.Equal("done", recorder.Header().Get("x-guble-status"))
+}
+
+func TestRestMessageAPI_RequestTimeout(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// an invalid timeout is rejected
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/request/orders?timeout=2h", bytes.NewBufferString("question"))
+	a.NoError(err)
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+
+	// and without a reply, the request times out
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/request/orders?timeout=10ms", bytes.NewBufferString("question"))
+	a.NoError(err)
+	recorder = httptest.NewRecorder()
+
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) (*router.Route, error) {
+		return r, nil
+	})
+	routerMock.EXPECT().HandleMessage(gomock.Any())
+	routerMock.EXPECT().Unsubscribe(gomock.Any())
+
+	start := time.Now()
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusGatewayTimeout, recorder.Code)
+	a.True(time.Since(start) >= 10*time.Millisecond)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	timeout, err := requestTimeout("")
+	a.NoError(err)
+	a.Equal(defaultRequestTimeout, timeout)
+
+	timeout, err = requestTimeout("30")
+	a.NoError(err)
+	a.Equal(30*time.Second, timeout)
+
+	timeout, err = requestTimeout("500ms")
+	a.NoError(err)
+	a.Equal(500*time.Millisecond, timeout)
+
+	for _, value := range []string{"0", "-1s", "2m", "soon"} {
+		_, err = requestTimeout(value)
+		a.Error(err, value)
+	}
+}
@@ -14,15 +14,23 @@ import (
 	"bytes"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 const (
-	xHeaderPrefix     = "x-guble-"
-	filterPrefix      = "filter"
-	subscribersPrefix = "/subscribers"
+	xHeaderPrefix         = "x-guble-"
+	xHeaderTTL            = xHeaderPrefix + "ttl"
+	xHeaderIdempotencyKey = xHeaderPrefix + "idempotency-key"
+	xHeaderDeliverAt      = xHeaderPrefix + "deliver-at"
+	xHeaderRetain         = xHeaderPrefix + "retain"
+	xHeaderCompactionKey  = xHeaderPrefix + "compaction-key"
+	xHeaderMessageID      = xHeaderPrefix + "message-id"
+	filterPrefix          = "filter"
+	messagePrefix         = "/message"
+	subscribersPrefix     = "/subscribers"
 )
 
 var errNotFound = errors.New("Not Found.")
@@ -54,6 +62,11 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		log.WithField("url", r.URL.Path).Debug("GET")
 
+		if strings.HasPrefix(r.URL.Path, removeTrailingSlash(api.prefix)+messagePrefix) {
+			api.serveFetch(w, r)
+			return
+		}
+
 		topic, err := api.extractTopic(r.URL.Path, subscribersPrefix)
 		if err != nil {
 			log.WithError(err).Error("Extracting topic failed")
@@ -82,20 +95,44 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, removeTrailingSlash(api.prefix)+requestPrefix) {
+		api.serveRequest(w, r)
+		return
+	}
+
+	msg, ok := api.readMessage(w, r, messagePrefix)
+	if !ok {
+		return
+	}
+
+	if err := api.router.HandleMessage(msg); err != nil {
+		log.WithError(err).WithField("topic", msg.Path).Error("Handling message failed")
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	// a scheduled message has no id until it is delivered
+	if msg.IdempotencyKey != "" && msg.ID != 0 {
+		w.Header().Set(xHeaderMessageID, strconv.FormatUint(msg.ID, 10))
+	}
+	fmt.Fprintf(w, "OK")
+}
+
+// readMessage creates the message published by the request, or writes the error response
+func (api *RestMessageAPI) readMessage(w http.ResponseWriter, r *http.Request, requestTypeTopicPrefix string) (*protocol.Message, bool) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Can not read body", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	topic, err := api.extractTopic(r.URL.Path, "/message")
+	topic, err := api.extractTopic(r.URL.Path, requestTypeTopicPrefix)
 	if err != nil {
 		if err == errNotFound {
 			http.NotFound(w, r)
-			return
+			return nil, false
 		}
 		http.Error(w, "Server error.", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
 	msg := &protocol.Message{
@@ -106,11 +143,72 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		HeaderJSON:    headersToJSON(r.Header),
 	}
 
+	if deliverAt := r.Header.Get(xHeaderDeliverAt); deliverAt != "" {
+		t, err := protocol.ParseDeliverAt(deliverAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		msg.DeliverAt = t
+	}
+
+	// the time-to-live of a scheduled message starts at its delivery time
+	if ttl := r.Header.Get(xHeaderTTL); ttl != "" {
+		d, err := protocol.ParseTTL(ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		msg.SetTTL(d)
+	}
+
+	if key := r.Header.Get(xHeaderIdempotencyKey); key != "" {
+		if err := protocol.ValidateIdempotencyKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		msg.IdempotencyKey = key
+	}
+
+	if retain := r.Header.Get(xHeaderRetain); retain != "" {
+		if msg.Retain, err = strconv.ParseBool(retain); err != nil {
+			http.Error(w, fmt.Sprintf("retain has to be true or false, but was %v", retain), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	if key := r.Header.Get(xHeaderCompactionKey); key != "" {
+		if err := protocol.ValidateCompactionKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		msg.CompactionKey = key
+	}
+
 	// add filters
 	api.setFilters(r, msg)
+	return msg, true
+}
 
-	api.router.HandleMessage(msg)
-	fmt.Fprintf(w, "OK")
+// statusCode returns the HTTP status code for an error returned by the router when handling a message
+func statusCode(err error) int {
+	switch err.(type) {
+	case *router.MessageRejectedError:
+		return http.StatusBadRequest
+	case *router.PermissionDeniedError:
+		return http.StatusForbidden
+	case *router.ModuleStoppingError:
+		return http.StatusServiceUnavailable
+	}
+	switch err {
+	case router.ErrWildcardTopic:
+		return http.StatusBadRequest
+	case router.ErrRateLimitExceeded:
+		return http.StatusTooManyRequests
+	case router.ErrSchedulingDisabled:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
 }
 
 func (api *RestMessageAPI) extractTopic(path string, requestTypeTopicPrefix string) (string, error) {
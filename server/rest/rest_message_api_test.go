@@ -2,6 +2,7 @@ package rest
 
 import (
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -9,6 +10,7 @@ import (
 
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -60,7 +62,7 @@ func TestServeHTTP_GetError(t *testing.T) {
 	defer testutil.EnableDebugForMethod()()
 	api := NewRestMessageAPI(nil, "/api")
 
-	u, _ := url.Parse("http://localhost/api/message/my/topic?userId=marvin&messageId=42")
+	u, _ := url.Parse("http://localhost/api/unknown/my/topic?userId=marvin&messageId=42")
 	// and a http context
 	req := &http.Request{
 		Method: http.MethodGet,
@@ -223,3 +225,228 @@ func TestRestMessageAPI_SetFiltersWhenServing(t *testing.T) {
 
 	time.Sleep(10 * time.Millisecond)
 }
+
+func TestRestMessageAPI_TTLHeader(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// a valid ttl sets the expiry time of the message
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-ttl", "60s")
+	recorder := httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.InDelta(time.Now().Add(time.Minute).Unix(), msg.Expires, 1)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+
+	// an invalid ttl is rejected, and the message is not handled
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-ttl", "soon")
+	recorder = httptest.NewRecorder()
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestRestMessageAPI_RetainHeader(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// the retain header marks the message as retained
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/device/123/state", bytes.NewBufferString("on"))
+	a.NoError(err)
+	req.Header.Set("x-guble-retain", "true")
+	recorder := httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.True(msg.Retain)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+
+	// an invalid value is rejected, and the message is not handled
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/device/123/state", bytes.NewBufferString("on"))
+	a.NoError(err)
+	req.Header.Set("x-guble-retain", "maybe")
+	recorder = httptest.NewRecorder()
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestRestMessageAPI_CompactionKeyHeader(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/devices", bytes.NewBufferString("on"))
+	a.NoError(err)
+	req.Header.Set("x-guble-compaction-key", "device-123")
+	recorder := httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal("device-123", msg.CompactionKey)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+
+	// an invalid key is rejected, and the message is not handled
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/devices", bytes.NewBufferString("on"))
+	a.NoError(err)
+	req.Header.Set("x-guble-compaction-key", "device,123")
+	recorder = httptest.NewRecorder()
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestRestMessageAPI_DeliverAtHeader(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// a valid delivery time is set on the message
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-deliver-at", "2030-01-01T00:00:00Z")
+	recorder := httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal(int64(1893456000), msg.DeliverAt)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+
+	// the time-to-live of a scheduled message starts at its delivery time
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-ttl", "60s")
+	req.Header.Set("x-guble-deliver-at", "2030-01-01T00:00:00Z")
+	recorder = httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal(int64(1893456000), msg.DeliverAt)
+		a.Equal(int64(1893456000+60), msg.Expires)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+
+	// an invalid delivery time is rejected, and the message is not handled
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-deliver-at", "tomorrow")
+	recorder = httptest.NewRecorder()
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestRestMessageAPI_IdempotencyKeyHeader(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// the key is passed to the router, and the id of the message is returned
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-idempotency-key", "order-42")
+	recorder := httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal("order-42", msg.IdempotencyKey)
+		msg.ID = 17
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal("17", recorder.Header().Get("x-guble-message-id"))
+
+	// a scheduled message has no id yet, so the header is left out
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-idempotency-key", "order-43")
+	req.Header.Set("x-guble-deliver-at", "2030-01-01T00:00:00Z")
+	recorder = httptest.NewRecorder()
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) error {
+		a.Equal("order-43", msg.IdempotencyKey)
+		a.Equal(int64(1893456000), msg.DeliverAt)
+		return nil
+	})
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Empty(recorder.Header().Get("x-guble-message-id"))
+
+	// an invalid key is rejected, and the message is not handled
+	req, err = http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+	a.NoError(err)
+	req.Header.Set("x-guble-idempotency-key", "order 42")
+	recorder = httptest.NewRecorder()
+
+	api.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestRestMessageAPI_HandleMessageErrors(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{&router.MessageRejectedError{Reason: "body too large"}, http.StatusBadRequest},
+		{&router.PermissionDeniedError{UserID: "marvin", Path: "/topic"}, http.StatusForbidden},
+		{&router.ModuleStoppingError{Name: "Router"}, http.StatusServiceUnavailable},
+		{router.ErrRateLimitExceeded, http.StatusTooManyRequests},
+		{router.ErrSchedulingDisabled, http.StatusNotImplemented},
+		{errors.New("store failed"), http.StatusInternalServerError},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost/api/message/topic", bytes.NewBufferString("body"))
+		a.NoError(err)
+		recorder := httptest.NewRecorder()
+
+		routerMock.EXPECT().HandleMessage(gomock.Any()).Return(tc.err)
+		api.ServeHTTP(recorder, req)
+
+		a.Equal(tc.status, recorder.Code)
+		a.Contains(recorder.Body.String(), tc.err.Error())
+	}
+}
@@ -0,0 +1,232 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store/backup"
+)
+
+// routeInfo describes the state of a route in the admin API
+type routeInfo struct {
+	Key           string
+	Params        RouteParams
+	Group         string `json:",omitempty"`
+	QueueLength   int
+	ChannelLength int
+	Consuming     bool
+	Invalid       bool
+}
+
+// importResponse is the result of an import in the admin API, with the error which stopped it
+type importResponse struct {
+	backup.ImportResult
+	Error string `json:",omitempty"`
+}
+
+// partitionStats counts the activity of a partition in the router
+type partitionStats struct {
+	Messages  int64
+	Overloads int64
+	Routes    int
+}
+
+// partitionCounters holds the stats of the partitions, by partition name
+type partitionCounters struct {
+	stats map[string]*partitionStats
+	sync.Mutex
+}
+
+// add counts a message handled in the partition, and an overload event if the handling shard was overloaded
+func (c *partitionCounters) add(partition string, overloaded bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	stats, ok := c.stats[partition]
+	if !ok {
+		stats = &partitionStats{}
+		c.stats[partition] = stats
+	}
+	stats.Messages++
+	if overloaded {
+		stats.Overloads++
+	}
+}
+
+// snapshot returns a copy of the stats of all partitions
+func (c *partitionCounters) snapshot() map[string]partitionStats {
+	c.Lock()
+	defer c.Unlock()
+
+	snapshot := make(map[string]partitionStats, len(c.stats))
+	for partition, stats := range c.stats {
+		snapshot[partition] = *stats
+	}
+	return snapshot
+}
+
+// ServeHTTP serves the admin API of the router:
+//
+//	GET    <prefix>                   lists the routes grouped by path, as they are configured
+//	GET    <prefix>/routes            lists the routes grouped by path, with their state
+//	DELETE <prefix>/routes?key=<key>  resets the route having the key: it is closed, and its subscriber subscribes again
+//	GET    <prefix>/partitions        returns the counters of each partition
+//	GET    <prefix>/presence          returns the users subscribed to each topic, or to the topic given with `?topic=`
+//	GET    <prefix>/export            exports the messages of the partitions given with `?partition=`, or of all
+//	                                  the partitions, as a stream of JSON records
+//	POST   <prefix>/import            imports the exported messages of the request body into the message store
+func (router *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch path := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, prefix), "/"); {
+	case req.Method == http.MethodGet && path == "":
+		router.writeJSON(w, router.routes())
+	case req.Method == http.MethodGet && path == "/routes":
+		router.writeJSON(w, router.routeInfos())
+	case req.Method == http.MethodDelete && path == "/routes":
+		router.resetRoute(w, req.URL.Query().Get("key"))
+	case req.Method == http.MethodGet && path == "/partitions":
+		router.writeJSON(w, router.partitionStats())
+	case req.Method == http.MethodGet && path == "/presence":
+		router.writeJSON(w, router.presentUsers(protocol.Path(req.URL.Query().Get("topic"))))
+	case req.Method == http.MethodGet && path == "/export":
+		router.exportMessages(w, req.URL.Query()["partition"])
+	case req.Method == http.MethodPost && path == "/import":
+		router.importMessages(w, req.Body)
+	case path == "" || path == "/routes" || path == "/partitions" || path == "/presence" ||
+		path == "/export" || path == "/import":
+		http.Error(w, `{"error":"Method not allowed."}`, http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, `{"error":"Not found."}`, http.StatusNotFound)
+	}
+}
+
+func (router *router) GetPrefix() string {
+	return prefix
+}
+
+func (router *router) writeJSON(w http.ResponseWriter, value interface{}) {
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, `{"error":"Error encoding data."}`, http.StatusInternalServerError)
+		logger.WithField("error", err.Error()).Error("Error encoding data.")
+	}
+}
+
+// routeInfos returns the state of all the routes, grouped by path
+func (router *router) routeInfos() map[protocol.Path][]routeInfo {
+	infos := make(map[protocol.Path][]routeInfo)
+	for path, routes := range router.routes() {
+		for _, r := range routes {
+			infos[path] = append(infos[path], routeInfo{
+				Key:           r.Key(),
+				Params:        r.RouteParams,
+				Group:         r.Group,
+				QueueLength:   r.queue.size(),
+				ChannelLength: len(r.messagesC),
+				Consuming:     r.isConsuming(),
+				Invalid:       r.isInvalid(),
+			})
+		}
+	}
+	return infos
+}
+
+// partitionStats returns the counters of the partitions, including the number of their current routes
+func (router *router) partitionStats() map[string]partitionStats {
+	stats := router.partitionCounters.snapshot()
+	for path, routes := range router.routes() {
+		partition := path.Partition()
+		partitionStats := stats[partition]
+		partitionStats.Routes += len(routes)
+		stats[partition] = partitionStats
+	}
+	return stats
+}
+
+// resetRoute unsubscribes and closes the route having the key, so that its subscriber is notified.
+// As for a slow consumer, the websocket receivers and the connectors subscribe again right away,
+// after fetching the messages they missed, so the route is reset rather than removed.
+func (router *router) resetRoute(w http.ResponseWriter, key string) {
+	if key == "" {
+		http.Error(w, `{"error":"Missing route key."}`, http.StatusBadRequest)
+		return
+	}
+	for _, routes := range router.routes() {
+		for _, r := range routes {
+			if r.Key() == key {
+				logger.WithField("route", r).Info("Resetting route by admin request")
+				router.Unsubscribe(r)
+				r.Close()
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, "Route not found: "+key), http.StatusNotFound)
+}
+
+// exportMessages writes the messages of the partitions from the message store, while the router is running
+func (router *router) exportMessages(w http.ResponseWriter, partitions []string) {
+	if err := router.isStopping(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	ms, err := router.MessageStore()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	count, err := backup.Export(w, ms, partitions)
+	if err != nil {
+		// the response can be changed only if no message was written
+		if count == 0 {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusInternalServerError)
+		}
+		return
+	}
+	logger.WithField("messages", count).Info("Exported messages by admin request")
+}
+
+// importMessages stores the exported messages in the message store, while the router is running.
+// The messages are not delivered to the subscribers.
+func (router *router) importMessages(w http.ResponseWriter, body io.Reader) {
+	if err := router.isStopping(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	ms, err := router.MessageStore()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+
+	var nodeID uint8
+	if router.cluster != nil {
+		nodeID = router.cluster.Config.ID
+	}
+	result, err := backup.Import(body, ms, nodeID)
+	logger.WithFields(log.Fields{
+		"messages":   result.Messages,
+		"renumbered": result.Renumbered,
+	}).Info("Imported messages by admin request")
+
+	response := importResponse{ImportResult: result}
+	if err != nil {
+		response.Error = err.Error()
+		if _, ok := err.(*backup.InvalidRecordError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	router.writeJSON(w, response)
+}
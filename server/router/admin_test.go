@@ -0,0 +1,164 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/backup"
+	"github.com/smancke/guble/server/store/filestore"
+)
+
+func TestRouter_AdminRoutesAndPartitions(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes
+	router, r := aRouterRoute(chanSize)
+	other, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"user_id": "user02"},
+		Path:        protocol.Path("/other/topic"),
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+
+	// and messages handled in the partitions
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah/sub", Body: aTestByteMessage}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: other.Path, Body: aTestByteMessage}))
+	time.Sleep(10 * time.Millisecond)
+
+	request := func(method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		a.NoError(err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// when listing the routes
+	w := request(http.MethodGet, "/admin/router/routes")
+	a.Equal(http.StatusOK, w.Code)
+
+	// then they are grouped by path, with their state
+	var routes map[protocol.Path][]routeInfo
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &routes))
+	a.Equal(2, len(routes))
+	if a.Len(routes[r.Path], 1) {
+		info := routes[r.Path][0]
+		a.Equal(r.Key(), info.Key)
+		a.Equal(r.RouteParams, info.Params)
+		a.Equal(2, info.QueueLength+info.ChannelLength)
+		a.False(info.Invalid)
+	}
+	a.Equal(other.Key(), routes[other.Path][0].Key)
+
+	// and the partitions have their counters
+	w = request(http.MethodGet, "/admin/router/partitions")
+	a.Equal(http.StatusOK, w.Code)
+	var partitions map[string]partitionStats
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &partitions))
+	a.Equal(map[string]partitionStats{
+		"blah":  {Messages: 2, Routes: 1},
+		"other": {Messages: 1, Routes: 1},
+	}, partitions)
+
+	// and the routes are listed as they are configured at the prefix
+	w = request(http.MethodGet, "/admin/router")
+	a.Equal(http.StatusOK, w.Code)
+	var configs map[protocol.Path][]RouteConfig
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &configs))
+	a.Equal(2, len(configs))
+	if a.Len(configs[r.Path], 1) {
+		a.Equal(r.Path, configs[r.Path][0].Path)
+		a.Equal(r.RouteParams, configs[r.Path][0].RouteParams)
+	}
+
+	// when resetting a route
+	w = request(http.MethodDelete, "/admin/router/routes?key="+url.QueryEscape(r.Key()))
+	a.Equal(http.StatusNoContent, w.Code)
+
+	// then it is closed and removed
+	for range r.MessagesChannel() {
+	}
+	a.True(r.isInvalid())
+	w = request(http.MethodGet, "/admin/router/routes")
+	routes = nil
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &routes))
+	a.Equal(1, len(routes))
+	a.Contains(routes, other.Path)
+
+	// and unknown routes and methods are reported
+	a.Equal(http.StatusNotFound, request(http.MethodDelete, "/admin/router/routes?key="+url.QueryEscape(r.Key())).Code)
+	a.Equal(http.StatusBadRequest, request(http.MethodDelete, "/admin/router/routes").Code)
+	a.Equal(http.StatusMethodNotAllowed, request(http.MethodPost, "/admin/router/routes").Code)
+	a.Equal(http.StatusNotFound, request(http.MethodGet, "/admin/router/unknown").Code)
+}
+
+func TestRouter_AdminExportImport(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_router_test")
+	defer os.RemoveAll(dir)
+
+	// Given a Router with a file message store, and messages in two partitions
+	router := New(auth.NewAllowAllAccessManager(true), filestore.New(dir), kvstore.NewMemoryKVStore(), nil, Config{}).(*router)
+	a.NoError(router.Start())
+	defer router.Stop()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/foo/1", Body: aTestByteMessage}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/bar", Body: aTestByteMessage}))
+
+	request := func(method, url string, body io.Reader) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, body)
+		a.NoError(err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// when exporting a partition
+	w := request(http.MethodGet, "/admin/router/export?partition=foo", nil)
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	// then its messages are written as records
+	export := w.Body.String()
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	a.Equal(1, len(lines))
+	var record backup.Record
+	a.NoError(json.Unmarshal([]byte(lines[0]), &record))
+	a.Equal("foo", record.Partition)
+
+	// when importing the export again
+	w = request(http.MethodPost, "/admin/router/import", bytes.NewBufferString(export))
+
+	// then the message is stored with a new id
+	a.Equal(http.StatusOK, w.Code)
+	var response importResponse
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	a.Equal(importResponse{ImportResult: backup.ImportResult{Messages: 1, Renumbered: 1}}, response)
+	maxID, err := router.messageStore.MaxMessageID("foo")
+	a.NoError(err)
+	a.True(maxID > record.ID)
+
+	// and invalid imports and methods are reported
+	w = request(http.MethodPost, "/admin/router/import", bytes.NewBufferString(export+"{"))
+	a.Equal(http.StatusBadRequest, w.Code)
+	response = importResponse{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	a.Equal(1, response.Messages)
+	a.Equal("Invalid record 2: unexpected EOF", response.Error)
+	a.Equal(http.StatusMethodNotAllowed, request(http.MethodGet, "/admin/router/import", nil).Code)
+	a.Equal(http.StatusMethodNotAllowed, request(http.MethodPost, "/admin/router/export", nil).Code)
+}
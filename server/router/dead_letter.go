@@ -0,0 +1,115 @@
+package router
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// headers added to the messages republished on a dead-letter topic
+const (
+	DeadLetterReasonHeader     = "dead-letter-reason"
+	DeadLetterAttemptsHeader   = "dead-letter-attempts"
+	DeadLetterSubscriberHeader = "dead-letter-subscriber"
+	DeadLetterTopicHeader      = "dead-letter-topic"
+	DeadLetterMessageIDHeader  = "dead-letter-message-id"
+)
+
+// connectorParam is the route param naming the connector of a route, which is the source of its dead letters
+const connectorParam = "connector"
+
+// DeadLetter describes a message which could not be delivered to a subscriber
+type DeadLetter struct {
+	// the undeliverable message
+	Message *protocol.Message
+
+	// the name of the component which failed delivering the message, e.g. the connector name
+	Source string
+
+	// the reason of the failure
+	Reason string
+
+	// the number of delivery attempts, or 0 if it is not known,
+	// e.g. because the push service client retries internally
+	Attempts int
+
+	// the key of the subscriber (or route) which did not receive the message
+	SubscriberKey string
+}
+
+// DeadLetterer is implemented by a Router which republishes undeliverable messages on dead-letter topics.
+// The topic of a dead letter is `<prefix>/<source>/<original-topic>`.
+type DeadLetterer interface {
+	DeadLetter(DeadLetter)
+}
+
+// DeadLetter republishes the message on its dead-letter topic, if a dead-letter prefix is configured.
+// The dead letter is published by the router itself, so it is not subject to the checks of the original publisher.
+// The publishing is asynchronous, so that it can be called from the dispatch loops.
+// Messages already published on a dead-letter topic are not republished, to avoid loops.
+func (router *router) DeadLetter(dl DeadLetter) {
+	if router.deadLetterPrefix == "" || dl.Message == nil || router.deadLetterPrefix.Matches(dl.Message.Path) {
+		return
+	}
+	mTotalDeadLetters.Add(1)
+
+	message := dl.message(router.deadLetterPrefix)
+	go func() {
+		if err := router.publishInternal(message); err != nil {
+			logger.WithError(err).WithField("path", message.Path).Error("Error publishing dead letter")
+		}
+	}()
+}
+
+// isDeadLetter returns true if the delivery error of the message to the route makes it a dead letter.
+// Only the routes of the connectors are concerned, since the other subscribers fetch the missed messages again
+// when they reconnect, and the routes closed in the meantime are not delivery failures.
+func isDeadLetter(route *Route, err error) bool {
+	return err != nil && err != ErrInvalidRoute && route.Get(connectorParam) != ""
+}
+
+// topic returns the dead-letter topic of the message under the prefix
+func (dl DeadLetter) topic(prefix protocol.Path) protocol.Path {
+	source := dl.Source
+	if source == "" {
+		source = "router"
+	}
+	return protocol.Path(strings.TrimSuffix(string(prefix), "/") + "/" + source + "/" + dl.Message.Path.RemovePrefixSlash())
+}
+
+// message returns the message to be published on the dead-letter topic,
+// having the original headers and body, and headers describing the failure
+func (dl DeadLetter) message(prefix protocol.Path) *protocol.Message {
+	original := dl.Message
+
+	headers := make(map[string]interface{})
+	if original.HeaderJSON != "" {
+		if err := json.Unmarshal([]byte(original.HeaderJSON), &headers); err != nil {
+			logger.WithError(err).WithField("header", original.HeaderJSON).Warn("Dropping invalid header of dead letter")
+		}
+	}
+	headers[DeadLetterReasonHeader] = dl.Reason
+	if dl.Attempts > 0 {
+		headers[DeadLetterAttemptsHeader] = strconv.Itoa(dl.Attempts)
+	}
+	headers[DeadLetterSubscriberHeader] = dl.SubscriberKey
+	headers[DeadLetterTopicHeader] = string(original.Path)
+	headers[DeadLetterMessageIDHeader] = strconv.FormatUint(original.ID, 10)
+
+	headerJSON, err := json.Marshal(headers)
+	if err != nil {
+		logger.WithFields(log.Fields{"error": err, "headers": headers}).Error("Error encoding dead letter header")
+	}
+
+	return &protocol.Message{
+		Path:          dl.topic(prefix),
+		UserID:        original.UserID,
+		ApplicationID: original.ApplicationID,
+		HeaderJSON:    string(headerJSON),
+		Body:          original.Body,
+	}
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRouter_DeadLetterOfFullRoute(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a dead-letter prefix
+	router, _, _, _ := aStartedRouter()
+	router.deadLetterPrefix = "/dlq"
+
+	// and a subscriber of the dead letters
+	dlq, err := router.Subscribe(NewRoute(RouteConfig{
+		Path:        protocol.Path("/dlq/#"),
+		ChannelSize: 10,
+	}))
+	a.NoError(err)
+
+	// and a connector route, which is not consumed
+	route, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"connector": "fcm", "user_id": "user01"},
+		Path:        protocol.Path("/blah"),
+		ChannelSize: 1,
+	}))
+	a.NoError(err)
+	route.timeout = 5 * time.Millisecond
+
+	// when I send one more message than the channel size
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("first")}))
+	a.NoError(router.HandleMessage(&protocol.Message{
+		Path:       "/blah",
+		UserID:     "publisher",
+		HeaderJSON: `{"correlationId":"42"}`,
+		Body:       []byte("second"),
+	}))
+
+	// then the undeliverable message is republished on the dead-letter topic of the connector
+	select {
+	case m := <-dlq.MessagesChannel():
+		a.Equal(protocol.Path("/dlq/fcm/blah"), m.Path)
+		a.Equal("publisher", m.UserID)
+		a.Equal("second", string(m.Body))
+
+		var headers map[string]string
+		a.NoError(json.Unmarshal([]byte(m.HeaderJSON), &headers))
+		a.Equal("42", headers["correlationId"])
+		a.Equal(ErrChannelFull.Error(), headers[DeadLetterReasonHeader])
+		_, hasAttempts := headers[DeadLetterAttemptsHeader]
+		a.False(hasAttempts)
+		a.Equal(route.Key(), headers[DeadLetterSubscriberHeader])
+		a.Equal("/blah", headers[DeadLetterTopicHeader])
+		a.NotEmpty(headers[DeadLetterMessageIDHeader])
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("No dead letter received")
+	}
+}
+
+func TestRouter_DeadLetterIgnored(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	// Given a Router without a dead-letter prefix, and a message store not expecting any message
+	router, _, _, _ := aStartedRouter()
+	router.messageStore = NewMockMessageStore(ctrl)
+
+	// when dead letters are passed, then nothing is published
+	router.DeadLetter(DeadLetter{Message: &protocol.Message{Path: "/blah"}, Reason: "failed"})
+
+	// and dead letters of dead letters are not republished either
+	router.deadLetterPrefix = "/dlq"
+	router.DeadLetter(DeadLetter{Message: &protocol.Message{Path: "/dlq/fcm/blah"}, Reason: "failed"})
+
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestDeadLetter_topic(t *testing.T) {
+	a := assert.New(t)
+
+	dl := DeadLetter{Message: &protocol.Message{Path: "/orders/42"}, Source: "apns"}
+	a.Equal(protocol.Path("/dlq/apns/orders/42"), dl.topic("/dlq"))
+	a.Equal(protocol.Path("/dlq/apns/orders/42"), dl.topic("/dlq/"))
+
+	dl.Source = ""
+	a.Equal(protocol.Path("/dlq/router/orders/42"), dl.topic("/dlq"))
+}
+
+func TestIsDeadLetter(t *testing.T) {
+	a := assert.New(t)
+
+	connectorRoute := NewRoute(RouteConfig{RouteParams: RouteParams{"connector": "fcm"}, Path: "/blah"})
+	a.True(isDeadLetter(connectorRoute, ErrChannelFull))
+	a.False(isDeadLetter(connectorRoute, ErrInvalidRoute))
+	a.False(isDeadLetter(connectorRoute, nil))
+
+	// the other subscribers fetch the missed messages again
+	wsRoute := NewRoute(RouteConfig{RouteParams: RouteParams{"user_id": "user01"}, Path: "/blah"})
+	a.False(isDeadLetter(wsRoute, ErrChannelFull))
+}
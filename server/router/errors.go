@@ -22,6 +22,15 @@ var (
 
 	// ErrQueueFull is returned when trying to `Deliver` a message in a full queued route
 	ErrQueueFull = errors.New("Route queue is full. Route is closed.")
+
+	// ErrWildcardTopic is returned when trying to publish a message on a topic containing wildcards
+	ErrWildcardTopic = errors.New("Messages cannot be published on a wildcard topic.")
+
+	// ErrRateLimitExceeded is returned when a user or an application publishes faster than allowed
+	ErrRateLimitExceeded = errors.New("Publish rate limit exceeded.")
+
+	// ErrSchedulingDisabled is returned when a message with a future delivery time is published without a scheduler
+	ErrSchedulingDisabled = errors.New("Scheduled delivery is not enabled.")
 )
 
 // PermissionDeniedError is returned when AccessManager denies a user request for a topic
@@ -41,6 +50,17 @@ func (e *PermissionDeniedError) Error() string {
 	return fmt.Sprintf("Access Denied for user=[%s] on path=[%s] for Operation=[%s]", e.UserID, e.Path, e.AccessType)
 }
 
+// MessageRejectedError is returned when an interceptor rejected a message before storing it
+type MessageRejectedError struct {
+
+	// the reason given by the interceptor
+	Reason string
+}
+
+func (e *MessageRejectedError) Error() string {
+	return fmt.Sprintf("Message rejected: %s", e.Reason)
+}
+
 // ModuleStoppingError is returned when the module is stopping
 type ModuleStoppingError struct {
 	Name string
@@ -0,0 +1,398 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FilterExpression is a boolean expression on the filters of a message, which a route can use
+// to select the messages it delivers. Example:
+//
+//	symbol in (A, B) and change > 2
+//
+// The supported comparisons are `=`, `!=`, `in (...)`, `not in (...)`, `prefix`, and the numeric
+// comparisons `<`, `<=`, `>`, `>=`. They can be combined with `and`, `or`, `not` and parentheses.
+// Values containing spaces or operator characters can be quoted with single or double quotes.
+type FilterExpression struct {
+	source string
+	root   filterNode
+	keys   map[string]bool
+}
+
+// ParseFilterExpression parses the given filter expression
+func ParseFilterExpression(source string) (*FilterExpression, error) {
+	tokens, err := tokenizeFilter(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, keys: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q in filter expression at position %d", t.text, t.pos)
+	}
+	return &FilterExpression{source: source, root: root, keys: p.keys}, nil
+}
+
+// Matches returns true if the expression is true for the given message filters
+func (e *FilterExpression) Matches(filters map[string]string) bool {
+	return e.root.matches(filters)
+}
+
+// String returns the source of the expression
+func (e *FilterExpression) String() string {
+	return e.source
+}
+
+// MarshalText returns the source of the expression, so that it can be stored as JSON
+func (e *FilterExpression) MarshalText() ([]byte, error) {
+	return []byte(e.source), nil
+}
+
+// UnmarshalText parses the expression from its source
+func (e *FilterExpression) UnmarshalText(text []byte) error {
+	parsed, err := ParseFilterExpression(string(text))
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+// references returns true if the filter key is used in the expression
+func (e *FilterExpression) references(key string) bool {
+	return e != nil && e.keys[key]
+}
+
+type filterNode interface {
+	matches(filters map[string]string) bool
+}
+
+type andNode struct {
+	left, right filterNode
+}
+
+func (n andNode) matches(filters map[string]string) bool {
+	return n.left.matches(filters) && n.right.matches(filters)
+}
+
+type orNode struct {
+	left, right filterNode
+}
+
+func (n orNode) matches(filters map[string]string) bool {
+	return n.left.matches(filters) || n.right.matches(filters)
+}
+
+type notNode struct {
+	node filterNode
+}
+
+func (n notNode) matches(filters map[string]string) bool {
+	return !n.node.matches(filters)
+}
+
+type comparisonNode struct {
+	key    string
+	op     string
+	values []string
+	number float64
+}
+
+func (n comparisonNode) matches(filters map[string]string) bool {
+	value, ok := filters[n.key]
+	switch n.op {
+	case "=":
+		return ok && equalValues(value, n.values[0])
+	case "!=":
+		return !ok || !equalValues(value, n.values[0])
+	case "in":
+		return ok && n.contains(value)
+	case "not in":
+		return !ok || !n.contains(value)
+	case "prefix":
+		return ok && strings.HasPrefix(value, n.values[0])
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if !ok || err != nil {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return number < n.number
+	case "<=":
+		return number <= n.number
+	case ">":
+		return number > n.number
+	case ">=":
+		return number >= n.number
+	}
+	return false
+}
+
+func (n comparisonNode) contains(value string) bool {
+	for _, v := range n.values {
+		if equalValues(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares the values as numbers if both are numeric, and as strings otherwise
+func equalValues(a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	return errX == nil && errY == nil && x == y
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// isKeyword returns true if the token is the given keyword, which is case-insensitive
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+const filterOperatorChars = "=!<>"
+
+func tokenizeFilter(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLeftParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRightParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter expression at position %d", i)
+			}
+			tokens = append(tokens, token{tokenString, string(runes[i+1 : end]), i})
+			i = end + 1
+		case strings.ContainsRune(filterOperatorChars, r):
+			end := i + 1
+			for end < len(runes) && strings.ContainsRune(filterOperatorChars, runes[end]) {
+				end++
+			}
+			op := string(runes[i:end])
+			switch op {
+			case "=", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q in filter expression at position %d", op, i)
+			}
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i = end
+		default:
+			end := i + 1
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("(),'\""+filterOperatorChars, runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:end]), i})
+			i = end
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(runes)}), nil
+}
+
+// filterParser is a recursive descent parser of the grammar:
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = key ( operator value | [ "not" ] "in" "(" value { "," value } ")" | "prefix" value )
+type filterParser struct {
+	tokens []token
+	next   int
+	keys   map[string]bool
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) consume() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *filterParser) expect(kind tokenKind, what string) (token, error) {
+	t := p.consume()
+	if t.kind != kind {
+		return t, p.unexpected(t, what)
+	}
+	return t, nil
+}
+
+func (p *filterParser) unexpected(t token, what string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("filter expression ends, but %s was expected", what)
+	}
+	return fmt.Errorf("expected %s in filter expression at position %d, but was %q", what, t.pos, t.text)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.consume()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.consume()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	switch t := p.peek(); {
+	case t.isKeyword("not"):
+		p.consume()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case t.kind == tokenLeftParen:
+		p.consume()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightParen, "')'"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	key := p.consume()
+	if key.kind != tokenWord && key.kind != tokenString {
+		return nil, p.unexpected(key, "a filter key")
+	}
+	p.keys[key.text] = true
+	node := comparisonNode{key: key.text}
+
+	switch t := p.consume(); {
+	case t.kind == tokenOperator:
+		node.op = t.text
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.values = []string{value}
+		if node.op != "=" && node.op != "!=" {
+			if node.number, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("operator %s requires a number in filter expression, but was %q", node.op, value)
+			}
+		}
+	case t.isKeyword("prefix"):
+		node.op = "prefix"
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.values = []string{value}
+	case t.isKeyword("not"):
+		if in := p.consume(); !in.isKeyword("in") {
+			return nil, p.unexpected(in, "'in'")
+		}
+		node.op = "not in"
+		return p.parseValueList(node)
+	case t.isKeyword("in"):
+		node.op = "in"
+		return p.parseValueList(node)
+	default:
+		return nil, p.unexpected(t, "an operator")
+	}
+	return node, nil
+}
+
+func (p *filterParser) parseValueList(node comparisonNode) (filterNode, error) {
+	if _, err := p.expect(tokenLeftParen, "'('"); err != nil {
+		return nil, err
+	}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.values = append(node.values, value)
+
+		t := p.consume()
+		if t.kind == tokenRightParen {
+			return node, nil
+		}
+		if t.kind != tokenComma {
+			return nil, p.unexpected(t, "',' or ')'")
+		}
+	}
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	t := p.consume()
+	if t.kind != tokenWord && t.kind != tokenString {
+		return "", p.unexpected(t, "a value")
+	}
+	return t.text, nil
+}
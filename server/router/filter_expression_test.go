@@ -0,0 +1,148 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestFilterExpression_Matches(t *testing.T) {
+	a := assert.New(t)
+
+	filters := map[string]string{
+		"symbol": "A",
+		"change": "2.5",
+		"region": "eu-west",
+		"name":   "Foo Bar",
+	}
+
+	testcases := []struct {
+		expression string
+		matches    bool
+	}{
+		{"symbol = A", true},
+		{"symbol = B", false},
+		{"symbol != B", true},
+		{"symbol != A", false},
+		{"missing != A", true},
+		{"missing = A", false},
+		{"symbol in (A, B)", true},
+		{"symbol in (B,C)", false},
+		{"symbol not in (B, C)", true},
+		{"symbol NOT IN (A)", false},
+		{"missing in (A)", false},
+		{"region prefix eu-", true},
+		{"region prefix us-", false},
+		{"change > 2", true},
+		{"change >= 2.5", true},
+		{"change < 2.5", false},
+		{"change <= 3", true},
+		{"change = 2.50", true},
+		{"symbol > 2", false},
+		{"missing > 2", false},
+		{"name = 'Foo Bar'", true},
+		{`name = "Foo"`, false},
+		{"symbol in (A,B) and change > 2", true},
+		{"symbol in (A,B) and change > 3", false},
+		{"symbol = B or change > 2", true},
+		{"symbol = B or change > 3", false},
+		{"not symbol = B", true},
+		{"not (symbol = A and change > 2)", false},
+		{"symbol = B and change > 2 or region prefix eu", true},
+		{"symbol = B and (change > 2 or region prefix eu)", false},
+	}
+
+	for _, tc := range testcases {
+		expression, err := ParseFilterExpression(tc.expression)
+		if a.NoError(err, tc.expression) {
+			a.Equal(tc.matches, expression.Matches(filters), tc.expression)
+		}
+	}
+}
+
+func TestFilterExpression_ParseErrors(t *testing.T) {
+	a := assert.New(t)
+
+	for _, source := range []string{
+		"",
+		"symbol",
+		"symbol =",
+		"symbol == A",
+		"symbol => A",
+		"symbol in A",
+		"symbol in (A",
+		"symbol in (A B)",
+		"symbol not A",
+		"change > two",
+		"(symbol = A",
+		"symbol = A)",
+		"symbol = A and",
+		"symbol = A or or change > 2",
+		"name = 'Foo",
+		"= A",
+	} {
+		_, err := ParseFilterExpression(source)
+		a.Error(err, source)
+	}
+}
+
+func TestFilterExpression_JSON(t *testing.T) {
+	a := assert.New(t)
+
+	expression, err := ParseFilterExpression("symbol in (A,B) and change > 2")
+	a.NoError(err)
+
+	data, err := json.Marshal(struct{ Filter *FilterExpression }{expression})
+	a.NoError(err)
+	a.Equal(`{"Filter":"symbol in (A,B) and change \u003e 2"}`, string(data))
+
+	var decoded struct{ Filter *FilterExpression }
+	a.NoError(json.Unmarshal(data, &decoded))
+	a.Equal(expression.String(), decoded.Filter.String())
+	a.True(decoded.Filter.Matches(map[string]string{"symbol": "B", "change": "3"}))
+
+	a.Error(json.Unmarshal([]byte(`{"Filter":"symbol in"}`), &decoded))
+}
+
+func TestRoute_messageFilterWithExpression(t *testing.T) {
+	a := assert.New(t)
+
+	expression, err := ParseFilterExpression("symbol in (A,B) and change > 2")
+	a.NoError(err)
+
+	route := NewRoute(RouteConfig{
+		Path:             "/prices",
+		ChannelSize:      1,
+		RouteParams:      RouteParams{"user_id": "user01"},
+		FilterExpression: expression,
+	})
+
+	message := func(filters map[string]string) *protocol.Message {
+		return &protocol.Message{ID: 1, Path: "/prices", Filters: filters}
+	}
+
+	// the expression has to match the message filters
+	msg := message(map[string]string{"symbol": "A", "change": "3"})
+	route.Deliver(msg, true)
+	a.True(isMessageReceived(route, msg))
+
+	msg = message(map[string]string{"symbol": "C", "change": "3"})
+	route.Deliver(msg, true)
+	a.False(isMessageReceived(route, msg))
+
+	msg = message(nil)
+	route.Deliver(msg, true)
+	a.False(isMessageReceived(route, msg))
+
+	// the other message filters are still checked against the route params
+	msg = message(map[string]string{"symbol": "B", "change": "5", "user_id": "user01"})
+	route.Deliver(msg, true)
+	a.True(isMessageReceived(route, msg))
+
+	msg = message(map[string]string{"symbol": "B", "change": "5", "user_id": "user02"})
+	route.Deliver(msg, true)
+	a.False(isMessageReceived(route, msg))
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// groupKey identifies the consumer group of a route: the routes sharing a group name on the same path
+func (r *Route) groupKey() string {
+	return r.Group + " " + string(r.Path)
+}
+
+// load returns the number of messages waiting to be consumed by the route
+func (r *Route) load() int {
+	return r.queue.size() + len(r.messagesC)
+}
+
+// deliverToGroup delivers the message to a single member of a consumer group, the least loaded one.
+// The search starts after the member chosen last, so that the members having the same load take turns.
+// If the delivery fails, the message is delivered to another member.
+func (s *shard) deliverToGroup(key string, members []*Route, message *protocol.Message) {
+	candidates := make([]*Route, 0, len(members))
+	for _, r := range members {
+		if r.messageFilter(message) {
+			candidates = append(candidates, r)
+		}
+	}
+
+	var err error
+	for len(candidates) > 0 {
+		start := s.groupCursors[key] % len(candidates)
+		chosen := start
+		for i := 1; i < len(candidates); i++ {
+			if j := (start + i) % len(candidates); candidates[j].load() < candidates[chosen].load() {
+				chosen = j
+			}
+		}
+		s.groupCursors[key] = chosen + 1
+
+		route := candidates[chosen]
+		if err = route.Deliver(message, false); err == nil {
+			return
+		}
+		if err == ErrInvalidRoute {
+			s.unsubscribe(route)
+			go s.router.broadcastGroups()
+		}
+		candidates = append(candidates[:chosen], candidates[chosen+1:]...)
+	}
+
+	if isDeadLetter(members[0], err) {
+		s.router.DeadLetter(DeadLetter{
+			Message:       message,
+			Source:        members[0].Get(connectorParam),
+			Reason:        err.Error(),
+			Attempts:      len(members),
+			SubscriberKey: key,
+		})
+	}
+}
+
+// ownsGroupMessage returns true if this node delivers the message to the consumer group.
+// In cluster mode, the node is chosen by the message id among the nodes having members of the group,
+// which every node does the same way.
+func (router *router) ownsGroupMessage(key string, message *protocol.Message) bool {
+	if router.cluster == nil {
+		return true
+	}
+	nodes := append(router.cluster.GroupNodes(key), router.cluster.Config.ID)
+	if len(nodes) == 1 {
+		return true
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatUint(message.ID, 10)))
+	return nodes[h.Sum32()%uint32(len(nodes))] == router.cluster.Config.ID
+}
+
+// groupKeys returns the keys of the consumer groups having members on this node, sorted
+func (router *router) groupKeys() []string {
+	set := make(map[string]bool)
+	for _, routes := range router.routes() {
+		for _, r := range routes {
+			if r.Group != "" {
+				set[r.groupKey()] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// broadcastGroups announces the consumer groups of this node to the other cluster nodes
+func (router *router) broadcastGroups() {
+	if router.cluster == nil {
+		return
+	}
+	router.groupsMu.Lock()
+	defer router.groupsMu.Unlock()
+
+	if err := router.cluster.BroadcastGroups(router.groupKeys()); err != nil {
+		logger.WithError(err).Error("Error broadcasting consumer groups")
+	}
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func aGroupRoute(router *router, applicationID, group string) *Route {
+	r, _ := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": applicationID, "user_id": "user01"},
+		Path:        protocol.Path("/orders"),
+		ChannelSize: chanSize,
+		Group:       group,
+	}))
+	return r
+}
+
+func drain(c <-chan *protocol.Message) int {
+	count := 0
+	for {
+		select {
+		case <-c:
+			count++
+		case <-time.After(10 * time.Millisecond):
+			return count
+		}
+	}
+}
+
+func TestRouter_ConsumerGroup(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes of a group, and a route outside of the group
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(router, "worker1", "workers")
+	worker2 := aGroupRoute(router, "worker2", "workers")
+	other := aGroupRoute(router, "other", "")
+
+	// when publishing messages
+	for i := 0; i < 4; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/orders", Body: aTestByteMessage}))
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// then each message is delivered once in the group, in turns
+	a.Equal(2, drain(worker1.MessagesChannel()))
+	a.Equal(2, drain(worker2.MessagesChannel()))
+
+	// and to all the routes outside of the group
+	a.Equal(4, drain(other.MessagesChannel()))
+}
+
+func TestRouter_ConsumerGroupLeastLoaded(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes of a group
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(router, "worker1", "workers")
+	worker2 := aGroupRoute(router, "worker2", "workers")
+
+	// when the first worker does not consume its messages
+	for i := 0; i < 4; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/orders", Body: aTestByteMessage}))
+		time.Sleep(5 * time.Millisecond)
+		drain(worker2.MessagesChannel())
+	}
+
+	// then the messages are delivered to the least loaded worker
+	a.Equal(1, drain(worker1.MessagesChannel()))
+
+	// and when a worker is closed, the messages are delivered to the other one
+	worker2.Close()
+	for i := 0; i < 3; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/orders", Body: aTestByteMessage}))
+	}
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(3, drain(worker1.MessagesChannel()))
+	a.Len(router.routes()["/orders"], 1)
+}
+
+func TestRouter_ConsumerGroupFilters(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes of a group, having different params
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(router, "worker1", "workers")
+	worker2 := aGroupRoute(router, "worker2", "workers")
+
+	// then the messages are delivered only to the members matching their filters
+	for i := 0; i < 3; i++ {
+		message := &protocol.Message{Path: "/orders", Body: aTestByteMessage}
+		message.SetFilter("application_id", "worker2")
+		a.NoError(router.HandleMessage(message))
+	}
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, drain(worker1.MessagesChannel()))
+	a.Equal(3, drain(worker2.MessagesChannel()))
+}
+
+func TestRouter_GroupKeys(t *testing.T) {
+	a := assert.New(t)
+
+	router, _, _, _ := aStartedRouter()
+	aGroupRoute(router, "worker1", "workers")
+	aGroupRoute(router, "worker2", "workers")
+	aGroupRoute(router, "mailer", "mailers")
+	aGroupRoute(router, "other", "")
+
+	a.Equal([]string{"mailers /orders", "workers /orders"}, router.groupKeys())
+	a.True(router.ownsGroupMessage("workers /orders", &protocol.Message{ID: 42}))
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// idempotencyEntry remembers the message published with an idempotency key
+type idempotencyEntry struct {
+	key     string
+	id      uint64
+	time    int64
+	expires time.Time
+
+	// closed when the handling of the message is finished; ok is true if the message was handled successfully
+	done chan struct{}
+	ok   bool
+}
+
+// idempotencyPartition holds the idempotency keys of a partition, in the order they were added
+type idempotencyPartition struct {
+	entries map[string]*idempotencyEntry
+	order   []*idempotencyEntry
+}
+
+// idempotencyCache remembers the idempotency keys of the published messages per partition, during the window
+type idempotencyCache struct {
+	window     time.Duration
+	partitions map[string]*idempotencyPartition
+	now        func() time.Time
+	sync.Mutex
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		window:     window,
+		partitions: make(map[string]*idempotencyPartition),
+		now:        time.Now,
+	}
+}
+
+// reserve returns the entry of the key in the partition, and true if the key is known already.
+// Otherwise, it adds a pending entry for the key, which has to be completed.
+func (c *idempotencyCache) reserve(partition, key string) (*idempotencyEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	p := c.partition(partition)
+	if entry, ok := p.entries[key]; ok {
+		return entry, true
+	}
+	entry := &idempotencyEntry{key: key, done: make(chan struct{})}
+	c.add(p, entry)
+	return entry, false
+}
+
+// complete finishes a pending entry with the handled message, or removes it if the handling failed
+func (c *idempotencyCache) complete(partition string, entry *idempotencyEntry, message *protocol.Message, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err != nil {
+		if p := c.partitions[partition]; p != nil && p.entries[entry.key] == entry {
+			delete(p.entries, entry.key)
+		}
+	} else {
+		entry.id, entry.time, entry.ok = message.ID, message.Time, true
+	}
+	close(entry.done)
+}
+
+// remember adds the key of a message handled by another cluster node
+func (c *idempotencyCache) remember(partition string, message *protocol.Message) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry := &idempotencyEntry{
+		key:  message.IdempotencyKey,
+		id:   message.ID,
+		time: message.Time,
+		done: make(chan struct{}),
+		ok:   true,
+	}
+	close(entry.done)
+	c.add(c.partition(partition), entry)
+}
+
+// partition returns the keys of the partition, after removing the expired ones
+func (c *idempotencyCache) partition(name string) *idempotencyPartition {
+	p, ok := c.partitions[name]
+	if !ok {
+		p = &idempotencyPartition{entries: make(map[string]*idempotencyEntry)}
+		c.partitions[name] = p
+	}
+
+	now := c.now()
+	expired := 0
+	for _, entry := range p.order {
+		if entry.expires.After(now) {
+			break
+		}
+		if p.entries[entry.key] == entry {
+			delete(p.entries, entry.key)
+		}
+		expired++
+	}
+	p.order = p.order[expired:]
+	return p
+}
+
+func (c *idempotencyCache) add(p *idempotencyPartition, entry *idempotencyEntry) {
+	entry.expires = c.now().Add(c.window)
+	p.entries[entry.key] = entry
+	p.order = append(p.order, entry)
+}
+
+// handleIdempotencyKey deduplicates a message published on this node with an idempotency key.
+// It returns true if the message is a duplicate, which gets the id of the original message.
+// Otherwise, it returns the entry which has to be completed after handling the message.
+func (router *router) handleIdempotencyKey(message *protocol.Message) (*idempotencyEntry, bool) {
+	partition := message.Path.Partition()
+	for {
+		entry, duplicate := router.idempotency.reserve(partition, message.IdempotencyKey)
+		if !duplicate {
+			return entry, false
+		}
+
+		// wait for the original message, which is published again if its handling failed
+		<-entry.done
+		if entry.ok {
+			logger.WithField("path", message.Path).WithField("idempotencyKey", message.IdempotencyKey).
+				Info("Duplicate message not published again")
+			mTotalDuplicateMessages.Add(1)
+			message.ID, message.Time = entry.id, entry.time
+			return nil, true
+		}
+	}
+}
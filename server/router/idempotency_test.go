@@ -0,0 +1,114 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	cache := newIdempotencyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	// a new key is reserved
+	entry, duplicate := cache.reserve("orders", "key1")
+	a.False(duplicate)
+
+	// and known after the message was handled
+	cache.complete("orders", entry, &protocol.Message{ID: 42, Time: 1000}, nil)
+	known, duplicate := cache.reserve("orders", "key1")
+	a.True(duplicate)
+	a.True(known.ok)
+	a.Equal(uint64(42), known.id)
+
+	// but not in the other partitions
+	_, duplicate = cache.reserve("users", "key1")
+	a.False(duplicate)
+
+	// the key of a failed message is removed
+	entry, _ = cache.reserve("orders", "key2")
+	cache.complete("orders", entry, nil, errors.New("store failed"))
+	a.False(entry.ok)
+	_, duplicate = cache.reserve("orders", "key2")
+	a.False(duplicate)
+
+	// the keys of the other cluster nodes are remembered
+	cache.remember("orders", &protocol.Message{ID: 43, NodeID: 2, IdempotencyKey: "key3"})
+	known, duplicate = cache.reserve("orders", "key3")
+	a.True(duplicate)
+	a.Equal(uint64(43), known.id)
+
+	// and the keys expire after the window
+	now = now.Add(time.Minute)
+	_, duplicate = cache.reserve("orders", "key1")
+	a.False(duplicate)
+	a.Len(cache.partitions["orders"].entries, 1)
+}
+
+func TestRouter_IdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route, remembering the idempotency keys
+	router, r := aRouterRoute(chanSize)
+	router.idempotency = newIdempotencyCache(time.Minute)
+
+	// when a message is published with a key
+	message := &protocol.Message{Path: r.Path, Body: []byte("first"), IdempotencyKey: "key1"}
+	a.NoError(router.HandleMessage(message))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("first"))
+
+	// then the duplicate gets the id of the first message, and is not delivered again
+	duplicate := &protocol.Message{Path: r.Path, Body: []byte("duplicate"), IdempotencyKey: "key1"}
+	a.NoError(router.HandleMessage(duplicate))
+	a.Equal(message.ID, duplicate.ID)
+	a.Equal(message.Time, duplicate.Time)
+
+	// and the messages having another key or no key are delivered
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("second"), IdempotencyKey: "key2"}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("second"))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("third")}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("third"))
+
+	// and a message received from another cluster node is remembered
+	remote := &protocol.Message{Path: r.Path, Body: []byte("remote"), NodeID: 2, IdempotencyKey: "key3"}
+	a.NoError(router.HandleMessage(remote))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("remote"))
+
+	retry := &protocol.Message{Path: r.Path, Body: []byte("retry"), IdempotencyKey: "key3"}
+	a.NoError(router.HandleMessage(retry))
+	a.Equal(remote.ID, retry.ID)
+
+	select {
+	case m := <-r.MessagesChannel():
+		a.Fail("Unexpected message delivered", string(m.Body))
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestRouter_IdempotencyKeyOfRejectedMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route, remembering the idempotency keys and limiting the users
+	router, r := aRouterRoute(chanSize)
+	router.idempotency = newIdempotencyCache(time.Minute)
+	router.rateLimit = RateLimitConfig{UserRate: 1}
+
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, UserID: "user01", Body: []byte("first")}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("first"))
+
+	// when a message with a key is rejected
+	message := &protocol.Message{Path: r.Path, UserID: "user01", Body: []byte("second"), IdempotencyKey: "key1"}
+	a.Equal(ErrRateLimitExceeded, router.HandleMessage(message))
+
+	// then the key is not remembered, and the message can be published again
+	router.rateLimit = RateLimitConfig{}
+	a.NoError(router.HandleMessage(message))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("second"))
+}
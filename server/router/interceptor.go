@@ -0,0 +1,53 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// Interceptor is called by the router with each message published on this node, before the message is stored.
+// It can validate the message, enrich it (e.g. stamp headers or normalize the payload),
+// or reject it by returning an error, whose text is sent to the publisher as the reason.
+type Interceptor interface {
+	Intercept(message *protocol.Message) error
+}
+
+// InterceptorFunc is an adapter allowing the use of an ordinary function as an Interceptor
+type InterceptorFunc func(message *protocol.Message) error
+
+// Intercept calls f(message)
+func (f InterceptorFunc) Intercept(message *protocol.Message) error {
+	return f(message)
+}
+
+// Interceptable is implemented by a Router accepting interceptors.
+// The interceptors are called in the order they were added, and the chain stops at the first rejection.
+type Interceptable interface {
+	AddInterceptor(Interceptor)
+}
+
+// AddInterceptor adds an interceptor at the end of the chain
+func (router *router) AddInterceptor(interceptor Interceptor) {
+	router.Lock()
+	defer router.Unlock()
+
+	router.interceptors = append(router.interceptors, interceptor)
+}
+
+// intercept passes the message through the interceptor chain,
+// and returns a MessageRejectedError if one of the interceptors rejected it
+func (router *router) intercept(message *protocol.Message) error {
+	router.RLock()
+	interceptors := router.interceptors
+	router.RUnlock()
+
+	for _, interceptor := range interceptors {
+		if err := interceptor.Intercept(message); err != nil {
+			mTotalMessagesRejected.Add(1)
+			if rejected, ok := err.(*MessageRejectedError); ok {
+				return rejected
+			}
+			return &MessageRejectedError{Reason: err.Error()}
+		}
+	}
+	return nil
+}
@@ -11,8 +11,9 @@ const (
 )
 
 type queue struct {
-	mu    sync.Mutex
-	queue []*protocol.Message
+	mu     sync.Mutex
+	queue  []*protocol.Message
+	polled bool // the first item was polled, and is being sent until removed
 }
 
 // newQueue creates a *queue that will have the capacity specified by size.
@@ -42,6 +43,24 @@ func (q *queue) remove() {
 		return
 	}
 	q.queue = q.queue[1:]
+	q.polled = false
+}
+
+// dropOldest removes the oldest item which is not being sent.
+// Returns false if there is no such item.
+func (q *queue) dropOldest() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := 0
+	if q.polled {
+		i = 1
+	}
+	if len(q.queue) <= i {
+		return false
+	}
+	q.queue = append(q.queue[:i], q.queue[i+1:]...)
+	return true
 }
 
 // poll returns the first item from the queue without removing it
@@ -53,6 +72,7 @@ func (q *queue) poll() (*protocol.Message, error) {
 		return nil, errEmptyQueue
 	}
 
+	q.polled = true
 	return q.queue[0], nil
 }
 
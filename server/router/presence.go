@@ -0,0 +1,201 @@
+package router
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// the kinds of presence events
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// presenceChannelCapacity is the number of presence events waiting to be published, before new events are dropped
+const presenceChannelCapacity = 500
+
+// PresenceEvent is the body of the messages published on the presence topic of a topic,
+// when a user subscribes to the topic with its first route, or unsubscribes its last route.
+// The presence topic of a topic is `<prefix>/<topic>`.
+type PresenceEvent struct {
+	Event  string
+	UserID string
+	Path   protocol.Path
+}
+
+// presenceTable counts the routes of each user, by topic path
+type presenceTable struct {
+	routes map[protocol.Path]map[string]int
+	sync.Mutex
+}
+
+// join counts a route of the user, and returns true if it is the first one on the path
+func (t *presenceTable) join(path protocol.Path, userID string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.routes == nil {
+		t.routes = make(map[protocol.Path]map[string]int)
+	}
+	users, present := t.routes[path]
+	if !present {
+		users = make(map[string]int)
+		t.routes[path] = users
+	}
+	users[userID]++
+	return users[userID] == 1
+}
+
+// leave uncounts a route of the user, and returns true if it was the last one on the path
+func (t *presenceTable) leave(path protocol.Path, userID string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	users := t.routes[path]
+	if users[userID] == 0 {
+		return false
+	}
+	users[userID]--
+	if users[userID] > 0 {
+		return false
+	}
+	delete(users, userID)
+	if len(users) == 0 {
+		delete(t.routes, path)
+	}
+	return true
+}
+
+// users returns the sorted ids of the present users, by topic path
+func (t *presenceTable) users() map[string][]string {
+	t.Lock()
+	defer t.Unlock()
+
+	result := make(map[string][]string, len(t.routes))
+	for path, users := range t.routes {
+		for userID := range users {
+			result[string(path)] = append(result[string(path)], userID)
+		}
+		sort.Strings(result[string(path)])
+	}
+	return result
+}
+
+// tracksPresence returns true if the presence of the route's user is tracked.
+// The routes without user, on wildcard paths, and on the presence topics themselves are not tracked.
+func (router *router) tracksPresence(r *Route) bool {
+	if r.Get("user_id") == "" || r.Path.HasWildcard() {
+		return false
+	}
+	return router.presencePrefix == "" || !router.presencePrefix.Matches(r.Path)
+}
+
+// routeAdded records the presence of the user of a new route.
+// It is called by the dispatch loop of the primary shard of the route path.
+func (router *router) routeAdded(r *Route) {
+	if router.tracksPresence(r) && router.presence.join(r.Path, r.Get("user_id")) {
+		router.presenceChanged(PresenceJoin, r)
+	}
+}
+
+// routeRemoved records that the user of a route may have left.
+// It is called by the dispatch loop of the primary shard of the route path.
+func (router *router) routeRemoved(r *Route) {
+	if router.tracksPresence(r) && router.presence.leave(r.Path, r.Get("user_id")) {
+		router.presenceChanged(PresenceLeave, r)
+	}
+}
+
+// presenceChanged queues the presence event for publishing, and announces the users to the other cluster nodes
+func (router *router) presenceChanged(event string, r *Route) {
+	if router.presencePrefix != "" {
+		message := router.presenceMessage(event, r)
+		select {
+		case router.presenceC <- message:
+		default:
+			logger.WithField("path", message.Path).Warn("Dropping presence event, too many events are waiting")
+		}
+	}
+	if router.cluster != nil {
+		go router.broadcastPresence()
+	}
+}
+
+// presenceMessage returns the message announcing the event on the presence topic of the route path.
+// It is published by the router, and not by the user of the route.
+func (router *router) presenceMessage(event string, r *Route) *protocol.Message {
+	body, err := json.Marshal(PresenceEvent{Event: event, UserID: r.Get("user_id"), Path: r.Path})
+	if err != nil {
+		logger.WithError(err).Error("Error encoding presence event")
+	}
+	return &protocol.Message{
+		Path: protocol.Path(strings.TrimSuffix(string(router.presencePrefix), "/") + string(r.Path)),
+		Body: body,
+	}
+}
+
+// publishPresence publishes the queued presence events in their order, until the router is stopped
+func (router *router) publishPresence(stopC <-chan bool) {
+	defer router.wg.Done()
+
+	for {
+		select {
+		case message := <-router.presenceC:
+			if err := router.publishInternal(message); err != nil {
+				logger.WithFields(log.Fields{
+					"path":  message.Path,
+					"error": err,
+				}).Error("Error publishing presence event")
+			}
+		case <-stopC:
+			return
+		}
+	}
+}
+
+// broadcastPresence announces the users subscribed on this node to the other cluster nodes
+func (router *router) broadcastPresence() {
+	router.presenceMu.Lock()
+	defer router.presenceMu.Unlock()
+
+	if err := router.cluster.BroadcastPresence(router.presence.users()); err != nil {
+		logger.WithError(err).Error("Error broadcasting presence")
+	}
+}
+
+// presentUsers returns the users subscribed to the topic, or to all topics if the topic is empty, by topic path.
+// In cluster mode, it includes the users subscribed on the other nodes.
+func (router *router) presentUsers(topic protocol.Path) map[string][]string {
+	users := router.presence.users()
+	if router.cluster != nil {
+		for path, remoteUsers := range router.cluster.Presence() {
+			users[path] = mergeSorted(users[path], remoteUsers)
+		}
+	}
+	if topic == "" {
+		return users
+	}
+	return map[string][]string{string(topic): append([]string{}, users[string(topic)]...)}
+}
+
+// mergeSorted merges two sorted slices of strings, keeping each string once
+func mergeSorted(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			merged, a = append(merged, a[0]), a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			merged, b = append(merged, b[0]), b[1:]
+		default:
+			merged, a, b = append(merged, a[0]), a[1:], b[1:]
+		}
+	}
+	return merged
+}
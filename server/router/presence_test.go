@@ -0,0 +1,168 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/smancke/guble/server/topics"
+	"github.com/smancke/guble/testutil"
+)
+
+func aUserRoute(router *router, applicationID, userID string, path protocol.Path) *Route {
+	r, _ := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": applicationID, "user_id": userID},
+		Path:        path,
+		ChannelSize: chanSize,
+	}))
+	return r
+}
+
+func expectPresenceEvent(a *assert.Assertions, c <-chan *protocol.Message, expected PresenceEvent) {
+	select {
+	case m := <-c:
+		a.Equal(protocol.Path("/$presence/chat/room1"), m.Path)
+		a.Empty(m.UserID)
+		var event PresenceEvent
+		a.NoError(json.Unmarshal(m.Body, &event))
+		a.Equal(expected, event)
+	case <-time.After(20 * time.Millisecond):
+		a.Fail("No presence event received", expected.Event)
+	}
+}
+
+func TestPresenceTable(t *testing.T) {
+	a := assert.New(t)
+
+	var table presenceTable
+	a.True(table.join("/chat/room1", "alice"))
+	a.False(table.join("/chat/room1", "alice"))
+	a.True(table.join("/chat/room1", "bob"))
+	a.True(table.join("/chat/room2", "alice"))
+	a.Equal(map[string][]string{
+		"/chat/room1": {"alice", "bob"},
+		"/chat/room2": {"alice"},
+	}, table.users())
+
+	a.False(table.leave("/chat/room1", "alice"))
+	a.True(table.leave("/chat/room1", "alice"))
+	a.False(table.leave("/chat/room1", "alice"))
+	a.True(table.leave("/chat/room2", "alice"))
+	a.Equal(map[string][]string{"/chat/room1": {"bob"}}, table.users())
+}
+
+func TestRouter_PresenceEvents(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router announcing the presence, and a route on the presence topic of a chat room
+	kvs := kvstore.NewMemoryKVStore()
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{PresencePrefix: "/$presence"}).(*router)
+	a.NoError(router.Start())
+	defer router.Stop()
+	watcher := aUserRoute(router, "dashboard", "admin", "/$presence/chat/room1")
+
+	// when users join the room, alice with two devices
+	alicePhone := aUserRoute(router, "phone", "alice", "/chat/room1")
+	aUserRoute(router, "web", "bob", "/chat/room1")
+	aliceWeb := aUserRoute(router, "web", "alice", "/chat/room1")
+
+	// then each user joins once
+	expectPresenceEvent(a, watcher.MessagesChannel(), PresenceEvent{Event: PresenceJoin, UserID: "alice", Path: "/chat/room1"})
+	expectPresenceEvent(a, watcher.MessagesChannel(), PresenceEvent{Event: PresenceJoin, UserID: "bob", Path: "/chat/room1"})
+	expectNoMessage(a, watcher.MessagesChannel())
+
+	// and a user leaves with its last route
+	router.Unsubscribe(alicePhone)
+	expectNoMessage(a, watcher.MessagesChannel())
+	router.Unsubscribe(aliceWeb)
+	expectPresenceEvent(a, watcher.MessagesChannel(), PresenceEvent{Event: PresenceLeave, UserID: "alice", Path: "/chat/room1"})
+
+	// and the routes without user, or on a wildcard path are not announced
+	aUserRoute(router, "web", "", "/chat/room1")
+	aUserRoute(router, "web", "carol", "/chat/*")
+	expectNoMessage(a, watcher.MessagesChannel())
+}
+
+func TestRouter_PresenceEventsPublishedByRouter(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// Given a Router announcing the presence, whose users may only subscribe
+	am := NewMockAccessManager(ctrl)
+	am.EXPECT().IsAllowed(auth.READ, gomock.Any(), gomock.Any()).Return(true).AnyTimes()
+	am.EXPECT().IsAllowed(auth.WRITE, gomock.Any(), gomock.Any()).Return(false).AnyTimes()
+	kvs := kvstore.NewMemoryKVStore()
+	router := New(am, dummystore.New(kvs), kvs, nil, Config{PresencePrefix: "/$presence"}).(*router)
+	a.NoError(router.Start())
+	defer router.Stop()
+
+	// and whose presence topics reject the messages of publishers and interceptors
+	router.topics = topics.NewRegistry(kvstore.NewMemoryKVStore(), "")
+	a.NoError(router.topics.Put(&topics.Config{Path: "/$presence", AllowedPublishers: []string{"nobody"}, PublishRate: 1, PublishBurst: 1}))
+	router.AddInterceptor(InterceptorFunc(func(message *protocol.Message) error {
+		return &MessageRejectedError{Reason: "rejected"}
+	}))
+	watcher := aUserRoute(router, "dashboard", "admin", "/$presence/chat/room1")
+
+	// when users join the room
+	aUserRoute(router, "web", "alice", "/chat/room1")
+	aUserRoute(router, "web", "bob", "/chat/room1")
+
+	// then their presence is published by the router
+	expectPresenceEvent(a, watcher.MessagesChannel(), PresenceEvent{Event: PresenceJoin, UserID: "alice", Path: "/chat/room1"})
+	expectPresenceEvent(a, watcher.MessagesChannel(), PresenceEvent{Event: PresenceJoin, UserID: "bob", Path: "/chat/room1"})
+
+	// while the users cannot publish on the presence topic
+	a.Error(router.HandleMessage(&protocol.Message{Path: "/$presence/chat/room1", UserID: "alice"}))
+}
+
+func TestRouter_AdminPresence(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with routes of users, not announcing the presence
+	router, _, _, _ := aStartedRouter()
+	aUserRoute(router, "phone", "alice", "/chat/room1")
+	aUserRoute(router, "web", "alice", "/chat/room1")
+	aUserRoute(router, "web", "bob", "/chat/room1")
+	aUserRoute(router, "web", "bob", "/chat/room2")
+
+	request := func(url string) map[string][]string {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		a.NoError(err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		a.Equal(http.StatusOK, w.Code)
+
+		var users map[string][]string
+		a.NoError(json.Unmarshal(w.Body.Bytes(), &users))
+		return users
+	}
+
+	// then the users are listed by topic
+	a.Equal(map[string][]string{
+		"/chat/room1": {"alice", "bob"},
+		"/chat/room2": {"bob"},
+	}, request("/admin/router/presence"))
+
+	// or for a single topic
+	a.Equal(map[string][]string{"/chat/room2": {"bob"}}, request("/admin/router/presence?topic=/chat/room2"))
+	a.Equal(map[string][]string{"/chat/room3": {}}, request("/admin/router/presence?topic=/chat/room3"))
+}
+
+func TestMergeSorted(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{}, mergeSorted(nil, nil))
+	a.Equal([]string{"a", "b", "c", "d"}, mergeSorted([]string{"a", "c"}, []string{"b", "c", "d"}))
+	a.Equal([]string{"a"}, mergeSorted(nil, []string{"a"}))
+}
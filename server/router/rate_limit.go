@@ -0,0 +1,173 @@
+package router
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/topics"
+)
+
+// maxRateLimitBuckets is the number of buckets above which the full buckets are removed
+const maxRateLimitBuckets = 10000
+
+// RateLimitConfig configures the token buckets limiting the publishing rate of each user and application.
+// The publishers with an empty id are not limited.
+type RateLimitConfig struct {
+	// UserRate is the number of messages per second a user can publish. If not set, the users are not limited.
+	UserRate float64
+
+	// ApplicationRate is the number of messages per second an application can publish.
+	// If not set, the applications are not limited.
+	ApplicationRate float64
+
+	// Burst is the number of messages which can be published at once.
+	// If not set, it is the rate (but at least one message).
+	Burst int
+}
+
+type rateKind int
+
+const (
+	rateKindUser rateKind = iota
+	rateKindApplication
+)
+
+// rateLimit is the limit of a single bucket
+type rateLimit struct {
+	kind  rateKind
+	key   string
+	rate  float64
+	burst float64
+}
+
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	updated  time.Time
+}
+
+// refill adds the tokens accumulated since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// rateLimiter holds the token buckets, by key
+type rateLimiter struct {
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+	sync.Mutex
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// take takes a token from each bucket of the limits, if all of them have one.
+// Otherwise it takes no token, and returns the first exceeded limit.
+func (l *rateLimiter) take(limits []rateLimit) (*rateLimit, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	buckets := make([]*tokenBucket, len(limits))
+	for i, limit := range limits {
+		buckets[i] = l.bucket(limit, now)
+	}
+	for i, b := range buckets {
+		if b.tokens < 1 {
+			return &limits[i], false
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil, true
+}
+
+// bucket returns the refilled bucket of the limit, which is created full if it does not exist.
+// The bucket is updated if the limit was changed.
+func (l *rateLimiter) bucket(limit rateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[limit.key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: limit.burst, updated: now}
+		l.buckets[limit.key] = b
+		mCurrentRateLimitBuckets.Set(int64(len(l.buckets)))
+	}
+	b.capacity = limit.burst
+	b.rate = limit.rate
+	b.refill(now)
+	return b
+}
+
+// prune removes the full buckets, which are equivalent to new ones
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+	mCurrentRateLimitBuckets.Set(int64(len(l.buckets)))
+}
+
+// checkRateLimit takes a token from the buckets of the publisher of a message published on this node,
+// or returns ErrRateLimitExceeded. The rate configured for the topic overrides the global rates.
+func (router *router) checkRateLimit(config *topics.Config, message *protocol.Message) error {
+	limits := router.rateLimits(config, message)
+	if len(limits) == 0 {
+		return nil
+	}
+	exceeded, ok := router.rateLimiter.take(limits)
+	if ok {
+		return nil
+	}
+
+	logger.WithFields(log.Fields{
+		"userID":        message.UserID,
+		"applicationID": message.ApplicationID,
+		"path":          message.Path,
+		"limit":         exceeded.key,
+	}).Info("Publish rate limit exceeded")
+	mTotalMessagesRateLimited[exceeded.kind].Add(1)
+	return ErrRateLimitExceeded
+}
+
+// rateLimits returns the limits applying to the publisher of the message
+func (router *router) rateLimits(config *topics.Config, message *protocol.Message) []rateLimit {
+	userRate, applicationRate := router.rateLimit.UserRate, router.rateLimit.ApplicationRate
+	burst := router.rateLimit.Burst
+	scope := ""
+	if config != nil && config.PublishRate > 0 {
+		userRate, applicationRate = config.PublishRate, config.PublishRate
+		burst = config.PublishBurst
+		scope = " " + string(config.Path)
+	}
+
+	var limits []rateLimit
+	if userRate > 0 && message.UserID != "" {
+		limits = append(limits, newRateLimit(rateKindUser, "user:"+message.UserID+scope, userRate, burst))
+	}
+	if applicationRate > 0 && message.ApplicationID != "" {
+		limits = append(limits, newRateLimit(rateKindApplication, "application:"+message.ApplicationID+scope, applicationRate, burst))
+	}
+	return limits
+}
+
+func newRateLimit(kind rateKind, key string, rate float64, burst int) rateLimit {
+	capacity := float64(burst)
+	if burst <= 0 {
+		capacity = math.Max(1, rate)
+	}
+	return rateLimit{kind: kind, key: key, rate: rate, burst: capacity}
+}
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/topics"
+)
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	limit := newRateLimit(rateKindUser, "user:user01", 2, 3)
+
+	// the burst can be taken at once
+	for i := 0; i < 3; i++ {
+		_, ok := limiter.take([]rateLimit{limit})
+		a.True(ok)
+	}
+	exceeded, ok := limiter.take([]rateLimit{limit})
+	a.False(ok)
+	a.Equal("user:user01", exceeded.key)
+
+	// and the bucket is refilled at the rate
+	now = now.Add(500 * time.Millisecond)
+	_, ok = limiter.take([]rateLimit{limit})
+	a.True(ok)
+	_, ok = limiter.take([]rateLimit{limit})
+	a.False(ok)
+
+	// no token is taken if one of the buckets is empty
+	other := newRateLimit(rateKindApplication, "application:app01", 2, 3)
+	exceeded, ok = limiter.take([]rateLimit{other, limit})
+	a.False(ok)
+	a.Equal(rateKindUser, exceeded.kind)
+	a.Equal(3.0, limiter.buckets["application:app01"].tokens)
+
+	// the full buckets are pruned
+	now = now.Add(time.Minute)
+	limiter.prune(now)
+	a.Empty(limiter.buckets)
+}
+
+func TestRouter_RateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route, limiting the users to 1 message per second
+	router, r := aRouterRoute(chanSize)
+	router.rateLimit = RateLimitConfig{UserRate: 1}
+	message := func(userID, applicationID string) *protocol.Message {
+		return &protocol.Message{Path: r.Path, UserID: userID, ApplicationID: applicationID, Body: aTestByteMessage}
+	}
+
+	// when a user publishes two messages, then the second is rejected
+	a.NoError(router.HandleMessage(message("user01", "app01")))
+	a.Equal(ErrRateLimitExceeded, router.HandleMessage(message("user01", "app01")))
+
+	// and the other users and the anonymous messages are not limited
+	a.NoError(router.HandleMessage(message("user02", "app01")))
+	a.NoError(router.HandleMessage(message("", "app01")))
+	a.NoError(router.HandleMessage(message("", "app01")))
+
+	// and when limiting the applications
+	router.rateLimit.ApplicationRate = 1
+	a.NoError(router.HandleMessage(message("user03", "app02")))
+	a.Equal(ErrRateLimitExceeded, router.HandleMessage(message("user04", "app02")))
+
+	// and when the topic overrides the rate
+	router.topics = topics.NewRegistry(kvstore.NewMemoryKVStore(), "")
+	a.NoError(router.topics.Put(&topics.Config{Path: r.Path, PublishRate: 1, PublishBurst: 2}))
+	a.NoError(router.HandleMessage(message("user01", "app01")))
+	a.NoError(router.HandleMessage(message("user01", "app01")))
+	a.Equal(ErrRateLimitExceeded, router.HandleMessage(message("user01", "app01")))
+}
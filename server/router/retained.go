@@ -0,0 +1,100 @@
+package router
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// retainedSchema is the schema of the key-value store holding the retained messages, by topic path
+const retainedSchema = "retained_messages"
+
+// retainedChannelCapacity is the number of retained messages waiting to be persisted,
+// before the shards wait for the key-value store
+const retainedChannelCapacity = 500
+
+// retainedWrite is a change of the retained message of a path, waiting to be persisted; a nil data deletes it
+type retainedWrite struct {
+	path protocol.Path
+	data []byte
+}
+
+// retain keeps a retained message as the last value of its topic, or clears the last value if the body is empty.
+// It is called by the loop of the shard owning the partition, so that the last value follows the delivery order.
+// The change is persisted asynchronously, in the same order.
+func (s *shard) retain(message *protocol.Message) {
+	if len(message.Body) == 0 {
+		s.clearRetained(message.Path)
+		return
+	}
+
+	_, present := s.retained[message.Path]
+	s.retained[message.Path] = message
+	if !present {
+		mCurrentRetainedMessages.Add(1)
+	}
+	s.router.retainedC <- retainedWrite{path: message.Path, data: message.Bytes()}
+}
+
+// clearRetained removes the retained message of the path
+func (s *shard) clearRetained(path protocol.Path) {
+	if _, present := s.retained[path]; !present {
+		return
+	}
+	delete(s.retained, path)
+	mCurrentRetainedMessages.Add(-1)
+	s.router.retainedC <- retainedWrite{path: path}
+}
+
+// persistRetained writes the changes of the retained messages to the key-value store,
+// until the channel is closed after the shards have stopped
+func (router *router) persistRetained() {
+	defer close(router.retainedDoneC)
+
+	for w := range router.retainedC {
+		if w.data == nil {
+			if err := router.kvStore.Delete(retainedSchema, string(w.path)); err != nil {
+				logger.WithError(err).WithField("path", w.path).Error("Error deleting retained message")
+			}
+			continue
+		}
+		if err := router.kvStore.Put(retainedSchema, string(w.path), w.data); err != nil {
+			logger.WithError(err).WithField("path", w.path).Error("Error storing retained message")
+		}
+	}
+}
+
+// deliverRetained delivers the retained message of the route's path to a new route, before the live messages.
+// The routes of consumer groups and the routes on a parent topic or on a wildcard path do not receive it.
+func (s *shard) deliverRetained(r *Route) {
+	message, present := s.retained[r.Path]
+	if !present || r.Group != "" {
+		return
+	}
+	if message.IsExpired() {
+		s.clearRetained(r.Path)
+		return
+	}
+	if err := r.Deliver(message, false); err != nil {
+		logger.WithFields(log.Fields{
+			"route": r,
+			"path":  message.Path,
+		}).WithError(err).Info("Error delivering retained message")
+	}
+}
+
+// loadRetained loads the retained messages from the key-value store into the shards owning their partitions
+func (router *router) loadRetained() {
+	for _, s := range router.shards {
+		s.retained = make(map[protocol.Path]*protocol.Message)
+	}
+	for entry := range router.kvStore.Iterate(retainedSchema, "") {
+		message, err := protocol.ParseMessage([]byte(entry[1]))
+		if err != nil {
+			logger.WithError(err).WithField("path", entry[0]).Error("Error parsing retained message")
+			continue
+		}
+		router.shardFor(message.Path.Partition()).retained[message.Path] = message
+		mCurrentRetainedMessages.Add(1)
+	}
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+func aStateRoute(router *router, path protocol.Path) *Route {
+	r, _ := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+		Path:        path,
+		ChannelSize: chanSize,
+	}))
+	return r
+}
+
+func TestRouter_RetainedMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a retained message on a topic
+	router, _, _, kvs := aStartedRouter()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("on"), Retain: true}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("off"), Retain: true}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("not retained")}))
+	time.Sleep(10 * time.Millisecond)
+
+	// when a route subscribes to the topic
+	r := aStateRoute(router, "/device/123/state")
+
+	// then it gets the last retained message first, and then the live messages
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("live")}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("off"))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("live"))
+
+	// and the retained message is persisted
+	value, exist, err := kvs.Get(retainedSchema, "/device/123/state")
+	a.NoError(err)
+	a.True(exist)
+	stored, err := protocol.ParseMessage(value)
+	a.NoError(err)
+	a.Equal("off", string(stored.Body))
+	a.True(stored.Retain)
+
+	// and the routes on the parent topic do not get it
+	parent := aStateRoute(router, "/device")
+	expectNoMessage(a, parent.MessagesChannel())
+}
+
+func TestRouter_ClearRetainedMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a retained message on a topic
+	router, _, _, kvs := aStartedRouter()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("on"), Retain: true}))
+
+	// when an empty retained message is published
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Retain: true}))
+	time.Sleep(10 * time.Millisecond)
+
+	// then the new routes do not get a retained message
+	r := aStateRoute(router, "/device/123/state")
+	expectNoMessage(a, r.MessagesChannel())
+
+	_, exist, err := kvs.Get(retainedSchema, "/device/123/state")
+	a.NoError(err)
+	a.False(exist)
+}
+
+func TestRouter_RetainedMessagesAreLoadedOnStart(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a key-value store with retained messages
+	kvs := kvstore.NewMemoryKVStore()
+	retained := &protocol.Message{ID: 1, Path: "/device/123/state", Body: []byte("on"), Retain: true}
+	a.NoError(kvs.Put(retainedSchema, string(retained.Path), retained.Bytes()))
+	expired := &protocol.Message{ID: 2, Path: "/device/456/state", Body: []byte("on"), Retain: true, Expires: 1}
+	a.NoError(kvs.Put(retainedSchema, string(expired.Path), expired.Bytes()))
+
+	// when a router is started
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{Shards: 2}).(*router)
+	a.NoError(router.Start())
+	defer router.Stop()
+
+	// then the new routes get the retained message of their topic
+	r := aStateRoute(router, "/device/123/state")
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("on"))
+
+	// but not the expired ones, which are removed
+	r = aStateRoute(router, "/device/456/state")
+	expectNoMessage(a, r.MessagesChannel())
+	_, exist, err := kvs.Get(retainedSchema, "/device/456/state")
+	a.NoError(err)
+	a.False(exist)
+}
+
+// blockingKVStore is a key-value store whose Put waits until the unblockC is closed
+type blockingKVStore struct {
+	*kvstore.MemoryKVStore
+	unblockC chan bool
+}
+
+func (kvs *blockingKVStore) Put(schema, key string, value []byte) error {
+	<-kvs.unblockC
+	return kvs.MemoryKVStore.Put(schema, key, value)
+}
+
+func TestRouter_RetainedMessagesArePersistedAsynchronously(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router whose key-value store is blocked
+	kvs := &blockingKVStore{MemoryKVStore: kvstore.NewMemoryKVStore(), unblockC: make(chan bool)}
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{Shards: 1}).(*router)
+	a.NoError(router.Start())
+	r := aStateRoute(router, "/device/123/state")
+
+	// when retained messages are published, then they are delivered while they cannot be persisted
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("on"), Retain: true}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/123/state", Body: []byte("off"), Retain: true}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("on"))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("off"))
+	assertChannelContainsMessage(a, aStateRoute(router, "/device/123/state").MessagesChannel(), []byte("off"))
+
+	// and the last one is persisted when the store is available again, at the latest when the router is stopped
+	close(kvs.unblockC)
+	a.NoError(router.Stop())
+	value, exist, err := kvs.Get(retainedSchema, "/device/123/state")
+	a.NoError(err)
+	a.True(exist)
+	stored, err := protocol.ParseMessage(value)
+	a.NoError(err)
+	a.Equal("off", string(stored.Body))
+}
+
+func expectNoMessage(a *assert.Assertions, c <-chan *protocol.Message) {
+	select {
+	case m := <-c:
+		a.Fail("Unexpected message received", string(m.Body))
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -28,15 +28,24 @@ type Route struct {
 
 	// queue that will store the messages in correct order.
 	// The queue can have a settable size;
-	// if it reaches the capacity the route is closed.
+	// if it reaches the capacity the slow consumer policy is applied.
 	queue *queue
 
+	// notified by the consumer each time a message is removed from the queue
+	dequeuedC chan struct{}
+
+	// blockedC holds in order the messages waiting for room in a route with PolicyBlock,
+	// which are delivered by the goroutine of the route, so that a slow consumer does not block the router
+	blockedC chan *protocol.Message
+
 	closeC chan struct{}
 
 	// Indicates if the consumer go routine is running
 	consuming bool
-	invalid   bool
-	mu        sync.RWMutex
+	// Indicates if the go routine delivering the blocked messages is running
+	blocking bool
+	invalid  bool
+	mu       sync.RWMutex
 
 	logger *log.Entry
 }
@@ -48,6 +57,7 @@ func NewRoute(config RouteConfig) *Route {
 
 		queue:     newQueue(config.queueSize),
 		messagesC: make(chan *protocol.Message, config.ChannelSize),
+		dequeuedC: make(chan struct{}, 1),
 		closeC:    make(chan struct{}),
 
 		logger: logger.WithFields(log.Fields{"path": config.Path, "params": config.RouteParams}),
@@ -90,14 +100,17 @@ func (r *Route) Deliver(msg *protocol.Message, isFromStore bool) error {
 	}
 	// not an infinite queue
 	if r.queueSize >= 0 {
+		// the messages of the router wait for room in the goroutine of the route, after the messages already waiting
+		if r.policy() == PolicyBlock && !isFromStore && (r.isBlocking() || r.isFull()) {
+			return r.block(msg)
+		}
 		// if size is zero the sending is direct
 		if r.queueSize == 0 {
 			return r.sendDirect(msg, isFromStore)
 		} else if r.queue.size() >= r.queueSize {
-			loggerMessage.Error("Closing route because queue is full")
-			r.Close()
-			mTotalDeliverMessageErrors.Add(1)
-			return ErrQueueFull
+			if err := r.handleFullQueue(msg); err != nil || !r.hasRoomInQueue() {
+				return err
+			}
 		}
 	}
 
@@ -136,12 +149,37 @@ func (r *Route) handleFetch(router Router) error {
 		return ErrInvalidRoute
 	}
 
-	r.FetchRequest.Partition = r.Path.Partition()
 	ms, err := router.MessageStore()
 	if err != nil {
 		return err
 	}
 
+	if !r.Path.HasWildcard() {
+		r.FetchRequest.Partition = r.Path.Partition()
+		return r.fetchPartition(router, ms)
+	}
+
+	// a wildcard route fetches from every partition that can contain matching topics,
+	// applying the same FetchRequest on each of them
+	partitions, err := ms.Partitions()
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		if !r.Path.MatchesPartition(partition.Name()) {
+			continue
+		}
+		r.FetchRequest.Partition = partition.Name()
+		if err := r.fetchPartition(router, ms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchPartition fetches the messages from the partition set in the FetchRequest
+// and delivers them to the route
+func (r *Route) fetchPartition(router Router, ms store.MessageStore) error {
 	var (
 		lastID   uint64
 		received int
@@ -184,11 +222,20 @@ REFETCH:
 				return err
 			}
 
+			lastID = message.ID
+			if r.Path.HasWildcard() && !r.Path.Matches(message.Path) {
+				continue
+			}
+			if message.IsExpired() {
+				r.logger.WithField("messageID", message.ID).Debug("Skipping expired fetched message")
+				mTotalMessagesExpired.Add(1)
+				continue
+			}
+
 			r.logger.WithField("messageID", message.ID).Debug("Sending fetched message in channel")
 			if err := r.Deliver(message, true); err != nil {
 				return err
 			}
-			lastID = message.ID
 			received++
 		case err := <-r.FetchRequest.Errors():
 			return err
@@ -241,6 +288,20 @@ func (r *Route) setInvalid(invalid bool) {
 	r.invalid = invalid
 }
 
+func (r *Route) isBlocking() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.blocking
+}
+
+// isFull returns true if a message cannot be delivered without waiting
+func (r *Route) isFull() bool {
+	if r.queueSize == 0 {
+		return len(r.messagesC) >= cap(r.messagesC)
+	}
+	return !r.hasRoomInQueue()
+}
+
 func (r *Route) isConsuming() bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -253,18 +314,39 @@ func (r *Route) setConsuming(consuming bool) {
 	r.consuming = consuming
 }
 
+// startConsuming marks the route as consuming and returns true if it was not consuming already.
+// The route can be delivered to concurrently by several router shards, so the check and set must be atomic.
+func (r *Route) startConsuming() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.consuming {
+		return false
+	}
+	r.consuming = true
+	return true
+}
+
+// stopConsumingIfEmpty marks the route as not consuming and returns true, if the queue is empty.
+// The check and set are atomic with startConsuming, so that a message delivered meanwhile starts a new consumer.
+func (r *Route) stopConsumingIfEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue.size() > 0 {
+		return false
+	}
+	r.consuming = false
+	return true
+}
+
 // consume starts a goroutine to consume the queue and pass the messages to route
 // channel. Stops if there are no items in the queue.
 func (r *Route) consume() {
-	if r.isConsuming() {
+	if !r.startConsuming() {
 		return
 	}
-	r.setConsuming(true)
 
 	r.logger.Debug("Consuming route queue")
 	go func() {
-		defer r.setConsuming(false)
-
 		var (
 			msg *protocol.Message
 			err error
@@ -274,6 +356,7 @@ func (r *Route) consume() {
 			if r.isInvalid() {
 				r.logger.Debug("Stopping to consume because route is invalid.")
 				mTotalDeliverMessageErrors.Add(1)
+				r.setConsuming(false)
 				return
 			}
 
@@ -281,8 +364,12 @@ func (r *Route) consume() {
 
 			if err != nil {
 				if err == errEmptyQueue {
-					r.logger.Debug("Empty queue")
-					return
+					// a message can be pushed after polling, before the consumer is marked as stopped
+					if r.stopConsumingIfEmpty() {
+						r.logger.Debug("Empty queue")
+						return
+					}
+					continue
 				}
 				r.logger.WithField("error", err).Error("Error fetching a message from queue")
 				continue
@@ -292,11 +379,13 @@ func (r *Route) consume() {
 				r.logger.WithField("message", msg).Error("Error sending message through route")
 				if err == errTimeout || err == ErrInvalidRoute {
 					// channel been closed, ending the consumer
+					r.setConsuming(false)
 					return
 				}
 			}
 			// remove the first item from the queue
 			r.queue.remove()
+			r.notifyDequeued()
 		}
 	}()
 	runtime.Gosched()
@@ -315,12 +404,23 @@ func (r *Route) send(msg *protocol.Message) error {
 		return nil
 	}
 
+	timeout := r.timeout
+	if r.policy() == PolicyBlock {
+		timeout = r.deadline()
+	}
+
 	select {
 	case r.messagesC <- msg:
 		return nil
 	case <-r.closeC:
 		return ErrInvalidRoute
-	case <-time.After(r.timeout):
+	case <-time.After(timeout):
+		mTotalDroppedMessages[r.policy()].Add(1)
+		if r.policy() == PolicyDropOldest || r.policy() == PolicyDropNewest {
+			// the message could not be sent in time: drop it and keep the route open
+			r.logger.Debug("Dropping message because of timeout")
+			return nil
+		}
 		r.logger.Debug("Closing route because of timeout")
 		r.Close()
 		return errTimeout
@@ -347,8 +447,184 @@ func (r *Route) sendDirect(msg *protocol.Message, store bool) error {
 	case r.messagesC <- msg:
 		return nil
 	default:
-		r.logger.Debug("Closing route because of full channel")
+	}
+
+	switch r.policy() {
+	case PolicyDropNewest:
+		r.logger.Debug("Dropping newest message because of full channel")
+		mTotalDroppedMessages[PolicyDropNewest].Add(1)
+		return nil
+	case PolicyDropOldest:
+		for {
+			select {
+			case <-r.messagesC:
+				r.logger.Debug("Dropping oldest message because of full channel")
+				mTotalDroppedMessages[PolicyDropOldest].Add(1)
+			default:
+			}
+			select {
+			case r.messagesC <- msg:
+				return nil
+			default:
+			}
+		}
+	case PolicyBlock:
+		select {
+		case r.messagesC <- msg:
+			return nil
+		case <-r.closeC:
+			return ErrInvalidRoute
+		case <-time.After(r.deadline()):
+		}
+	}
+
+	r.logger.Debug("Closing route because of full channel")
+	mTotalDroppedMessages[r.policy()].Add(1)
+	r.Close()
+	return ErrChannelFull
+}
+
+// handleFullQueue applies the slow consumer policy when a message is delivered and the queue is full.
+// If no error is returned, the message has to be pushed in the queue only if there is room for it.
+func (r *Route) handleFullQueue(msg *protocol.Message) error {
+	switch r.policy() {
+	case PolicyDropNewest:
+		r.logger.WithField("message", msg).Debug("Dropping newest message because queue is full")
+		mTotalDroppedMessages[PolicyDropNewest].Add(1)
+		return nil
+	case PolicyDropOldest:
+		if r.queue.dropOldest() {
+			r.logger.Debug("Dropping oldest message because queue is full")
+		} else {
+			// the only queued message is being sent, so the new one is the oldest which can be dropped
+			r.logger.WithField("message", msg).Debug("Dropping message because queue is full")
+		}
+		mTotalDroppedMessages[PolicyDropOldest].Add(1)
+		return nil
+	case PolicyBlock:
+		deadline := time.After(r.deadline())
+		for !r.hasRoomInQueue() {
+			select {
+			case <-r.dequeuedC:
+			case <-r.closeC:
+				return ErrInvalidRoute
+			case <-deadline:
+				r.logger.WithField("message", msg).Error("Closing route because queue is full after deadline")
+				mTotalDroppedMessages[PolicyBlock].Add(1)
+				r.Close()
+				mTotalDeliverMessageErrors.Add(1)
+				return ErrQueueFull
+			}
+		}
+		return nil
+	}
+
+	r.logger.WithField("message", msg).Error("Closing route because queue is full")
+	mTotalDroppedMessages[PolicyClose].Add(1)
+	r.Close()
+	mTotalDeliverMessageErrors.Add(1)
+	return ErrQueueFull
+}
+
+// block hands the message over to the goroutine delivering the blocked messages, which is started if needed.
+// The route is closed if too many messages are waiting already.
+func (r *Route) block(msg *protocol.Message) error {
+	r.mu.Lock()
+	if r.blockedC == nil {
+		r.blockedC = make(chan *protocol.Message, r.blockedSize())
+	}
+	queued := false
+	select {
+	case r.blockedC <- msg:
+		queued = true
+	default:
+	}
+	start := queued && !r.blocking
+	if start {
+		r.blocking = true
+	}
+	r.mu.Unlock()
+
+	if !queued {
+		r.logger.WithField("message", msg).Error("Closing route because too many messages are blocked")
+		mTotalDroppedMessages[PolicyBlock].Add(1)
 		r.Close()
-		return ErrChannelFull
+		mTotalDeliverMessageErrors.Add(1)
+		return ErrQueueFull
+	}
+	if start {
+		go r.deliverBlocked()
+	}
+	return nil
+}
+
+// blockedSize returns how many messages can wait for room in the route, as many as it can hold
+func (r *Route) blockedSize() int {
+	if r.queueSize > r.ChannelSize {
+		return r.queueSize
+	}
+	if r.ChannelSize > 0 {
+		return r.ChannelSize
+	}
+	return 1
+}
+
+// deliverBlocked delivers the blocked messages in order, waiting for room in the route until the deadline.
+// It stops when there are no more blocked messages, or when the route is closed.
+func (r *Route) deliverBlocked() {
+	for {
+		select {
+		case msg := <-r.blockedC:
+			if err := r.deliverWhenRoom(msg); err != nil {
+				return
+			}
+		default:
+			// a message can be blocked after checking the channel, before the goroutine is marked as stopped
+			if r.stopBlockingIfEmpty() {
+				return
+			}
+		}
+	}
+}
+
+// stopBlockingIfEmpty marks the goroutine delivering the blocked messages as stopped and returns true,
+// if there is no blocked message
+func (r *Route) stopBlockingIfEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.blockedC) > 0 {
+		return false
+	}
+	r.blocking = false
+	return true
+}
+
+// deliverWhenRoom waits for room in the route before delivering the message, applying PolicyBlock
+func (r *Route) deliverWhenRoom(msg *protocol.Message) error {
+	if r.isInvalid() {
+		return ErrInvalidRoute
+	}
+	if r.queueSize == 0 {
+		return r.sendDirect(msg, false)
+	}
+	if !r.hasRoomInQueue() {
+		if err := r.handleFullQueue(msg); err != nil {
+			return err
+		}
+	}
+	r.queue.push(msg)
+	r.consume()
+	return nil
+}
+
+func (r *Route) hasRoomInQueue() bool {
+	return r.queue.size() < r.queueSize
+}
+
+// notifyDequeued notifies a delivery blocked on a full queue, without blocking the consumer
+func (r *Route) notifyDequeued() {
+	select {
+	case r.dequeuedC <- struct{}{}:
+	default:
 	}
 }
@@ -1,12 +1,98 @@
 package router
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 )
 
+const (
+	defaultBlockDeadline = time.Second
+
+	// maxBlockDeadline is the longest deadline of PolicyBlock, so that the clients cannot hold messages for long
+	maxBlockDeadline = time.Minute
+)
+
+// SlowConsumerPolicy defines what a route does with the messages it cannot deliver,
+// because its consumer doesn't read them fast enough.
+type SlowConsumerPolicy string
+
+const (
+	// PolicyClose closes the route, so that the consumer has to fetch the missed messages.
+	PolicyClose SlowConsumerPolicy = "close"
+
+	// PolicyDropOldest drops the oldest message waiting to be delivered, making room for the new one.
+	PolicyDropOldest SlowConsumerPolicy = "drop-oldest"
+
+	// PolicyDropNewest drops the new message, keeping the ones waiting to be delivered.
+	PolicyDropNewest SlowConsumerPolicy = "drop-newest"
+
+	// PolicyBlock delays the delivery until there is room for the new message, or closes the route
+	// if the deadline is exceeded. The router is not blocked, as the messages wait in the goroutine of the route.
+	PolicyBlock SlowConsumerPolicy = "block"
+)
+
+// ParseSlowConsumerPolicy returns the policy with the given name.
+// The empty name is accepted, and stands for the default policy.
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case "", PolicyClose, PolicyDropOldest, PolicyDropNewest, PolicyBlock:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q", name)
+}
+
+// SlowConsumerConfig defines how a route handles a consumer which doesn't read its messages fast enough:
+// when the channel or the queue of the route is full, or when the sending timeout expires.
+type SlowConsumerConfig struct {
+	// Policy applied to the messages which cannot be delivered. If not set, PolicyClose is used.
+	Policy SlowConsumerPolicy `json:",omitempty"`
+
+	// Deadline is how long PolicyBlock waits before closing the route. If not set, defaultBlockDeadline is used.
+	// It is limited to maxBlockDeadline.
+	Deadline time.Duration `json:",omitempty"`
+}
+
+// ParseSlowConsumerConfig returns the configuration for the given policy name and deadline.
+// The deadline is a duration as accepted by time.ParseDuration, up to maxBlockDeadline, and can be empty.
+func ParseSlowConsumerConfig(policy, deadline string) (SlowConsumerConfig, error) {
+	var (
+		config SlowConsumerConfig
+		err    error
+	)
+	if config.Policy, err = ParseSlowConsumerPolicy(policy); err != nil {
+		return config, err
+	}
+	if deadline != "" {
+		if config.Deadline, err = time.ParseDuration(deadline); err != nil {
+			return config, fmt.Errorf("deadline has to be a duration, but was %q: %v", deadline, err)
+		}
+		if config.Deadline > maxBlockDeadline {
+			return config, fmt.Errorf("deadline can be at most %v, but was %v", maxBlockDeadline, config.Deadline)
+		}
+	}
+	return config, nil
+}
+
+func (sc SlowConsumerConfig) policy() SlowConsumerPolicy {
+	if sc.Policy == "" {
+		return PolicyClose
+	}
+	return sc.Policy
+}
+
+func (sc SlowConsumerConfig) deadline() time.Duration {
+	if sc.Deadline <= 0 {
+		return defaultBlockDeadline
+	}
+	if sc.Deadline > maxBlockDeadline {
+		return maxBlockDeadline
+	}
+	return sc.Deadline
+}
+
 // Matcher is a func type that receives two route configurations pointers as parameters and
 // returns true if the routes are matching
 type Matcher func(RouteConfig, RouteConfig, ...string) bool
@@ -28,11 +114,23 @@ type RouteConfig struct {
 	// If timeout is reached the route is closed.
 	timeout time.Duration
 
+	// SlowConsumerConfig defines what happens when the consumer of the route is too slow
+	SlowConsumerConfig
+
+	// FilterExpression if set has to be matched by the filters of a message for the message to be delivered.
+	// The message filters referenced by the expression are not checked against the route params.
+	FilterExpression *FilterExpression `json:",omitempty"`
+
+	// Group if set is the name of the consumer group of the route.
+	// Each message is delivered to a single route among the routes of a group on the same path.
+	Group string `json:",omitempty"`
+
 	// Matcher if set will be used to check equality of the routes
 	Matcher Matcher `json:"-"`
 
 	// FetchRequest to fetch messages before subscribing
-	// The Partition field of the FetchRequest is overrided with the Partition of the Route topic
+	// The Partition field of the FetchRequest is overrided with the Partition of the Route topic,
+	// or with each of the matching partitions if the Route topic contains wildcards
 	FetchRequest *store.FetchRequest `json:"-"`
 }
 
@@ -43,13 +141,17 @@ func (rc *RouteConfig) Equal(other RouteConfig, keys ...string) bool {
 	return rc.Path == other.Path && rc.RouteParams.Equal(other.RouteParams, keys...)
 }
 
-// messageFilter returns true if the route matches message filters
+// messageFilter returns true if the route matches message filters.
+// Each message filter has to be equal to the route param with the same name,
+// unless it is referenced by the filter expression of the route, which has to match too.
 func (rc *RouteConfig) messageFilter(m *protocol.Message) bool {
-	if m.Filters == nil {
-		return true
+	for key, value := range m.Filters {
+		if !rc.FilterExpression.references(key) && rc.Get(key) != value {
+			return false
+		}
 	}
 
-	return rc.Filter(m.Filters)
+	return rc.FilterExpression == nil || rc.FilterExpression.Matches(m.Filters)
 }
 
 // Filter returns true if all filters are matched on the route
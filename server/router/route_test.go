@@ -2,6 +2,8 @@ package router
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -12,6 +14,7 @@ import (
 	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/filestore"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -144,6 +147,176 @@ func TestRouteDeliver_WithTimeout(t *testing.T) {
 	a.False(r.consuming)
 }
 
+func TestRouteDeliver_SlowConsumerPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy      SlowConsumerPolicy
+		queueSize   int
+		expectedIDs []uint64 // the ids received after delivering ids from 1 to chanSize+queueSize+2
+		invalid     bool
+	}{
+		{PolicyClose, 0, ids(1, chanSize), true},
+		{PolicyDropNewest, 0, ids(1, chanSize), false},
+		{PolicyDropOldest, 0, ids(3, chanSize+2), false},
+		{PolicyBlock, 0, ids(1, chanSize), true},
+		{PolicyDropNewest, queueSize, ids(1, chanSize+queueSize), false},
+		{PolicyDropOldest, queueSize, append(ids(1, chanSize+1), ids(chanSize+4, chanSize+queueSize+2)...), false},
+	} {
+		a := assert.New(t)
+		resetRouterMetrics()
+
+		r := testRoute()
+		r.queueSize = test.queueSize
+		r.timeout = -1
+		r.Policy = test.policy
+		r.Deadline = 5 * time.Millisecond
+
+		// deliver 2 messages more than the route can hold,
+		// the consumer of a queued route holding an extra message while blocked on sending it
+		for i := 1; i <= chanSize+test.queueSize+2; i++ {
+			r.Deliver(&protocol.Message{ID: uint64(i), Path: dummyPath}, false)
+			if test.queueSize > 0 && i <= chanSize+1 {
+				waitForConsumer(r, i)
+			}
+		}
+		// the blocked messages wait for room until the deadline
+		time.Sleep(4 * r.Deadline)
+
+		received := make([]uint64, 0)
+		for done := false; !done; {
+			select {
+			case m, open := <-r.MessagesChannel():
+				if open {
+					received = append(received, m.ID)
+				} else {
+					done = true
+				}
+			case <-time.After(20 * time.Millisecond):
+				done = true
+			}
+		}
+		a.Equal(test.expectedIDs, received, "policy %s, queue size %d", test.policy, test.queueSize)
+		a.Equal(test.invalid, r.isInvalid(), "policy %s, queue size %d", test.policy, test.queueSize)
+	}
+}
+
+func TestRouteDeliver_BlockUntilReceived(t *testing.T) {
+	for _, size := range []int{0, queueSize} {
+		a := assert.New(t)
+
+		r := testRoute()
+		r.queueSize = size
+		r.timeout = -1
+		r.Policy = PolicyBlock
+		r.Deadline = time.Second
+
+		// fill the channel, and the queue with the consumer blocked on sending its first message
+		for i := 1; i <= chanSize+size; i++ {
+			a.NoError(r.Deliver(&protocol.Message{ID: uint64(i), Path: dummyPath}, false))
+			if size > 0 && i <= chanSize+1 {
+				waitForConsumer(r, i)
+			}
+		}
+
+		// the delivery does not block the router while the route is full,
+		// and the messages wait in order until the consumer receives a message
+		for i := chanSize + size + 1; i <= chanSize+size+3; i++ {
+			done := make(chan error)
+			go func(id uint64) {
+				done <- r.Deliver(&protocol.Message{ID: id, Path: dummyPath}, false)
+			}(uint64(i))
+			select {
+			case err := <-done:
+				a.NoError(err)
+			case <-time.After(20 * time.Millisecond):
+				a.Fail("The delivery should not block", "queue size %d", size)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		a.False(r.isInvalid())
+
+		// and all the messages are received in order
+		for i := 1; i <= chanSize+size+3; i++ {
+			select {
+			case m := <-r.MessagesChannel():
+				a.Equal(uint64(i), m.ID)
+			case <-time.After(20 * time.Millisecond):
+				a.Fail("Message not received", "queue size %d", size)
+			}
+		}
+	}
+}
+
+func TestParseSlowConsumerConfig(t *testing.T) {
+	a := assert.New(t)
+
+	config, err := ParseSlowConsumerConfig("", "")
+	a.NoError(err)
+	a.Equal(PolicyClose, config.policy())
+	a.Equal(defaultBlockDeadline, config.deadline())
+
+	config, err = ParseSlowConsumerConfig("block", "100ms")
+	a.NoError(err)
+	a.Equal(SlowConsumerConfig{Policy: PolicyBlock, Deadline: 100 * time.Millisecond}, config)
+
+	_, err = ParseSlowConsumerConfig("unknown", "")
+	a.Error(err)
+
+	_, err = ParseSlowConsumerConfig("block", "soon")
+	a.Error(err)
+
+	_, err = ParseSlowConsumerConfig("block", "1000h")
+	a.Error(err)
+	a.Equal(maxBlockDeadline, SlowConsumerConfig{Deadline: 1000 * time.Hour}.deadline())
+}
+
+func TestRouteDeliver_TooManyBlocked(t *testing.T) {
+	a := assert.New(t)
+
+	r := testRoute()
+	r.queueSize = 0
+	r.timeout = -1
+	r.Policy = PolicyBlock
+	r.Deadline = time.Second
+
+	// the route can hold about as many blocked messages as its channel
+	var err error
+	delivered := 0
+	for err == nil && delivered <= 3*chanSize {
+		err = r.Deliver(&protocol.Message{ID: uint64(delivered + 1), Path: dummyPath}, false)
+		delivered++
+	}
+	a.Equal(ErrQueueFull, err)
+	a.True(delivered > 2*chanSize && delivered <= 2*chanSize+2)
+	a.True(r.isInvalid())
+}
+
+// waitForConsumer waits until the consumer of a queued route sent the delivered messages in the channel,
+// or polled the first one not fitting in the channel
+func waitForConsumer(r *Route, delivered int) {
+	inChannel := delivered
+	if inChannel > cap(r.messagesC) {
+		inChannel = cap(r.messagesC)
+	}
+	for i := 0; i < 100; i++ {
+		r.queue.mu.Lock()
+		pending, polled := len(r.queue.queue), r.queue.polled
+		r.queue.mu.Unlock()
+
+		if len(r.messagesC) == inChannel && pending == delivered-inChannel && (pending == 0 || polled) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func ids(from, to int) []uint64 {
+	ids := make([]uint64, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		ids = append(ids, uint64(i))
+	}
+	return ids
+}
+
 func TestRoute_CloseTwice(t *testing.T) {
 	a := assert.New(t)
 
@@ -316,6 +489,108 @@ func TestRoute_Provide_Fetch(t *testing.T) {
 	<-done
 }
 
+func TestRoute_Provide_FetchWithWildcard(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_route_test")
+	defer os.RemoveAll(dir)
+	fs := filestore.New(dir)
+	defer fs.Stop()
+
+	for _, path := range []protocol.Path{
+		"/orders/1/shipped",
+		"/orders/2/created",
+		"/invoices/3/shipped",
+		"/orders/4/shipped",
+	} {
+		_, err := fs.StoreMessage(&protocol.Message{Path: path, Body: []byte("body")}, 0)
+		a.NoError(err)
+	}
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(fs, nil)
+	routerMock.EXPECT().Done().Return(make(chan bool)).AnyTimes()
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
+		fs.Fetch(req)
+	}).AnyTimes()
+
+	route := NewRoute(RouteConfig{
+		Path:         protocol.Path("/*/*/shipped"),
+		ChannelSize:  5,
+		FetchRequest: store.NewFetchRequest("", 0, 0, store.DirectionForward, -1),
+	})
+
+	err := route.Provide(routerMock, false)
+	a.NoError(err)
+
+	received := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-route.MessagesChannel():
+			received = append(received, string(m.Path))
+		case <-time.After(50 * time.Millisecond):
+			a.Fail("Message not received")
+		}
+	}
+	a.Equal(0, len(route.MessagesChannel()))
+	a.Contains(received, "/orders/1/shipped")
+	a.Contains(received, "/orders/4/shipped")
+	a.Contains(received, "/invoices/3/shipped")
+}
+
+func TestRoute_Provide_FetchSkipsExpiredMessages(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_route_test")
+	defer os.RemoveAll(dir)
+	fs := filestore.New(dir)
+	defer fs.Stop()
+
+	for body, expires := range map[string]int64{
+		"never":   0,
+		"expired": time.Now().Add(-time.Minute).Unix(),
+		"later":   time.Now().Add(time.Minute).Unix(),
+	} {
+		_, err := fs.StoreMessage(&protocol.Message{Path: "/orders", Body: []byte(body), Expires: expires}, 0)
+		a.NoError(err)
+	}
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(fs, nil)
+	routerMock.EXPECT().Done().Return(make(chan bool)).AnyTimes()
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
+		fs.Fetch(req)
+	}).AnyTimes()
+
+	route := NewRoute(RouteConfig{
+		Path:         protocol.Path("/orders"),
+		ChannelSize:  5,
+		FetchRequest: store.NewFetchRequest("", 0, 0, store.DirectionForward, -1),
+	})
+
+	err := route.Provide(routerMock, false)
+	a.NoError(err)
+
+	received := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-route.MessagesChannel():
+			received = append(received, string(m.Body))
+		case <-time.After(50 * time.Millisecond):
+			a.Fail("Message not received")
+		}
+	}
+	a.Equal(0, len(route.MessagesChannel()))
+	a.Contains(received, "never")
+	a.Contains(received, "later")
+}
+
 func TestRoute_Provide_WithSubscribe(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -407,7 +682,7 @@ func TestRoute_Provide_MultipleFetch(t *testing.T) {
 	memoryKV := kvstore.NewMemoryKVStore()
 
 	msMock := NewMockMessageStore(ctrl)
-	router := New(auth.AllowAllAccessManager(true), msMock, memoryKV, nil)
+	router := New(auth.AllowAllAccessManager(true), msMock, memoryKV, nil, Config{})
 
 	if startable, ok := router.(startable); ok {
 		startable.Start()
@@ -2,22 +2,23 @@ package router
 
 import (
 	"fmt"
+	"hash/fnv"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/health"
 
 	"encoding/json"
 
-	"net/http"
-
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/cluster"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/topics"
 )
 
 const (
@@ -50,85 +51,145 @@ type subRequest struct {
 	doneC chan bool
 }
 
+// Config is used for configuring the router.
+type Config struct {
+	// Shards is the number of dispatch loops. The messages are distributed among them by partition.
+	// If not set, the number of CPUs is used.
+	Shards int
+
+	// DeadLetterPrefix is the topic prefix under which the undeliverable messages are republished.
+	// If not set, the undeliverable messages are only logged.
+	DeadLetterPrefix string
+
+	// Topics holds the per-topic configurations enforced when publishing (optional)
+	Topics *topics.Registry
+
+	// RateLimit configures the limits of the publishing rate
+	RateLimit RateLimitConfig
+
+	// PresencePrefix is the topic prefix under which the users joining and leaving the topics are announced.
+	// If not set, the presence of the users is only tracked, for the admin API.
+	PresencePrefix string
+
+	// ReplyPrefix is the topic prefix of the temporary topics on which the replies to the requests are received.
+	// Their messages are routed without being stored, unless their topics are configured.
+	// If not set, the replies are stored as the other messages.
+	ReplyPrefix string
+
+	// IdempotencyWindow is the time during which the idempotency keys of the published messages are remembered,
+	// to deduplicate the messages published again with the same key. If not set, the keys are ignored.
+	IdempotencyWindow time.Duration
+}
+
 type router struct {
-	routes       map[protocol.Path][]*Route // mapping the path to the route slice
-	handleC      chan *protocol.Message
-	subscribeC   chan subRequest
-	unsubscribeC chan subRequest
-	stopC        chan bool      // Channel that signals stop of the router
-	stopping     bool           // Flag: the router is in stopping process and no incoming messages are accepted
-	wg           sync.WaitGroup // Add any operation that we need to wait upon here
+	shards   []*shard       // dispatch loops, each owning the routes of a subset of partitions
+	stopC    chan bool      // Channel that signals stop of the router
+	stopping bool           // Flag: the router is in stopping process and no incoming messages are accepted
+	wg       sync.WaitGroup // Add any operation that we need to wait upon here
 
 	accessManager auth.AccessManager
 	messageStore  store.MessageStore
 	kvStore       kvstore.KVStore
 	cluster       *cluster.Cluster
+	interceptors  []Interceptor
+	scheduler     Scheduler
+
+	deadLetterPrefix protocol.Path
+	topics           *topics.Registry
+
+	partitionCounters partitionCounters
+
+	rateLimit   RateLimitConfig
+	rateLimiter *rateLimiter
+	idempotency *idempotencyCache
+
+	groupsMu sync.Mutex // serializes the announcements of the consumer groups
+
+	presence       presenceTable
+	presencePrefix protocol.Path
+	presenceC      chan *protocol.Message // presence events waiting to be published
+	presenceMu     sync.Mutex             // serializes the announcements of the presence
+
+	replyPrefix protocol.Path
+
+	retainedC     chan retainedWrite // changes of the retained messages waiting to be persisted
+	retainedDoneC chan bool          // closed when the changes are persisted after stopping
 
 	sync.RWMutex
 }
 
 // New returns a pointer to Router
-func New(accessManager auth.AccessManager, messageStore store.MessageStore, kvStore kvstore.KVStore, cluster *cluster.Cluster) Router {
-	return &router{
-		routes: make(map[protocol.Path][]*Route),
-
-		handleC:      make(chan *protocol.Message, handleChannelCapacity),
-		subscribeC:   make(chan subRequest, subscribeChannelCapacity),
-		unsubscribeC: make(chan subRequest, unsubscribeChannelCapacity),
-		stopC:        make(chan bool, 1),
+func New(accessManager auth.AccessManager, messageStore store.MessageStore, kvStore kvstore.KVStore, cluster *cluster.Cluster, config Config) Router {
+	if config.Shards <= 0 {
+		config.Shards = runtime.NumCPU()
+	}
+	router := &router{
+		stopC: make(chan bool),
 
 		accessManager: accessManager,
 		messageStore:  messageStore,
 		kvStore:       kvStore,
 		cluster:       cluster,
+
+		deadLetterPrefix: protocol.Path(config.DeadLetterPrefix),
+		topics:           config.Topics,
+		presencePrefix:   protocol.Path(config.PresencePrefix),
+		presenceC:        make(chan *protocol.Message, presenceChannelCapacity),
+		replyPrefix:      protocol.Path(config.ReplyPrefix),
+
+		partitionCounters: partitionCounters{stats: make(map[string]*partitionStats)},
+
+		rateLimit:   config.RateLimit,
+		rateLimiter: newRateLimiter(),
+	}
+	if config.IdempotencyWindow > 0 {
+		router.idempotency = newIdempotencyCache(config.IdempotencyWindow)
 	}
+	router.shards = make([]*shard, config.Shards)
+	for i := range router.shards {
+		router.shards[i] = newShard(router)
+	}
+	return router
 }
 
 func (router *router) Start() error {
 	router.panicIfInternalDependenciesAreNil()
-	logger.Info("Starting router")
+	logger.WithField("shards", len(router.shards)).Info("Starting router")
 	resetRouterMetrics()
+	router.loadRetained()
+	router.retainedC = make(chan retainedWrite, retainedChannelCapacity)
+	router.retainedDoneC = make(chan bool)
+	go router.persistRetained()
 
-	router.wg.Add(1)
-	router.setStopping(false)
-
-	go func() {
-		for {
-			if router.stopping && router.channelsAreEmpty() {
-				router.closeRoutes()
-				router.wg.Done()
-				return
-			}
+	stopC := router.start()
 
-			func() {
-				defer protocol.PanicLogger()
-
-				select {
-				case message := <-router.handleC:
-					router.handleMessage(message)
-					runtime.Gosched()
-				case subscriber := <-router.subscribeC:
-					router.subscribe(subscriber.route)
-					subscriber.doneC <- true
-				case unsubscriber := <-router.unsubscribeC:
-					router.unsubscribe(unsubscriber.route)
-					unsubscriber.doneC <- true
-				case <-router.Done():
-					router.setStopping(true)
-				}
-			}()
-		}
-	}()
+	for _, s := range router.shards {
+		router.wg.Add(1)
+		go s.loop(stopC)
+	}
+	if router.presencePrefix != "" {
+		router.wg.Add(1)
+		go router.publishPresence(stopC)
+	}
 
 	return nil
 }
 
-// Stop stops the router by closing the stop channel, and waiting on the WaitGroup
+// Stop stops the router by closing the stop channel, and waiting on the WaitGroup.
+// Each shard closes its routes after handling the messages and requests already queued,
+// and the changes of the retained messages are persisted.
+// Stopping a router which is stopped already has no effect.
 func (router *router) Stop() error {
+	if !router.stop() {
+		return nil
+	}
 	logger.Info("Stopping router")
 
-	router.stopC <- true
 	router.wg.Wait()
+	if router.retainedC != nil {
+		close(router.retainedC)
+		<-router.retainedDoneC
+	}
 	return nil
 }
 
@@ -157,6 +218,16 @@ func (router *router) Check() error {
 // HandleMessage stores the message in the MessageStore(and gets a new ID for it if the message was created locally)
 // and then passes it to the internal channel, and asynchronously to the cluster (if available).
 func (router *router) HandleMessage(message *protocol.Message) error {
+	return router.handleMessage(message, fromPublisher)
+}
+
+// publishInternal publishes a message created by the router itself, e.g. a presence event,
+// which is not subject to the access control, the allowed publishers of its topic, the rate limits and the interceptors
+func (router *router) publishInternal(message *protocol.Message) error {
+	return router.handleMessage(message, fromRouter)
+}
+
+func (router *router) handleMessage(message *protocol.Message, origin messageOrigin) (err error) {
 	logger.WithFields(log.Fields{
 		"userID": message.UserID,
 		"path":   message.Path}).Debug("HandleMessage")
@@ -167,27 +238,87 @@ func (router *router) HandleMessage(message *protocol.Message) error {
 		return err
 	}
 
-	if !router.accessManager.IsAllowed(auth.WRITE, message.UserID, message.Path) {
+	if message.Path.HasWildcard() {
+		return ErrWildcardTopic
+	}
+
+	if origin == fromPublisher && !router.accessManager.IsAllowed(auth.WRITE, message.UserID, message.Path) {
 		return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path}
 	}
 
+	// the idempotency key of a scheduled message is handled when it is scheduled
+	if message.IdempotencyKey != "" && router.idempotency != nil && origin != fromScheduler {
+		partition := message.Path.Partition()
+		if message.NodeID == 0 {
+			entry, duplicate := router.handleIdempotencyKey(message)
+			if duplicate {
+				return nil
+			}
+			defer func() {
+				router.idempotency.complete(partition, entry, message, err)
+			}()
+		} else {
+			// remember the keys of the messages published on other cluster nodes, to deduplicate their retries
+			defer func() {
+				if err == nil {
+					router.idempotency.remember(partition, message)
+				}
+			}()
+		}
+	}
+
+	topicConfig := router.topics.Lookup(message.Path)
+
+	// messages received from other cluster nodes have been checked and intercepted already,
+	// and so have the scheduled messages passed again by the scheduler
+	if origin == fromPublisher && message.NodeID == 0 {
+		if err := router.checkTopicConfig(topicConfig, message); err != nil {
+			return err
+		}
+		if err := router.checkRateLimit(topicConfig, message); err != nil {
+			return err
+		}
+		if err := router.intercept(message); err != nil {
+			logger.WithError(err).WithField("path", message.Path).Info("Message rejected")
+			return err
+		}
+	}
+
+	// scheduled messages are handled when they are passed again by the scheduler, at their delivery time
+	if origin != fromScheduler && message.NodeID == 0 && message.IsScheduled() {
+		return router.schedule(message)
+	}
+
+	// the expiry of a scheduled message starts at its delivery time
+	if message.NodeID == 0 {
+		topicConfig.ApplyExpiry(message)
+	}
+
 	var nodeID uint8
 	if router.cluster != nil {
 		nodeID = router.cluster.Config.ID
 	}
 
 	mTotalMessagesIncomingBytes.Add(int64(len(message.Bytes())))
-	size, err := router.messageStore.StoreMessage(message, nodeID)
-	if err != nil {
-		logger.WithField("error", err.Error()).Error("Error storing message")
-		mTotalMessageStoreErrors.Add(1)
-		return err
+	if router.isTransient(topicConfig, message) {
+		if err := router.generateID(message, nodeID); err != nil {
+			return err
+		}
+	} else {
+		size, err := router.messageStore.StoreMessage(message, nodeID)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("Error storing message")
+			mTotalMessageStoreErrors.Add(1)
+			return err
+		}
+		mTotalMessagesStoredBytes.Add(int64(size))
 	}
-	mTotalMessagesStoredBytes.Add(int64(size))
 
-	router.handleOverloadedChannel()
+	partition := message.Path.Partition()
+	s := router.shardFor(partition)
+	router.partitionCounters.add(partition, s.handleOverloadedChannel())
 
-	router.handleC <- message
+	s.handleC <- message
 
 	if router.cluster != nil && message.NodeID == router.cluster.Config.ID {
 		go router.cluster.BroadcastMessage(message)
@@ -213,13 +344,17 @@ func (router *router) Subscribe(r *Route) (*Route, error) {
 	if !accessAllowed {
 		return r, &PermissionDeniedError{UserID: userID, AccessType: auth.READ, Path: routePath}
 	}
-	req := subRequest{
-		route: r,
-		doneC: make(chan bool),
+	for _, s := range router.shardsFor(routePath) {
+		req := subRequest{
+			route: r,
+			doneC: make(chan bool),
+		}
+		s.subscribeC <- req
+		<-req.doneC
+	}
+	if r.Group != "" {
+		router.broadcastGroups()
 	}
-
-	router.subscribeC <- req
-	<-req.doneC
 	return r, nil
 }
 
@@ -230,17 +365,23 @@ func (router *router) Unsubscribe(r *Route) {
 		"route":         r,
 	}).Debug("Unsubscribe")
 
-	req := subRequest{
-		route: r,
-		doneC: make(chan bool),
+	for _, s := range router.shardsFor(r.Path) {
+		req := subRequest{
+			route: r,
+			doneC: make(chan bool),
+		}
+		s.unsubscribeC <- req
+		<-req.doneC
+	}
+	if r.Group != "" {
+		router.broadcastGroups()
 	}
-	router.unsubscribeC <- req
-	<-req.doneC
 }
 
 func (router *router) GetSubscribers(topicPath string) ([]byte, error) {
 	subscribers := make([]RouteParams, 0)
-	routes, present := router.routes[protocol.Path(topicPath)]
+	path := protocol.Path(topicPath)
+	routes, present := router.shardsFor(path)[0].routesForPath(path)
 	if present {
 		for index, currRoute := range routes {
 			logger.WithFields(log.Fields{
@@ -253,55 +394,6 @@ func (router *router) GetSubscribers(topicPath string) ([]byte, error) {
 	return json.Marshal(subscribers)
 }
 
-func (router *router) subscribe(r *Route) {
-	logger.WithField("route", r).Debug("Internal subscribe")
-	mTotalSubscriptionAttempts.Add(1)
-
-	routePath := r.Path
-	slice, present := router.routes[routePath]
-	var removed bool
-	if present {
-		// Try to remove, to avoid double subscriptions of the same app
-		slice, removed = removeIfMatching(slice, r)
-	} else {
-		// Path not present yet. Initialize the slice
-		slice = make([]*Route, 0, 1)
-		router.routes[routePath] = slice
-		mCurrentRoutes.Add(1)
-	}
-	router.routes[routePath] = append(slice, r)
-	if removed {
-		mTotalDuplicateSubscriptionsAttempts.Add(1)
-	} else {
-		mTotalSubscriptions.Add(1)
-		mCurrentSubscriptions.Add(1)
-	}
-}
-
-func (router *router) unsubscribe(r *Route) {
-	logger.WithField("route", r).Debug("Internal unsubscribe")
-	mTotalUnsubscriptionAttempts.Add(1)
-
-	routePath := r.Path
-	slice, present := router.routes[routePath]
-	if !present {
-		mTotalInvalidTopicOnUnsubscriptionAttempts.Add(1)
-		return
-	}
-	var removed bool
-	router.routes[routePath], removed = removeIfMatching(slice, r)
-	if removed {
-		mTotalUnsubscriptions.Add(1)
-		mCurrentSubscriptions.Add(-1)
-	} else {
-		mTotalInvalidUnsubscriptionAttempts.Add(1)
-	}
-	if len(router.routes[routePath]) == 0 {
-		delete(router.routes, routePath)
-		mCurrentRoutes.Add(-1)
-	}
-}
-
 func (router *router) panicIfInternalDependenciesAreNil() {
 	if router.accessManager == nil || router.kvStore == nil || router.messageStore == nil {
 		panic(fmt.Sprintf("router: the internal dependencies marked with `true` are not set: AccessManager=%v, KVStore=%v, MessageStore=%v",
@@ -309,18 +401,39 @@ func (router *router) panicIfInternalDependenciesAreNil() {
 	}
 }
 
-func (router *router) channelsAreEmpty() bool {
-	return len(router.handleC) == 0 && len(router.subscribeC) == 0 && len(router.unsubscribeC) == 0
+// start marks the router as accepting messages, and returns its stop channel,
+// which is created again if the router was stopped before
+func (router *router) start() chan bool {
+	router.Lock()
+	defer router.Unlock()
+
+	select {
+	case <-router.stopC:
+		router.stopC = make(chan bool)
+	default:
+	}
+	router.stopping = false
+	return router.stopC
 }
 
-func (router *router) setStopping(v bool) {
+// stop marks the router as stopping and closes its stop channel.
+// It returns false if the router was stopping already.
+func (router *router) stop() bool {
 	router.Lock()
 	defer router.Unlock()
 
-	router.stopping = v
+	if router.stopping {
+		return false
+	}
+	router.stopping = true
+	close(router.stopC)
+	return true
 }
 
 func (router *router) Done() <-chan bool {
+	router.RLock()
+	defer router.RUnlock()
+
 	return router.stopC
 }
 
@@ -335,58 +448,45 @@ func (router *router) isStopping() error {
 	return nil
 }
 
-func (router *router) handleMessage(message *protocol.Message) {
-	flog := logger.WithFields(log.Fields{
-		"topic":    message.Path,
-		"metadata": message.Metadata(),
-		"filters":  message.Filters,
-	})
-	flog.Debug("Called routeMessage for data")
-	mTotalMessagesRouted.Add(1)
-
-	matched := false
-	for path, pathRoutes := range router.routes {
-		if matchesTopic(message.Path, path) {
-			matched = true
-			for _, route := range pathRoutes {
-				if err := route.Deliver(message, false); err == ErrInvalidRoute {
-					// Unsubscribe invalid routes
-					router.unsubscribe(route)
-				}
-			}
-		}
-	}
-
-	if !matched {
-		flog.Debug("No route matched.")
-		mTotalMessagesNotMatchingTopic.Add(1)
-	}
+// shardFor returns the shard handling the messages of the partition
+func (router *router) shardFor(partition string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(partition))
+	return router.shards[h.Sum32()%uint32(len(router.shards))]
 }
 
-func (router *router) closeRoutes() {
-	logger.Debug("closeRoutes")
-
-	for _, currentRouteList := range router.routes {
-		for _, route := range currentRouteList {
-			router.unsubscribe(route)
-			log.WithFields(log.Fields{"module": "router", "route": route.String()}).Debug("Closing route")
-			route.Close()
-		}
+// shardsFor returns the shards where a route with the supplied path has to be subscribed.
+// A path with a wildcard partition can match messages from any partition, so it belongs to all the shards.
+// The first returned shard is the one which accounts the route in the metrics.
+func (router *router) shardsFor(path protocol.Path) []*shard {
+	partition := path.Partition()
+	if partition == protocol.SingleLevelWildcard || partition == protocol.MultiLevelWildcard {
+		return router.shards
 	}
+	return []*shard{router.shardFor(partition)}
 }
 
-func (router *router) handleOverloadedChannel() {
-	if float32(len(router.handleC))/float32(cap(router.handleC)) > overloadedHandleChannelRatio {
-		logger.WithFields(log.Fields{
-			"currentLength": len(router.handleC),
-			"maxCapacity":   cap(router.handleC),
-		}).Warn("handleC channel is almost full")
-		mTotalOverloadedHandleChannel.Add(1)
+// routes returns all the routes of the router, mapped by path
+func (router *router) routes() map[protocol.Path][]*Route {
+	routes := make(map[protocol.Path][]*Route)
+	for _, s := range router.shards {
+		s.RLock()
+		for path, pathRoutes := range s.routes {
+			if router.shardsFor(path)[0] == s {
+				routes[path] = append([]*Route(nil), pathRoutes...)
+			}
+		}
+		s.RUnlock()
 	}
+	return routes
 }
 
-// matchesTopic checks whether the supplied routePath matches the message topic
+// matchesTopic checks whether the supplied routePath matches the message topic.
+// The routePath can contain single-level (`*`) and multi-level (`#`) wildcards.
 func matchesTopic(messagePath, routePath protocol.Path) bool {
+	if routePath.HasWildcard() {
+		return routePath.Matches(messagePath)
+	}
 	messagePathLen := len(string(messagePath))
 	routePathLen := len(string(routePath))
 	return strings.HasPrefix(string(messagePath), string(routePath)) &&
@@ -447,23 +547,3 @@ func (router *router) KVStore() (kvstore.KVStore, error) {
 func (router *router) Cluster() *cluster.Cluster {
 	return router.cluster
 }
-
-func (router *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if req.Method != http.MethodGet {
-		http.Error(w, `{"error": Error method not allowed.Only HTTP GET is accepted}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	err := json.NewEncoder(w).Encode(router.routes)
-	if err != nil {
-		http.Error(w, `{"error":Error encoding data.}`, http.StatusInternalServerError)
-		logger.WithField("error", err.Error()).Error("Error encoding data.")
-		return
-	}
-}
-
-func (router *router) GetPrefix() string {
-	return prefix
-}
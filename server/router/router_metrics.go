@@ -23,6 +23,27 @@ var (
 	mTotalMessageStoreErrors                   = metrics.NewInt("router.total_errors_message_store")
 	mTotalDeliverMessageErrors                 = metrics.NewInt("router.total_errors_deliver_message")
 	mTotalNotMatchedByFilters                  = metrics.NewInt("router.total_not_matched_by_filters")
+	mTotalMessagesExpired                      = metrics.NewInt("router.total_messages_expired")
+	mTotalMessagesRejected                     = metrics.NewInt("router.total_messages_rejected")
+	mTotalDeadLetters                          = metrics.NewInt("router.total_dead_letters")
+	mCurrentRateLimitBuckets                   = metrics.NewInt("router.current_rate_limit_buckets")
+	mTotalDuplicateMessages                    = metrics.NewInt("router.total_duplicate_messages")
+	mTotalMessagesScheduled                    = metrics.NewInt("router.total_messages_scheduled")
+	mCurrentRetainedMessages                   = metrics.NewInt("router.current_retained_messages")
+
+	// messages rejected because the publisher exceeded its rate limit, by the kind of limit
+	mTotalMessagesRateLimited = map[rateKind]metrics.Int{
+		rateKindUser:        metrics.NewInt("router.total_messages_rate_limited_user"),
+		rateKindApplication: metrics.NewInt("router.total_messages_rate_limited_application"),
+	}
+
+	// messages dropped because of slow consumers, by the policy of the route
+	mTotalDroppedMessages = map[SlowConsumerPolicy]metrics.Int{
+		PolicyClose:      metrics.NewInt("router.total_dropped_messages_close"),
+		PolicyDropOldest: metrics.NewInt("router.total_dropped_messages_drop_oldest"),
+		PolicyDropNewest: metrics.NewInt("router.total_dropped_messages_drop_newest"),
+		PolicyBlock:      metrics.NewInt("router.total_dropped_messages_block"),
+	}
 )
 
 func resetRouterMetrics() {
@@ -44,4 +65,16 @@ func resetRouterMetrics() {
 	mTotalMessagesIncomingBytes.Set(0)
 	mTotalMessagesStoredBytes.Set(0)
 	mTotalNotMatchedByFilters.Set(0)
+	mTotalMessagesExpired.Set(0)
+	mTotalMessagesRejected.Set(0)
+	mTotalDeadLetters.Set(0)
+	mTotalDuplicateMessages.Set(0)
+	mTotalMessagesScheduled.Set(0)
+	mCurrentRetainedMessages.Set(0)
+	for _, m := range mTotalMessagesRateLimited {
+		m.Set(0)
+	}
+	for _, m := range mTotalDroppedMessages {
+		m.Set(0)
+	}
 }
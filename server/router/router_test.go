@@ -2,6 +2,7 @@ package router
 
 import (
 	"errors"
+	"strconv"
 	"testing"
 	"time"
 
@@ -76,22 +77,22 @@ func TestRouter_AddAndRemoveRoutes(t *testing.T) {
 	// then
 
 	// the routes are stored
-	a.Equal(2, len(router.routes[protocol.Path("/blah")]))
-	a.True(routeBlah1.Equal(router.routes[protocol.Path("/blah")][0]))
-	a.True(routeBlah2.Equal(router.routes[protocol.Path("/blah")][1]))
+	a.Equal(2, len(router.routes()[protocol.Path("/blah")]))
+	a.True(routeBlah1.Equal(router.routes()[protocol.Path("/blah")][0]))
+	a.True(routeBlah2.Equal(router.routes()[protocol.Path("/blah")][1]))
 
-	a.Equal(1, len(router.routes[protocol.Path("/foo")]))
-	a.True(routeFoo.Equal(router.routes[protocol.Path("/foo")][0]))
+	a.Equal(1, len(router.routes()[protocol.Path("/foo")]))
+	a.True(routeFoo.Equal(router.routes()[protocol.Path("/foo")][0]))
 
 	// when i remove routes
 	router.Unsubscribe(routeBlah1)
 	router.Unsubscribe(routeFoo)
 
 	// then they are gone
-	a.Equal(1, len(router.routes[protocol.Path("/blah")]))
-	a.True(routeBlah2.Equal(router.routes[protocol.Path("/blah")][0]))
+	a.Equal(1, len(router.routes()[protocol.Path("/blah")]))
+	a.True(routeBlah2.Equal(router.routes()[protocol.Path("/blah")][0]))
 
-	a.Nil(router.routes[protocol.Path("/foo")])
+	a.Nil(router.routes()[protocol.Path("/foo")])
 }
 
 func TestRouter_SubscribeNotAllowed(t *testing.T) {
@@ -104,8 +105,11 @@ func TestRouter_SubscribeNotAllowed(t *testing.T) {
 	kvsMock := NewMockKVStore(ctrl)
 
 	am.EXPECT().IsAllowed(auth.READ, "user01", protocol.Path("/blah")).Return(false)
+	noRetainedMessages := make(chan [2]string)
+	close(noRetainedMessages)
+	kvsMock.EXPECT().Iterate(retainedSchema, "").Return(noRetainedMessages)
 
-	router := New(am, msMock, kvsMock, nil).(*router)
+	router := New(am, msMock, kvsMock, nil, Config{}).(*router)
 	router.Start()
 
 	_, e := router.Subscribe(NewRoute(
@@ -185,6 +189,61 @@ func TestRouter_HandleMessageNotAllowed(t *testing.T) {
 	a.NoError(err)
 }
 
+func TestRouter_Interceptors(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// Given a Router with route
+	router, r := aRouterRoute(chanSize)
+	msMock := NewMockMessageStore(ctrl)
+	router.messageStore = msMock
+
+	// and an interceptor chain, enriching the messages and rejecting the empty ones
+	var calls []string
+	router.AddInterceptor(InterceptorFunc(func(m *protocol.Message) error {
+		calls = append(calls, "reject")
+		if len(m.Body) == 0 {
+			return errors.New("empty body")
+		}
+		return nil
+	}))
+	router.AddInterceptor(InterceptorFunc(func(m *protocol.Message) error {
+		calls = append(calls, "enrich")
+		m.HeaderJSON = `{"intercepted":"true"}`
+		return nil
+	}))
+
+	// when i send an empty message
+	err := router.HandleMessage(&protocol.Message{Path: r.Path})
+
+	// then it is rejected with the reason, without storing it
+	if a.IsType(&MessageRejectedError{}, err) {
+		a.Equal("empty body", err.(*MessageRejectedError).Reason)
+	}
+	a.Equal([]string{"reject"}, calls)
+
+	// and when i send a message with a body
+	msMock.EXPECT().
+		StoreMessage(gomock.Any(), gomock.Any()).
+		Do(func(m *protocol.Message, nodeID uint8) (int, error) {
+			a.Equal(`{"intercepted":"true"}`, m.HeaderJSON)
+			return len(m.Bytes()), nil
+		})
+	err = router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage})
+
+	// then it is enriched, stored and delivered
+	a.NoError(err)
+	a.Equal([]string{"reject", "reject", "enrich"}, calls)
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+
+	// and a message received from another cluster node is not intercepted again
+	msMock.EXPECT().StoreMessage(gomock.Any(), gomock.Any()).Return(0, nil)
+	err = router.HandleMessage(&protocol.Message{Path: r.Path, NodeID: 2})
+	a.NoError(err)
+	a.Equal(3, len(calls))
+}
+
 func TestRouter_ReplacingOfRoutesMatchingAppID(t *testing.T) {
 	a := assert.New(t)
 
@@ -212,9 +271,9 @@ func TestRouter_ReplacingOfRoutesMatchingAppID(t *testing.T) {
 	))
 
 	// then: the router only contains the new route
-	a.Equal(1, len(router.routes))
-	a.Equal(1, len(router.routes["/blah"]))
-	a.Equal("newUserId", router.routes["/blah"][0].Get("user_id"))
+	a.Equal(1, len(router.routes()))
+	a.Equal(1, len(router.routes()["/blah"]))
+	a.Equal("newUserId", router.routes()["/blah"][0].Get("user_id"))
 }
 
 func TestRouter_SimpleMessageSending(t *testing.T) {
@@ -244,6 +303,30 @@ func TestRouter_SimpleMessageSending(t *testing.T) {
 	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
 }
 
+func TestRouter_ExpiredMessagesAreNotRouted(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route
+	router, r := aRouterRoute(chanSize)
+
+	// when i send an expired message and a message which is not expired yet
+	router.HandleMessage(&protocol.Message{
+		Path:    r.Path,
+		Body:    []byte("expired"),
+		Expires: time.Now().Add(-time.Minute).Unix(),
+	})
+	router.HandleMessage(&protocol.Message{
+		Path:    r.Path,
+		Body:    aTestByteMessage,
+		Expires: time.Now().Add(time.Minute).Unix(),
+	})
+
+	// then only the message which is not expired is delivered
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, len(r.MessagesChannel()))
+}
+
 func TestRouter_RoutingWithSubTopics(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -290,6 +373,133 @@ func TestRouter_RoutingWithSubTopics(t *testing.T) {
 	a.Equal(0, len(r.MessagesChannel()))
 }
 
+func TestRouter_RoutingWithWildcards(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a single-level and a multi-level wildcard route
+	router, _, _, _ := aStartedRouter()
+
+	single, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        protocol.Path("/orders/*/shipped"),
+			ChannelSize: chanSize,
+		},
+	))
+	multi, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user01"},
+			Path:        protocol.Path("/orders/#/shipped"),
+			ChannelSize: chanSize,
+		},
+	))
+
+	// when i send a message to a topic matched by both routes
+	router.HandleMessage(&protocol.Message{Path: "/orders/42/shipped", Body: aTestByteMessage})
+
+	// then both routes receive it
+	assertChannelContainsMessage(a, single.MessagesChannel(), aTestByteMessage)
+	assertChannelContainsMessage(a, multi.MessagesChannel(), aTestByteMessage)
+
+	// when i send a message to a topic matched only by the multi-level wildcard
+	router.HandleMessage(&protocol.Message{Path: "/orders/eu/42/shipped", Body: aTestByteMessage})
+
+	// then only the multi-level route receives it
+	assertChannelContainsMessage(a, multi.MessagesChannel(), aTestByteMessage)
+	a.Equal(0, len(single.MessagesChannel()))
+
+	// when i send a message to a topic not matched by any route
+	router.HandleMessage(&protocol.Message{Path: "/orders/42/created", Body: aTestByteMessage})
+
+	// then no message gets delivered
+	time.Sleep(5 * time.Millisecond)
+	a.Equal(0, len(single.MessagesChannel()))
+	a.Equal(0, len(multi.MessagesChannel()))
+}
+
+func TestRouter_HandleMessageOnWildcardTopic(t *testing.T) {
+	a := assert.New(t)
+
+	router, _, _, _ := aStartedRouter()
+
+	err := router.HandleMessage(&protocol.Message{Path: "/orders/*/shipped", Body: aTestByteMessage})
+	a.Equal(ErrWildcardTopic, err)
+}
+
+func TestRouter_Shards(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with several shards
+	am := auth.NewAllowAllAccessManager(true)
+	kvs := kvstore.NewMemoryKVStore()
+	router := New(am, dummystore.New(kvs), kvs, nil, Config{Shards: 4}).(*router)
+	router.Start()
+	defer router.Stop()
+	a.Equal(4, len(router.shards))
+
+	// then a partition is always handled by the same shard
+	a.True(router.shardFor("orders") == router.shardFor("orders"))
+	a.Equal(1, len(router.shardsFor("/orders/42")))
+	a.True(router.shardFor("orders") == router.shardsFor("/orders/42")[0])
+
+	// and a route with a wildcard partition belongs to all the shards
+	a.Equal(4, len(router.shardsFor("/*/shipped")))
+	a.Equal(4, len(router.shardsFor("/#")))
+
+	// when i subscribe a route with a wildcard partition and a plain route
+	wildcard, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        protocol.Path("/*/shipped"),
+			ChannelSize: chanSize,
+		},
+	))
+	plain, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user01"},
+			Path:        protocol.Path("/orders/shipped"),
+			ChannelSize: chanSize,
+		},
+	))
+
+	// then each route is reported only once
+	a.Equal(2, len(router.routes()))
+	a.Equal(1, len(router.routes()["/*/shipped"]))
+
+	// and the messages of any partition are delivered in order
+	for i := 0; i < 5; i++ {
+		router.HandleMessage(&protocol.Message{Path: "/orders/shipped", Body: []byte(strconv.Itoa(i))})
+		router.HandleMessage(&protocol.Message{Path: "/invoices/shipped", Body: []byte(strconv.Itoa(i))})
+	}
+	var orders, invoices []string
+	for i := 0; i < 10; i++ {
+		select {
+		case m := <-wildcard.MessagesChannel():
+			if m.Path.Partition() == "orders" {
+				orders = append(orders, string(m.Body))
+			} else {
+				invoices = append(invoices, string(m.Body))
+			}
+		case <-time.After(100 * time.Millisecond):
+			a.Fail("No message received")
+		}
+	}
+	a.Equal([]string{"0", "1", "2", "3", "4"}, orders)
+	a.Equal([]string{"0", "1", "2", "3", "4"}, invoices)
+	for i := 0; i < 5; i++ {
+		assertChannelContainsMessage(a, plain.MessagesChannel(), []byte(strconv.Itoa(i)))
+	}
+
+	// when i unsubscribe the wildcard route
+	router.Unsubscribe(wildcard)
+
+	// then it is removed from all the shards
+	for _, s := range router.shards {
+		_, present := s.routesForPath("/*/shipped")
+		a.False(present)
+	}
+}
+
 func TestMatchesTopic(t *testing.T) {
 	for _, test := range []struct {
 		messagePath protocol.Path
@@ -301,6 +511,11 @@ func TestMatchesTopic(t *testing.T) {
 		{"/foo", "/bar", false},
 		{"/fooxyz", "/foo", false},
 		{"/foo", "/bar/xyz", false},
+		{"/foo/xyz/bar", "/foo/*/bar", true},
+		{"/foo/xyz/bar", "/foo/#", true},
+		{"/foo/xyz/abc/bar", "/foo/#/bar", true},
+		{"/foo/xyz/abc/bar", "/foo/*/bar", false},
+		{"/bar/xyz", "/*/xyz", true},
 	} {
 		if !test.matches == matchesTopic(test.messagePath, test.routePath) {
 			t.Errorf("error: expected %v, but: matchesTopic(%q, %q) = %v",
@@ -449,6 +664,35 @@ func TestRouter_CleanShutdown(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 }
 
+func TestRouter_StopTwiceAndRestart(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a started Router
+	router, _, _, _ := aStartedRouter()
+
+	// when it is stopped twice, then the second stop has no effect
+	a.NoError(router.Stop())
+	a.NoError(router.Stop())
+	select {
+	case <-router.Done():
+	default:
+		a.Fail("Router not done after stopping")
+	}
+
+	// and when it is started again, then it routes the messages
+	a.NoError(router.Start())
+	select {
+	case <-router.Done():
+		a.Fail("Router done after starting again")
+	default:
+	}
+	route, err := router.Subscribe(NewRoute(RouteConfig{Path: "/blah", ChannelSize: chanSize}))
+	a.NoError(err)
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: aTestByteMessage}))
+	assertChannelContainsMessage(a, route.MessagesChannel(), aTestByteMessage)
+	a.NoError(router.Stop())
+}
+
 func TestRouter_Check(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -510,7 +754,7 @@ func TestRouter_Check(t *testing.T) {
 
 func TestPanicOnInternalDependencies(t *testing.T) {
 	defer testutil.ExpectPanic(t)
-	router := New(nil, nil, nil, nil).(*router)
+	router := New(nil, nil, nil, nil, Config{}).(*router)
 	router.panicIfInternalDependenciesAreNil()
 }
 
@@ -518,7 +762,7 @@ func aStartedRouter() (*router, auth.AccessManager, store.MessageStore, kvstore.
 	am := auth.NewAllowAllAccessManager(true)
 	kvs := kvstore.NewMemoryKVStore()
 	ms := dummystore.New(kvs)
-	router := New(am, ms, kvs, nil).(*router)
+	router := New(am, ms, kvs, nil, Config{}).(*router)
 	router.Start()
 	return router, am, ms, kvs
 }
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// Scheduler holds the messages published with a future delivery time,
+// and passes them again to the router at this time.
+type Scheduler interface {
+	Schedule(message *protocol.Message) error
+}
+
+// Schedulable is implemented by a Router handing the scheduled messages to a Scheduler.
+// The Scheduler passes them again to HandleScheduledMessage, or to HandleMessage if the router is not Schedulable.
+type Schedulable interface {
+	SetScheduler(Scheduler)
+	HandleScheduledMessage(message *protocol.Message) error
+}
+
+// messageOrigin tells where a message handled by the router comes from, which determines the checks applied to it
+type messageOrigin int
+
+const (
+	// fromPublisher is a message published by a user, or received from another cluster node
+	fromPublisher messageOrigin = iota
+
+	// fromRouter is a message created by the router itself, e.g. a presence event or a dead letter
+	fromRouter
+
+	// fromScheduler is a scheduled message passed again by the scheduler at its delivery time,
+	// which was checked when it was scheduled
+	fromScheduler
+)
+
+// SetScheduler sets the scheduler of the messages published with a future delivery time
+func (router *router) SetScheduler(scheduler Scheduler) {
+	router.Lock()
+	defer router.Unlock()
+
+	router.scheduler = scheduler
+}
+
+// HandleScheduledMessage routes a scheduled message at its delivery time.
+// The publishing checks, the rate limits and the idempotency keys were applied when the message was scheduled,
+// so they are not applied again.
+func (router *router) HandleScheduledMessage(message *protocol.Message) error {
+	return router.handleMessage(message, fromScheduler)
+}
+
+// schedule hands a message to the scheduler, or returns ErrSchedulingDisabled
+func (router *router) schedule(message *protocol.Message) error {
+	router.RLock()
+	scheduler := router.scheduler
+	router.RUnlock()
+
+	if scheduler == nil {
+		return ErrSchedulingDisabled
+	}
+	if err := scheduler.Schedule(message); err != nil {
+		logger.WithError(err).WithField("path", message.Path).Error("Error scheduling message")
+		return err
+	}
+	mTotalMessagesScheduled.Add(1)
+	return nil
+}
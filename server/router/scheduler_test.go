@@ -0,0 +1,89 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+type schedulerFunc func(message *protocol.Message) error
+
+func (f schedulerFunc) Schedule(message *protocol.Message) error {
+	return f(message)
+}
+
+func TestRouter_ScheduledMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route, without a scheduler
+	router, r := aRouterRoute(chanSize)
+	later := time.Now().Add(time.Hour).Unix()
+
+	// a scheduled message is rejected
+	a.Equal(ErrSchedulingDisabled, router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("later"), DeliverAt: later}))
+
+	// when a scheduler is set
+	var scheduled []*protocol.Message
+	router.SetScheduler(schedulerFunc(func(message *protocol.Message) error {
+		scheduled = append(scheduled, message)
+		return nil
+	}))
+
+	// then the scheduled message is handed to it, and not delivered
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("later"), DeliverAt: later}))
+	a.Len(scheduled, 1)
+	select {
+	case m := <-r.MessagesChannel():
+		a.Fail("Unexpected message delivered", string(m.Body))
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// and a message with a past delivery time is delivered immediately
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("now"), DeliverAt: time.Now().Add(-time.Second).Unix()}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("now"))
+	a.Len(scheduled, 1)
+
+	// and the messages received from other cluster nodes are not scheduled again
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("remote"), NodeID: 2, DeliverAt: later}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("remote"))
+	a.Len(scheduled, 1)
+}
+
+func TestRouter_ScheduledMessageChecks(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route and a scheduler, limiting the users to 1 message per second
+	// and remembering the idempotency keys
+	router, r := aRouterRoute(chanSize)
+	router.rateLimit = RateLimitConfig{UserRate: 1}
+	router.idempotency = newIdempotencyCache(time.Minute)
+	var scheduled []*protocol.Message
+	router.SetScheduler(schedulerFunc(func(message *protocol.Message) error {
+		scheduled = append(scheduled, message)
+		return nil
+	}))
+	later := time.Now().Add(time.Hour).Unix()
+	message := func(body string) *protocol.Message {
+		return &protocol.Message{Path: r.Path, UserID: "user01", IdempotencyKey: "key01", Body: []byte(body), DeliverAt: later}
+	}
+
+	// when a scheduled message is published twice with the same idempotency key, then it is scheduled once
+	a.NoError(router.HandleMessage(message("later")))
+	a.NoError(router.HandleMessage(message("later")))
+	a.Len(scheduled, 1)
+
+	// and the scheduled messages use up the rate limit
+	other := message("other")
+	other.IdempotencyKey = ""
+	a.Equal(ErrRateLimitExceeded, router.HandleMessage(other))
+	a.Len(scheduled, 1)
+
+	// and when the scheduler passes the message again, then it is delivered without being checked again
+	due := scheduled[0]
+	due.DeliverAt = time.Now().Unix()
+	a.NoError(router.HandleScheduledMessage(due))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("later"))
+}
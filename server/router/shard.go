@@ -0,0 +1,252 @@
+package router
+
+import (
+	"runtime"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// shard is a dispatch loop of the router, owning the routes of a subset of the partitions.
+// Routes having a wildcard partition are subscribed in all the shards.
+type shard struct {
+	router *router
+
+	routes       map[protocol.Path][]*Route // mapping the path to the route slice
+	handleC      chan *protocol.Message
+	subscribeC   chan subRequest
+	unsubscribeC chan subRequest
+	stopping     bool // Flag: the router was stopped and the loop ends as soon as the channels are empty
+
+	// the last retained message of each topic path of the shard's partitions; used only by the loop
+	retained map[protocol.Path]*protocol.Message
+
+	// the position after the route chosen last in each consumer group, by group key; used only by the loop
+	groupCursors map[string]int
+
+	// guards the routes map; it is modified only by the loop, so the loop reads it without locking
+	sync.RWMutex
+}
+
+func newShard(router *router) *shard {
+	return &shard{
+		router: router,
+
+		routes:       make(map[protocol.Path][]*Route),
+		handleC:      make(chan *protocol.Message, handleChannelCapacity),
+		subscribeC:   make(chan subRequest, subscribeChannelCapacity),
+		unsubscribeC: make(chan subRequest, unsubscribeChannelCapacity),
+		retained:     make(map[protocol.Path]*protocol.Message),
+		groupCursors: make(map[string]int),
+	}
+}
+
+func (s *shard) loop(stopC <-chan bool) {
+	defer s.router.wg.Done()
+	s.stopping = false
+
+	for {
+		if s.stopping && s.channelsAreEmpty() {
+			s.closeRoutes()
+			return
+		}
+
+		func() {
+			defer protocol.PanicLogger()
+
+			select {
+			case message := <-s.handleC:
+				s.handleMessage(message)
+				runtime.Gosched()
+			case subscriber := <-s.subscribeC:
+				s.subscribe(subscriber.route)
+				s.deliverRetained(subscriber.route)
+				subscriber.doneC <- true
+			case unsubscriber := <-s.unsubscribeC:
+				s.unsubscribe(unsubscriber.route)
+				unsubscriber.doneC <- true
+			case <-stopC:
+				s.stopping = true
+				stopC = nil
+			}
+		}()
+	}
+}
+
+// isPrimary returns true if the shard accounts the route path in the metrics
+func (s *shard) isPrimary(path protocol.Path) bool {
+	return s.router.shardsFor(path)[0] == s
+}
+
+func (s *shard) subscribe(r *Route) {
+	logger.WithField("route", r).Debug("Internal subscribe")
+	primary := s.isPrimary(r.Path)
+	if primary {
+		mTotalSubscriptionAttempts.Add(1)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	routePath := r.Path
+	slice, present := s.routes[routePath]
+	var removed bool
+	if present {
+		// Try to remove, to avoid double subscriptions of the same app
+		slice, removed = removeIfMatching(slice, r)
+	} else {
+		// Path not present yet. Initialize the slice
+		slice = make([]*Route, 0, 1)
+		s.routes[routePath] = slice
+		if primary {
+			mCurrentRoutes.Add(1)
+		}
+	}
+	s.routes[routePath] = append(slice, r)
+	if !primary {
+		return
+	}
+	if removed {
+		mTotalDuplicateSubscriptionsAttempts.Add(1)
+	} else {
+		mTotalSubscriptions.Add(1)
+		mCurrentSubscriptions.Add(1)
+		s.router.routeAdded(r)
+	}
+}
+
+func (s *shard) unsubscribe(r *Route) {
+	logger.WithField("route", r).Debug("Internal unsubscribe")
+	primary := s.isPrimary(r.Path)
+	if primary {
+		mTotalUnsubscriptionAttempts.Add(1)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	routePath := r.Path
+	slice, present := s.routes[routePath]
+	if !present {
+		if primary {
+			mTotalInvalidTopicOnUnsubscriptionAttempts.Add(1)
+		}
+		return
+	}
+	var removed bool
+	s.routes[routePath], removed = removeIfMatching(slice, r)
+	if primary {
+		if removed {
+			mTotalUnsubscriptions.Add(1)
+			mCurrentSubscriptions.Add(-1)
+			s.router.routeRemoved(r)
+		} else {
+			mTotalInvalidUnsubscriptionAttempts.Add(1)
+		}
+	}
+	if len(s.routes[routePath]) == 0 {
+		delete(s.routes, routePath)
+		if primary {
+			mCurrentRoutes.Add(-1)
+		}
+	}
+}
+
+func (s *shard) handleMessage(message *protocol.Message) {
+	flog := logger.WithFields(log.Fields{
+		"topic":    message.Path,
+		"metadata": message.Metadata(),
+		"filters":  message.Filters,
+	})
+	flog.Debug("Called routeMessage for data")
+	if message.IsExpired() {
+		flog.Debug("Message expired before routing")
+		mTotalMessagesExpired.Add(1)
+		return
+	}
+	mTotalMessagesRouted.Add(1)
+
+	if message.Retain {
+		s.retain(message)
+	}
+
+	matched := false
+	for path, pathRoutes := range s.routes {
+		if matchesTopic(message.Path, path) {
+			matched = true
+			var groups map[string][]*Route
+			for _, route := range pathRoutes {
+				if route.Group != "" {
+					if groups == nil {
+						groups = make(map[string][]*Route)
+					}
+					groups[route.groupKey()] = append(groups[route.groupKey()], route)
+					continue
+				}
+				err := route.Deliver(message, false)
+				if isDeadLetter(route, err) {
+					s.router.DeadLetter(DeadLetter{
+						Message:       message,
+						Source:        route.Get(connectorParam),
+						Reason:        err.Error(),
+						SubscriberKey: route.Key(),
+					})
+				}
+				if err == ErrInvalidRoute {
+					// Unsubscribe invalid routes
+					s.unsubscribe(route)
+				}
+			}
+			for key, members := range groups {
+				if s.router.ownsGroupMessage(key, message) {
+					s.deliverToGroup(key, members, message)
+				}
+			}
+		}
+	}
+
+	if !matched {
+		flog.Debug("No route matched.")
+		mTotalMessagesNotMatchingTopic.Add(1)
+	}
+}
+
+// routesForPath returns a copy of the routes subscribed on the path
+func (s *shard) routesForPath(path protocol.Path) ([]*Route, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	routes, present := s.routes[path]
+	return append([]*Route(nil), routes...), present
+}
+
+func (s *shard) channelsAreEmpty() bool {
+	return len(s.handleC) == 0 && len(s.subscribeC) == 0 && len(s.unsubscribeC) == 0
+}
+
+func (s *shard) closeRoutes() {
+	logger.Debug("closeRoutes")
+
+	for _, currentRouteList := range s.routes {
+		for _, route := range currentRouteList {
+			s.unsubscribe(route)
+			log.WithFields(log.Fields{"module": "router", "route": route.String()}).Debug("Closing route")
+			route.Close()
+		}
+	}
+}
+
+// handleOverloadedChannel warns if the message channel is almost full, and returns true in this case
+func (s *shard) handleOverloadedChannel() bool {
+	if float32(len(s.handleC))/float32(cap(s.handleC)) > overloadedHandleChannelRatio {
+		logger.WithFields(log.Fields{
+			"currentLength": len(s.handleC),
+			"maxCapacity":   cap(s.handleC),
+		}).Warn("handleC channel is almost full")
+		mTotalOverloadedHandleChannel.Add(1)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/topics"
+)
+
+// checkTopicConfig enforces the configuration of the topic on a message published on this node.
+// The default TTL and retention of the topic are applied when the message is routed.
+func (router *router) checkTopicConfig(config *topics.Config, message *protocol.Message) error {
+	if config == nil {
+		return nil
+	}
+	if !config.IsPublisherAllowed(message.UserID) {
+		return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path}
+	}
+	if config.IsTooLarge(message) {
+		mTotalMessagesRejected.Add(1)
+		return &MessageRejectedError{
+			Reason: fmt.Sprintf("message body of %d bytes exceeds the maximum size of %d bytes", len(message.Body), config.MaxMessageSize),
+		}
+	}
+	return nil
+}
+
+// isTransient returns true if the message is routed without being stored.
+// The replies to the requests, published below the reply prefix, are transient unless their topic is configured.
+func (router *router) isTransient(config *topics.Config, message *protocol.Message) bool {
+	if config == nil {
+		return router.replyPrefix != "" && router.replyPrefix.Matches(message.Path)
+	}
+	return config.IsTransient()
+}
+
+// generateID sets the id and time of a transient message, which is routed without being stored
+func (router *router) generateID(message *protocol.Message, nodeID uint8) error {
+	// as in the message store, the messages received from other nodes keep their id
+	if nodeID != 0 && message.NodeID != 0 {
+		return nil
+	}
+	id, ts, err := router.messageStore.GenerateNextMsgID(message.Path.Partition(), nodeID)
+	if err != nil {
+		logger.WithError(err).WithField("path", message.Path).Error("Error generating id of transient message")
+		return err
+	}
+	message.ID = id
+	message.Time = ts
+	message.NodeID = nodeID
+	return nil
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/topics"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRouter_TopicConfigIsEnforced(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// Given a Router with route, and a message store which is not expected to store messages
+	router, r := aRouterRoute(chanSize)
+	msMock := NewMockMessageStore(ctrl)
+	router.messageStore = msMock
+
+	// and a configuration of the topic
+	router.topics = topics.NewRegistry(kvstore.NewMemoryKVStore(), "")
+	a.NoError(router.topics.Put(&topics.Config{
+		Path:              r.Path,
+		MaxMessageSize:    10,
+		Transient:         true,
+		DefaultTTL:        topics.Duration(time.Minute),
+		AllowedPublishers: []string{"user01"},
+	}))
+
+	// when a user which is not allowed publishes, then the message is rejected
+	err := router.HandleMessage(&protocol.Message{Path: r.Path, UserID: "user02", Body: []byte("body")})
+	a.IsType(&PermissionDeniedError{}, err)
+
+	// and a message which is too large is rejected
+	err = router.HandleMessage(&protocol.Message{Path: r.Path, UserID: "user01", Body: []byte("a large body")})
+	a.IsType(&MessageRejectedError{}, err)
+
+	// and a valid message gets an id and the default TTL, and is delivered without being stored
+	msMock.EXPECT().GenerateNextMsgID(r.Path.Partition(), uint8(0)).Return(uint64(42), time.Now().Unix(), nil)
+	err = router.HandleMessage(&protocol.Message{Path: r.Path, UserID: "user01", Body: []byte("body")})
+	a.NoError(err)
+
+	select {
+	case m := <-r.MessagesChannel():
+		a.Equal(uint64(42), m.ID)
+		a.Equal("body", string(m.Body))
+		a.InDelta(time.Now().Add(time.Minute).Unix(), m.Expires, 1)
+	case <-time.After(10 * time.Millisecond):
+		a.Fail("No message received")
+	}
+}
+
+func TestRouter_RepliesAreTransient(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// Given a Router with a reply prefix and a route on a reply topic,
+	// and a message store which is not expected to store messages
+	router, _, _, _ := aStartedRouter()
+	router.replyPrefix = "/replies"
+	msMock := NewMockMessageStore(ctrl)
+	router.messageStore = msMock
+	r, err := router.Subscribe(NewRoute(RouteConfig{Path: "/replies/request01", ChannelSize: chanSize}))
+	a.NoError(err)
+
+	// when a reply is published, then it is delivered without being stored
+	msMock.EXPECT().GenerateNextMsgID("replies", uint8(0)).Return(uint64(42), time.Now().Unix(), nil)
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("reply")}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("reply"))
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GetPrefix returns the prefix of the admin API.
+// It is a part of the service.endpoint implementation.
+func (s *Scheduler) GetPrefix() string {
+	return s.prefix
+}
+
+// ServeHTTP serves the admin API of the scheduled messages:
+//
+//	GET    <prefix>       lists the pending scheduled messages
+//	DELETE <prefix>/<id>  cancels the scheduled message having the id
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(s.prefix, "/")), "/")
+
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		if err := json.NewEncoder(w).Encode(s.List()); err != nil {
+			logger.WithError(err).Error("Error encoding data.")
+		}
+	case req.Method == http.MethodDelete && id != "":
+		if err := s.Cancel(id); err != nil {
+			status := http.StatusInternalServerError
+			if err == ErrNotFound {
+				status = http.StatusNotFound
+			} else {
+				logger.WithError(err).WithField("id", id).Error("Error cancelling scheduled message")
+			}
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), status)
+			return
+		}
+		logger.WithField("id", id).Info("Scheduled message cancelled")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"error":"Method not allowed."}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,9 @@
+package scheduler
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithFields(log.Fields{
+	"module": "scheduler",
+})
@@ -0,0 +1,338 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+const schema = "scheduled_messages"
+
+// ErrNotFound is returned when there is no pending scheduled message with an id
+var ErrNotFound = errors.New("Scheduled message not found.")
+
+// syncInterval is the interval of loading the scheduled messages from the KVStore,
+// which picks up the messages scheduled, and drops the ones delivered or cancelled by the other nodes sharing it
+var syncInterval = 10 * time.Second
+
+// ScheduledMessage describes a message waiting for its delivery time
+type ScheduledMessage struct {
+	ID            string
+	DeliverAt     time.Time
+	Path          protocol.Path
+	UserID        string `json:",omitempty"`
+	ApplicationID string `json:",omitempty"`
+	Size          int
+}
+
+// storedMessage is the form in which a scheduled message is persisted in the KVStore
+type storedMessage struct {
+	DeliverAt int64
+	Message   []byte
+}
+
+// pending is a scheduled message waiting for its timer
+type pending struct {
+	info    ScheduledMessage
+	message []byte
+	timer   *time.Timer
+}
+
+// Scheduler holds the messages published with a future delivery time, and passes them to the router at this time.
+// The messages are persisted in the KVStore of the router, so that they survive restarts.
+// If the KVStore is shared by the nodes of a cluster, each message is delivered by the node claiming it first,
+// which requires a KVStore implementing kvstore.ExclusiveDeleter, as the SQL stores do.
+type Scheduler struct {
+	router    router.Router
+	kvStore   kvstore.KVStore
+	prefix    string
+	pending   map[string]*pending
+	stopped   bool
+	syncStopC chan bool
+	syncDoneC chan bool
+
+	sync.Mutex
+}
+
+// New returns a new Scheduler for the router, serving its admin API at the prefix.
+// It is set as the scheduler of the router, which hands it the scheduled messages.
+func New(r router.Router, prefix string) *Scheduler {
+	s := &Scheduler{
+		router:  r,
+		prefix:  prefix,
+		pending: make(map[string]*pending),
+	}
+	if schedulable, ok := r.(router.Schedulable); ok {
+		schedulable.SetScheduler(s)
+	}
+	return s
+}
+
+// Start loads the scheduled messages from the KVStore, and starts their timers.
+// The messages which were due while the server was stopped are delivered immediately.
+// The messages are loaded again periodically, to pick up the ones scheduled by other nodes.
+func (s *Scheduler) Start() error {
+	kvStore, err := s.router.KVStore()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.kvStore = kvStore
+	s.stopped = false
+	s.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	logger.WithField("count", len(s.List())).Info("Loaded scheduled messages")
+
+	s.syncStopC = make(chan bool)
+	s.syncDoneC = make(chan bool)
+	go s.syncPeriodically(time.NewTicker(syncInterval))
+	return nil
+}
+
+// Stop stops the timers. The pending messages stay persisted, and are scheduled again on the next start.
+func (s *Scheduler) Stop() error {
+	s.Lock()
+	s.stopped = true
+	for id, p := range s.pending {
+		p.timer.Stop()
+		delete(s.pending, id)
+	}
+	s.Unlock()
+
+	if s.syncStopC != nil {
+		close(s.syncStopC)
+		<-s.syncDoneC
+		s.syncStopC = nil
+	}
+	return nil
+}
+
+func (s *Scheduler) syncPeriodically(ticker *time.Ticker) {
+	defer close(s.syncDoneC)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// the errors are logged, and the messages loaded before are kept
+			s.load()
+		case <-s.syncStopC:
+			return
+		}
+	}
+}
+
+// load schedules the messages of the KVStore which are not pending yet,
+// and drops the pending messages which are not in the KVStore any more
+func (s *Scheduler) load() error {
+	s.Lock()
+	known := make(map[string]bool, len(s.pending))
+	for id := range s.pending {
+		known[id] = true
+	}
+	s.Unlock()
+
+	entries := make(map[string]string)
+	for entry := range s.kvStore.Iterate(schema, "") {
+		entries[entry[0]] = entry[1]
+	}
+	stored := make(map[string]*storedMessage)
+	messages := make(map[string]*protocol.Message)
+	for id, value := range entries {
+		sm := &storedMessage{}
+		if err := json.Unmarshal([]byte(value), sm); err != nil {
+			logger.WithError(err).WithField("id", id).Error("Error loading scheduled message")
+			return err
+		}
+		message, err := protocol.ParseMessage(sm.Message)
+		if err != nil {
+			logger.WithError(err).WithField("id", id).Error("Error parsing scheduled message")
+			return err
+		}
+		stored[id] = sm
+		messages[id] = message
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+	for id, sm := range stored {
+		if _, ok := s.pending[id]; !ok {
+			s.add(id, sm, messages[id])
+		}
+	}
+	// the messages scheduled meanwhile are not in the loaded ones, so only the ones known before are dropped
+	for id := range known {
+		if p, ok := s.pending[id]; ok && stored[id] == nil {
+			p.timer.Stop()
+			delete(s.pending, id)
+		}
+	}
+	return nil
+}
+
+// Schedule persists the message, to pass it to the router at its delivery time.
+// It is a part of the router.Scheduler implementation.
+func (s *Scheduler) Schedule(message *protocol.Message) error {
+	stored := &storedMessage{DeliverAt: message.DeliverAt, Message: message.Bytes()}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.kvStore == nil || s.stopped {
+		return &router.ModuleStoppingError{Name: "scheduler"}
+	}
+	id := xid.New().String()
+	if err := s.kvStore.Put(schema, id, data); err != nil {
+		return err
+	}
+	s.add(id, stored, message)
+	logger.WithField("scheduled", s.pending[id].info).Debug("Scheduled message")
+	return nil
+}
+
+// List returns the pending scheduled messages, sorted by delivery time
+func (s *Scheduler) List() []ScheduledMessage {
+	s.Lock()
+	defer s.Unlock()
+
+	list := make([]ScheduledMessage, 0, len(s.pending))
+	for _, p := range s.pending {
+		list = append(list, p.info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DeliverAt.Equal(list[j].DeliverAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].DeliverAt.Before(list[j].DeliverAt)
+	})
+	return list
+}
+
+// Cancel removes a pending scheduled message, which is not delivered, or returns ErrNotFound
+func (s *Scheduler) Cancel(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	p, ok := s.pending[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.kvStore.Delete(schema, id); err != nil {
+		return err
+	}
+	p.timer.Stop()
+	delete(s.pending, id)
+	return nil
+}
+
+func (s *Scheduler) add(id string, stored *storedMessage, message *protocol.Message) {
+	deliverAt := time.Unix(stored.DeliverAt, 0)
+	s.pending[id] = &pending{
+		info: ScheduledMessage{
+			ID:            id,
+			DeliverAt:     deliverAt,
+			Path:          message.Path,
+			UserID:        message.UserID,
+			ApplicationID: message.ApplicationID,
+			Size:          len(message.Body),
+		},
+		message: stored.Message,
+		timer:   time.AfterFunc(deliverAt.Sub(time.Now()), func() { s.deliver(id) }),
+	}
+}
+
+// deliver passes a due message to the router, after removing it from the KVStore,
+// so that it is not delivered by another node sharing the KVStore.
+// If the router is stopping, the message is persisted again.
+// A message rejected by the router is republished on the dead-letter topics, if the router supports it.
+func (s *Scheduler) deliver(id string) {
+	s.Lock()
+	p, ok := s.pending[id]
+	if ok {
+		delete(s.pending, id)
+	}
+	s.Unlock()
+	if !ok {
+		return
+	}
+
+	claimed, err := s.claim(id)
+	if err != nil {
+		// the message is still persisted, so it is scheduled again by the next load
+		logger.WithError(err).WithField("id", id).Error("Error claiming scheduled message")
+		return
+	}
+	if !claimed {
+		logger.WithField("id", id).Debug("Scheduled message delivered or cancelled by another node")
+		return
+	}
+
+	message, err := protocol.ParseMessage(p.message)
+	if err != nil {
+		logger.WithError(err).WithField("id", id).Error("Error parsing scheduled message")
+		return
+	}
+
+	err = s.handle(message)
+	if _, stopping := err.(*router.ModuleStoppingError); stopping {
+		logger.WithField("id", id).Info("Router is stopping, scheduled message kept for the next start")
+		data, err := json.Marshal(&storedMessage{DeliverAt: p.info.DeliverAt.Unix(), Message: p.message})
+		if err == nil {
+			err = s.kvStore.Put(schema, id, data)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("id", id).Error("Error persisting scheduled message again")
+		}
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("scheduled", p.info).Error("Error delivering scheduled message")
+		if deadLetterer, ok := s.router.(router.DeadLetterer); ok {
+			deadLetterer.DeadLetter(router.DeadLetter{
+				Message:  message,
+				Source:   "scheduler",
+				Reason:   err.Error(),
+				Attempts: 1,
+			})
+		}
+	}
+}
+
+// handle passes a due message to the router, which does not check it again if it is Schedulable
+func (s *Scheduler) handle(message *protocol.Message) error {
+	if schedulable, ok := s.router.(router.Schedulable); ok {
+		return schedulable.HandleScheduledMessage(message)
+	}
+	return s.router.HandleMessage(message)
+}
+
+// claim removes a due message from the KVStore, and returns false if it was removed before, e.g. by another node
+func (s *Scheduler) claim(id string) (bool, error) {
+	if deleter, ok := s.kvStore.(kvstore.ExclusiveDeleter); ok {
+		return deleter.DeleteIfExists(schema, id)
+	}
+	_, exists, err := s.kvStore.Get(schema, id)
+	if err != nil || !exists {
+		return false, err
+	}
+	return true, s.kvStore.Delete(schema, id)
+}
@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+type startable interface {
+	Start() error
+	Stop() error
+}
+
+// aStartedRouter returns a started router with a route on /reminders
+func aStartedRouter(a *assert.Assertions, kvs kvstore.KVStore) (router.Router, *router.Route) {
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, router.Config{})
+	a.NoError(r.(startable).Start())
+
+	route, err := r.Subscribe(router.NewRoute(router.RouteConfig{
+		RouteParams: router.RouteParams{"application_id": "app01", "user_id": "user01"},
+		Path:        "/reminders",
+		ChannelSize: 10,
+	}))
+	a.NoError(err)
+	return r, route
+}
+
+func countStored(kvs kvstore.KVStore) int {
+	count := 0
+	for range kvs.IterateKeys(schema, "") {
+		count++
+	}
+	return count
+}
+
+func expectMessage(a *assert.Assertions, route *router.Route, body string, timeout time.Duration) {
+	select {
+	case m := <-route.MessagesChannel():
+		a.Equal(body, string(m.Body))
+	case <-time.After(timeout):
+		a.Fail("No message received", body)
+	}
+}
+
+func expectNoMessage(a *assert.Assertions, route *router.Route) {
+	select {
+	case m := <-route.MessagesChannel():
+		a.Fail("Unexpected message received", string(m.Body))
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestScheduler_DeliversOnTime(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r, route := aStartedRouter(a, kvs)
+	s := New(r, "/admin/scheduled")
+	a.NoError(s.Start())
+	defer s.Stop()
+
+	// when a message is published with a delivery time
+	deliverAt := time.Now().Add(time.Second).Unix()
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/reminders", UserID: "user01", Body: []byte("wake up"), DeliverAt: deliverAt}))
+
+	// then it is pending, and not delivered yet
+	list := s.List()
+	a.Len(list, 1)
+	a.Equal(protocol.Path("/reminders"), list[0].Path)
+	a.Equal(deliverAt, list[0].DeliverAt.Unix())
+	a.Equal(7, list[0].Size)
+	expectNoMessage(a, route)
+
+	// and it is delivered at its delivery time
+	expectMessage(a, route, "wake up", 2*time.Second)
+	a.Empty(s.List())
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, countStored(kvs))
+}
+
+func TestScheduler_SurvivesRestart(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r, route := aStartedRouter(a, kvs)
+	s := New(r, "/admin/scheduled")
+	a.NoError(s.Start())
+
+	// given a message scheduled in an hour, and one scheduled in a second
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/reminders", Body: []byte("later"), DeliverAt: time.Now().Add(time.Hour).Unix()}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/reminders", Body: []byte("soon"), DeliverAt: time.Now().Add(time.Second).Unix()}))
+	a.Len(s.List(), 2)
+
+	// when the scheduler is stopped until the second message is due
+	a.NoError(s.Stop())
+	a.Empty(s.List())
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+2, 0)))
+	expectNoMessage(a, route)
+
+	// then the messages are loaded on start, and the due message is delivered immediately
+	s = New(r, "/admin/scheduled")
+	a.NoError(s.Start())
+	defer s.Stop()
+	expectMessage(a, route, "soon", 100*time.Millisecond)
+	a.Len(s.List(), 1)
+	a.Equal(1, countStored(kvs))
+}
+
+func TestScheduler_SharedKVStore(t *testing.T) {
+	a := assert.New(t)
+	defer func(interval time.Duration) { syncInterval = interval }(syncInterval)
+	syncInterval = 10 * time.Millisecond
+
+	// given the schedulers of two nodes sharing the KVStore
+	kvs := kvstore.NewMemoryKVStore()
+	r1, route1 := aStartedRouter(a, kvs)
+	r2, route2 := aStartedRouter(a, kvs)
+	s1 := New(r1, "/admin/scheduled")
+	a.NoError(s1.Start())
+	defer s1.Stop()
+	s2 := New(r2, "/admin/scheduled")
+	a.NoError(s2.Start())
+	defer s2.Stop()
+
+	// when messages are scheduled on one node after the start
+	a.NoError(r1.HandleMessage(&protocol.Message{Path: "/reminders", Body: []byte("soon"), DeliverAt: time.Now().Add(time.Second).Unix()}))
+	a.NoError(r1.HandleMessage(&protocol.Message{Path: "/reminders", Body: []byte("later"), DeliverAt: time.Now().Add(time.Hour).Unix()}))
+
+	// then the other node picks them up
+	time.Sleep(50 * time.Millisecond)
+	a.Len(s2.List(), 2)
+
+	// and a cancellation on one node applies on the other one
+	a.NoError(s2.Cancel(s2.List()[1].ID))
+	time.Sleep(50 * time.Millisecond)
+	a.Len(s1.List(), 1)
+
+	// and the due message is delivered by one node only
+	received := 0
+	timeout := time.After(2 * time.Second)
+	for received < 2 {
+		select {
+		case m := <-route1.MessagesChannel():
+			a.Equal("soon", string(m.Body))
+		case m := <-route2.MessagesChannel():
+			a.Equal("soon", string(m.Body))
+		case <-timeout:
+			a.Equal(1, received)
+			return
+		}
+		received++
+	}
+	a.Fail("Message delivered twice")
+}
+
+func TestScheduler_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r, route := aStartedRouter(a, kvs)
+	s := New(r, "/admin/scheduled/")
+	a.NoError(s.Start())
+	defer s.Stop()
+
+	request := func(method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		a.NoError(err)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/reminders", Body: []byte("later"), DeliverAt: time.Now().Add(time.Second).Unix()}))
+
+	// the pending messages are listed
+	w := request(http.MethodGet, "/admin/scheduled")
+	a.Equal(http.StatusOK, w.Code)
+	var list []ScheduledMessage
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &list))
+	a.Len(list, 1)
+	a.Equal(protocol.Path("/reminders"), list[0].Path)
+
+	// and can be cancelled
+	w = request(http.MethodDelete, "/admin/scheduled/"+list[0].ID)
+	a.Equal(http.StatusNoContent, w.Code)
+	a.Empty(s.List())
+	a.Equal(0, countStored(kvs))
+
+	w = request(http.MethodDelete, "/admin/scheduled/"+list[0].ID)
+	a.Equal(http.StatusNotFound, w.Code)
+	w = request(http.MethodPut, "/admin/scheduled/")
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	// and the cancelled message is not delivered
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+2, 0)))
+	expectNoMessage(a, route)
+}
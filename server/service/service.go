@@ -81,11 +81,13 @@ func (s *Service) MetricsEndpoint(endpointPrefix string) *Service {
 }
 
 // Start checks the modules for the following interfaces and registers and/or starts:
+//   router.Interceptor: Add the module to the interceptor chain of the router, before starting any module
 //   Startable:
 //   health.Checker:
 //   Endpoint: Register the handler function of the Endpoint in the http service at prefix
 func (s *Service) Start() error {
 	var multierr *multierror.Error
+	s.registerInterceptors()
 	if s.healthEndpoint != "" {
 		logger.WithField("healthEndpoint", s.healthEndpoint).Info("Health endpoint")
 		s.webserver.Handle(s.healthEndpoint, http.HandlerFunc(health.StatusHandler))
@@ -122,6 +124,20 @@ func (s *Service) Start() error {
 	return multierr.ErrorOrNil()
 }
 
+// registerInterceptors adds the modules implementing router.Interceptor to the router, in their start order
+func (s *Service) registerInterceptors() {
+	interceptable, ok := s.router.(router.Interceptable)
+	if !ok {
+		return
+	}
+	for _, iface := range s.ModulesSortedByStartOrder() {
+		if i, ok := iface.(router.Interceptor); ok {
+			logger.WithField("name", reflect.TypeOf(iface).String()).Info("Registering module as Interceptor")
+			interceptable.AddInterceptor(i)
+		}
+	}
+}
+
 // Stop stops the registered modules in their given order
 func (s *Service) Stop() error {
 	var multierr *multierror.Error
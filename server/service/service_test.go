@@ -1,7 +1,9 @@
 package service
 
 import (
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
 	"github.com/smancke/guble/server/webserver"
@@ -162,6 +164,30 @@ func TestMetricsEnabled(t *testing.T) {
 	a.True(len(body) > 0)
 }
 
+func TestInterceptorsRegistration(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	// given: a service with an interceptable router, and two interceptor modules
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	routerMock.EXPECT().Cluster().Return(nil).MaxTimes(2)
+	r := &interceptableRouter{MockRouter: routerMock}
+	service := New(r, webserver.New("localhost:0"))
+
+	first, second := &testInterceptor{"first"}, &testInterceptor{"second"}
+	service.RegisterModules(5, 5, second)
+	service.RegisterModules(1, 1, first, &testEndpoint{})
+
+	// when starting the service
+	defer service.Stop()
+	service.Start()
+
+	// then the interceptors are added to the router in their start order
+	a.Equal([]router.Interceptor{first, second}, r.interceptors)
+}
+
 func aMockedServiceWithMockedRouterStandalone() (*Service, kvstore.KVStore, store.MessageStore, *MockRouter) {
 	kvStore := kvstore.NewMemoryKVStore()
 	messageStore := dummystore.New(kvStore)
@@ -196,3 +222,21 @@ type testStopable struct {
 func (*testStopable) Stop() error {
 	panic(fmt.Errorf("In a panic when I should stop"))
 }
+
+type testInterceptor struct {
+	name string
+}
+
+func (i *testInterceptor) Intercept(m *protocol.Message) error {
+	m.SetFilter("intercepted_by", i.name)
+	return nil
+}
+
+type interceptableRouter struct {
+	*MockRouter
+	interceptors []router.Interceptor
+}
+
+func (r *interceptableRouter) AddInterceptor(i router.Interceptor) {
+	r.interceptors = append(r.interceptors, i)
+}
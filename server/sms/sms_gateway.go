@@ -224,6 +224,12 @@ func (g *gateway) retry(msg *protocol.Message) error {
 }
 
 func (g *gateway) send(receivedMsg *protocol.Message) error {
+	if receivedMsg.IsExpired() {
+		g.logger.WithField("messageID", receivedMsg.ID).Info("Skipping expired message")
+		mTotalExpiredMessages.Add(1)
+		g.SetLastSentID(receivedMsg.ID)
+		return nil
+	}
 	err := g.sender.Send(receivedMsg)
 	if err != nil {
 		log.WithField("error", err.Error()).Error("Sending of message failed")
@@ -131,6 +131,57 @@ func Test_SendOneSms(t *testing.T) {
 	a.Equal(totalSentCount, mTotalSentMessages)
 }
 
+func Test_SkipExpiredSms(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// a sender which is never called
+	mockSmsSender := NewMockSender(ctrl)
+	kvStore := kvstore.NewMemoryKVStore()
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	routerMock.EXPECT().KVStore().AnyTimes().Return(kvStore, nil)
+	msgStore := dummystore.New(kvStore)
+	routerMock.EXPECT().MessageStore().AnyTimes().Return(msgStore, nil)
+
+	topic := "/sms"
+	worker := 1
+	intervalMetrics := false
+	config := Config{
+		Workers:         &worker,
+		SMSTopic:        &topic,
+		Name:            "test_gateway",
+		Schema:          SMSSchema,
+		IntervalMetrics: &intervalMetrics,
+	}
+
+	routerMock.EXPECT().Subscribe(gomock.Any()).Return(nil, nil)
+
+	gw, err := New(routerMock, mockSmsSender, config)
+	a.NoError(err)
+
+	err = gw.Start()
+	a.NoError(err)
+
+	msg := protocol.Message{
+		Path:    protocol.Path(topic),
+		ID:      uint64(4),
+		Body:    []byte(`{"to":"toNumber","from":"FromNumber","text":"body"}`),
+		Expires: time.Now().Add(-time.Minute).Unix(),
+	}
+	gw.route.Deliver(&msg, true)
+	time.Sleep(100 * time.Millisecond)
+
+	err = gw.Stop()
+	a.NoError(err)
+
+	// the expired message is skipped, but counted as handled
+	err = gw.ReadLastID()
+	a.NoError(err)
+	a.Equal(uint64(4), gw.LastIDSent)
+}
+
 func Test_Restart(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -11,6 +11,7 @@ var (
 	mTotalSendErrors             = ns.NewInt("total_sent_message_errors")
 	mTotalResponseErrors         = ns.NewInt("total_response_errors")
 	mTotalResponseInternalErrors = ns.NewInt("total_response_internal_errors")
+	mTotalExpiredMessages        = ns.NewInt("total_expired_messages")
 	mMinute                      = ns.NewMap("minute")
 	mHour                        = ns.NewMap("hour")
 	mDay                         = ns.NewMap("day")
@@ -0,0 +1,195 @@
+// Package backup exports the messages of the partitions of a message store to a portable format,
+// and imports them into any other message store.
+//
+// An export is a stream of records, one JSON object per line, ordered by partition and message id.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+var (
+	// fetchPageSize is the number of messages fetched at once from a partition by an export
+	fetchPageSize = 1000
+
+	logger = log.WithField("module", "backup")
+)
+
+// Record is an exported message.
+type Record struct {
+	Partition string `json:"partition"`
+	ID        uint64 `json:"id"`
+
+	// Message is the stored message, usually in the guble wire format. It is encoded in base64 in JSON.
+	Message []byte `json:"message"`
+}
+
+// InvalidRecordError is returned by Import for a record which cannot be read.
+type InvalidRecordError struct {
+	// Number is the position of the record in the import, starting with 1
+	Number int
+	Reason string
+}
+
+func (e *InvalidRecordError) Error() string {
+	return fmt.Sprintf("Invalid record %d: %s", e.Number, e.Reason)
+}
+
+// ImportResult counts the messages of an import.
+type ImportResult struct {
+	Messages int
+
+	// Renumbered is the number of messages stored with a new id,
+	// because their id was not higher than the max message id of their partition.
+	Renumbered int
+}
+
+// Export writes the messages of the partitions, or of all the partitions of the message store if none is given,
+// and returns the number of exported messages.
+func Export(w io.Writer, ms store.MessageStore, partitions []string) (int, error) {
+	if len(partitions) == 0 {
+		storePartitions, err := ms.Partitions()
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range storePartitions {
+			partitions = append(partitions, p.Name())
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for _, partition := range partitions {
+		n, err := exportPartition(encoder, ms, partition)
+		exported += n
+		if err != nil {
+			logger.WithError(err).WithField("partition", partition).Error("Error exporting partition")
+			return exported, err
+		}
+		logger.WithFields(log.Fields{"partition": partition, "messages": n}).Info("Exported partition")
+	}
+	return exported, nil
+}
+
+// exportPartition writes the messages of the partition, fetching them by pages
+func exportPartition(encoder *json.Encoder, ms store.MessageStore, partition string) (int, error) {
+	exported := 0
+	startID := uint64(0)
+	for {
+		req := store.NewFetchRequest(partition, startID, 0, store.DirectionForward, fetchPageSize)
+		req.Init()
+		ms.Fetch(req)
+
+		var count int
+		select {
+		case count = <-req.StartC:
+		case err := <-req.Errors():
+			return exported, err
+		}
+
+		for open := true; open; {
+			select {
+			case fetched, ok := <-req.Messages():
+				if !ok {
+					open = false
+					break
+				}
+				if err := encoder.Encode(Record{Partition: partition, ID: fetched.ID, Message: fetched.Message}); err != nil {
+					drain(req)
+					return exported, err
+				}
+				exported++
+				startID = fetched.ID + 1
+			case err := <-req.Errors():
+				return exported, err
+			}
+		}
+
+		if count < fetchPageSize {
+			return exported, nil
+		}
+	}
+}
+
+// drain reads the rest of the fetched messages in the background, so that the fetch can finish
+func drain(req *store.FetchRequest) {
+	go func() {
+		for {
+			select {
+			case _, ok := <-req.Messages():
+				if !ok {
+					return
+				}
+			case <-req.Errors():
+				return
+			}
+		}
+	}()
+}
+
+// Import stores the records read from the reader in the message store.
+// The message ids are kept if they are higher than the max message id of their partition,
+// otherwise the messages get a new id, generated by the message store for the cluster node.
+func Import(r io.Reader, ms store.MessageStore, nodeID uint8) (ImportResult, error) {
+	result := ImportResult{}
+	decoder := json.NewDecoder(r)
+	for {
+		var record Record
+		if err := decoder.Decode(&record); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return result, &InvalidRecordError{Number: result.Messages + 1, Reason: err.Error()}
+		}
+		if !validPartition(record.Partition) {
+			return result, &InvalidRecordError{Number: result.Messages + 1, Reason: fmt.Sprintf("invalid partition %q", record.Partition)}
+		}
+
+		renumbered, err := importRecord(ms, record, nodeID)
+		if err != nil {
+			logger.WithError(err).WithFields(log.Fields{
+				"partition": record.Partition,
+				"id":        record.ID,
+			}).Error("Error importing message")
+			return result, err
+		}
+		result.Messages++
+		if renumbered {
+			result.Renumbered++
+		}
+	}
+}
+
+// importRecord stores the message of the record, and returns true if it got a new id
+func importRecord(ms store.MessageStore, record Record, nodeID uint8) (bool, error) {
+	maxID, err := ms.MaxMessageID(record.Partition)
+	if err != nil {
+		return false, err
+	}
+	if record.ID > maxID {
+		return false, ms.Store(record.Partition, record.ID, record.Message)
+	}
+
+	id, _, err := ms.GenerateNextMsgID(record.Partition, nodeID)
+	if err != nil {
+		return false, err
+	}
+	data := record.Message
+	if msg, err := protocol.ParseMessage(data); err == nil {
+		msg.ID = id
+		data = msg.Bytes()
+	}
+	return true, ms.Store(record.Partition, id, data)
+}
+
+// validPartition returns true if the name can be the partition of a topic, which is also safe as a directory name
+func validPartition(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/sqlstore"
+)
+
+func anOpenMessageStore(a *assert.Assertions, dir string) *sqlstore.SqliteMessageStore {
+	ms := sqlstore.NewSqliteMessageStore(path.Join(dir, "message-store.db"), false)
+	a.NoError(ms.Open())
+	return ms
+}
+
+// readRecords parses an export
+func readRecords(a *assert.Assertions, export string) []Record {
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(export), "\n") {
+		var record Record
+		a.NoError(json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestExportImport(t *testing.T) {
+	a := assert.New(t)
+	defer func(size int) { fetchPageSize = size }(fetchPageSize)
+	fetchPageSize = 2
+
+	dir, _ := ioutil.TempDir("", "guble_backup_test")
+	defer os.RemoveAll(dir)
+
+	source := anOpenMessageStore(a, path.Join(dir, "source"))
+	defer source.Stop()
+	for _, p := range []protocol.Path{"/foo/1", "/bar", "/foo/2", "/foo/3", "/foo/4", "/foo/5"} {
+		_, err := source.StoreMessage(&protocol.Message{Path: p, UserID: "user", Body: []byte("body of " + string(p))}, 0)
+		a.NoError(err)
+	}
+
+	// export of all partitions, by pages
+	export := &bytes.Buffer{}
+	count, err := Export(export, source, nil)
+	a.NoError(err)
+	a.Equal(6, count)
+	records := readRecords(a, export.String())
+	if a.Equal(6, len(records)) {
+		a.Equal(Record{Partition: "bar", ID: 1, Message: records[0].Message}, records[0])
+		a.Equal("foo", records[5].Partition)
+		a.Equal(uint64(5), records[5].ID)
+		msg, err := protocol.ParseMessage(records[5].Message)
+		a.NoError(err)
+		a.Equal("body of /foo/5", string(msg.Body))
+	}
+
+	// export of a partition
+	partitionExport := &bytes.Buffer{}
+	count, err = Export(partitionExport, source, []string{"bar"})
+	a.NoError(err)
+	a.Equal(1, count)
+
+	// import in an empty store keeps the ids
+	target := anOpenMessageStore(a, path.Join(dir, "target"))
+	defer target.Stop()
+	result, err := Import(bytes.NewReader(export.Bytes()), target, 0)
+	a.NoError(err)
+	a.Equal(ImportResult{Messages: 6}, result)
+	reexport := &bytes.Buffer{}
+	_, err = Export(reexport, target, nil)
+	a.NoError(err)
+	a.Equal(export.String(), reexport.String())
+
+	// import of messages already stored gives them new ids
+	result, err = Import(bytes.NewReader(partitionExport.Bytes()), target, 0)
+	a.NoError(err)
+	a.Equal(ImportResult{Messages: 1, Renumbered: 1}, result)
+
+	req := store.NewFetchRequest("bar", 2, 0, store.DirectionForward, 1)
+	req.Init()
+	target.Fetch(req)
+	a.Equal(1, req.Ready())
+	fetched := <-req.Messages()
+	a.Equal(uint64(2), fetched.ID)
+	msg, err := protocol.ParseMessage(fetched.Message)
+	a.NoError(err)
+	a.Equal(uint64(2), msg.ID)
+	a.Equal("body of /bar", string(msg.Body))
+}
+
+func TestImport_InvalidRecords(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_backup_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenMessageStore(a, dir)
+	defer ms.Stop()
+
+	for _, c := range []struct {
+		input    string
+		imported int
+		reason   string
+	}{
+		{`{"partition":"foo","id":1,"message":"Zm9v"}` + "\n" + `{"partition":`, 1, "unexpected EOF"},
+		{`{"partition":"foo","id":2,"message":"not base64"}`, 0,
+			"json: cannot unmarshal string into Go struct field Record.message of type []uint8: illegal base64 data at input byte 3"},
+		{`{"partition":"../foo","id":2,"message":"Zm9v"}`, 0, `invalid partition "../foo"`},
+		{`{"id":2,"message":"Zm9v"}`, 0, `invalid partition ""`},
+	} {
+		result, err := Import(strings.NewReader(c.input), ms, 0)
+		a.Equal(c.imported, result.Messages, c.input)
+		if a.IsType(&InvalidRecordError{}, err, c.input) {
+			a.Equal(c.imported+1, err.(*InvalidRecordError).Number)
+			a.Equal(c.reason, err.(*InvalidRecordError).Reason)
+		}
+	}
+}
@@ -38,6 +38,10 @@ type FetchRequest struct {
 	// EndID is the message sequence id to finish. If  will not be used.
 	EndID uint64
 
+	// StartTime is a Unix Timestamp. If set, the fetch starts with the first message published at or after this time:
+	// the StartID is replaced by the id of this message, or by a lower bound of its id.
+	StartTime int64
+
 	// Direction has 3 possible values:
 	// Direction == 0: Only the Message with StartId
 	// Direction == 1: Fetch also the next Count Messages with a higher MessageId
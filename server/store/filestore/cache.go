@@ -6,14 +6,21 @@ import (
 	"github.com/smancke/guble/server/store"
 )
 
+// cache holds the id ranges of the closed segments of a partition, the oldest first.
+// The segments removed by the retention while being fetched are deleted when the last fetch releases them.
 type cache struct {
 	entries []*cacheEntry
+	first   int          // the file id of the first entry
+	refs    map[int]int  // the number of fetches reading each segment, by file id
+	removed map[int]bool // the removed segments still being fetched, by file id
 	sync.RWMutex
 }
 
 func newCache() *cache {
 	c := &cache{
 		entries: make([]*cacheEntry, 0),
+		refs:    make(map[int]int),
+		removed: make(map[int]bool),
 	}
 	return c
 }
@@ -25,6 +32,14 @@ func (c *cache) length() int {
 	return len(c.entries)
 }
 
+// nextFileID returns the file id following the closed segments, which is the id of the current segment
+func (c *cache) nextFileID() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.first + len(c.entries)
+}
+
 func (c *cache) add(entry *cacheEntry) {
 	c.Lock()
 	defer c.Unlock()
@@ -32,6 +47,51 @@ func (c *cache) add(entry *cacheEntry) {
 	c.entries = append(c.entries, entry)
 }
 
+// retain marks the segments as being fetched; it has to be called holding the read lock
+func (c *cache) retain(fileIDs map[int]bool) {
+	for fileID := range fileIDs {
+		c.refs[fileID]++
+	}
+}
+
+// release marks the segments as not being fetched any more,
+// and returns the ids of the removed segments which can be deleted now
+func (c *cache) release(fileIDs map[int]bool) (deletable []int) {
+	c.Lock()
+	defer c.Unlock()
+
+	for fileID := range fileIDs {
+		c.refs[fileID]--
+		if c.refs[fileID] > 0 {
+			continue
+		}
+		delete(c.refs, fileID)
+		if c.removed[fileID] {
+			delete(c.removed, fileID)
+			deletable = append(deletable, fileID)
+		}
+	}
+	return
+}
+
+// removeFirst removes the n oldest segments,
+// and returns the ids of the segments which are not being fetched and can be deleted now
+func (c *cache) removeFirst(n int) (deletable []int) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i := 0; i < n && len(c.entries) > 0; i++ {
+		if c.refs[c.first] > 0 {
+			c.removed[c.first] = true
+		} else {
+			deletable = append(deletable, c.first)
+		}
+		c.entries = c.entries[1:]
+		c.first++
+	}
+	return
+}
+
 type cacheEntry struct {
 	min, max uint64
 }
@@ -0,0 +1,325 @@
+package filestore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// compactionSuffix is the suffix of the files written while compacting a segment,
+// which replace the files of the segment once both are written
+const compactionSuffix = ".compacting"
+
+// compactionKey returns the compaction key of a stored message, or "" if it has none
+func compactionKey(data []byte) string {
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		return ""
+	}
+	return msg.CompactionKey
+}
+
+// segmentKeys holds the compaction keys of the messages of a segment read by the compaction, by message id.
+// The messages without compaction key are only listed until all the messages of a closed segment were read.
+type segmentKeys struct {
+	keys     map[uint64]string
+	complete bool
+}
+
+// compact rewrites the closed segments of the partition, so that only the latest message of each compaction key is kept.
+// The messages without compaction key are always kept. The current segment is not compacted,
+// but its messages supersede the older ones. A segment being fetched is skipped, and compacted by a later run.
+// The segments are read and written without locking the partition, which is only locked to replace their files,
+// and the compaction keys are kept between the runs, so that only the messages stored since the last run are read.
+// It returns the number of removed messages.
+func (p *messagePartition) compact() (int, error) {
+	p.compactionMutex.Lock()
+	defer p.compactionMutex.Unlock()
+
+	p.RLock()
+	p.fileCache.RLock()
+	first, closed := p.fileCache.first, len(p.fileCache.entries)
+	p.fileCache.RUnlock()
+	current := newIndexList(p.list.len())
+	current.insertList(p.list)
+	p.RUnlock()
+	if closed == 0 {
+		return 0, nil
+	}
+
+	// the keys of the segments removed by the retention are dropped
+	if p.compactionKeys == nil {
+		p.compactionKeys = make(map[int]*segmentKeys)
+	}
+	for fileID := range p.compactionKeys {
+		if fileID < first {
+			delete(p.compactionKeys, fileID)
+		}
+	}
+
+	latest := make(map[string]uint64)
+	for fileID := first; fileID <= first+closed; fileID++ {
+		sk, err := p.readCompactionKeys(fileID, fileID == first+closed, current)
+		if err != nil {
+			return 0, err
+		}
+		for id, key := range sk.keys {
+			if key != "" && id > latest[key] {
+				latest[key] = id
+			}
+		}
+	}
+
+	removed := 0
+	for fileID := first; fileID < first+closed; fileID++ {
+		sk := p.compactionKeys[fileID]
+		superseded := make(map[uint64]bool)
+		for id, key := range sk.keys {
+			if latest[key] != id {
+				superseded[id] = true
+			}
+		}
+		if len(superseded) == 0 {
+			continue
+		}
+
+		l, err := p.loadIndexList(fileID)
+		if err != nil {
+			return removed, err
+		}
+		var kept []*index
+		l.mapWithPredicate(func(elem *index, _ int) error {
+			if !superseded[elem.id] {
+				kept = append(kept, elem)
+			}
+			return nil
+		})
+		compacted, err := p.compactSegment(fileID, kept, len(superseded))
+		if err != nil {
+			return removed, err
+		}
+		if compacted {
+			removed += len(superseded)
+			for id := range superseded {
+				delete(sk.keys, id)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// readCompactionKeys reads the compaction keys of the messages of a segment which were not read by an earlier run,
+// and returns all the keys of the segment. The messages of the current segment are the ones of the list.
+func (p *messagePartition) readCompactionKeys(fileID int, isCurrent bool, current *indexList) (*segmentKeys, error) {
+	sk, ok := p.compactionKeys[fileID]
+	if !ok {
+		sk = &segmentKeys{keys: make(map[uint64]string)}
+		p.compactionKeys[fileID] = sk
+	}
+	if sk.complete {
+		return sk, nil
+	}
+
+	l := current
+	if !isCurrent {
+		var err error
+		if l, err = p.loadIndexList(fileID); err != nil {
+			return nil, err
+		}
+	}
+	if l.len() > len(sk.keys) {
+		filename := p.composeMsgFilenameForPosition(uint64(fileID))
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		version, err := readFileVersion(file)
+		if err != nil {
+			return nil, err
+		}
+
+		err = l.mapWithPredicate(func(elem *index, _ int) error {
+			if _, read := sk.keys[elem.id]; read {
+				return nil
+			}
+			data, err := readRecord(file, filename, version, p.keys, elem)
+			if err != nil {
+				return err
+			}
+			sk.keys[elem.id] = compactionKey(data)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the messages of a closed segment do not change any more, so only the ones with compaction key are kept
+	if !isCurrent {
+		for id, key := range sk.keys {
+			if key == "" {
+				delete(sk.keys, id)
+			}
+		}
+		sk.complete = true
+	}
+	return sk, nil
+}
+
+// compactSegment replaces the files of a closed segment with files holding only the kept messages.
+// It returns false if the segment is being fetched, and was not replaced.
+func (p *messagePartition) compactSegment(fileID int, kept []*index, removed int) (bool, error) {
+	msgFilename := p.composeMsgFilenameForPosition(uint64(fileID))
+	idxFilename := p.composeIdxFilenameForPosition(uint64(fileID))
+	discard := func() {
+		os.Remove(msgFilename + compactionSuffix)
+		os.Remove(idxFilename + compactionSuffix)
+	}
+
+	if err := writeCompactedSegment(msgFilename, idxFilename, p.newFileVersion(), p.keys, kept); err != nil {
+		logger.WithError(err).WithField("filename", msgFilename).Error("Error writing compacted segment")
+		discard()
+		return false, err
+	}
+
+	// the compacted files keep the modification time of the segment, by which its age is determined by the retention
+	if err := keepModTime(msgFilename, msgFilename+compactionSuffix, idxFilename+compactionSuffix); err != nil {
+		discard()
+		return false, err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.fileCache.Lock()
+	defer p.fileCache.Unlock()
+
+	if p.fileCache.refs[fileID] > 0 {
+		discard()
+		return false, nil
+	}
+
+	// the message file is replaced first, so that an interrupted replacement is completed when loading the partition
+	if err := os.Rename(msgFilename+compactionSuffix, msgFilename); err != nil {
+		discard()
+		return false, err
+	}
+	if err := os.Rename(idxFilename+compactionSuffix, idxFilename); err != nil {
+		return false, err
+	}
+
+	entry := &cacheEntry{}
+	if len(kept) > 0 {
+		entry = &cacheEntry{min: kept[0].id, max: kept[len(kept)-1].id}
+	}
+	p.fileCache.entries[fileID-p.fileCache.first] = entry
+	p.totalNumberOfMessages -= uint64(removed)
+	return true, nil
+}
+
+// keepModTime sets the modification time of the file to the files replacing it
+func keepModTime(filename string, replacements ...string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	for _, replacement := range replacements {
+		if err := os.Chtimes(replacement, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCompactedSegment writes the kept messages of a segment and their sorted index
+// into new files of the version, named after the files of the segment with the compactionSuffix.
+// The keys decrypt the messages of the segment, and encrypt the new ones.
+func writeCompactedSegment(msgFilename, idxFilename string, version byte, keys *Keys, kept []*index) error {
+	src, err := os.Open(msgFilename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	srcVersion, err := readFileVersion(src)
+	if err != nil {
+		return err
+	}
+
+	msgFile, err := os.Create(msgFilename + compactionSuffix)
+	if err != nil {
+		return err
+	}
+	defer msgFile.Close()
+
+	idxFile, err := os.Create(idxFilename + compactionSuffix)
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	if _, err := msgFile.Write(magicNumber); err != nil {
+		return err
+	}
+	if _, err := msgFile.Write([]byte{version}); err != nil {
+		return err
+	}
+	position := fileHeaderSize()
+
+	for i, elem := range kept {
+		data, err := readRecord(src, msgFilename, srcVersion, keys, elem)
+		if err != nil {
+			return err
+		}
+
+		record, err := encodeRecord(version, keys, elem.id, data)
+		if err != nil {
+			return err
+		}
+		if _, err := msgFile.Write(record); err != nil {
+			return err
+		}
+
+		offset := position + recordHeaderSize(version)
+		size := uint32(uint64(len(record)) - recordHeaderSize(version))
+		if err := writeIndexEntry(idxFile, elem.id, offset, size, uint64(i)); err != nil {
+			return err
+		}
+		position += uint64(len(record))
+	}
+
+	if err := msgFile.Sync(); err != nil {
+		return err
+	}
+	return idxFile.Sync()
+}
+
+// completeCompactions completes the replacements of segment files interrupted after replacing the message file,
+// and discards the files of the other interrupted compactions
+func (p *messagePartition) completeCompactions() error {
+	filenames, err := filepath.Glob(filepath.Join(p.basedir, p.name+"-*"+compactionSuffix))
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, filename := range filenames {
+		written[filename] = true
+	}
+	for _, filename := range filenames {
+		idxFilename := strings.TrimSuffix(filename, compactionSuffix)
+		msgFilename := strings.TrimSuffix(idxFilename, ".idx") + ".msg"
+		if strings.HasSuffix(idxFilename, ".idx") && !written[msgFilename+compactionSuffix] {
+			logger.WithField("filename", idxFilename).Info("Completing interrupted compaction")
+			if err := os.Rename(filename, idxFilename); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// aCompactionPartition returns a partition of 12 messages with five messages per file,
+// in which the latest messages of the compaction keys are: a=6, b=11, c=5, d=10
+func aCompactionPartition(a *assert.Assertions, dir string) *messagePartition {
+	messagesPerFile = uint64(5)
+
+	p, err := newMessagePartition(dir, "devices")
+	a.NoError(err)
+	keys := []string{"a", "b", "a", "", "c", "a", "b", "", "d", "d", "b", ""}
+	for i, key := range keys {
+		msg := &protocol.Message{ID: uint64(i + 1), Path: "/devices", CompactionKey: key, Body: []byte("state")}
+		a.NoError(p.Store(msg.ID, msg.Bytes()))
+	}
+	return p
+}
+
+func Test_MessagePartition_compact(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compaction_test")
+	defer os.RemoveAll(dir)
+	p := aCompactionPartition(a, dir)
+
+	removed, err := p.compact()
+	a.NoError(err)
+	a.Equal(5, removed)
+	a.Equal(uint64(7), p.Count())
+	a.Equal(uint64(12), p.MaxMessageID())
+
+	removed, err = p.compact()
+	a.NoError(err)
+	a.Equal(0, removed)
+
+	expectFetches := func(p *messagePartition) {
+		a.Equal([]uint64{4, 5, 6, 8, 10, 11, 12}, fetchIDs(a, p, &store.FetchRequest{StartID: 0, Count: 100}))
+		a.Equal([]uint64{4, 5}, fetchIDs(a, p, &store.FetchRequest{StartID: 2, Direction: 1, Count: 2}))
+		a.Equal([]uint64{10, 11}, fetchIDs(a, p, &store.FetchRequest{StartID: 9, Direction: 1, Count: 2}))
+		a.Equal([]uint64{5, 6}, fetchIDs(a, p, &store.FetchRequest{StartID: 7, Direction: -1, Count: 2}))
+	}
+	expectFetches(p)
+
+	// the compacted segments are loaded after a restart
+	a.NoError(p.Close())
+	p, err = newMessagePartition(dir, "devices")
+	a.NoError(err)
+	defer p.Close()
+	a.Equal(uint64(7), p.Count())
+	a.Equal(uint64(12), p.MaxMessageID())
+	expectFetches(p)
+}
+
+func Test_MessagePartition_compactKeepsAgeForRetention(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compaction_test")
+	defer os.RemoveAll(dir)
+	p := aCompactionPartition(a, dir)
+	defer p.Close()
+
+	// the closed segments were written two hours ago
+	written := time.Now().Add(-2 * time.Hour)
+	for fileID := 0; fileID < 2; fileID++ {
+		a.NoError(os.Chtimes(p.composeMsgFilenameForPosition(uint64(fileID)), written, written))
+		a.NoError(os.Chtimes(p.composeIdxFilenameForPosition(uint64(fileID)), written, written))
+	}
+
+	removed, err := p.compact()
+	a.NoError(err)
+	a.Equal(5, removed)
+
+	// the compacted segments are as old as before, so they are removed by the retention
+	removed, err = p.applyRetention(RetentionPolicy{MaxAge: time.Hour}, time.Now())
+	a.NoError(err)
+	a.Equal(2, removed)
+	a.Equal([]uint64{11, 12}, fetchIDs(a, p, &store.FetchRequest{StartID: 0, Count: 100}))
+}
+
+func Test_MessagePartition_compactReadsNewMessagesOnly(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compaction_test")
+	defer os.RemoveAll(dir)
+	p := aCompactionPartition(a, dir)
+	defer p.Close()
+
+	removed, err := p.compact()
+	a.NoError(err)
+	a.Equal(5, removed)
+
+	// the segments compacted before are not read again, so the damage of the first one is not noticed
+	corrupt(a, p.composeMsgFilenameForPosition(0), 9+16)
+	removed, err = p.compact()
+	a.NoError(err)
+	a.Equal(0, removed)
+
+	// a new message supersedes one of the second segment
+	msg := &protocol.Message{ID: 13, Path: "/devices", CompactionKey: "a", Body: []byte("state")}
+	a.NoError(p.Store(msg.ID, msg.Bytes()))
+	removed, err = p.compact()
+	a.NoError(err)
+	a.Equal(1, removed)
+	a.Equal([]uint64{8, 10, 11, 12, 13}, fetchIDs(a, p, &store.FetchRequest{StartID: 6, Count: 100}))
+
+	// a partition loaded again reads all the segments
+	p.compactionKeys = nil
+	_, err = p.compact()
+	a.Error(err)
+}
+
+func Test_MessagePartition_compactWhileFetching(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compaction_test")
+	defer os.RemoveAll(dir)
+	p := aCompactionPartition(a, dir)
+	defer p.Close()
+
+	fetchList, err := p.calculateFetchList(&store.FetchRequest{StartID: 1, Direction: 1, Count: 2})
+	a.NoError(err)
+
+	// the first segment is being fetched, so only the second one is compacted
+	removed, err := p.compact()
+	a.NoError(err)
+	a.Equal(2, removed)
+
+	p.releaseSegments(fetchList)
+	removed, err = p.compact()
+	a.NoError(err)
+	a.Equal(3, removed)
+	a.Equal([]uint64{4, 5, 6}, fetchIDs(a, p, &store.FetchRequest{StartID: 1, Direction: 1, Count: 3}))
+}
+
+func Test_MessagePartition_completeCompactions(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compaction_test")
+	defer os.RemoveAll(dir)
+	p := aCompactionPartition(a, dir)
+	a.NoError(p.Close())
+
+	// the compaction of the first segment was interrupted after replacing its message file
+	idxFile, err := os.Create(p.composeIdxFilenameForPosition(0) + compactionSuffix)
+	a.NoError(err)
+	a.NoError(writeIndexEntry(idxFile, 5, 21, 10, 0))
+	a.NoError(idxFile.Close())
+
+	// the compaction of the second segment was interrupted before replacing any file
+	a.NoError(ioutil.WriteFile(p.composeMsgFilenameForPosition(1)+compactionSuffix, nil, 0666))
+	a.NoError(ioutil.WriteFile(p.composeIdxFilenameForPosition(1)+compactionSuffix, nil, 0666))
+
+	a.NoError(p.completeCompactions())
+
+	entries, err := calculateNoEntries(p.composeIdxFilenameForPosition(0))
+	a.NoError(err)
+	a.Equal(uint64(1), entries)
+	entries, err = calculateNoEntries(p.composeIdxFilenameForPosition(1))
+	a.NoError(err)
+	a.Equal(uint64(5), entries)
+	for _, fileID := range []uint64{0, 1} {
+		_, err = os.Stat(p.composeMsgFilenameForPosition(fileID) + compactionSuffix)
+		a.True(os.IsNotExist(err))
+		_, err = os.Stat(p.composeIdxFilenameForPosition(fileID) + compactionSuffix)
+		a.True(os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,142 @@
+package filestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// segmentVersion returns the file format version of the message file of a segment
+func segmentVersion(a *assert.Assertions, p *messagePartition, fileID int) byte {
+	file, err := os.Open(p.composeMsgFilenameForPosition(uint64(fileID)))
+	a.NoError(err)
+	defer file.Close()
+	version, err := readFileVersion(file)
+	a.NoError(err)
+	return version
+}
+
+// fetchBodies returns the messages fetched from the partition
+func fetchBodies(a *assert.Assertions, p *messagePartition, req *store.FetchRequest) [][]byte {
+	req.Partition = p.Name()
+	req.Init()
+	p.Fetch(req)
+	req.Ready()
+
+	bodies := [][]byte{}
+	for {
+		select {
+		case msg, open := <-req.Messages():
+			if !open {
+				return bodies
+			}
+			bodies = append(bodies, msg.Message)
+		case err := <-req.Errors():
+			a.Fail(err.Error())
+			return bodies
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+			return bodies
+		}
+	}
+}
+
+func Test_MessagePartition_Compression(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_compression_test")
+	defer os.RemoveAll(dir)
+	messagesPerFile = uint64(5)
+
+	body := func(id uint64) []byte {
+		return bytes.Repeat([]byte(strconv.FormatUint(id, 10)), 100)
+	}
+
+	// the current segment written uncompressed before enabling the compression is not continued
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	for id := uint64(1); id <= 3; id++ {
+		a.NoError(p.Store(id, body(id)))
+	}
+	a.NoError(p.Close())
+
+	p, err = newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	p.compress = true
+	for id := uint64(4); id <= 12; id++ {
+		a.NoError(p.Store(id, body(id)))
+	}
+
+	a.Equal(fileFormatV2, segmentVersion(a, p, 0))
+	a.Equal(fileFormatV3, segmentVersion(a, p, 1))
+	a.Equal(fileFormatV3, segmentVersion(a, p, 2))
+
+	// the compressed messages are stored with their compressed size
+	idxFile, err := os.Open(p.composeIdxFilenameForPosition(1))
+	a.NoError(err)
+	_, _, size, err := readIndexEntry(idxFile, 0)
+	a.NoError(err)
+	a.NoError(idxFile.Close())
+	a.True(int(size) < len(body(6)))
+
+	ratio, err := strconv.ParseFloat(mCompression.Get("ratio").String(), 64)
+	a.NoError(err)
+	a.True(ratio > 0 && ratio < 1)
+
+	expectFetches := func(p *messagePartition) {
+		bodies := fetchBodies(a, p, &store.FetchRequest{StartID: 0, Count: 100})
+		if a.Equal(12, len(bodies)) {
+			for i, b := range bodies {
+				a.Equal(body(uint64(i+1)), b)
+			}
+		}
+		a.Equal([][]byte{body(5), body(6)}, fetchBodies(a, p, &store.FetchRequest{StartID: 5, Direction: 1, Count: 2}))
+	}
+	expectFetches(p)
+
+	// the compression does not matter for reading after a restart
+	a.NoError(p.Close())
+	p, err = newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	defer p.Close()
+	a.Equal(uint64(12), p.MaxMessageID())
+	expectFetches(p)
+}
+
+func Test_MessagePartition_compactCompressed(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_compression_test")
+	defer os.RemoveAll(basedir)
+	dir := path.Join(basedir, "devices")
+	a.NoError(os.Mkdir(dir, 0700))
+	p := aCompactionPartition(a, dir)
+
+	// the compacted segments are rewritten in the format of the new segments
+	p.compress = true
+	removed, err := p.compact()
+	a.NoError(err)
+	a.Equal(5, removed)
+	a.Equal(fileFormatV3, segmentVersion(a, p, 0))
+	a.Equal(fileFormatV3, segmentVersion(a, p, 1))
+	a.Equal(fileFormatV2, segmentVersion(a, p, 2))
+	a.Equal([]uint64{4, 5, 6, 8, 10, 11, 12}, fetchIDs(a, p, &store.FetchRequest{StartID: 0, Count: 100}))
+	a.NoError(p.Close())
+
+	damages, err := Verify(basedir, false)
+	a.NoError(err)
+	a.Empty(damages)
+
+	// a corrupted compressed message is detected
+	corrupt(a, p.composeMsgFilenameForPosition(0), 9+16)
+	damages, err = Verify(basedir, false)
+	a.NoError(err)
+	if a.Equal(1, len(damages)) {
+		a.Contains(damages[0].Reason, "message 4")
+	}
+}
@@ -0,0 +1,137 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// encryptionHeaderSize is the size of the data preceding an encrypted message in a record: the key id and the nonce
+const encryptionHeaderSize = 4 + 12
+
+var errNoKeys = errors.New("Cannot write to an encrypted message file without keys")
+
+// Keys are the AES keys of the encrypted message files, by key id.
+// The key with the highest id is the current key, which encrypts the records written from now on,
+// so that a key is rotated by adding a key with a higher id. The other keys are kept to read the older records.
+type Keys struct {
+	ciphers map[uint32]cipher.AEAD
+	current uint32
+}
+
+// KeyError is returned when reading an encrypted record whose key is not loaded, or is not the key it was encrypted with
+type KeyError struct {
+	Filename string
+	ID       uint64
+	KeyID    uint32
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("Cannot decrypt message %d in %s with the key %d", e.ID, e.Filename, e.KeyID)
+}
+
+// LoadKeys reads a key file, having a key on each line: its id, a positive number, and the key encoded in base64,
+// separated by whitespace. The AES keys can have 16, 24 or 32 bytes. Empty lines and lines starting with # are ignored.
+func LoadKeys(filename string) (*Keys, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key file %s: %v", filename, err)
+	}
+	return keys, nil
+}
+
+func parseKeys(data []byte) (*Keys, error) {
+	keys := &Keys{ciphers: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a key id and a key", line)
+		}
+		keyID, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || keyID == 0 {
+			return nil, fmt.Errorf("line %d: invalid key id %q", line, fields[0])
+		}
+		if _, exists := keys.ciphers[uint32(keyID)]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key id %d", line, keyID)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid base64 key", line)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		keys.ciphers[uint32(keyID)] = gcm
+		if uint32(keyID) > keys.current {
+			keys.current = uint32(keyID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys.ciphers) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	return keys, nil
+}
+
+// additionalData binds an encrypted message to its id, so that the records cannot be exchanged
+func additionalData(id uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, id)
+	return data
+}
+
+// encrypt returns the message encrypted with the current key, preceded by the key id and the nonce
+func (k *Keys) encrypt(id uint64, msg []byte) ([]byte, error) {
+	gcm := k.ciphers[k.current]
+	data := make([]byte, encryptionHeaderSize, encryptionHeaderSize+len(msg)+gcm.Overhead())
+	binary.LittleEndian.PutUint32(data, k.current)
+	nonce := data[4:encryptionHeaderSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(data, nonce, msg, additionalData(id)), nil
+}
+
+// decrypt returns the message of the encrypted data of a record, which has at least the encryptionHeaderSize,
+// and false if it is not encrypted with one of the keys
+func (k *Keys) decrypt(id uint64, data []byte) ([]byte, bool) {
+	if k == nil {
+		return nil, false
+	}
+	gcm, ok := k.ciphers[keyID(data)]
+	if !ok {
+		return nil, false
+	}
+	msg, err := gcm.Open(nil, data[4:encryptionHeaderSize], data[encryptionHeaderSize:], additionalData(id))
+	return msg, err == nil
+}
+
+// keyID returns the id of the key of the encrypted data of a record
+func keyID(data []byte) uint32 {
+	return binary.LittleEndian.Uint32(data)
+}
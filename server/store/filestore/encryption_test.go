@@ -0,0 +1,321 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// aKeyFile returns the content of a key file with 32 byte keys for the key ids
+func aKeyFile(keyIDs ...int) string {
+	content := "# the keys of the test\n"
+	for _, keyID := range keyIDs {
+		key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(keyID)}, 32))
+		content += fmt.Sprintf("%d %s\n", keyID, key)
+	}
+	return content
+}
+
+func someKeys(a *assert.Assertions, keyIDs ...int) *Keys {
+	keys, err := parseKeys([]byte(aKeyFile(keyIDs...)))
+	a.NoError(err)
+	return keys
+}
+
+// segmentKeyIDs returns the ids of the keys of the records in the message file of a segment
+func segmentKeyIDs(a *assert.Assertions, p *messagePartition, fileID int) []uint32 {
+	filename := p.composeMsgFilenameForPosition(uint64(fileID))
+	records, _, reason, err := scanMessageFile(filename)
+	a.NoError(err)
+	a.Empty(reason)
+
+	file, err := os.Open(filename)
+	a.NoError(err)
+	defer file.Close()
+	version, err := readFileVersion(file)
+	a.NoError(err)
+
+	keyIDs := []uint32{}
+	for _, elem := range records {
+		data, err := readStoredData(file, filename, version, elem)
+		a.NoError(err)
+		keyIDs = append(keyIDs, keyID(data))
+	}
+	return keyIDs
+}
+
+// fetchError returns the error of a fetch from the partition
+func fetchError(a *assert.Assertions, p *messagePartition, req *store.FetchRequest) error {
+	req.Partition = p.Name()
+	req.Init()
+	p.Fetch(req)
+	req.Ready()
+
+	for {
+		select {
+		case _, open := <-req.Messages():
+			if !open {
+				return nil
+			}
+		case err := <-req.Errors():
+			return err
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+			return nil
+		}
+	}
+}
+
+func Test_parseKeys(t *testing.T) {
+	a := assert.New(t)
+
+	keys, err := parseKeys([]byte(aKeyFile(2, 1) + "\n  # a comment\n3 " + base64.StdEncoding.EncodeToString(make([]byte, 16))))
+	a.NoError(err)
+	a.Equal(3, len(keys.ciphers))
+	a.Equal(uint32(3), keys.current)
+
+	for _, invalid := range []string{
+		"",
+		"# no keys",
+		"1",
+		"0 " + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"x " + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"1 no-base64",
+		"1 " + base64.StdEncoding.EncodeToString(make([]byte, 20)),
+		aKeyFile(1) + aKeyFile(1),
+	} {
+		_, err := parseKeys([]byte(invalid))
+		a.Error(err, invalid)
+	}
+}
+
+func Test_LoadKeys(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_encryption_test")
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "keys")
+	a.NoError(ioutil.WriteFile(filename, []byte(aKeyFile(1, 2)), 0600))
+	keys, err := LoadKeys(filename)
+	a.NoError(err)
+	a.Equal(uint32(2), keys.current)
+
+	a.NoError(ioutil.WriteFile(filename, []byte("1 x"), 0600))
+	_, err = LoadKeys(filename)
+	a.Contains(err.Error(), filename)
+
+	_, err = LoadKeys(path.Join(dir, "missing"))
+	a.Error(err)
+}
+
+func Test_Keys_encrypt(t *testing.T) {
+	a := assert.New(t)
+	keys := someKeys(a, 1)
+
+	data, err := keys.encrypt(42, []byte("a message"))
+	a.NoError(err)
+	a.Equal(uint32(1), keyID(data))
+	a.False(bytes.Contains(data, []byte("a message")))
+
+	msg, ok := keys.decrypt(42, data)
+	a.True(ok)
+	a.Equal([]byte("a message"), msg)
+
+	// the encrypted message is bound to its id
+	_, ok = keys.decrypt(43, data)
+	a.False(ok)
+
+	_, ok = someKeys(a, 2).decrypt(42, data)
+	a.False(ok)
+
+	var noKeys *Keys
+	_, ok = noKeys.decrypt(42, data)
+	a.False(ok)
+}
+
+func Test_MessagePartition_Encryption(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_encryption_test")
+	defer os.RemoveAll(basedir)
+	dir := path.Join(basedir, "myMessages")
+	a.NoError(os.Mkdir(dir, 0700))
+	messagesPerFile = uint64(5)
+
+	body := func(id uint64) []byte {
+		return []byte("secret message " + strconv.FormatUint(id, 10))
+	}
+	storeMessages := func(keys *Keys, from, to uint64) {
+		p, err := newMessagePartition(dir, "myMessages")
+		a.NoError(err)
+		p.keys = keys
+		for id := from; id <= to; id++ {
+			a.NoError(p.Store(id, body(id)))
+		}
+		a.NoError(p.Close())
+	}
+
+	// the current segment written unencrypted before enabling the encryption is not continued,
+	// nor the current segment encrypted with the key before its rotation
+	storeMessages(nil, 1, 3)
+	storeMessages(someKeys(a, 1), 4, 10)
+	storeMessages(someKeys(a, 1, 2), 11, 12)
+
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	p.keys = someKeys(a, 1, 2)
+	a.Equal(fileFormatV2, segmentVersion(a, p, 0))
+	a.Equal(fileFormatV4, segmentVersion(a, p, 1))
+	a.Equal(fileFormatV4, segmentVersion(a, p, 2))
+	a.Equal(fileFormatV4, segmentVersion(a, p, 3))
+	a.Equal([]uint32{1, 1, 1, 1, 1}, segmentKeyIDs(a, p, 1))
+	a.Equal([]uint32{1, 1}, segmentKeyIDs(a, p, 2))
+	a.Equal([]uint32{2, 2}, segmentKeyIDs(a, p, 3))
+
+	data, err := ioutil.ReadFile(p.composeMsgFilenameForPosition(1))
+	a.NoError(err)
+	a.False(bytes.Contains(data, []byte("secret")))
+
+	bodies := fetchBodies(a, p, &store.FetchRequest{StartID: 0, Count: 100})
+	if a.Equal(12, len(bodies)) {
+		for i, b := range bodies {
+			a.Equal(body(uint64(i+1)), b)
+		}
+	}
+	a.Equal([][]byte{body(9), body(10)}, fetchBodies(a, p, &store.FetchRequest{StartID: 10, Direction: -1, Count: 2}))
+
+	// the messages encrypted with a missing key cannot be read
+	p.keys = someKeys(a, 2)
+	err = fetchError(a, p, &store.FetchRequest{StartID: 4, Count: 100})
+	if keyErr, ok := err.(*KeyError); a.True(ok, "%v", err) {
+		a.Equal(uint64(4), keyErr.ID)
+		a.Equal(uint32(1), keyErr.KeyID)
+	}
+	p.keys = nil
+	_, ok := fetchError(a, p, &store.FetchRequest{StartID: 11, Count: 100}).(*KeyError)
+	a.True(ok)
+
+	// the messages are written in clear again only in a new segment, after disabling the encryption
+	a.NoError(p.Store(13, body(13)))
+	a.Equal(fileFormatV2, segmentVersion(a, p, 4))
+	a.NoError(p.Close())
+
+	// the key problems are no damages
+	damages, err := Verify(basedir, false)
+	a.NoError(err)
+	a.Empty(damages)
+
+	// a corrupted encrypted message is detected without keys
+	corrupt(a, p.composeMsgFilenameForPosition(3), 9+16+4)
+	damages, err = Verify(basedir, false)
+	a.NoError(err)
+	if a.Equal(1, len(damages)) {
+		a.Contains(damages[0].Reason, "message 11")
+	}
+}
+
+func Test_MessagePartition_compressedEncryption(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_encryption_test")
+	defer os.RemoveAll(dir)
+	messagesPerFile = uint64(5)
+
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	defer p.Close()
+	p.compress = true
+	p.keys = someKeys(a, 1)
+
+	body := bytes.Repeat([]byte("compressed and encrypted "), 100)
+	for id := uint64(1); id <= 7; id++ {
+		a.NoError(p.Store(id, body))
+	}
+	a.Equal(fileFormatV5, segmentVersion(a, p, 0))
+	a.Equal(fileFormatV5, segmentVersion(a, p, 1))
+
+	idxFile, err := os.Open(p.composeIdxFilenameForPosition(0))
+	a.NoError(err)
+	_, _, size, err := readIndexEntry(idxFile, 0)
+	a.NoError(err)
+	a.NoError(idxFile.Close())
+	a.True(int(size) < len(body))
+
+	bodies := fetchBodies(a, p, &store.FetchRequest{StartID: 0, Count: 100})
+	a.Equal(7, len(bodies))
+	for _, b := range bodies {
+		a.Equal(body, b)
+	}
+}
+
+func Test_Reencrypt(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_encryption_test")
+	defer os.RemoveAll(basedir)
+	messagesPerFile = uint64(5)
+
+	for _, name := range []string{"plain", "compressed"} {
+		a.NoError(os.Mkdir(path.Join(basedir, name), 0700))
+		p, err := newMessagePartition(path.Join(basedir, name), name)
+		a.NoError(err)
+		p.compress = name == "compressed"
+		for id := uint64(1); id <= 12; id++ {
+			if id == 6 {
+				p.keys = someKeys(a, 1)
+			}
+			if id == 11 {
+				p.keys = someKeys(a, 1, 2)
+			}
+			a.NoError(p.Store(id, []byte("message "+strconv.FormatUint(id, 10))))
+		}
+		a.NoError(p.Close())
+	}
+
+	rewritten, err := Reencrypt(basedir, someKeys(a, 1, 2))
+	a.NoError(err)
+	a.Equal(4, rewritten)
+
+	// the messages can be read with the current key only
+	for name, version := range map[string]byte{"plain": fileFormatV4, "compressed": fileFormatV5} {
+		p, err := newMessagePartition(path.Join(basedir, name), name)
+		a.NoError(err)
+		p.compress = name == "compressed"
+		p.keys = someKeys(a, 2)
+		for fileID := 0; fileID < 3; fileID++ {
+			a.Equal(version, segmentVersion(a, p, fileID))
+		}
+		a.Equal([]uint32{2, 2, 2, 2, 2}, segmentKeyIDs(a, p, 0))
+		a.Equal(uint64(12), p.MaxMessageID())
+
+		bodies := fetchBodies(a, p, &store.FetchRequest{StartID: 0, Count: 100})
+		if a.Equal(12, len(bodies)) {
+			a.Equal([]byte("message 1"), bodies[0])
+			a.Equal([]byte("message 12"), bodies[11])
+		}
+
+		// the current segment is continued with the current key
+		a.NoError(p.Store(13, []byte("message 13")))
+		a.Equal([]uint32{2, 2, 2}, segmentKeyIDs(a, p, 2))
+		a.NoError(p.Close())
+	}
+
+	rewritten, err = Reencrypt(basedir, someKeys(a, 1, 2))
+	a.NoError(err)
+	a.Equal(0, rewritten)
+
+	damages, err := Verify(basedir, false)
+	a.NoError(err)
+	a.Empty(damages)
+
+	// a damaged segment is not rewritten
+	corrupt(a, path.Join(basedir, "plain", "plain-00000000000000000000.msg"), 9+16+4)
+	_, err = Reencrypt(basedir, someKeys(a, 3))
+	a.Error(err)
+}
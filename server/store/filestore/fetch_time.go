@@ -0,0 +1,16 @@
+package filestore
+
+import (
+	"time"
+)
+
+// firstIDSince returns the lowest id which a message published at or after the Unix Timestamp can have.
+// The ids generated by generateNextMsgID start with the publishing time of their message,
+// so a forward fetch from this id starts with the first message published since the time, without reading any message.
+func firstIDSince(since int64) uint64 {
+	nanoTimestamp := since * int64(time.Second)
+	if nanoTimestamp <= gubleEpoch {
+		return 0
+	}
+	return uint64(nanoTimestamp-gubleEpoch) << timestampLeftShift
+}
@@ -0,0 +1,67 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MessagePartition_FetchSince(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_fetch_time_test")
+	defer os.RemoveAll(dir)
+	messagesPerFile = uint64(5)
+
+	// 12 messages published every 10 seconds, in three segments, with the ids generated at their publishing time
+	start := int64(1500000000)
+	p, err := newMessagePartition(dir, "orders")
+	a.NoError(err)
+	defer p.Close()
+	ids := make([]uint64, 13)
+	for i := 1; i <= 12; i++ {
+		published := start + int64(i)*10
+		ids[i] = firstIDSince(published) | uint64(i)
+		msg := &protocol.Message{ID: ids[i], Path: "/orders", Time: published, Body: []byte("order")}
+		a.NoError(p.Store(ids[i], msg.Bytes()))
+	}
+
+	for _, c := range []struct {
+		since    int64
+		expected []uint64
+	}{
+		{since: start - 100, expected: []uint64{ids[1], ids[2], ids[3]}},
+		{since: start + 10, expected: []uint64{ids[1], ids[2], ids[3]}},
+		{since: start + 55, expected: []uint64{ids[6], ids[7], ids[8]}},
+		{since: start + 101, expected: []uint64{ids[11], ids[12]}},
+		{since: start + 120, expected: []uint64{ids[12]}},
+		{since: start + 121, expected: []uint64{}},
+	} {
+		req := &store.FetchRequest{StartTime: c.since, Direction: 1, Count: 3}
+		a.Equal(c.expected, fetchIDs(a, p, req), "since %d", c.since)
+	}
+
+	// the messages are not read to find the start of the fetch
+	l, err := p.loadIndexList(0)
+	a.NoError(err)
+	corrupt(a, p.composeMsgFilenameForPosition(0), int64(l.get(2).offset))
+	a.Equal([]uint64{ids[6], ids[7], ids[8]}, fetchIDs(a, p, &store.FetchRequest{StartTime: start + 55, Direction: 1, Count: 3}))
+}
+
+func Test_firstIDSince(t *testing.T) {
+	a := assert.New(t)
+
+	// the times before the epoch of the ids start with the first id
+	a.Equal(uint64(0), firstIDSince(1000))
+
+	// the generated ids are not lower than the id of their publishing time
+	p := &messagePartition{}
+	since := firstIDSince(time.Now().Unix())
+	id, _, err := p.generateNextMsgID(1)
+	a.NoError(err)
+	a.True(id >= since)
+}
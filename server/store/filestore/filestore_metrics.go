@@ -0,0 +1,10 @@
+package filestore
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	// the bytes of the messages stored compressed, before and after compression, and their ratio
+	mCompression = metrics.NewMap("filestore.compression")
+)
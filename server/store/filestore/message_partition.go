@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,7 +20,7 @@ import (
 
 var (
 	magicNumber       = []byte{42, 249, 180, 108, 82, 75, 222, 182}
-	fileFormatVersion = []byte{1}
+	fileFormatVersion = []byte{fileFormatV2}
 	messagesPerFile   = uint64(10000)
 	indexEntrySize    = 20
 )
@@ -45,6 +46,9 @@ type messagePartition struct {
 	appendFile            *os.File
 	indexFile             *os.File
 	appendFilePosition    uint64
+	appendFileVersion     byte
+	compress              bool
+	keys                  *Keys
 	maxMessageID          uint64
 	sequenceNumber        uint64
 	totalNumberOfMessages uint64
@@ -52,6 +56,10 @@ type messagePartition struct {
 	list                  *indexList
 	fileCache             *cache
 
+	// the compaction keys read by the compaction, by file id, which are only used while holding the compactionMutex
+	compactionKeys  map[int]*segmentKeys
+	compactionMutex sync.Mutex
+
 	sync.RWMutex
 }
 
@@ -101,6 +109,10 @@ func (p *messagePartition) initialize() error {
 // Returns the start messages ids for all available message files
 // in a sorted list
 func (p *messagePartition) readIdxFiles() error {
+	if err := p.completeCompactions(); err != nil {
+		return err
+	}
+
 	allFiles, err := ioutil.ReadDir(p.basedir)
 	if err != nil {
 		return err
@@ -127,6 +139,14 @@ func (p *messagePartition) readIdxFiles() error {
 		"totalFiles": len(indexFilenames),
 	}).Info("Found files")
 
+	// the segments removed by the retention are the oldest ones, so the remaining files start at a later position
+	first, err := fileIDFromFilename(indexFilenames[0])
+	if err != nil {
+		logger.WithField("idxFilename", indexFilenames[0]).WithError(err).Error("Error parsing position of .idx file")
+		return err
+	}
+	p.fileCache.first = first
+
 	for i := 0; i < len(indexFilenames)-1; i++ {
 		cEntry, err := readCacheEntryFromIdxFile(indexFilenames[i])
 		if err != nil {
@@ -136,8 +156,12 @@ func (p *messagePartition) readIdxFiles() error {
 			}).Error("Error loading existing .idxFile")
 			return err
 		}
-		//add to total number of messages per partition
-		p.totalNumberOfMessages += messagesPerFile
+		//add to total number of messages per partition, which is less than messagesPerFile in compacted files
+		entriesInIndex, err := calculateNoEntries(indexFilenames[i])
+		if err != nil {
+			return err
+		}
+		p.totalNumberOfMessages += entriesInIndex
 
 		// put entry in file cache
 		p.fileCache.add(cEntry)
@@ -191,11 +215,15 @@ func (p *messagePartition) closeAppendFiles() error {
 }
 
 // readCacheEntryFromIdxFile  reads the first and last entry from a idx file which should be sorted
+// The idx file of a segment whose messages were all removed by the compaction is empty.
 func readCacheEntryFromIdxFile(filename string) (entry *cacheEntry, err error) {
 	entriesInIndex, err := calculateNoEntries(filename)
 	if err != nil {
 		return
 	}
+	if entriesInIndex == 0 {
+		return &cacheEntry{}, nil
+	}
 
 	file, err := os.Open(filename)
 	if err != nil {
@@ -216,8 +244,40 @@ func readCacheEntryFromIdxFile(filename string) (entry *cacheEntry, err error) {
 	return
 }
 
+// currentSegmentOutdated returns true if the current segment has messages and is not in the format of the new segments,
+// or its messages are not encrypted with the current key
+func (p *messagePartition) currentSegmentOutdated() (bool, error) {
+	last := p.list.back()
+	if last == nil {
+		return false, nil
+	}
+
+	filename := p.composeMsgFilenameForPosition(uint64(p.fileCache.nextFileID()))
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	version, err := readFileVersion(file)
+	if err != nil {
+		return false, err
+	}
+	if version != p.newFileVersion() {
+		return true, nil
+	}
+	if !encrypted(version) {
+		return false, nil
+	}
+	data, err := readStoredData(file, filename, version, last)
+	if err != nil {
+		return false, err
+	}
+	return keyID(data) != p.keys.current, nil
+}
+
 func (p *messagePartition) createNextAppendFiles() error {
-	filename := p.composeMsgFilenameForPosition(uint64(p.fileCache.length()))
+	filename := p.composeMsgFilenameForPosition(uint64(p.fileCache.nextFileID()))
 	logger.WithField("filename", filename).Info("Creating next append files")
 
 	appendfile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -225,22 +285,30 @@ func (p *messagePartition) createNextAppendFiles() error {
 		return err
 	}
 
-	// write file header on new files
-	if stat, _ := appendfile.Stat(); stat.Size() == 0 {
-		p.appendFilePosition = uint64(stat.Size())
+	// write file header on new files, and keep the file format version of existing files having messages
+	if stat, _ := appendfile.Stat(); stat.Size() <= int64(fileHeaderSize()) {
+		if err := appendfile.Truncate(0); err != nil {
+			appendfile.Close()
+			return err
+		}
+		p.appendFilePosition = 0
 
 		_, err = appendfile.Write(magicNumber)
 		if err != nil {
 			return err
 		}
 
-		_, err = appendfile.Write(fileFormatVersion)
+		p.appendFileVersion = p.newFileVersion()
+		_, err = appendfile.Write([]byte{p.appendFileVersion})
 		if err != nil {
 			return err
 		}
+	} else if p.appendFileVersion, err = readFileVersion(appendfile); err != nil {
+		appendfile.Close()
+		return err
 	}
 
-	indexfile, errIndex := os.OpenFile(p.composeIdxFilenameForPosition(uint64(p.fileCache.length())), os.O_RDWR|os.O_CREATE, 0666)
+	indexfile, errIndex := os.OpenFile(p.composeIdxFilenameForPosition(uint64(p.fileCache.nextFileID())), os.O_RDWR|os.O_CREATE, 0666)
 	if errIndex != nil {
 		defer appendfile.Close()
 		defer os.Remove(appendfile.Name())
@@ -325,7 +393,17 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 			return err
 		}
 
-		if p.entriesCount == messagesPerFile {
+		// the current segment is not continued if it was written in another format or with another key,
+		// e.g. before the encryption was enabled, so that no message is written in clear afterwards
+		outdated := false
+		if p.entriesCount < messagesPerFile {
+			var err error
+			if outdated, err = p.currentSegmentOutdated(); err != nil {
+				return err
+			}
+		}
+
+		if p.entriesCount == messagesPerFile || outdated {
 
 			logger.WithFields(log.Fields{
 				"msgId":        messageID,
@@ -333,7 +411,7 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 			}).Info("Dumping current file")
 
 			//sort the indexFile
-			err := p.rewriteSortedIdxFile(p.composeIdxFilenameForPosition(uint64(p.fileCache.length())))
+			err := p.rewriteSortedIdxFile(p.composeIdxFilenameForPosition(uint64(p.fileCache.nextFileID())))
 			if err != nil {
 				logger.WithError(err).Error("Error dumping file")
 				return err
@@ -354,23 +432,20 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 		}
 	}
 
-	// write the message size and the message id: 32 bit and 64 bit, so 12 bytes
-	sizeAndID := make([]byte, 12)
-	binary.LittleEndian.PutUint32(sizeAndID, uint32(len(data)))
-	binary.LittleEndian.PutUint64(sizeAndID[4:], messageID)
-
-	if _, err := p.appendFile.Write(sizeAndID); err != nil {
+	// write the message size, the message id, the checksum and the message at once
+	record, err := encodeRecord(p.appendFileVersion, p.keys, messageID, data)
+	if err != nil {
 		return err
 	}
-
-	// write the message
-	if _, err := p.appendFile.Write(data); err != nil {
+	if _, err := p.appendFile.Write(record); err != nil {
 		return err
 	}
 
-	// write the index entry to the index file
-	messageOffset := p.appendFilePosition + uint64(len(sizeAndID))
-	err := writeIndexEntry(p.indexFile, messageID, messageOffset, uint32(len(data)), p.entriesCount)
+	// write the index entry to the index file, with the size of the message as it is stored
+	headerSize := recordHeaderSize(p.appendFileVersion)
+	messageOffset := p.appendFilePosition + headerSize
+	messageSize := uint32(uint64(len(record)) - headerSize)
+	err = writeIndexEntry(p.indexFile, messageID, messageOffset, messageSize, p.entriesCount)
 	if err != nil {
 		return err
 	}
@@ -380,7 +455,7 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 	logger.WithFields(log.Fields{
 		"p.noOfEntriesInIndexFile": p.entriesCount,
 		"msgID":                    messageID,
-		"msgSize":                  uint32(len(data)),
+		"msgSize":                  messageSize,
 		"msgOffset":                messageOffset,
 		"filename":                 p.indexFile.Name(),
 	}).Debug("Wrote in indexFile")
@@ -389,12 +464,12 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 	e := &index{
 		id:     messageID,
 		offset: messageOffset,
-		size:   uint32(len(data)),
-		fileID: p.fileCache.length(),
+		size:   messageSize,
+		fileID: p.fileCache.nextFileID(),
 	}
 	p.list.insert(e)
 
-	p.appendFilePosition += uint64(len(sizeAndID) + len(data))
+	p.appendFilePosition += uint64(len(record))
 
 	if messageID > p.maxMessageID {
 		p.maxMessageID = messageID
@@ -408,6 +483,7 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 	le := logger.WithFields(log.Fields{
 		"partition": req.Partition,
 		"startID":   req.StartID,
+		"startTime": req.StartTime,
 		"endID":     req.EndID,
 		"Count":     req.Count,
 	})
@@ -421,6 +497,7 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 			req.ErrorC <- err
 			return
 		}
+		defer p.releaseSegments(fetchList)
 		req.StartC <- fetchList.len()
 
 		err = p.fetchByFetchlist(fetchList, req)
@@ -434,22 +511,37 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 	}()
 }
 
-// fetchByFetchlist fetches the messages in the supplied fetchlist and sends them to the message-channel
+// fetchByFetchlist fetches the messages in the supplied fetchlist and sends them to the message-channel.
+// A corrupted record stops the fetch with a ChecksumError.
 func (p *messagePartition) fetchByFetchlist(fetchList *indexList, req *store.FetchRequest) error {
+	var file *os.File
+	var version byte
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
 	return fetchList.mapWithPredicate(func(index *index, _ int) error {
 		if req.IsDone() {
 			return store.ErrRequestDone
 		}
 
 		filename := p.composeMsgFilenameForPosition(uint64(index.fileID))
-		file, err := os.Open(filename)
-		if err != nil {
-			return err
+		if file == nil || file.Name() != filename {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			if file, err = os.Open(filename); err != nil {
+				return err
+			}
+			if version, err = readFileVersion(file); err != nil {
+				return err
+			}
 		}
-		defer file.Close()
 
-		msg := make([]byte, index.size, index.size)
-		_, err = file.ReadAt(msg, int64(index.offset))
+		msg, err := readRecord(file, filename, version, p.keys, index)
 		if err != nil {
 			logger.WithFields(log.Fields{
 				"err":    err,
@@ -464,6 +556,11 @@ func (p *messagePartition) fetchByFetchlist(fetchList *indexList, req *store.Fet
 }
 
 // calculateFetchList returns a list of fetchEntry records for all messages in the fetch request.
+// The segments of the entries are retained until they are released with releaseSegments,
+// so that they are not deleted by the retention while being fetched.
+// A forward fetch starting at an id which is not stored any more, because it was removed by the retention
+// or the compaction, starts with the next stored message.
+// A fetch having a StartTime starts with the first message published at or after it.
 func (p *messagePartition) calculateFetchList(req *store.FetchRequest) (*indexList, error) {
 	if req.Direction == 0 {
 		req.Direction = 1
@@ -476,14 +573,25 @@ func (p *messagePartition) calculateFetchList(req *store.FetchRequest) (*indexLi
 	// prev specifies if we found anything in the previous list, in which case
 	// it is possible the items to continue in the next list
 	prev := false
+	// lastMax is the max id of the previous segments, to detect a forward fetch starting between two segments
+	lastMax := uint64(0)
 
 	p.fileCache.RLock()
+	defer p.fileCache.RUnlock()
+
+	if req.StartTime > 0 {
+		req.StartID = firstIDSince(req.StartTime)
+	}
 
 	for i, fce := range p.fileCache.entries {
-		if fce.Contains(req) || (prev && potentialEntries.len() < req.Count) {
+		startsInGap := startsBefore(req, fce.min) && req.StartID > lastMax
+		if fce.max > lastMax {
+			lastMax = fce.max
+		}
+		if fce.Contains(req) || startsInGap || (prev && potentialEntries.len() < req.Count) {
 			prev = true
 
-			l, err := p.loadIndexList(i)
+			l, err := p.loadIndexList(p.fileCache.first + i)
 			if err != nil {
 				logger.WithError(err).Info("Error loading idx file in memory")
 				return nil, err
@@ -496,19 +604,42 @@ func (p *messagePartition) calculateFetchList(req *store.FetchRequest) (*indexLi
 	}
 
 	// Read from current cached value (the idx file which size is smaller than MESSAGE_PER_FILE
-	if p.list.contains(req.StartID) || (prev && potentialEntries.len() < req.Count) {
+	front := p.list.front()
+	startsInGap := front != nil && startsBefore(req, front.id) && req.StartID > lastMax
+	if p.list.contains(req.StartID) || startsInGap || (prev && potentialEntries.len() < req.Count) {
 		potentialEntries.insert(p.list.extract(req).toSliceArray()...)
 	}
 
 	// Currently potentialEntries contains a potentials IDs from any files and
 	// from in memory. From this will select only Count.
 	fetchList := potentialEntries.extract(req)
-
-	p.fileCache.RUnlock()
+	p.fileCache.retain(segmentsOf(fetchList))
 
 	return fetchList, nil
 }
 
+// startsBefore returns true if the request is a forward fetch, starting before the id
+func startsBefore(req *store.FetchRequest, id uint64) bool {
+	return req.Direction >= 0 && req.StartID > 0 && req.StartID < id
+}
+
+// segmentsOf returns the file ids of the segments of the entries
+func segmentsOf(l *indexList) map[int]bool {
+	fileIDs := make(map[int]bool)
+	l.mapWithPredicate(func(elem *index, _ int) error {
+		fileIDs[elem.fileID] = true
+		return nil
+	})
+	return fileIDs
+}
+
+// releaseSegments releases the segments of a fetch list, and deletes those removed by the retention meanwhile
+func (p *messagePartition) releaseSegments(fetchList *indexList) {
+	for _, fileID := range p.fileCache.release(segmentsOf(fetchList)) {
+		p.deleteSegment(fileID)
+	}
+}
+
 func (p *messagePartition) rewriteSortedIdxFile(filename string) error {
 	logger.WithFields(log.Fields{
 		"filename": filename,
@@ -603,7 +734,7 @@ func calculateNoEntries(filename string) (uint64, error) {
 func (p *messagePartition) loadLastIndexList(filename string) error {
 	logger.WithField("filename", filename).Info("Loading last index file")
 
-	l, err := p.loadIndexList(p.fileCache.length())
+	l, err := p.loadIndexList(p.fileCache.nextFileID())
 	if err != nil {
 		logger.WithError(err).Error("Error loading last index filename")
 		return err
@@ -659,6 +790,25 @@ func (p *messagePartition) loadIndexList(fileID int) (*indexList, error) {
 	return l, nil
 }
 
+// newFileVersion returns the file format version of the message files created by the partition
+func (p *messagePartition) newFileVersion() byte {
+	switch {
+	case p.keys != nil && p.compress:
+		return fileFormatV5
+	case p.keys != nil:
+		return fileFormatV4
+	case p.compress:
+		return fileFormatV3
+	}
+	return fileFormatVersion[0]
+}
+
+// fileIDFromFilename returns the position of a segment from the name of one of its files
+func fileIDFromFilename(filename string) (int, error) {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	return strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+}
+
 func (p *messagePartition) composeMsgFilenameForPosition(value uint64) string {
 	return filepath.Join(p.basedir, fmt.Sprintf("%s-%020d.msg", p.name, value))
 }
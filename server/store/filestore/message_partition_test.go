@@ -192,6 +192,10 @@ func Test_calculateFetchList(t *testing.T) {
 	// allow five messages per file
 	messagesPerFile = uint64(5)
 
+	// the offsets below are the ones of the file format version 1, which is still supported
+	defer func() { fileFormatVersion = []byte{fileFormatV2} }()
+	fileFormatVersion = []byte{fileFormatV1}
+
 	msgData := []byte("aaaaaaaaaa") // 10 bytes message
 
 	a := assert.New(t)
@@ -18,9 +18,14 @@ import (
 // FileMessageStore is a struct used by the filesystem-based implementation of the MessageStore interface.
 // It holds the base directory, a map of messagePartitions etc.
 type FileMessageStore struct {
-	partitions map[string]*messagePartition
-	basedir    string
-	mutex      sync.RWMutex
+	partitions   map[string]*messagePartition
+	basedir      string
+	mutex        sync.RWMutex
+	retention    Retention
+	compress     bool
+	keys         *Keys
+	janitorStopC chan bool
+	janitorDoneC chan bool
 }
 
 // New returns a new FileMessageStore.
@@ -43,6 +48,8 @@ func (fms *FileMessageStore) MaxMessageID(partition string) (uint64, error) {
 // Stop the FileMessageStore.
 // Implements the service.stopable interface.
 func (fms *FileMessageStore) Stop() error {
+	fms.stopJanitor()
+
 	fms.mutex.Lock()
 	defer fms.mutex.Unlock()
 
@@ -192,11 +199,30 @@ func (fms *FileMessageStore) Partition(partition string) (store.MessagePartition
 			logger.WithField("err", err).Error("partitionStore")
 			return nil, err
 		}
+		partitionStore.compress = fms.compress
+		partitionStore.keys = fms.keys
 		fms.partitions[partition] = partitionStore
 	}
 	return partitionStore, nil
 }
 
+// SetCompression enables the compression of the messages in the message files created from now on.
+// The message files written before are still read. A current message file written in another format
+// is not continued: the partition starts a new one for the next message.
+// It has to be called before the partitions are used.
+func (fms *FileMessageStore) SetCompression(compress bool) {
+	fms.compress = compress
+}
+
+// SetEncryption enables the encryption of the messages in the message files created from now on,
+// with the current key. The keys decrypt the messages of all the encrypted message files.
+// As with the compression, a current message file written in another format or with another key is not continued:
+// the partition starts a new one for the next message.
+// It has to be called before the partitions are used.
+func (fms *FileMessageStore) SetEncryption(keys *Keys) {
+	fms.keys = keys
+}
+
 // Check returns if available storage space is still above a certain threshold.
 func (fms *FileMessageStore) Check() error {
 	var stat syscall.Statfs_t
@@ -0,0 +1,192 @@
+package filestore
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/smancke/guble/server/metrics"
+)
+
+const (
+	// fileFormatV1 message files have records of the message size, the message id and the message
+	fileFormatV1 = byte(1)
+
+	// fileFormatV2 message files have records of the message size, the message id,
+	// the CRC32 checksum of the message and the message
+	fileFormatV2 = byte(2)
+
+	// fileFormatV3 message files have the records of fileFormatV2, with the messages compressed with DEFLATE.
+	// The size and the checksum are the ones of the compressed message.
+	fileFormatV3 = byte(3)
+
+	// fileFormatV4 message files have the records of fileFormatV2, with the messages encrypted with AES-GCM,
+	// preceded by the key id and the nonce. The size and the checksum are the ones of the encrypted message.
+	fileFormatV4 = byte(4)
+
+	// fileFormatV5 message files have the records of fileFormatV4, with the messages compressed before the encryption
+	fileFormatV5 = byte(5)
+)
+
+// compressed returns true if the messages are compressed in the message files of the version
+func compressed(version byte) bool {
+	return version == fileFormatV3 || version == fileFormatV5
+}
+
+// encrypted returns true if the messages are encrypted in the message files of the version
+func encrypted(version byte) bool {
+	return version == fileFormatV4 || version == fileFormatV5
+}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// ChecksumError is returned when reading a record of a message file which is corrupted
+type ChecksumError struct {
+	Filename string
+	ID       uint64
+	Offset   uint64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("Corrupted record of message %d in %s at offset %d", e.ID, e.Filename, e.Offset)
+}
+
+// fileHeaderSize is the size of the header of a message file: the magic number and the file format version
+func fileHeaderSize() uint64 {
+	return uint64(len(magicNumber) + len(fileFormatVersion))
+}
+
+// recordHeaderSize returns the size of the data preceding a message in a message file of the version
+func recordHeaderSize(version byte) uint64 {
+	if version == fileFormatV1 {
+		return 12
+	}
+	return 16
+}
+
+// recordHeader returns the data preceding a message in a message file of the version
+func recordHeader(version byte, id uint64, data []byte) []byte {
+	header := make([]byte, recordHeaderSize(version))
+	binary.LittleEndian.PutUint32(header, uint32(len(data)))
+	binary.LittleEndian.PutUint64(header[4:], id)
+	if version != fileFormatV1 {
+		binary.LittleEndian.PutUint32(header[12:], crc32.ChecksumIEEE(data))
+	}
+	return header
+}
+
+// encodeRecord returns the record of a message in a message file of the version,
+// encrypted with the current key if the version is encrypted
+func encodeRecord(version byte, keys *Keys, id uint64, msg []byte) ([]byte, error) {
+	data := msg
+	if compressed(version) {
+		data = compressMessage(msg)
+		mCompression.Add("uncompressed_bytes", int64(len(msg)))
+		mCompression.Add("compressed_bytes", int64(len(data)))
+		metrics.SetAverage(mCompression, "ratio",
+			mCompression.Get("compressed_bytes"), mCompression.Get("uncompressed_bytes"), 1, "0")
+	}
+	if encrypted(version) {
+		if keys == nil {
+			return nil, errNoKeys
+		}
+		var err error
+		if data, err = keys.encrypt(id, data); err != nil {
+			return nil, err
+		}
+	}
+	return append(recordHeader(version, id, data), data...), nil
+}
+
+func compressMessage(msg []byte) []byte {
+	buff := &bytes.Buffer{}
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(buff)
+	w.Write(msg)
+	w.Close()
+	return buff.Bytes()
+}
+
+// readFileVersion reads the header of a message file, and returns its file format version
+func readFileVersion(file io.ReaderAt) (byte, error) {
+	header := make([]byte, fileHeaderSize())
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[:len(magicNumber)], magicNumber) {
+		return 0, fmt.Errorf("Invalid message file: wrong magic number")
+	}
+	version := header[len(magicNumber)]
+	if version < fileFormatV1 || version > fileFormatV5 {
+		return 0, fmt.Errorf("Invalid message file: unknown file format version %d", version)
+	}
+	return version, nil
+}
+
+// readRecord reads the message of an index entry from a message file of the version,
+// decrypting and decompressing it if needed. It returns a ChecksumError if the record does not match the entry
+// or the checksum of the message, and a KeyError if the message cannot be decrypted with the keys.
+func readRecord(file io.ReaderAt, filename string, version byte, keys *Keys, elem *index) ([]byte, error) {
+	data, err := readStoredData(file, filename, version, elem)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessage(filename, version, keys, elem, data)
+}
+
+// readStoredData reads the data of an index entry as it is stored in a message file of the version,
+// and returns a ChecksumError if the record does not match the entry or the checksum of the data
+func readStoredData(file io.ReaderAt, filename string, version byte, elem *index) ([]byte, error) {
+	headerSize := recordHeaderSize(version)
+	if elem.offset < fileHeaderSize()+headerSize {
+		return nil, &ChecksumError{Filename: filename, ID: elem.id, Offset: elem.offset}
+	}
+
+	record := make([]byte, headerSize+uint64(elem.size))
+	if _, err := file.ReadAt(record, int64(elem.offset-headerSize)); err != nil {
+		if err == io.EOF {
+			return nil, &ChecksumError{Filename: filename, ID: elem.id, Offset: elem.offset}
+		}
+		return nil, err
+	}
+
+	data := record[headerSize:]
+	if !bytes.Equal(record[:headerSize], recordHeader(version, elem.id, data)) {
+		return nil, &ChecksumError{Filename: filename, ID: elem.id, Offset: elem.offset}
+	}
+	if encrypted(version) && len(data) < encryptionHeaderSize {
+		return nil, &ChecksumError{Filename: filename, ID: elem.id, Offset: elem.offset}
+	}
+	return data, nil
+}
+
+// decodeMessage returns the message of the stored data of an index entry, decrypting and decompressing it if needed
+func decodeMessage(filename string, version byte, keys *Keys, elem *index, data []byte) ([]byte, error) {
+	if encrypted(version) {
+		decrypted, ok := keys.decrypt(elem.id, data)
+		if !ok {
+			return nil, &KeyError{Filename: filename, ID: elem.id, KeyID: keyID(data)}
+		}
+		data = decrypted
+	}
+	if compressed(version) {
+		msg, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, &ChecksumError{Filename: filename, ID: elem.id, Offset: elem.offset}
+		}
+		return msg, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,124 @@
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Reencrypt rewrites the segments of the partitions in the storage path having messages which are not encrypted
+// with the current key, so that the older keys can be removed from the key file afterwards.
+// The unencrypted segments are encrypted, and the compressed segments stay compressed.
+// It returns the number of rewritten segments. The message store must not be running on the storage path.
+func Reencrypt(basedir string, keys *Keys) (int, error) {
+	entries, err := ioutil.ReadDir(basedir)
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		p := &messagePartition{basedir: filepath.Join(basedir, entry.Name()), name: entry.Name()}
+		n, err := p.reencrypt(keys)
+		rewritten += n
+		if err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
+
+// reencrypt rewrites the segments of the partition which are not encrypted with the current key
+func (p *messagePartition) reencrypt(keys *Keys) (int, error) {
+	filenames, err := filepath.Glob(filepath.Join(p.basedir, p.name+"-*.msg"))
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, filename := range filenames {
+		fileID, err := fileIDFromFilename(filename)
+		if err != nil {
+			continue
+		}
+		done, err := p.reencryptSegment(fileID, keys)
+		if err != nil {
+			return rewritten, err
+		}
+		if done {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// reencryptSegment replaces the files of a segment with files holding its messages encrypted with the current key,
+// unless they are already. It returns true if the segment was rewritten.
+func (p *messagePartition) reencryptSegment(fileID int, keys *Keys) (bool, error) {
+	msgFilename := p.composeMsgFilenameForPosition(uint64(fileID))
+	idxFilename := p.composeIdxFilenameForPosition(uint64(fileID))
+
+	records, _, reason, err := scanMessageFile(msgFilename)
+	if err != nil {
+		return false, err
+	}
+	if reason != "" {
+		return false, fmt.Errorf("Damaged message file %s: %s", msgFilename, reason)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].id < records[j].id })
+
+	version, current, err := segmentEncryption(msgFilename, records, keys)
+	if err != nil || current {
+		return false, err
+	}
+
+	newVersion := fileFormatV4
+	if compressed(version) {
+		newVersion = fileFormatV5
+	}
+	if err := writeCompactedSegment(msgFilename, idxFilename, newVersion, keys, records); err != nil {
+		os.Remove(msgFilename + compactionSuffix)
+		os.Remove(idxFilename + compactionSuffix)
+		return false, err
+	}
+
+	// the message file is replaced first, so that an interrupted replacement is completed when loading the partition
+	if err := os.Rename(msgFilename+compactionSuffix, msgFilename); err != nil {
+		return false, err
+	}
+	if err := os.Rename(idxFilename+compactionSuffix, idxFilename); err != nil {
+		return false, err
+	}
+	logger.WithField("filename", msgFilename).Info("Re-encrypted segment")
+	return true, nil
+}
+
+// segmentEncryption returns the file format version of a message file,
+// and true if all its records are encrypted with the current key
+func segmentEncryption(filename string, records []*index, keys *Keys) (byte, bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	version, err := readFileVersion(file)
+	if err != nil || !encrypted(version) {
+		return version, false, err
+	}
+	for _, elem := range records {
+		data, err := readStoredData(file, filename, version, elem)
+		if err != nil {
+			return version, false, err
+		}
+		if keyID(data) != keys.current {
+			return version, false, nil
+		}
+	}
+	return version, true, nil
+}
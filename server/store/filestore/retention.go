@@ -0,0 +1,221 @@
+package filestore
+
+import (
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// janitorInterval is the interval at which the retention policies are applied
+var janitorInterval = time.Minute
+
+// RetentionPolicy limits the messages kept in a partition.
+// The oldest segments are removed, while one of the limits is exceeded.
+// A zero value of a limit means unlimited.
+// If Compact is set, only the latest message of each compaction key is kept.
+type RetentionPolicy struct {
+	MaxAge      time.Duration
+	MaxMessages uint64
+	MaxBytes    int64
+	Compact     bool
+}
+
+// IsZero returns true if the policy has no limits
+func (policy RetentionPolicy) IsZero() bool {
+	return policy == RetentionPolicy{}
+}
+
+// Override returns a copy of the policy, with the limits set in the other policy replacing its own
+func (policy RetentionPolicy) Override(other RetentionPolicy) RetentionPolicy {
+	if other.MaxAge != 0 {
+		policy.MaxAge = other.MaxAge
+	}
+	if other.MaxMessages != 0 {
+		policy.MaxMessages = other.MaxMessages
+	}
+	if other.MaxBytes != 0 {
+		policy.MaxBytes = other.MaxBytes
+	}
+	if other.Compact {
+		policy.Compact = true
+	}
+	return policy
+}
+
+func (policy RetentionPolicy) exceeded(modified time.Time, messages uint64, bytes int64, now time.Time) bool {
+	return (policy.MaxAge > 0 && now.Sub(modified) > policy.MaxAge) ||
+		(policy.MaxMessages > 0 && messages > policy.MaxMessages) ||
+		(policy.MaxBytes > 0 && bytes > policy.MaxBytes)
+}
+
+// Retention holds the default retention policy, and optionally a function returning
+// the policy of a partition, whose limits override the default ones.
+type Retention struct {
+	Default   RetentionPolicy
+	Partition func(partition string) RetentionPolicy
+}
+
+func (r Retention) policy(partition string) RetentionPolicy {
+	if r.Partition == nil {
+		return r.Default
+	}
+	return r.Default.Override(r.Partition(partition))
+}
+
+// SetRetention sets the retention applied by the janitor of the FileMessageStore.
+// It has to be called before Start.
+func (fms *FileMessageStore) SetRetention(retention Retention) {
+	fms.retention = retention
+}
+
+// Start the janitor applying the retention, if a retention was set.
+// Implements the service.startable interface.
+func (fms *FileMessageStore) Start() error {
+	if fms.retention.Default.IsZero() && fms.retention.Partition == nil {
+		return nil
+	}
+	logger.WithField("interval", janitorInterval).Info("Starting retention janitor")
+	fms.janitorStopC = make(chan bool)
+	fms.janitorDoneC = make(chan bool)
+	go fms.janitor()
+	return nil
+}
+
+func (fms *FileMessageStore) stopJanitor() {
+	if fms.janitorStopC == nil {
+		return
+	}
+	close(fms.janitorStopC)
+	<-fms.janitorDoneC
+	fms.janitorStopC = nil
+}
+
+func (fms *FileMessageStore) janitor() {
+	defer close(fms.janitorDoneC)
+
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fms.applyRetention(time.Now())
+		case <-fms.janitorStopC:
+			return
+		}
+	}
+}
+
+// applyRetention applies the retention policy of each partition, compacting it first if the policy says so
+func (fms *FileMessageStore) applyRetention(now time.Time) {
+	partitions, err := fms.Partitions()
+	if err != nil {
+		return
+	}
+	for _, partition := range partitions {
+		policy := fms.retention.policy(partition.Name())
+		if policy.IsZero() {
+			continue
+		}
+		p := partition.(*messagePartition)
+		if policy.Compact {
+			compacted, err := p.compact()
+			if err != nil {
+				logger.WithError(err).WithField("partition", partition.Name()).Error("Error compacting partition")
+			} else if compacted > 0 {
+				logger.WithFields(log.Fields{
+					"partition": partition.Name(),
+					"messages":  compacted,
+				}).Info("Removed messages by compaction")
+			}
+		}
+		removed, err := p.applyRetention(policy, now)
+		if err != nil {
+			logger.WithError(err).WithField("partition", partition.Name()).Error("Error applying retention")
+			continue
+		}
+		if removed > 0 {
+			logger.WithFields(log.Fields{
+				"partition": partition.Name(),
+				"segments":  removed,
+			}).Info("Removed segments by retention")
+		}
+	}
+}
+
+type segmentInfo struct {
+	modified time.Time
+	messages uint64
+	bytes    int64
+}
+
+// applyRetention removes the oldest closed segments of the partition, while one of the limits of the policy is exceeded.
+// The current segment is never removed, but its messages and bytes count towards the limits.
+// It returns the number of removed segments.
+func (p *messagePartition) applyRetention(policy RetentionPolicy, now time.Time) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.fileCache.RLock()
+	first, closed := p.fileCache.first, len(p.fileCache.entries)
+	p.fileCache.RUnlock()
+	if closed == 0 {
+		return 0, nil
+	}
+
+	var bytes int64
+	segments := make([]segmentInfo, 0, closed+1)
+	for fileID := first; fileID <= first+closed; fileID++ {
+		s, err := p.statSegment(fileID)
+		if err != nil {
+			return 0, err
+		}
+		bytes += s.bytes
+		segments = append(segments, s)
+	}
+
+	messages := p.totalNumberOfMessages
+	n := 0
+	for ; n < closed && policy.exceeded(segments[n].modified, messages, bytes, now); n++ {
+		messages -= segments[n].messages
+		bytes -= segments[n].bytes
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	for _, fileID := range p.fileCache.removeFirst(n) {
+		p.deleteSegment(fileID)
+	}
+	p.totalNumberOfMessages = messages
+	// the maxMessageID is kept, since the ids of new messages have to be greater than the ones of the removed messages
+	return n, nil
+}
+
+func (p *messagePartition) statSegment(fileID int) (segmentInfo, error) {
+	msgInfo, err := os.Stat(p.composeMsgFilenameForPosition(uint64(fileID)))
+	if err != nil {
+		return segmentInfo{}, err
+	}
+	idxInfo, err := os.Stat(p.composeIdxFilenameForPosition(uint64(fileID)))
+	if err != nil {
+		return segmentInfo{}, err
+	}
+	return segmentInfo{
+		modified: msgInfo.ModTime(),
+		messages: uint64(idxInfo.Size() / int64(indexEntrySize)),
+		bytes:    msgInfo.Size() + idxInfo.Size(),
+	}, nil
+}
+
+// deleteSegment deletes the files of a segment, the index file first
+func (p *messagePartition) deleteSegment(fileID int) {
+	for _, filename := range []string{
+		p.composeIdxFilenameForPosition(uint64(fileID)),
+		p.composeMsgFilenameForPosition(uint64(fileID)),
+	} {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			logger.WithError(err).WithField("filename", filename).Error("Error deleting segment file")
+		}
+	}
+}
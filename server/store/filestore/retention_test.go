@@ -0,0 +1,174 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// aRetentionPartition returns a partition of 13 messages with five messages per file,
+// so with two closed segments and the current one
+func aRetentionPartition(a *assert.Assertions, dir string) *messagePartition {
+	messagesPerFile = uint64(5)
+
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	for _, id := range []uint64{3, 4, 10, 9, 5, 8, 15, 13, 22, 23, 24, 26, 30} {
+		a.NoError(p.Store(id, []byte("aaaaaaaaaa")))
+	}
+	return p
+}
+
+func fetchIDs(a *assert.Assertions, p *messagePartition, req *store.FetchRequest) []uint64 {
+	req.Partition = p.Name()
+	req.Init()
+	p.Fetch(req)
+	req.Ready()
+
+	ids := []uint64{}
+	for {
+		select {
+		case msg, open := <-req.Messages():
+			if !open {
+				return ids
+			}
+			ids = append(ids, msg.ID)
+		case err := <-req.Errors():
+			a.Fail(err.Error())
+			return ids
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+			return ids
+		}
+	}
+}
+
+func segmentExists(p *messagePartition, fileID int) bool {
+	_, errIdx := os.Stat(p.composeIdxFilenameForPosition(uint64(fileID)))
+	_, errMsg := os.Stat(p.composeMsgFilenameForPosition(uint64(fileID)))
+	return errIdx == nil && errMsg == nil
+}
+
+func Test_MessagePartition_applyRetentionByMessages(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_retention_test")
+	defer os.RemoveAll(dir)
+	p := aRetentionPartition(a, dir)
+	defer p.Close()
+
+	removed, err := p.applyRetention(RetentionPolicy{MaxMessages: 8}, time.Now())
+	a.NoError(err)
+	a.Equal(1, removed)
+	a.Equal(uint64(8), p.Count())
+	a.Equal(uint64(30), p.MaxMessageID())
+	a.False(segmentExists(p, 0))
+	a.True(segmentExists(p, 1))
+
+	// a fetch starting at a removed message starts with the oldest remaining one
+	a.Equal([]uint64{8, 13}, fetchIDs(a, p, &store.FetchRequest{StartID: 3, Direction: 1, Count: 2}))
+	a.Equal([]uint64{26, 30}, fetchIDs(a, p, &store.FetchRequest{StartID: 30, Direction: -1, Count: 2}))
+
+	// the remaining segments are loaded after a restart
+	a.NoError(p.Close())
+	p, err = newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	a.Equal(uint64(8), p.Count())
+	a.Equal(uint64(30), p.MaxMessageID())
+	a.Equal([]uint64{8, 13, 15}, fetchIDs(a, p, &store.FetchRequest{StartID: 1, Direction: 1, Count: 3}))
+
+	a.NoError(p.Store(31, []byte("aaaaaaaaaa")))
+	a.Equal(uint64(31), p.MaxMessageID())
+	a.Equal([]uint64{30, 31}, fetchIDs(a, p, &store.FetchRequest{StartID: 30, Direction: 1, Count: 5}))
+}
+
+func Test_MessagePartition_applyRetentionByAgeAndBytes(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_retention_test")
+	defer os.RemoveAll(dir)
+	p := aRetentionPartition(a, dir)
+	defer p.Close()
+
+	removed, err := p.applyRetention(RetentionPolicy{MaxAge: time.Hour}, time.Now())
+	a.NoError(err)
+	a.Equal(0, removed)
+
+	segment, err := p.statSegment(0)
+	a.NoError(err)
+	removed, err = p.applyRetention(RetentionPolicy{MaxBytes: 2 * segment.bytes}, time.Now())
+	a.NoError(err)
+	a.Equal(1, removed)
+
+	// the current segment is never removed
+	removed, err = p.applyRetention(RetentionPolicy{MaxAge: time.Minute}, time.Now().Add(time.Hour))
+	a.NoError(err)
+	a.Equal(1, removed)
+	a.Equal(uint64(3), p.Count())
+	a.True(segmentExists(p, 2))
+	a.Equal([]uint64{24, 26, 30}, fetchIDs(a, p, &store.FetchRequest{StartID: 0, Count: 10}))
+}
+
+func Test_MessagePartition_applyRetentionWhileFetching(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_retention_test")
+	defer os.RemoveAll(dir)
+	p := aRetentionPartition(a, dir)
+	defer p.Close()
+
+	fetchList, err := p.calculateFetchList(&store.FetchRequest{StartID: 3, Direction: 1, Count: 3})
+	a.NoError(err)
+
+	removed, err := p.applyRetention(RetentionPolicy{MaxMessages: 3}, time.Now())
+	a.NoError(err)
+	a.Equal(2, removed)
+	a.True(segmentExists(p, 0))
+	a.False(segmentExists(p, 1))
+
+	p.releaseSegments(fetchList)
+	a.False(segmentExists(p, 0))
+	a.Equal([]uint64{24}, fetchIDs(a, p, &store.FetchRequest{StartID: 3, Direction: 1, Count: 1}))
+}
+
+func Test_FileMessageStore_RetentionJanitor(t *testing.T) {
+	a := assert.New(t)
+	defer func(interval time.Duration) { janitorInterval = interval }(janitorInterval)
+	janitorInterval = 10 * time.Millisecond
+
+	dir, _ := ioutil.TempDir("", "guble_retention_test")
+	defer os.RemoveAll(dir)
+	a.NoError(os.Mkdir(dir+"/myMessages", 0700))
+	aRetentionPartition(a, dir+"/myMessages").Close()
+
+	fms := New(dir)
+	fms.SetRetention(Retention{
+		Default: RetentionPolicy{MaxMessages: 100},
+		Partition: func(partition string) RetentionPolicy {
+			if partition == "myMessages" {
+				return RetentionPolicy{MaxMessages: 3}
+			}
+			return RetentionPolicy{}
+		},
+	})
+	a.NoError(fms.Start())
+	time.Sleep(50 * time.Millisecond)
+	a.NoError(fms.Stop())
+
+	_, err := os.Stat(dir + "/myMessages/myMessages-00000000000000000001.idx")
+	a.True(os.IsNotExist(err))
+	_, err = os.Stat(dir + "/myMessages/myMessages-00000000000000000002.idx")
+	a.NoError(err)
+}
+
+func TestRetentionPolicy_Override(t *testing.T) {
+	a := assert.New(t)
+
+	policy := RetentionPolicy{MaxAge: time.Hour, MaxMessages: 10}
+	a.Equal(policy, policy.Override(RetentionPolicy{}))
+	a.Equal(RetentionPolicy{MaxAge: time.Hour, MaxMessages: 5, MaxBytes: 100},
+		policy.Override(RetentionPolicy{MaxMessages: 5, MaxBytes: 100}))
+	a.True(RetentionPolicy{}.IsZero())
+	a.False(policy.IsZero())
+}
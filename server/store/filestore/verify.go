@@ -0,0 +1,254 @@
+package filestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Damage describes a damaged segment of a partition, found by Verify
+type Damage struct {
+	Partition string
+	Filename  string
+	Reason    string
+	Repaired  bool
+}
+
+func (d Damage) String() string {
+	state := "not repaired"
+	if d.Repaired {
+		state = "repaired"
+	}
+	return fmt.Sprintf("%s: %s (%s)", d.Filename, d.Reason, state)
+}
+
+// Verify scans the partitions in the storage path, and returns the damage found in their segments:
+// corrupted or truncated records in the message files, and index files not matching the message files.
+// If repair is set, the message files are truncated before their first invalid record,
+// and the index files are rebuilt from the message files.
+// The message store must not be running on the storage path.
+func Verify(basedir string, repair bool) ([]Damage, error) {
+	entries, err := ioutil.ReadDir(basedir)
+	if err != nil {
+		return nil, err
+	}
+
+	var damages []Damage
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		partitionDamages, err := verifyPartition(filepath.Join(basedir, entry.Name()), entry.Name(), repair)
+		if err != nil {
+			return damages, err
+		}
+		damages = append(damages, partitionDamages...)
+	}
+	return damages, nil
+}
+
+func verifyPartition(dir string, name string, repair bool) ([]Damage, error) {
+	p := &messagePartition{basedir: dir, name: name}
+
+	filenames, err := filepath.Glob(filepath.Join(dir, name+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[int]bool)
+	for _, filename := range filenames {
+		if !strings.HasSuffix(filename, ".msg") && !strings.HasSuffix(filename, ".idx") {
+			continue
+		}
+		if fileID, err := fileIDFromFilename(filename); err == nil {
+			positions[fileID] = true
+		}
+	}
+	fileIDs := make([]int, 0, len(positions))
+	for fileID := range positions {
+		fileIDs = append(fileIDs, fileID)
+	}
+	sort.Ints(fileIDs)
+
+	var damages []Damage
+	for _, fileID := range fileIDs {
+		damage, err := p.verifySegment(fileID, repair)
+		if err != nil {
+			return damages, err
+		}
+		if damage != nil {
+			damage.Partition = name
+			damages = append(damages, *damage)
+		}
+	}
+	return damages, nil
+}
+
+// verifySegment returns the damage of a segment, or nil if it is not damaged
+func (p *messagePartition) verifySegment(fileID int, repair bool) (*Damage, error) {
+	msgFilename := p.composeMsgFilenameForPosition(uint64(fileID))
+	idxFilename := p.composeIdxFilenameForPosition(uint64(fileID))
+
+	if _, err := os.Stat(msgFilename); os.IsNotExist(err) {
+		damage := &Damage{Filename: msgFilename, Reason: "the message file is missing"}
+		if repair {
+			// the segment is replaced by an empty one, since the positions of the segments have to be consecutive
+			if err := ioutil.WriteFile(msgFilename, append(append([]byte{}, magicNumber...), fileFormatVersion...), 0666); err != nil {
+				return nil, err
+			}
+			damage.Repaired = true
+			return damage, writeIndexFile(idxFilename, nil)
+		}
+		return damage, nil
+	}
+
+	records, validSize, reason, err := scanMessageFile(msgFilename)
+	if err != nil {
+		return nil, err
+	}
+	if validSize == 0 {
+		return &Damage{Filename: msgFilename, Reason: reason}, nil
+	}
+
+	damage := &Damage{Filename: msgFilename, Reason: reason}
+	if reason == "" {
+		matches, err := indexMatches(idxFilename, records)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			return nil, nil
+		}
+		damage = &Damage{Filename: idxFilename, Reason: "the index file does not match the message file"}
+	}
+
+	if repair {
+		if reason != "" {
+			if err := os.Truncate(msgFilename, validSize); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeIndexFile(idxFilename, records); err != nil {
+			return nil, err
+		}
+		damage.Repaired = true
+	}
+	return damage, nil
+}
+
+// scanMessageFile reads the records of a message file until the first invalid one.
+// It returns the valid records, the size of the file up to the first invalid record, and the reason why it is invalid.
+// A validSize of 0 means that the header of the file is invalid.
+func scanMessageFile(filename string) (records []*index, validSize int64, reason string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	version, errVersion := readFileVersion(file)
+	if errVersion != nil {
+		return nil, 0, errVersion.Error(), nil
+	}
+
+	headerSize := recordHeaderSize(version)
+	offset := fileHeaderSize()
+	for int64(offset) < stat.Size() {
+		header := make([]byte, headerSize)
+		if _, err := file.ReadAt(header, int64(offset)); err != nil {
+			if err == io.EOF {
+				return records, int64(offset), fmt.Sprintf("truncated record at offset %d", offset), nil
+			}
+			return nil, 0, "", err
+		}
+
+		elem := &index{
+			id:     binary.LittleEndian.Uint64(header[4:]),
+			offset: offset + headerSize,
+			size:   binary.LittleEndian.Uint32(header),
+		}
+		if int64(elem.offset)+int64(elem.size) > stat.Size() {
+			return records, int64(offset), fmt.Sprintf("truncated record at offset %d", offset), nil
+		}
+		// the encrypted messages are verified by their checksums only, as the keys are not needed
+		data, err := readStoredData(file, filename, version, elem)
+		if err == nil && !encrypted(version) {
+			_, err = decodeMessage(filename, version, nil, elem, data)
+		}
+		if err != nil {
+			if _, ok := err.(*ChecksumError); ok {
+				return records, int64(offset), err.Error(), nil
+			}
+			return nil, 0, "", err
+		}
+
+		records = append(records, elem)
+		offset = elem.offset + uint64(elem.size)
+	}
+	return records, int64(offset), "", nil
+}
+
+// indexMatches returns true if the index file holds exactly the entries of the records, in any order
+func indexMatches(filename string, records []*index) (bool, error) {
+	stat, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if stat.Size() != int64(len(records)*indexEntrySize) {
+		return false, nil
+	}
+
+	expected := make(map[uint64]*index, len(records))
+	for _, elem := range records {
+		expected[elem.id] = elem
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	for i := range records {
+		id, offset, size, err := readIndexEntry(file, int64(i*indexEntrySize))
+		if err != nil {
+			return false, err
+		}
+		elem, ok := expected[id]
+		if !ok || elem.offset != offset || elem.size != size {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// writeIndexFile replaces the index file with the entries of the records, sorted by id
+func writeIndexFile(filename string, records []*index) error {
+	sorted := append([]*index{}, records...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].id < sorted[j].id })
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for i, elem := range sorted {
+		if err := writeIndexEntry(file, elem.id, elem.offset, elem.size, uint64(i)); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
@@ -0,0 +1,134 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// aVerifiedPartition returns the directory of a closed partition of 7 messages with five messages per file,
+// so with a closed segment and the current one
+func aVerifiedPartition(a *assert.Assertions, basedir string) string {
+	messagesPerFile = uint64(5)
+
+	dir := path.Join(basedir, "myMessages")
+	a.NoError(os.Mkdir(dir, 0700))
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	for id := uint64(1); id <= 7; id++ {
+		a.NoError(p.Store(id, []byte("aaaaaaaaaa")))
+	}
+	a.NoError(p.Close())
+	return dir
+}
+
+// corrupt overwrites a byte of the file
+func corrupt(a *assert.Assertions, filename string, offset int64) {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	a.NoError(err)
+	_, err = file.WriteAt([]byte{'X'}, offset)
+	a.NoError(err)
+	a.NoError(file.Close())
+}
+
+func Test_Verify(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_verify_test")
+	defer os.RemoveAll(basedir)
+	dir := aVerifiedPartition(a, basedir)
+	p := &messagePartition{basedir: dir, name: "myMessages"}
+
+	damages, err := Verify(basedir, false)
+	a.NoError(err)
+	a.Empty(damages)
+
+	// the message of the third record is corrupted: 9 bytes file header, 26 bytes per record, 16 bytes record header
+	corrupt(a, p.composeMsgFilenameForPosition(0), 9+2*26+16)
+	// a torn write left a partial record at the end of the current segment
+	file, err := os.OpenFile(p.composeMsgFilenameForPosition(1), os.O_WRONLY|os.O_APPEND, 0666)
+	a.NoError(err)
+	_, err = file.Write([]byte{10, 0, 0})
+	a.NoError(err)
+	a.NoError(file.Close())
+
+	damages, err = Verify(basedir, false)
+	a.NoError(err)
+	if a.Equal(2, len(damages)) {
+		a.Equal("myMessages", damages[0].Partition)
+		a.Equal(p.composeMsgFilenameForPosition(0), damages[0].Filename)
+		a.Contains(damages[0].Reason, "message 3")
+		a.False(damages[0].Repaired)
+		a.Contains(damages[1].Reason, "truncated record")
+	}
+
+	damages, err = Verify(basedir, true)
+	a.NoError(err)
+	a.Equal(2, len(damages))
+	for _, damage := range damages {
+		a.True(damage.Repaired)
+	}
+
+	damages, err = Verify(basedir, false)
+	a.NoError(err)
+	a.Empty(damages)
+
+	p, err = newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	defer p.Close()
+	a.Equal([]uint64{1, 2, 6, 7}, fetchIDs(a, p, &store.FetchRequest{StartID: 0, Count: 10}))
+}
+
+func Test_Verify_RebuildsIndex(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_verify_test")
+	defer os.RemoveAll(basedir)
+	dir := aVerifiedPartition(a, basedir)
+	p := &messagePartition{basedir: dir, name: "myMessages"}
+
+	// the last index entry of the current segment was not written
+	a.NoError(os.Truncate(p.composeIdxFilenameForPosition(1), int64(indexEntrySize)))
+
+	damages, err := Verify(basedir, true)
+	a.NoError(err)
+	if a.Equal(1, len(damages)) {
+		a.Equal(p.composeIdxFilenameForPosition(1), damages[0].Filename)
+		a.True(damages[0].Repaired)
+	}
+
+	p, err = newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	defer p.Close()
+	a.Equal([]uint64{6, 7}, fetchIDs(a, p, &store.FetchRequest{StartID: 6, Count: 10}))
+}
+
+func Test_Partition_FetchCorruptedRecord(t *testing.T) {
+	a := assert.New(t)
+	basedir, _ := ioutil.TempDir("", "guble_verify_test")
+	defer os.RemoveAll(basedir)
+	dir := aVerifiedPartition(a, basedir)
+
+	p, err := newMessagePartition(dir, "myMessages")
+	a.NoError(err)
+	defer p.Close()
+	corrupt(a, p.composeMsgFilenameForPosition(0), 9+16)
+
+	req := &store.FetchRequest{Partition: "myMessages", StartID: 1, Direction: 1, Count: 2}
+	req.Init()
+	p.Fetch(req)
+	req.Ready()
+
+	select {
+	case err := <-req.Errors():
+		a.IsType(&ChecksumError{}, err)
+		a.Contains(err.Error(), "message 1")
+	case <-req.Messages():
+		a.Fail("corrupted message fetched")
+	case <-time.After(time.Second):
+		a.Fail("timeout")
+	}
+}
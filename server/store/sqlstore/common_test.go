@@ -0,0 +1,199 @@
+package sqlstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+// fetch returns the ids of the fetched messages
+func fetch(a *assert.Assertions, ms store.MessageStore, req *store.FetchRequest) []uint64 {
+	req.Init()
+	ms.Fetch(req)
+
+	ids := []uint64{}
+	select {
+	case count := <-req.StartC:
+		defer func() { a.Equal(count, len(ids), "count of the messages started") }()
+	case err := <-req.Errors():
+		a.Fail(err.Error())
+		return ids
+	case <-time.After(time.Second):
+		a.Fail("timeout")
+		return ids
+	}
+	for {
+		select {
+		case msg, open := <-req.Messages():
+			if !open {
+				return ids
+			}
+			ids = append(ids, msg.ID)
+		case err := <-req.Errors():
+			a.Fail(err.Error())
+			return ids
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+			return ids
+		}
+	}
+}
+
+// CommonTestFetch stores 10 messages in a partition, published every 10 seconds, and 2 in another one
+func CommonTestFetch(t *testing.T, ms store.MessageStore) {
+	a := assert.New(t)
+
+	for id := uint64(1); id <= 10; id++ {
+		msg := &protocol.Message{ID: id, Path: "/p1", Time: 1000 + int64(id)*10, Body: []byte(fmt.Sprintf("m%d", id))}
+		a.NoError(ms.Store("p1", id, msg.Bytes()))
+	}
+	a.NoError(ms.Store("p2", 1, []byte("raw message")))
+	a.NoError(ms.Store("p2", 2, []byte("raw message")))
+
+	for _, c := range []struct {
+		desc      string
+		startID   uint64
+		endID     uint64
+		startTime int64
+		direction store.FetchDirection
+		count     int
+		expected  []uint64
+	}{
+		{"forward", 3, 0, 0, store.DirectionForward, 3, []uint64{3, 4, 5}},
+		{"forward to the end", 8, 0, 0, store.DirectionForward, 5, []uint64{8, 9, 10}},
+		{"forward to the end id", 3, 4, 0, store.DirectionForward, 5, []uint64{3, 4}},
+		{"forward after the end", 11, 0, 0, store.DirectionForward, 5, []uint64{}},
+		{"without direction", 5, 0, 0, store.DirectionOneMessage, 2, []uint64{5, 6}},
+		{"backward", 6, 0, 0, store.DirectionBackwards, 3, []uint64{4, 5, 6}},
+		{"backward to the start", 2, 0, 0, store.DirectionBackwards, 5, []uint64{1, 2}},
+		{"backward to the end id", 6, 5, 0, store.DirectionBackwards, 5, []uint64{5, 6}},
+		{"since a time", 0, 0, 1055, store.DirectionForward, 2, []uint64{6, 7}},
+		{"since a later time", 0, 0, 2000, store.DirectionForward, 2, []uint64{}},
+	} {
+		req := &store.FetchRequest{
+			Partition: "p1",
+			StartID:   c.startID,
+			EndID:     c.endID,
+			StartTime: c.startTime,
+			Direction: c.direction,
+			Count:     c.count,
+		}
+		a.Equal(c.expected, fetch(a, ms, req), c.desc)
+	}
+
+	p1, err := ms.Partition("p1")
+	a.NoError(err)
+	a.Equal(uint64(10), p1.Count())
+	a.Equal(uint64(10), p1.MaxMessageID())
+
+	maxID, err := ms.MaxMessageID("p2")
+	a.NoError(err)
+	a.Equal(uint64(2), maxID)
+
+	partitions, err := ms.Partitions()
+	a.NoError(err)
+	if a.Equal(2, len(partitions)) {
+		a.Equal("p1", partitions[0].Name())
+		a.Equal("p2", partitions[1].Name())
+	}
+}
+
+// CommonTestStoreMessage stores messages generating their ids, as a standalone server and as cluster nodes
+func CommonTestStoreMessage(t *testing.T, ms store.MessageStore) {
+	a := assert.New(t)
+
+	msg := &protocol.Message{Path: "/orders/42", Body: []byte("order")}
+	size, err := ms.StoreMessage(msg, 0)
+	a.NoError(err)
+	a.Equal(len(msg.Bytes()), size)
+	a.Equal(uint64(1), msg.ID)
+	a.True(msg.Time > 0)
+
+	err = ms.DoInTx("orders", func(maxMessageID uint64) error {
+		a.Equal(uint64(1), maxMessageID)
+		return nil
+	})
+	a.NoError(err)
+
+	// the ids generated by the nodes of a cluster do not collide
+	msg1 := &protocol.Message{Path: "/orders", Body: []byte("order")}
+	_, err = ms.StoreMessage(msg1, 1)
+	a.NoError(err)
+	id2, _, err := ms.GenerateNextMsgID("orders", 2)
+	a.NoError(err)
+	a.Equal(uint64(1<<nodeIDBits|1), msg1.ID)
+	a.Equal(uint64(2<<nodeIDBits|2), id2)
+
+	// a message received from another node keeps its id
+	msg3 := &protocol.Message{ID: 3<<nodeIDBits | 3, NodeID: 3, Path: "/orders", Body: []byte("order")}
+	_, err = ms.StoreMessage(msg3, 1)
+	a.NoError(err)
+	a.Equal(uint64(3<<nodeIDBits|3), msg3.ID)
+
+	maxID, err := ms.MaxMessageID("orders")
+	a.NoError(err)
+	a.Equal(msg3.ID, maxID)
+}
+
+// CommonTestEmptyPartition reads a partition before any message is stored in it
+func CommonTestEmptyPartition(t *testing.T, ms store.MessageStore) {
+	a := assert.New(t)
+
+	a.Equal([]uint64{}, fetch(a, ms, &store.FetchRequest{Partition: "empty", Direction: store.DirectionForward, Count: 5}))
+	maxID, err := ms.MaxMessageID("empty")
+	a.NoError(err)
+	a.Equal(uint64(0), maxID)
+
+	p, err := ms.Partition("empty")
+	a.NoError(err)
+	a.Equal(uint64(0), p.Count())
+
+	// the partition is added to the catalog only when it is written
+	partitions, err := ms.Partitions()
+	a.NoError(err)
+	a.Equal(0, len(partitions))
+
+	a.NoError(ms.DoInTx("empty", func(maxMessageID uint64) error {
+		a.Equal(uint64(0), maxMessageID)
+		return nil
+	}))
+	partitions, err = ms.Partitions()
+	a.NoError(err)
+	a.Equal(1, len(partitions))
+}
+
+// CommonTestSharedDatabase uses the same database with two stores, as the nodes of a cluster
+func CommonTestSharedDatabase(t *testing.T, ms store.MessageStore, other store.MessageStore) {
+	a := assert.New(t)
+
+	// the partition is read by a store before the other one creates it
+	maxID, err := ms.MaxMessageID("orders")
+	a.NoError(err)
+	a.Equal(uint64(0), maxID)
+	a.Equal([]uint64{}, fetch(a, ms, &store.FetchRequest{Partition: "orders", Direction: store.DirectionForward, Count: 5}))
+
+	msg := &protocol.Message{Path: "/orders", Body: []byte("order")}
+	_, err = other.StoreMessage(msg, 2)
+	a.NoError(err)
+	a.Equal(uint64(1<<nodeIDBits|2), msg.ID)
+
+	maxID, err = ms.MaxMessageID("orders")
+	a.NoError(err)
+	a.Equal(msg.ID, maxID)
+	a.Equal([]uint64{msg.ID}, fetch(a, ms, &store.FetchRequest{Partition: "orders", Direction: store.DirectionForward, Count: 5}))
+	id, _, err := ms.GenerateNextMsgID("orders", 1)
+	a.NoError(err)
+	a.Equal(uint64(2<<nodeIDBits|1), id)
+
+	// the message replicated from the other node is stored already
+	_, err = ms.StoreMessage(msg, 1)
+	a.NoError(err)
+	p, err := ms.Partition("orders")
+	a.NoError(err)
+	a.Equal(uint64(1), p.Count())
+}
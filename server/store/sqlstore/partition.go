@@ -0,0 +1,326 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	// nodeIDBits is the number of the lowest bits of the message ids holding the id of the cluster node
+	nodeIDBits = 8
+
+	// fetchPageSize is the number of messages read from the database at once by a fetch
+	fetchPageSize = 100
+)
+
+// sqlPartition is a partition of the sqlMessageStore, whose messages are stored in its own table.
+type sqlPartition struct {
+	sync.Mutex
+	db             *gorm.DB
+	name           string
+	lockQuery      string
+	conflictClause string
+	logger         *log.Entry
+
+	// entry is the partition in the catalog, which is created with the table when the partition is first written.
+	// Until it is found, it is looked up again, as it can be created by another server using the database.
+	entry partitionEntry
+	// generatedID is the last id generated for a message which may not be stored yet
+	generatedID uint64
+}
+
+// nextID returns the message id following the maxID.
+// In cluster mode, the ids are sequences over all the nodes, with the id of the node in their lowest bits,
+// so that the ids generated at the same time by different nodes for the same partition do not collide.
+func nextID(maxID uint64, nodeID uint8) uint64 {
+	if nodeID == 0 {
+		return maxID + 1
+	}
+	return ((maxID>>nodeIDBits)+1)<<nodeIDBits | uint64(nodeID)
+}
+
+// messageTime returns the publishing time of a stored message, or 0 if it is not a guble message
+func messageTime(data []byte) int64 {
+	msg, err := protocol.ParseMessage(data)
+	if err != nil {
+		return 0
+	}
+	return msg.Time
+}
+
+// Name returns the name of the partition
+func (p *sqlPartition) Name() string {
+	return p.name
+}
+
+// MaxMessageID returns the highest message id stored in the partition
+func (p *sqlPartition) MaxMessageID() uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	maxID, err := p.readMaxMessageID(p.db)
+	if err != nil {
+		p.logger.WithError(err).Error("Error reading the max message id")
+	}
+	return maxID
+}
+
+// Count returns the number of messages stored in the partition
+func (p *sqlPartition) Count() uint64 {
+	table := p.table()
+	if table == "" {
+		return 0
+	}
+	var count uint64
+	if err := p.db.Table(table).Count(&count).Error; err != nil {
+		p.logger.WithError(err).Error("Error counting messages")
+	}
+	return count
+}
+
+// Store stores a message in the partition
+func (p *sqlPartition) Store(msgID uint64, msg []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := p.ensureTable(); err != nil {
+		return err
+	}
+	// the key 0 is a valid key, which gorm would not insert
+	err := p.inTx(func(tx *gorm.DB) error {
+		return tx.Exec("insert into "+p.entry.table()+" (id, published, data) values (?, ?, ?)"+p.conflictClause,
+			key(msgID), messageTime(msg), msg).Error
+	})
+	if err != nil {
+		p.logger.WithError(err).WithField("id", msgID).Error("Error storing message")
+	}
+	return err
+}
+
+// DoInTx executes the function with the max message id, while no message can be stored in the partition
+func (p *sqlPartition) DoInTx(fnToExecute func(maxMessageId uint64) error) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := p.ensureTable(); err != nil {
+		return err
+	}
+	return p.inTx(func(tx *gorm.DB) error {
+		maxID, err := p.readMaxMessageID(tx)
+		if err != nil {
+			return err
+		}
+		return fnToExecute(maxID)
+	})
+}
+
+func (p *sqlPartition) generateNextMsgID(nodeID uint8) (uint64, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	last, err := p.readMaxMessageID(p.db)
+	if err != nil {
+		return 0, err
+	}
+	if p.generatedID > last {
+		last = p.generatedID
+	}
+	p.generatedID = nextID(last, nodeID)
+	return p.generatedID, nil
+}
+
+// table returns the name of the table of the partition, or an empty string if it was not created yet
+func (p *sqlPartition) table() string {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := p.lookup(); err != nil || p.entry.ID == 0 {
+		return ""
+	}
+	return p.entry.table()
+}
+
+// readMaxMessageID reads the max message id from the table of the partition. The lock has to be held by the caller.
+func (p *sqlPartition) readMaxMessageID(db *gorm.DB) (uint64, error) {
+	if err := p.lookup(); err != nil || p.entry.ID == 0 {
+		return 0, err
+	}
+	var maxKey sql.NullInt64
+	if err := db.Raw("select max(id) from " + p.entry.table()).Row().Scan(&maxKey); err != nil || !maxKey.Valid {
+		return 0, err
+	}
+	return messageID(maxKey.Int64), nil
+}
+
+// lookup reads the entry of the partition from the catalog, if it was not found yet.
+// The lock has to be held by the caller.
+func (p *sqlPartition) lookup() error {
+	if p.entry.ID != 0 {
+		return nil
+	}
+	var entry partitionEntry
+	err := p.db.Where("name = ?", p.name).First(&entry).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		p.logger.WithError(err).Error("Error reading the partition")
+		return err
+	}
+	p.entry = entry
+	return nil
+}
+
+// ensureTable adds the partition to the catalog and creates its table, if not done yet.
+// The lock has to be held by the caller.
+func (p *sqlPartition) ensureTable() error {
+	if p.entry.ID != 0 {
+		return nil
+	}
+
+	entry := partitionEntry{Name: p.name}
+	if err := p.db.Where(entry).FirstOrCreate(&entry).Error; err != nil {
+		// the partition may have been created at the same time by another server using the database
+		if err := p.db.Where(partitionEntry{Name: p.name}).First(&entry).Error; err != nil {
+			p.logger.WithError(err).Error("Error adding the partition to the catalog")
+			return err
+		}
+	}
+	if err := p.db.Table(entry.table()).AutoMigrate(&messageEntry{}).Error; err != nil {
+		p.logger.WithError(err).WithField("table", entry.table()).Error("Error creating the table of the partition")
+		return err
+	}
+	p.entry = entry
+	return nil
+}
+
+// inTx executes the function in a transaction locking the partition against the other servers using the database,
+// if the database needs it. The lock has to be held by the caller.
+func (p *sqlPartition) inTx(fnToExecute func(tx *gorm.DB) error) error {
+	if p.lockQuery == "" {
+		return fnToExecute(p.db)
+	}
+
+	tx := p.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := tx.Exec(p.lockQuery, p.entry.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fnToExecute(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Fetch fetches asynchronously the messages of the fetch request.
+// As in the file message store, the messages are returned in the order of their ids in all the directions.
+func (p *sqlPartition) Fetch(req *store.FetchRequest) {
+	go func() {
+		table := p.table()
+		if table == "" {
+			req.StartC <- 0
+			req.Done()
+			return
+		}
+
+		first, last, count, err := p.fetchRange(table, req)
+		if err != nil {
+			p.logger.WithError(err).Error("Error calculating the fetched messages")
+			req.ErrorC <- err
+			return
+		}
+		req.StartC <- count
+
+		if err := p.fetchMessages(table, req, first, last, count); err != nil {
+			p.logger.WithError(err).Error("Error fetching messages")
+			req.Error(err)
+			return
+		}
+		req.Done()
+	}()
+}
+
+// fetchRange returns the keys of the first and the last fetched messages, and the number of fetched messages
+func (p *sqlPartition) fetchRange(table string, req *store.FetchRequest) (first int64, last int64, count int, err error) {
+	if req.StartTime > 0 {
+		if err = p.resolveStartTime(table, req); err != nil {
+			return
+		}
+	}
+
+	condition, order := "id >= ?", "id"
+	if req.Direction == store.DirectionBackwards {
+		condition, order = "id <= ?", "id desc"
+	}
+	args := []interface{}{key(req.StartID)}
+	if req.EndID > 0 {
+		if req.Direction == store.DirectionBackwards {
+			condition += " and id >= ?"
+		} else {
+			condition += " and id <= ?"
+		}
+		args = append(args, key(req.EndID))
+	}
+	args = append(args, req.Count)
+
+	err = p.db.Raw("select count(*), coalesce(min(id), 0), coalesce(max(id), 0) from "+
+		"(select id from "+table+" where "+condition+" order by "+order+" limit ?) as fetched", args...).
+		Row().Scan(&count, &first, &last)
+	return
+}
+
+// resolveStartTime replaces the start id of the request by the id of the first message published since its start time
+func (p *sqlPartition) resolveStartTime(table string, req *store.FetchRequest) error {
+	var startKey sql.NullInt64
+	err := p.db.Raw("select min(id) from "+table+" where published >= ?", req.StartTime).
+		Row().Scan(&startKey)
+	if err != nil {
+		return err
+	}
+	if startKey.Valid {
+		req.StartID = messageID(startKey.Int64)
+	} else {
+		req.StartID = p.MaxMessageID() + 1
+	}
+	return nil
+}
+
+// fetchMessages sends the count messages from the first to the last key, reading them from the database by pages,
+// so that the database is not blocked by a slow receiver
+func (p *sqlPartition) fetchMessages(table string, req *store.FetchRequest, first int64, last int64, count int) error {
+	next := first
+	for sent := 0; sent < count; {
+		limit := count - sent
+		if limit > fetchPageSize {
+			limit = fetchPageSize
+		}
+		var entries []messageEntry
+		err := p.db.Table(table).Where("id >= ? and id <= ?", next, last).
+			Order("id").Limit(limit).Find(&entries).Error
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, entry := range entries {
+			if req.IsDone() {
+				return store.ErrRequestDone
+			}
+			req.Push(messageID(entry.ID), entry.Data)
+		}
+		sent += len(entries)
+		next = entries[len(entries)-1].ID + 1
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package sqlstore
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+
+	// use gorm's postgres dialect
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+const (
+	postgresGormLogMode = false
+
+	// postgresLockQuery locks the row of a partition in the catalog until the end of the transaction
+	postgresLockQuery = "select id from message_partition where id = ? for update"
+
+	// postgresConflictClause ignores the messages stored already, e.g. by another node of a cluster using the database
+	postgresConflictClause = " on conflict (id) do nothing"
+)
+
+// PostgresMessageStore is a message store in a Postgresql database, with a table for each partition.
+type PostgresMessageStore struct {
+	*sqlMessageStore
+	config kvstore.PostgresConfig
+}
+
+// NewPostgresMessageStore returns a new configured PostgresMessageStore (not opened yet).
+func NewPostgresMessageStore(config kvstore.PostgresConfig) *PostgresMessageStore {
+	ms := &PostgresMessageStore{
+		sqlMessageStore: newSQLMessageStore(log.WithFields(log.Fields{"module": "ms-postgres"})),
+		config:          config,
+	}
+	ms.lockQuery = postgresLockQuery
+	ms.conflictClause = postgresConflictClause
+	return ms
+}
+
+// Open a connection to Postgresql database, or return an error.
+func (ms *PostgresMessageStore) Open() error {
+	logger := ms.logger.WithField("config", ms.config)
+	logger.Info("Opening database")
+
+	gormdb, err := gorm.Open("postgres", ms.config.ConnectionString())
+	if err != nil {
+		logger.WithError(err).Error("Error opening database")
+		return err
+	}
+
+	if err := gormdb.DB().Ping(); err != nil {
+		logger.WithError(err).Error("Error pinging database")
+		return err
+	}
+
+	gormdb.LogMode(postgresGormLogMode)
+	gormdb.DB().SetMaxIdleConns(ms.config.MaxIdleConns)
+	gormdb.DB().SetMaxOpenConns(ms.config.MaxOpenConns)
+
+	if err := ms.migrate(gormdb); err != nil {
+		return err
+	}
+	ms.db = gormdb
+	return nil
+}
@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+// This config assumes a postgresql running locally
+func aPostgresConfig() kvstore.PostgresConfig {
+	return kvstore.PostgresConfig{
+		ConnParams: map[string]string{
+			"host":     "localhost",
+			"user":     "postgres",
+			"password": "",
+			"dbname":   "guble",
+			"sslmode":  "disable",
+		},
+		MaxIdleConns: 1,
+		MaxOpenConns: 2,
+	}
+}
+
+// anEmptyPostgresMessageStore returns an opened store without partitions, or skips the test if no database is running
+func anEmptyPostgresMessageStore(t *testing.T) *PostgresMessageStore {
+	ms := NewPostgresMessageStore(aPostgresConfig())
+	if err := ms.Open(); err != nil {
+		t.Skip("postgresql is not available:", err)
+	}
+
+	var entries []partitionEntry
+	assert.NoError(t, ms.db.Find(&entries).Error)
+	for _, entry := range entries {
+		assert.NoError(t, ms.db.DropTableIfExists(entry.table()).Error)
+	}
+	assert.NoError(t, ms.db.Exec("delete from message_partition").Error)
+	return ms
+}
+
+func TestPostgresMessageStore_Fetch(t *testing.T) {
+	ms := anEmptyPostgresMessageStore(t)
+	defer ms.Stop()
+	CommonTestFetch(t, ms)
+}
+
+func TestPostgresMessageStore_StoreMessage(t *testing.T) {
+	ms := anEmptyPostgresMessageStore(t)
+	defer ms.Stop()
+	CommonTestStoreMessage(t, ms)
+}
+
+func TestPostgresMessageStore_EmptyPartition(t *testing.T) {
+	ms := anEmptyPostgresMessageStore(t)
+	defer ms.Stop()
+	CommonTestEmptyPartition(t, ms)
+}
+
+func TestPostgresMessageStore_SharedDatabase(t *testing.T) {
+	ms := anEmptyPostgresMessageStore(t)
+	defer ms.Stop()
+	other := NewPostgresMessageStore(aPostgresConfig())
+	assert.NoError(t, other.Open())
+	defer other.Stop()
+	CommonTestSharedDatabase(t, ms, other)
+
+	// a message is stored only once
+	assert.NoError(t, ms.Store("orders", 1, []byte("raw message")))
+	assert.NoError(t, ms.Store("orders", 1, []byte("raw message")))
+}
+
+func TestPostgresMessageStore_DoInTx(t *testing.T) {
+	a := assert.New(t)
+	ms := anEmptyPostgresMessageStore(t)
+	defer ms.Stop()
+	a.NoError(ms.Store("p", 1, []byte("raw message")))
+
+	// a second server using the database cannot store while the partition is locked
+	other := NewPostgresMessageStore(aPostgresConfig())
+	a.NoError(other.Open())
+	defer other.Stop()
+
+	stored := make(chan error, 1)
+	err := ms.DoInTx("p", func(maxMessageID uint64) error {
+		a.Equal(uint64(1), maxMessageID)
+		go func() {
+			stored <- other.Store("p", 2, []byte("raw message"))
+		}()
+		select {
+		case <-stored:
+			a.Fail("stored in the transaction")
+		case <-time.After(100 * time.Millisecond):
+		}
+		return nil
+	})
+	a.NoError(err)
+	a.NoError(<-stored)
+
+	maxID, err := ms.MaxMessageID("p")
+	a.NoError(err)
+	a.Equal(uint64(2), maxID)
+}
+
+func TestPostgresMessageStore_Open(t *testing.T) {
+	config := aPostgresConfig()
+	config.ConnParams["port"] = "1"
+	ms := NewPostgresMessageStore(config)
+	assert.Error(t, ms.Open())
+}
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	// use this as gorm's sqlite dialect / implementation
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jinzhu/gorm"
+
+	log "github.com/Sirupsen/logrus"
+
+	"os"
+	"path/filepath"
+)
+
+const (
+	sqliteMaxIdleConns = 1
+	// sqlite serializes the writes, so the connections would only wait for each other
+	sqliteMaxOpenConns = 1
+	sqliteGormLogMode  = false
+)
+
+// SqliteMessageStore is a message store in a sqlite database file.
+type SqliteMessageStore struct {
+	*sqlMessageStore
+	filename    string
+	syncOnWrite bool
+}
+
+// NewSqliteMessageStore returns a new configured SqliteMessageStore (not opened yet).
+func NewSqliteMessageStore(filename string, syncOnWrite bool) *SqliteMessageStore {
+	return &SqliteMessageStore{
+		sqlMessageStore: newSQLMessageStore(log.WithFields(log.Fields{
+			"module":      "ms-sqlite",
+			"filename":    filename,
+			"syncOnWrite": syncOnWrite,
+		})),
+		filename:    filename,
+		syncOnWrite: syncOnWrite,
+	}
+}
+
+// Open opens the database file. If the directory does not exist, it will be created.
+func (ms *SqliteMessageStore) Open() error {
+	if err := os.MkdirAll(filepath.Dir(ms.filename), 0755); err != nil {
+		ms.logger.WithError(err).Error("Error creating the database directory")
+		return err
+	}
+
+	ms.logger.Info("Opening database")
+
+	gormdb, err := gorm.Open("sqlite3", ms.filename)
+	if err != nil {
+		ms.logger.WithError(err).Error("Error opening database")
+		return err
+	}
+
+	if err := gormdb.DB().Ping(); err != nil {
+		ms.logger.WithError(err).Error("Error pinging database")
+		return err
+	}
+
+	gormdb.LogMode(sqliteGormLogMode)
+	gormdb.DB().SetMaxIdleConns(sqliteMaxIdleConns)
+	gormdb.DB().SetMaxOpenConns(sqliteMaxOpenConns)
+
+	if err := ms.migrate(gormdb); err != nil {
+		return err
+	}
+
+	if !ms.syncOnWrite {
+		ms.logger.Info("Setting db: PRAGMA synchronous = OFF")
+		if err := gormdb.Exec("PRAGMA synchronous = OFF").Error; err != nil {
+			ms.logger.WithError(err).Error("Error setting PRAGMA synchronous = OFF")
+			return err
+		}
+	}
+	ms.db = gormdb
+	return nil
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/store"
+)
+
+func anOpenSqliteMessageStore(a *assert.Assertions, dir string) *SqliteMessageStore {
+	ms := NewSqliteMessageStore(path.Join(dir, "message-store.db"), false)
+	a.NoError(ms.Open())
+	return ms
+}
+
+func TestSqliteMessageStore_Fetch(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	defer ms.Stop()
+	CommonTestFetch(t, ms)
+
+	// an id can be stored only once
+	a.Error(ms.Store("p2", 2, []byte("raw message")))
+}
+
+func TestSqliteMessageStore_StoreMessage(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	CommonTestStoreMessage(t, ms)
+	a.NoError(ms.Stop())
+
+	// the max message ids are read again after a restart
+	ms = anOpenSqliteMessageStore(a, dir)
+	defer ms.Stop()
+	maxID, err := ms.MaxMessageID("orders")
+	a.NoError(err)
+	a.Equal(uint64(3<<nodeIDBits|3), maxID)
+}
+
+func TestSqliteMessageStore_EmptyPartition(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	defer ms.Stop()
+	CommonTestEmptyPartition(t, ms)
+}
+
+func TestSqliteMessageStore_SharedDatabase(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	defer ms.Stop()
+	other := anOpenSqliteMessageStore(a, dir)
+	defer other.Stop()
+
+	// the messages are stored as in a Postgres database shared by the nodes of a cluster
+	ms.conflictClause = postgresConflictClause
+	CommonTestSharedDatabase(t, ms, other)
+}
+
+func TestSqliteMessageStore_Check(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	a.NoError(ms.Check())
+
+	a.NoError(ms.Stop())
+	a.Error(ms.Check())
+	_, err := ms.Partitions()
+	a.Error(err)
+}
+
+func TestNextID(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(uint64(1), nextID(0, 0))
+	a.Equal(uint64(43), nextID(42, 0))
+	a.Equal(uint64(1<<nodeIDBits|5), nextID(0, 5))
+	a.Equal(uint64(2<<nodeIDBits|1), nextID(1<<nodeIDBits|5, 1))
+	a.Equal(uint64(1<<nodeIDBits|1), nextID(42, 1))
+}
+
+func TestSqliteMessageStore_HighMessageIDs(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_sqlstore_test")
+	defer os.RemoveAll(dir)
+
+	ms := anOpenSqliteMessageStore(a, dir)
+	defer ms.Stop()
+
+	// the ids of the file message store can have the highest bit set
+	ids := []uint64{1, 1<<63 - 1, 1 << 63, 1<<64 - 2}
+	for _, id := range ids {
+		a.NoError(ms.Store("p", id, []byte("raw message")))
+	}
+	a.Equal(ids, fetch(a, ms, &store.FetchRequest{Partition: "p", Direction: store.DirectionForward, Count: 10}))
+	a.Equal(ids[2:], fetch(a, ms, &store.FetchRequest{Partition: "p", StartID: 1 << 63, Direction: store.DirectionForward, Count: 10}))
+
+	maxID, err := ms.MaxMessageID("p")
+	a.NoError(err)
+	a.Equal(uint64(1<<64-2), maxID)
+}
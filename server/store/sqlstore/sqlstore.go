@@ -0,0 +1,243 @@
+// Package sqlstore is an SQL-based implementation of the MessageStore interface, using gorm.
+package sqlstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+var errNotOpen = errors.New("Error: Database is not initialized (nil)")
+
+// partitionEntry is a partition in the catalog of the partitions, whose messages are stored in their own table
+type partitionEntry struct {
+	ID   uint   `gorm:"primary_key"`
+	Name string `gorm:"unique_index" sql:"type:varchar(200)"`
+}
+
+// TableName returns the name of the table of the partitions
+func (partitionEntry) TableName() string {
+	return "message_partition"
+}
+
+// table returns the name of the table of the messages of the partition
+func (e partitionEntry) table() string {
+	return fmt.Sprintf("message_%d", e.ID)
+}
+
+// messageEntry is a stored message, with its publishing time for the fetches by time
+type messageEntry struct {
+	// ID is the key of the message id
+	ID        int64  `gorm:"primary_key;auto_increment:false"`
+	Published int64  `gorm:"index"`
+	Data      []byte `sql:"type:bytea"`
+}
+
+// key returns the key of a message id in the database. The highest bit of the id is flipped,
+// so that all the uint64 ids, e.g. of the file message store, are stored as int64 keys with the same order.
+func key(id uint64) int64 {
+	return int64(id ^ 1<<63)
+}
+
+// messageID returns the message id of a key in the database
+func messageID(key int64) uint64 {
+	return uint64(key) ^ 1<<63
+}
+
+// sqlMessageStore is the gorm-based implementation of the MessageStore interface,
+// which is extended by the stores of the SQL databases.
+type sqlMessageStore struct {
+	db         *gorm.DB
+	logger     *log.Entry
+	partitions map[string]*sqlPartition
+	mutex      sync.Mutex
+
+	// lockQuery is the query locking a partition in a transaction, against the other servers using the database.
+	// If it is empty, the partitions are locked only in this server.
+	lockQuery string
+
+	// conflictClause is appended to the insert of a message, to ignore the message if its id is stored already.
+	// If it is empty, storing an id again is an error.
+	conflictClause string
+}
+
+func newSQLMessageStore(logger *log.Entry) *sqlMessageStore {
+	return &sqlMessageStore{
+		logger:     logger,
+		partitions: make(map[string]*sqlPartition),
+	}
+}
+
+// Stop closes the database.
+func (s *sqlMessageStore) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.partitions = make(map[string]*sqlPartition)
+	if s.db != nil {
+		err := s.db.Close()
+		s.db = nil
+		return err
+	}
+	return nil
+}
+
+// Check returns an error if the database is not reachable.
+func (s *sqlMessageStore) Check() error {
+	if s.db == nil {
+		s.logger.Error(errNotOpen.Error())
+		return errNotOpen
+	}
+	if err := s.db.DB().Ping(); err != nil {
+		s.logger.WithError(err).Error("Error pinging database")
+		return err
+	}
+	return nil
+}
+
+// Store is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) Store(partition string, msgID uint64, msg []byte) error {
+	p, err := s.partition(partition)
+	if err != nil {
+		return err
+	}
+	return p.Store(msgID, msg)
+}
+
+// StoreMessage is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) StoreMessage(message *protocol.Message, nodeID uint8) (int, error) {
+	partitionName := message.Path.Partition()
+
+	// If nodeID is zero means we are running in standalone more, otherwise
+	// if the message has no nodeID it means it was received by this node
+	if nodeID == 0 || message.NodeID == 0 {
+		id, ts, err := s.GenerateNextMsgID(partitionName, nodeID)
+		if err != nil {
+			s.logger.WithError(err).Error("Generation of id failed")
+			return 0, err
+		}
+		message.ID = id
+		message.Time = ts
+		message.NodeID = nodeID
+	}
+
+	data := message.Bytes()
+	if err := s.Store(partitionName, message.ID, data); err != nil {
+		s.logger.WithError(err).WithField("partition", partitionName).Error("Error storing message")
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Fetch asynchronously fetches a set of messages defined by the fetch request.
+// It is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) Fetch(req *store.FetchRequest) {
+	p, err := s.partition(req.Partition)
+	if err != nil {
+		req.ErrorC <- err
+		return
+	}
+	p.Fetch(req)
+}
+
+// MaxMessageID is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) MaxMessageID(partition string) (uint64, error) {
+	p, err := s.partition(partition)
+	if err != nil {
+		return 0, err
+	}
+	return p.MaxMessageID(), nil
+}
+
+// DoInTx is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) DoInTx(partition string, fnToExecute func(maxMessageId uint64) error) error {
+	p, err := s.partition(partition)
+	if err != nil {
+		return err
+	}
+	return p.DoInTx(fnToExecute)
+}
+
+// GenerateNextMsgID is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) GenerateNextMsgID(partition string, nodeID uint8) (uint64, int64, error) {
+	p, err := s.partition(partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := p.generateNextMsgID(nodeID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, time.Now().Unix(), nil
+}
+
+// Partition is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) Partition(name string) (store.MessagePartition, error) {
+	return s.partition(name)
+}
+
+// Partitions returns the partitions of the catalog, sorted by name.
+// It is a part of the `store.MessageStore` implementation.
+func (s *sqlMessageStore) Partitions() ([]store.MessagePartition, error) {
+	if s.db == nil {
+		return nil, errNotOpen
+	}
+	var entries []partitionEntry
+	if err := s.db.Order("name").Find(&entries).Error; err != nil {
+		s.logger.WithError(err).Error("Error reading partitions")
+		return nil, err
+	}
+
+	partitions := make([]store.MessagePartition, 0, len(entries))
+	for _, entry := range entries {
+		p, err := s.partition(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// partition returns the partition, looking it up in the catalog when it is first used
+func (s *sqlMessageStore) partition(name string) (*sqlPartition, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.db == nil {
+		return nil, errNotOpen
+	}
+	if p, ok := s.partitions[name]; ok {
+		return p, nil
+	}
+
+	p := &sqlPartition{
+		db:             s.db,
+		name:           name,
+		lockQuery:      s.lockQuery,
+		conflictClause: s.conflictClause,
+		logger:         s.logger.WithField("partition", name),
+	}
+	if err := p.lookup(); err != nil {
+		return nil, err
+	}
+	s.partitions[name] = p
+	return p, nil
+}
+
+// migrate ensures the schema of the catalog of the partitions
+func (s *sqlMessageStore) migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&partitionEntry{}).Error; err != nil {
+		s.logger.WithError(err).Error("Error in schema migration")
+		return err
+	}
+	s.logger.Info("Ensured database schema")
+	return nil
+}
@@ -0,0 +1,79 @@
+package topics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// GetPrefix returns the prefix of the admin API.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) GetPrefix() string {
+	return r.prefix
+}
+
+// ServeHTTP serves the admin API of the topic configurations:
+//
+//	GET    <prefix>         lists all the configurations
+//	GET    <prefix>/<path>  returns the configuration of the path
+//	PUT    <prefix>/<path>  creates or replaces the configuration of the path
+//	DELETE <prefix>/<path>  removes the configuration of the path
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := normalize(protocol.Path(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(r.prefix, "/"))))
+
+	switch {
+	case req.Method == http.MethodGet && path == "":
+		writeJSON(w, r.List())
+	case req.Method == http.MethodGet:
+		config, err := r.Get(path)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, config)
+	case req.Method == http.MethodPut && path != "":
+		config := &Config{}
+		if err := json.NewDecoder(req.Body).Decode(config); err != nil {
+			writeError(w, &ValidationError{Reason: err.Error()})
+			return
+		}
+		config.Path = path
+		if err := r.Put(config); err != nil {
+			writeError(w, err)
+			return
+		}
+		logger.WithField("config", config).Info("Topic configuration updated")
+		writeJSON(w, config)
+	case req.Method == http.MethodDelete && path != "":
+		if err := r.Delete(path); err != nil {
+			writeError(w, err)
+			return
+		}
+		logger.WithField("path", path).Info("Topic configuration removed")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"error":"Method not allowed."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.WithError(err).Error("Error encoding data.")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == ErrNotFound {
+		status = http.StatusNotFound
+	} else if _, ok := err.(*ValidationError); ok {
+		status = http.StatusBadRequest
+	} else {
+		logger.WithError(err).Error("Error handling topic configuration")
+	}
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), status)
+}
@@ -0,0 +1,64 @@
+package topics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+	registry := NewRegistry(kvstore.NewMemoryKVStore(), "/admin/topics/")
+
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		a.NoError(err)
+		w := httptest.NewRecorder()
+		registry.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/admin/topics", "")
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`[]`, w.Body.String())
+
+	// create a configuration
+	w = request(http.MethodPut, "/admin/topics/orders/eu", `{"Retention":"24h","MaxMessageSize":1024,"AllowedPublishers":["shop"]}`)
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"Path":"/orders/eu","Retention":"24h0m0s","MaxMessageSize":1024,"AllowedPublishers":["shop"]}`, w.Body.String())
+	a.Equal(1024, registry.Lookup("/orders/eu/42").MaxMessageSize)
+
+	// read it
+	w = request(http.MethodGet, "/admin/topics/orders/eu", "")
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"Path":"/orders/eu","Retention":"24h0m0s","MaxMessageSize":1024,"AllowedPublishers":["shop"]}`, w.Body.String())
+
+	w = request(http.MethodGet, "/admin/topics/", "")
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`[{"Path":"/orders/eu","Retention":"24h0m0s","MaxMessageSize":1024,"AllowedPublishers":["shop"]}]`, w.Body.String())
+
+	// invalid configurations are rejected
+	w = request(http.MethodPut, "/admin/topics/orders", `{"DefaultTTL":"soon"}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+	w = request(http.MethodPut, "/admin/topics/orders", `{"MaxMessageSize":-1}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+	w = request(http.MethodPut, "/admin/topics/orders/*", `{}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+	w = request(http.MethodGet, "/admin/topics/orders", "")
+	a.Equal(http.StatusNotFound, w.Code)
+
+	// delete it
+	w = request(http.MethodDelete, "/admin/topics/orders/eu", "")
+	a.Equal(http.StatusNoContent, w.Code)
+	w = request(http.MethodDelete, "/admin/topics/orders/eu", "")
+	a.Equal(http.StatusNotFound, w.Code)
+	a.Nil(registry.Lookup("/orders/eu/42"))
+
+	w = request(http.MethodPost, "/admin/topics/orders/eu", "{}")
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
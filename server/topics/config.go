@@ -0,0 +1,143 @@
+package topics
+
+import (
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// ValidationError is returned when a topic configuration is invalid
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid topic configuration: " + e.Reason
+}
+
+// Duration is a time.Duration encoded as a string in JSON, e.g. "90s" or "24h"
+type Duration time.Duration
+
+// MarshalText returns the duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText parses the duration from a string
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds the settings of a topic, which apply to the topic and all its subtopics.
+// A configuration having a path with a single segment (e.g. `/orders`) is the configuration of a partition.
+// The zero values of the settings keep the global behaviour.
+type Config struct {
+	Path protocol.Path
+
+	// Retention is the maximum time for which a message can be delivered, after it was published
+	Retention Duration `json:",omitempty"`
+
+	// MaxMessageSize is the maximum size of the message body in bytes
+	MaxMessageSize int `json:",omitempty"`
+
+	// Transient messages are routed to the subscribers, but not persisted in the message store
+	Transient bool `json:",omitempty"`
+
+	// DefaultTTL is the time-to-live of the messages published without one
+	DefaultTTL Duration `json:",omitempty"`
+
+	// AllowedPublishers are the ids of the users allowed to publish on the topic; if empty, everyone is allowed
+	AllowedPublishers []string `json:",omitempty"`
+
+	// PublishRate is the number of messages per second each user and application can publish on the topic,
+	// overriding the global rate limits
+	PublishRate float64 `json:",omitempty"`
+
+	// PublishBurst is the number of messages which can be published at once, if a PublishRate is set
+	PublishBurst int `json:",omitempty"`
+
+	// StoreMaxAge, StoreMaxMessages and StoreMaxBytes limit the messages kept in the message store,
+	// overriding the global retention of the store. They can only be set for a partition.
+	StoreMaxAge      Duration `json:",omitempty"`
+	StoreMaxMessages uint64   `json:",omitempty"`
+	StoreMaxBytes    int64    `json:",omitempty"`
+
+	// StoreCompact compacts the partition in the message store, keeping only the latest message of each compaction key.
+	// It can only be set for a partition.
+	StoreCompact bool `json:",omitempty"`
+}
+
+// Validate returns a ValidationError if the configuration is invalid
+func (c *Config) Validate() error {
+	var reason string
+	switch {
+	case len(c.Path.Segments()) == 0:
+		reason = "the path is missing"
+	case c.Path.HasWildcard():
+		reason = "the path cannot contain wildcards"
+	case c.Retention < 0:
+		reason = "the retention cannot be negative"
+	case c.MaxMessageSize < 0:
+		reason = "the maximum message size cannot be negative"
+	case c.DefaultTTL < 0:
+		reason = "the default TTL cannot be negative"
+	case c.PublishRate < 0 || c.PublishBurst < 0:
+		reason = "the publish rate cannot be negative"
+	case c.StoreMaxAge < 0 || c.StoreMaxBytes < 0:
+		reason = "the store retention cannot be negative"
+	case c.hasStoreRetention() && len(c.Path.Segments()) > 1:
+		reason = "the store retention and compaction can only be set for a partition"
+	default:
+		return nil
+	}
+	return &ValidationError{Reason: reason}
+}
+
+func (c *Config) hasStoreRetention() bool {
+	return c.StoreMaxAge != 0 || c.StoreMaxMessages != 0 || c.StoreMaxBytes != 0 || c.StoreCompact
+}
+
+// IsPublisherAllowed returns true if the user is allowed to publish on the topic
+func (c *Config) IsPublisherAllowed(userID string) bool {
+	if c == nil || len(c.AllowedPublishers) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedPublishers {
+		if allowed == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTooLarge returns true if the body of the message exceeds the maximum message size
+func (c *Config) IsTooLarge(message *protocol.Message) bool {
+	return c != nil && c.MaxMessageSize > 0 && len(message.Body) > c.MaxMessageSize
+}
+
+// IsTransient returns true if the messages should not be persisted
+func (c *Config) IsTransient() bool {
+	return c != nil && c.Transient
+}
+
+// ApplyExpiry sets the expiry time of a message being published:
+// the default TTL is used if the message has no TTL, and the retention limits the expiry time.
+func (c *Config) ApplyExpiry(message *protocol.Message) {
+	if c == nil {
+		return
+	}
+	if message.Expires == 0 && c.DefaultTTL > 0 {
+		message.SetTTL(time.Duration(c.DefaultTTL))
+	}
+	if c.Retention > 0 {
+		retained := time.Now().Add(time.Duration(c.Retention)).Unix()
+		if message.Expires == 0 || message.Expires > retained {
+			message.Expires = retained
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package topics
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+)
+
+const schema = "topic_config"
+
+// ErrNotFound is returned when there is no configuration for a path
+var ErrNotFound = errors.New("Topic configuration not found.")
+
+// reloadInterval is the interval of reloading the configurations from the KVStore,
+// which applies the changes made by the other nodes of a cluster sharing it
+var reloadInterval = 10 * time.Second
+
+// Registry holds the topic configurations, which are persisted in the KVStore.
+// The configurations are cached in memory, so that the changes apply immediately on this node,
+// and are reloaded periodically, so that the changes made on other nodes apply after the reloadInterval.
+type Registry struct {
+	kvStore kvstore.KVStore
+	configs map[protocol.Path]*Config
+	prefix  string
+
+	reloadStopC chan bool
+	reloadDoneC chan bool
+
+	sync.RWMutex
+}
+
+// NewRegistry returns a new Registry (not loaded) persisting the configurations in the KVStore,
+// and serving the admin API at the prefix
+func NewRegistry(kvStore kvstore.KVStore, prefix string) *Registry {
+	return &Registry{
+		kvStore: kvStore,
+		configs: make(map[protocol.Path]*Config),
+		prefix:  prefix,
+	}
+}
+
+// Start loads the configurations from the KVStore, and starts reloading them periodically
+func (r *Registry) Start() error {
+	if err := r.Load(); err != nil {
+		return err
+	}
+	r.reloadStopC = make(chan bool)
+	r.reloadDoneC = make(chan bool)
+	go r.reload(time.NewTicker(reloadInterval))
+	return nil
+}
+
+// Stop stops reloading the configurations
+func (r *Registry) Stop() error {
+	if r.reloadStopC == nil {
+		return nil
+	}
+	close(r.reloadStopC)
+	<-r.reloadDoneC
+	r.reloadStopC = nil
+	return nil
+}
+
+func (r *Registry) reload(ticker *time.Ticker) {
+	defer close(r.reloadDoneC)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// the configurations loaded before are kept on errors
+			r.Load()
+		case <-r.reloadStopC:
+			return
+		}
+	}
+}
+
+// Load loads the configurations from the KVStore
+func (r *Registry) Load() error {
+	configs := make(map[protocol.Path]*Config)
+	for entry := range r.kvStore.Iterate(schema, "") {
+		config := &Config{}
+		if err := json.Unmarshal([]byte(entry[1]), config); err != nil {
+			logger.WithError(err).WithField("path", entry[0]).Error("Error loading topic configuration")
+			return err
+		}
+		configs[config.Path] = config
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if len(configs) != len(r.configs) {
+		logger.WithField("count", len(configs)).Info("Loaded topic configurations")
+	}
+	r.configs = configs
+	return nil
+}
+
+// Lookup returns the configuration applying to the topic, which is the configuration
+// of the topic itself, or else of its nearest parent topic. It returns nil if there is none.
+func (r *Registry) Lookup(topic protocol.Path) *Config {
+	if r == nil {
+		return nil
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.configs) == 0 {
+		return nil
+	}
+	segments := topic.Segments()
+	for i := len(segments); i > 0; i-- {
+		if config, ok := r.configs[pathOf(segments[:i])]; ok {
+			return config
+		}
+	}
+	return nil
+}
+
+// Get returns the configuration of the path, or ErrNotFound
+func (r *Registry) Get(path protocol.Path) (*Config, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	config, ok := r.configs[normalize(path)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return config, nil
+}
+
+// List returns all the configurations, sorted by path
+func (r *Registry) List() []*Config {
+	r.RLock()
+	defer r.RUnlock()
+
+	configs := make([]*Config, 0, len(r.configs))
+	for _, config := range r.configs {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Path < configs[j].Path
+	})
+	return configs
+}
+
+// Put validates, stores and applies the configuration, replacing the previous configuration of the path
+func (r *Registry) Put(config *Config) error {
+	config.Path = normalize(config.Path)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.kvStore.Put(schema, string(config.Path), data); err != nil {
+		return err
+	}
+	r.configs[config.Path] = config
+	return nil
+}
+
+// Delete removes the configuration of the path, or returns ErrNotFound.
+// The configuration is looked up in the KVStore, since it may have been stored by another node not reloaded yet.
+func (r *Registry) Delete(path protocol.Path) error {
+	path = normalize(path)
+
+	r.Lock()
+	defer r.Unlock()
+
+	_, exists, err := r.kvStore.Get(schema, string(path))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		delete(r.configs, path)
+		return ErrNotFound
+	}
+	if err := r.kvStore.Delete(schema, string(path)); err != nil {
+		return err
+	}
+	delete(r.configs, path)
+	return nil
+}
+
+// normalize returns the path with a leading and without a trailing slash
+func normalize(path protocol.Path) protocol.Path {
+	return pathOf(path.Segments())
+}
+
+func pathOf(segments []string) protocol.Path {
+	if len(segments) == 0 {
+		return ""
+	}
+	return protocol.Path("/" + strings.Join(segments, "/"))
+}
@@ -0,0 +1,142 @@
+package topics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+)
+
+func TestRegistry_PutLookupAndDelete(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	registry := NewRegistry(kvs, "/admin/topics")
+	a.NoError(registry.Start())
+	defer registry.Stop()
+	a.Nil(registry.Lookup("/orders/42"))
+
+	// when configuring a partition and one of its topics
+	a.NoError(registry.Put(&Config{Path: "/orders", MaxMessageSize: 100}))
+	a.NoError(registry.Put(&Config{Path: "orders/eu/", Transient: true}))
+
+	// then the nearest configuration applies
+	a.Equal(100, registry.Lookup("/orders/42").MaxMessageSize)
+	a.Equal(100, registry.Lookup("/orders").MaxMessageSize)
+	a.True(registry.Lookup("/orders/eu/42").Transient)
+	a.Equal(protocol.Path("/orders/eu"), registry.Lookup("/orders/eu").Path)
+	a.Nil(registry.Lookup("/ordersx"))
+	a.Nil(registry.Lookup("/"))
+
+	// and the configurations are persisted
+	reloaded := NewRegistry(kvs, "/admin/topics")
+	a.NoError(reloaded.Load())
+	a.Equal(registry.List(), reloaded.List())
+	a.Equal(2, len(reloaded.List()))
+
+	// and when deleting a configuration
+	a.NoError(registry.Delete("/orders/eu"))
+	a.Equal(ErrNotFound, registry.Delete("/orders/eu"))
+	_, err := registry.Get("/orders/eu")
+	a.Equal(ErrNotFound, err)
+	a.False(registry.Lookup("/orders/eu/42").Transient)
+
+	a.NoError(reloaded.Load())
+	a.Equal(1, len(reloaded.List()))
+}
+
+func TestRegistry_SharedKVStore(t *testing.T) {
+	a := assert.New(t)
+	defer func(interval time.Duration) { reloadInterval = interval }(reloadInterval)
+	reloadInterval = 10 * time.Millisecond
+
+	// given the registries of two nodes sharing the KVStore
+	kvs := kvstore.NewMemoryKVStore()
+	node1 := NewRegistry(kvs, "/admin/topics")
+	node2 := NewRegistry(kvs, "/admin/topics")
+	a.NoError(node1.Start())
+	defer node1.Stop()
+	a.NoError(node2.Start())
+	defer node2.Stop()
+
+	// when a configuration is stored on one node, then it applies on the other one after reloading
+	a.NoError(node1.Put(&Config{Path: "/orders", MaxMessageSize: 100}))
+	time.Sleep(50 * time.Millisecond)
+	if config := node2.Lookup("/orders/42"); a.NotNil(config) {
+		a.Equal(100, config.MaxMessageSize)
+	}
+
+	// and when it is deleted on the other node, then it is removed on both
+	a.NoError(node2.Delete("/orders"))
+	time.Sleep(50 * time.Millisecond)
+	a.Nil(node1.Lookup("/orders/42"))
+	a.Equal(ErrNotFound, node1.Delete("/orders"))
+}
+
+func TestRegistry_PutInvalid(t *testing.T) {
+	a := assert.New(t)
+	registry := NewRegistry(kvstore.NewMemoryKVStore(), "/admin/topics")
+
+	for _, config := range []*Config{
+		{Path: "/"},
+		{Path: "/orders/*"},
+		{Path: "/orders", Retention: Duration(-time.Second)},
+		{Path: "/orders", MaxMessageSize: -1},
+		{Path: "/orders", DefaultTTL: Duration(-time.Second)},
+		{Path: "/orders", PublishRate: -1},
+		{Path: "/orders", StoreMaxBytes: -1},
+		{Path: "/orders/eu", StoreMaxMessages: 100},
+		{Path: "/orders/eu", StoreCompact: true},
+	} {
+		err := registry.Put(config)
+		a.IsType(&ValidationError{}, err, string(config.Path))
+	}
+	a.Empty(registry.List())
+}
+
+func TestConfig_Enforcement(t *testing.T) {
+	a := assert.New(t)
+
+	var none *Config
+	a.True(none.IsPublisherAllowed("user01"))
+	a.False(none.IsTooLarge(&protocol.Message{Body: []byte("body")}))
+	a.False(none.IsTransient())
+
+	config := &Config{AllowedPublishers: []string{"user01"}, MaxMessageSize: 4}
+	a.True(config.IsPublisherAllowed("user01"))
+	a.False(config.IsPublisherAllowed("user02"))
+	a.False(config.IsTooLarge(&protocol.Message{Body: []byte("body")}))
+	a.True(config.IsTooLarge(&protocol.Message{Body: []byte("bodies")}))
+}
+
+func TestConfig_ApplyExpiry(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now().Unix()
+
+	// the default TTL is used for the messages without TTL
+	config := &Config{DefaultTTL: Duration(time.Minute)}
+	message := &protocol.Message{}
+	config.ApplyExpiry(message)
+	a.InDelta(now+60, message.Expires, 1)
+
+	message = &protocol.Message{Expires: now + 10}
+	config.ApplyExpiry(message)
+	a.Equal(now+10, message.Expires)
+
+	// the retention limits the expiry
+	config = &Config{Retention: Duration(time.Hour)}
+	message = &protocol.Message{}
+	config.ApplyExpiry(message)
+	a.InDelta(now+3600, message.Expires, 1)
+
+	message = &protocol.Message{Expires: now + 7200}
+	config.ApplyExpiry(message)
+	a.InDelta(now+3600, message.Expires, 1)
+
+	message = &protocol.Message{Expires: now + 10}
+	config.ApplyExpiry(message)
+	a.Equal(now+10, message.Expires)
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -28,8 +29,13 @@ type Receiver struct {
 	doFetch             bool
 	doSubscription      bool
 	startID             int64
+	startTime           int64
 	maxCount            int
 	lastSentID          uint64
+	lastSentIDs         map[string]uint64 // last sent id per partition, used by wildcard paths
+	slowConsumer        router.SlowConsumerConfig
+	filter              *router.FilterExpression
+	group               string
 	shouldStop          bool
 	route               *router.Route
 	enableNotifications bool
@@ -57,14 +63,56 @@ func NewReceiverFromCmd(
 		cancelC:             make(chan bool, 1),
 		enableNotifications: true,
 		userID:              userID,
+		lastSentIDs:         make(map[string]uint64),
 	}
 	if len(cmd.Arg) == 0 || cmd.Arg[0] != '/' {
 		return nil, fmt.Errorf("command requires at least a path argument, but non given")
 	}
 
-	args := strings.SplitN(cmd.Arg, " ", 3)
+	arg, filter, hasFilter := splitFilterOption(cmd.Arg)
+	if hasFilter {
+		if rec.filter, err = parseFilterOption(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	args, options := splitOptions(strings.Fields(arg))
+	if len(args) > 3 {
+		return nil, fmt.Errorf("command accepts at most a path, a startid and a maxCount, but was %q", cmd.Arg)
+	}
 	rec.path = protocol.Path(args[0])
 
+	if group, hasGroup := options["group"]; hasGroup {
+		if group == "" {
+			return nil, fmt.Errorf("group has to be a non empty name")
+		}
+		if len(args) > 1 {
+			return nil, fmt.Errorf("a group receiver can not fetch messages, but a startid was given")
+		}
+		rec.group = group
+		delete(options, "group")
+	}
+
+	if since, hasSince := options["since"]; hasSince {
+		if rec.group != "" {
+			return nil, fmt.Errorf("a group receiver can not fetch messages, but since was given")
+		}
+		if len(args) > 2 {
+			return nil, fmt.Errorf("command accepts at most a path and a maxCount with since, but was %q", cmd.Arg)
+		}
+		if rec.startTime, err = protocol.ParseSince(since); err != nil {
+			return nil, err
+		}
+		rec.doFetch = true
+		// the maxCount follows the path, instead of the startid
+		args = append([]string{args[0], "0"}, args[1:]...)
+		delete(options, "since")
+	}
+
+	if rec.slowConsumer, err = parseSlowConsumerOptions(options); err != nil {
+		return nil, err
+	}
+
 	if len(args) > 1 {
 		rec.doFetch = true
 		rec.startID, err = strconv.ParseInt(args[1], 10, 64)
@@ -85,6 +133,44 @@ func NewReceiverFromCmd(
 	return rec, nil
 }
 
+// splitOptions separates the `name=value` options from the positional arguments following the path
+func splitOptions(fields []string) (args []string, options map[string]string) {
+	options = make(map[string]string)
+	for i, field := range fields {
+		if nameValue := strings.SplitN(field, "=", 2); i > 0 && len(nameValue) == 2 {
+			options[nameValue[0]] = nameValue[1]
+		} else {
+			args = append(args, field)
+		}
+	}
+	return
+}
+
+// splitFilterOption separates the `filter` option from the other arguments.
+// The filter expression can contain spaces, so it has to be the last option of the command.
+func splitFilterOption(arg string) (string, string, bool) {
+	i := strings.Index(arg, " filter=")
+	if i < 0 {
+		return arg, "", false
+	}
+	return arg[:i], strings.TrimSpace(arg[i+len(" filter="):]), true
+}
+
+// parseFilterOption returns the filter expression of the route, as given in the `filter` option
+func parseFilterOption(filter string) (*router.FilterExpression, error) {
+	return router.ParseFilterExpression(filter)
+}
+
+// parseSlowConsumerOptions returns the slow consumer configuration of the route from the `policy` and `deadline` options
+func parseSlowConsumerOptions(options map[string]string) (router.SlowConsumerConfig, error) {
+	for name := range options {
+		if name != "policy" && name != "deadline" {
+			return router.SlowConsumerConfig{}, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	return router.ParseSlowConsumerConfig(options["policy"], options["deadline"])
+}
+
 // Start starts the receiver loop
 func (rec *Receiver) Start() error {
 	rec.shouldStop = false
@@ -106,13 +192,17 @@ func (rec *Receiver) subscriptionLoop() {
 				return
 			}
 
-			if err := rec.messageStore.DoInTx(rec.path.Partition(), rec.subscribeIfNoUnreadMessagesAvailable); err != nil {
+			if rec.shouldStop {
+				return
+			}
+
+			if err := rec.doInTx(rec.subscribeIfNoUnreadMessagesAvailable); err != nil {
 				if err == errUnreadMsgsAvailable {
 					logger.WithFields(log.Fields{
 						"lastSentId": rec.lastSentID,
 						"receiver":   rec,
 					}).Error("errUnreadMsgsAvailable")
-					rec.startID = int64(rec.lastSentID) + 1
+					rec.continueAfterLastSent()
 					continue // fetch again
 				} else {
 					logger.WithError(err).WithField("recStartId", rec.startID).
@@ -130,26 +220,107 @@ func (rec *Receiver) subscriptionLoop() {
 			//fmt.Printf(" router closed .. on msg: %v\n", rec.lastSendId)
 			// the router kicked us out, because we are too slow for realtime listening,
 			// so we setup parameters for fetching and closing the gap. Than we can subscribe again.
-			rec.startID = int64(rec.lastSentID) + 1
+			// Wildcard paths continue from the last sent id of each partition.
+			rec.continueAfterLastSent()
 			rec.doFetch = true
 		}
 	}
 }
 
-func (rec *Receiver) subscribeIfNoUnreadMessagesAvailable(maxMessageID uint64) error {
-	if maxMessageID > rec.lastSentID {
-		return errUnreadMsgsAvailable
+// continueAfterLastSent sets the start of the next fetch after the last message sent to the client.
+// A receiver fetching since a time keeps it until a message was sent.
+func (rec *Receiver) continueAfterLastSent() {
+	if rec.path.HasWildcard() || (rec.startTime > 0 && rec.lastSentID == 0) {
+		return
+	}
+	rec.startID = int64(rec.lastSentID) + 1
+	rec.startTime = 0
+}
+
+// doInTx executes the supplied function within the locking context of the partition of the receiver path,
+// passing its max message id.
+// A wildcard path can match all the partitions, so their max message ids are read one at a time,
+// each under the lock of its partition, and the function is executed without locking them:
+// the publishing is not blocked in all the partitions while subscribing. A message stored in the meantime
+// may be missed by the subscription, until the receiver fetches again from its last sent ids.
+func (rec *Receiver) doInTx(fnToExecute func(maxMessageIDs map[string]uint64) error) error {
+	if !rec.path.HasWildcard() {
+		partition := rec.path.Partition()
+		return rec.messageStore.DoInTx(partition, func(maxMessageID uint64) error {
+			return fnToExecute(map[string]uint64{partition: maxMessageID})
+		})
+	}
+
+	partitions, err := rec.partitions()
+	if err != nil {
+		return err
+	}
+	maxMessageIDs := make(map[string]uint64, len(partitions))
+	for _, partition := range partitions {
+		maxMessageID, err := rec.messageStore.MaxMessageID(partition)
+		if err != nil {
+			return err
+		}
+		maxMessageIDs[partition] = maxMessageID
+	}
+	return fnToExecute(maxMessageIDs)
+}
+
+// partitions returns the sorted names of the partitions which can contain topics matching the receiver path
+func (rec *Receiver) partitions() ([]string, error) {
+	if !rec.path.HasWildcard() {
+		return []string{rec.path.Partition()}, nil
+	}
+
+	storePartitions, err := rec.messageStore.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]string, 0, len(storePartitions))
+	for _, p := range storePartitions {
+		if rec.path.MatchesPartition(p.Name()) {
+			partitions = append(partitions, p.Name())
+		}
+	}
+	sort.Strings(partitions)
+	return partitions, nil
+}
+
+func (rec *Receiver) subscribeIfNoUnreadMessagesAvailable(maxMessageIDs map[string]uint64) error {
+	for partition, maxMessageID := range maxMessageIDs {
+		if maxMessageID > rec.lastID(partition) {
+			return errUnreadMsgsAvailable
+		}
 	}
 	rec.subscribe()
 	return nil
 }
 
+// lastID returns the id of the last message sent to the client from the partition
+func (rec *Receiver) lastID(partition string) uint64 {
+	if rec.path.HasWildcard() {
+		return rec.lastSentIDs[partition]
+	}
+	return rec.lastSentID
+}
+
+func (rec *Receiver) setLastID(partition string, id uint64) {
+	if rec.path.HasWildcard() {
+		rec.lastSentIDs[partition] = id
+		return
+	}
+	rec.lastSentID = id
+}
+
 func (rec *Receiver) subscribe() {
 	rec.route = router.NewRoute(
 		router.RouteConfig{
-			RouteParams: router.RouteParams{"application_id": rec.applicationID, "user_id": rec.userID},
-			Path:        rec.path,
-			ChannelSize: 10,
+			RouteParams:        router.RouteParams{"application_id": rec.applicationID, "user_id": rec.userID},
+			Path:               rec.path,
+			ChannelSize:        10,
+			SlowConsumerConfig: rec.slowConsumer,
+			FilterExpression:   rec.filter,
+			Group:              rec.group,
 		},
 	)
 
@@ -178,8 +349,8 @@ func (rec *Receiver) receiveFromSubscription() {
 				"messageMetadata": m.Metadata(),
 			}).Debug("Delivering message")
 
-			if m.ID > rec.lastSentID {
-				rec.lastSentID = m.ID
+			if partition := m.Path.Partition(); m.ID > rec.lastID(partition) {
+				rec.setLastID(partition, m.ID)
 				rec.sendC <- m.Bytes()
 			} else {
 				logger.WithFields(log.Fields{
@@ -204,31 +375,71 @@ func (rec *Receiver) fetchOnlyLoop() {
 	}
 }
 
+// fetch fetches the messages from the partition of the receiver path,
+// or from all the matching partitions if the path contains wildcards.
 func (rec *Receiver) fetch() error {
+	if !rec.path.HasWildcard() {
+		return rec.fetchPartition(rec.path.Partition(), rec.startID, rec.startTime)
+	}
+
+	partitions, err := rec.partitions()
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		// the max id is read before fetching, so that already existing messages skipped
+		// by the fetch will not be reported as unread when subscribing
+		maxID, err := rec.messageStore.MaxMessageID(partition)
+		if err != nil {
+			return err
+		}
+
+		startID, startTime := rec.startID, rec.startTime
+		if lastID, ok := rec.lastSentIDs[partition]; ok {
+			startID, startTime = int64(lastID)+1, 0
+		}
+		if err := rec.fetchPartition(partition, startID, startTime); err != nil {
+			return err
+		}
+		if rec.shouldStop {
+			return nil
+		}
+
+		if maxID > rec.lastSentIDs[partition] {
+			rec.lastSentIDs[partition] = maxID
+		}
+	}
+	return nil
+}
+
+// fetchPartition fetches the messages of the partition from the startID,
+// or from the first message published since the startTime, if it is set
+func (rec *Receiver) fetchPartition(partition string, startID int64, startTime int64) error {
 	fetch := &store.FetchRequest{
-		Partition: rec.path.Partition(),
+		Partition: partition,
+		StartTime: startTime,
 		MessageC:  make(chan *store.FetchedMessage, 10), //TODO MAKE more tests when the receiver will be refactored after the route params is integrated.Initial capacity was 3
 		ErrorC:    make(chan error),
 		StartC:    make(chan int),
 		Count:     rec.maxCount,
 	}
 
-	if rec.startID >= 0 {
+	if startID >= 0 {
 		fetch.Direction = 1
-		fetch.StartID = uint64(rec.startID)
+		fetch.StartID = uint64(startID)
 		if rec.maxCount == 0 {
 			fetch.Count = math.MaxInt32
 		}
 	} else {
 		fetch.Direction = -1
-		maxID, err := rec.messageStore.MaxMessageID(rec.path.Partition())
+		maxID, err := rec.messageStore.MaxMessageID(partition)
 		if err != nil {
 			return err
 		}
 
 		fetch.StartID = maxID
 		if rec.maxCount == 0 {
-			fetch.Count = -1 * int(rec.startID)
+			fetch.Count = -1 * int(startID)
 		}
 	}
 
@@ -249,7 +460,10 @@ func (rec *Receiver) fetch() error {
 				"lastSendId": rec.lastSentID,
 			}).Info("Reply sent")
 
-			rec.lastSentID = msgAndID.ID
+			rec.setLastID(partition, msgAndID.ID)
+			if !rec.shouldSend(msgAndID.Message) {
+				continue
+			}
 			rec.sendC <- msgAndID.Message
 		case err := <-fetch.ErrorC:
 			return err
@@ -262,6 +476,24 @@ func (rec *Receiver) fetch() error {
 	}
 }
 
+// shouldSend returns false if the serialized message is expired, if its filters are not matched by the
+// filter expression of the receiver, or if its topic is not matched by a receiver path containing wildcards
+func (rec *Receiver) shouldSend(data []byte) bool {
+	m, err := protocol.ParseMessage(data)
+	if err != nil {
+		logger.WithError(err).Error("Error parsing fetched message")
+		return !rec.path.HasWildcard() && rec.filter == nil
+	}
+	if m.IsExpired() {
+		mTotalExpiredMessages.Add(1)
+		return false
+	}
+	if rec.filter != nil && !rec.filter.Matches(m.Filters) {
+		return false
+	}
+	return !rec.path.HasWildcard() || rec.path.Matches(m.Path)
+}
+
 // Stop stops/cancels the receiver
 func (rec *Receiver) Stop() error {
 	rec.cancelC <- true
@@ -4,13 +4,16 @@ import (
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/filestore"
 	"github.com/smancke/guble/testutil"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
 	"errors"
+	"io/ioutil"
 	"math"
+	"os"
 	"testing"
 	"time"
 )
@@ -21,7 +24,10 @@ func Test_Receiver_error_handling_on_create(t *testing.T) {
 
 	a := assert.New(t)
 
-	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20", "/foo a", "/foo 20 b"}
+	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20", "/foo a", "/foo 20 b",
+		"/foo policy=unknown", "/foo policy=block deadline=b", "/foo 20 unknown=option",
+		"/foo filter=symbol in", "/foo 0 filter=", "/foo group=", "/foo 0 group=workers",
+		"/foo since=yesterday", "/foo since=1420110000 20 20", "/foo group=workers since=1420110000"}
 	for _, arg := range badArgs {
 		rec, _, _, _, err := aMockedReceiver(arg)
 		a.Nil(rec, "Testing with: "+arg)
@@ -29,6 +35,80 @@ func Test_Receiver_error_handling_on_create(t *testing.T) {
 	}
 }
 
+func Test_Receiver_SlowConsumerOptions(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, _, routerMock, _, err := aMockedReceiver("/foo policy=block 0 deadline=200ms")
+	a.NoError(err)
+	a.Equal(protocol.Path("/foo"), rec.path)
+	a.True(rec.doFetch)
+	a.True(rec.doSubscription)
+	a.Equal(int64(0), rec.startID)
+
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal(router.PolicyBlock, r.Policy)
+		a.Equal(200*time.Millisecond, r.Deadline)
+	})
+	rec.sendC = make(chan []byte, 1)
+	rec.subscribe()
+
+	rec, _, _, _, err = aMockedReceiver("/foo policy=drop-newest")
+	a.NoError(err)
+	a.False(rec.doFetch)
+	a.Equal(router.SlowConsumerConfig{Policy: router.PolicyDropNewest}, rec.slowConsumer)
+}
+
+func Test_Receiver_GroupOption(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, _, routerMock, _, err := aMockedReceiver("/orders group=workers policy=drop-newest")
+	a.NoError(err)
+	a.Equal(protocol.Path("/orders"), rec.path)
+	a.Equal("workers", rec.group)
+	a.False(rec.doFetch)
+	a.Equal(router.PolicyDropNewest, rec.slowConsumer.Policy)
+
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal("workers", r.Group)
+	})
+	rec.sendC = make(chan []byte, 1)
+	rec.subscribe()
+}
+
+func Test_Receiver_FilterOption(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, _, routerMock, _, err := aMockedReceiver("/prices 0 policy=drop-oldest filter=symbol in (A, B) and change > 2")
+	a.NoError(err)
+	a.Equal(protocol.Path("/prices"), rec.path)
+	a.True(rec.doFetch)
+	a.Equal(router.PolicyDropOldest, rec.slowConsumer.Policy)
+	if a.NotNil(rec.filter) {
+		a.Equal("symbol in (A, B) and change > 2", rec.filter.String())
+	}
+
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal(rec.filter, r.FilterExpression)
+	})
+	rec.sendC = make(chan []byte, 1)
+	rec.subscribe()
+
+	// fetched messages are filtered too
+	matching := &protocol.Message{ID: 1, Path: "/prices", Filters: map[string]string{"symbol": "A", "change": "3"}}
+	notMatching := &protocol.Message{ID: 2, Path: "/prices", Filters: map[string]string{"symbol": "C", "change": "3"}}
+	a.True(rec.shouldSend(matching.Bytes()))
+	a.False(rec.shouldSend(notMatching.Bytes()))
+}
+
 func Test_Receiver_Fetch_Subscribe_Fetch_Subscribe(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -189,6 +269,41 @@ func Test_Receiver_Fetch_Returns_Correct_Messages(t *testing.T) {
 	ctrl.Finish()
 }
 
+func Test_Receiver_Fetch_SkipsExpiredMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/foo 0")
+	a.NoError(err)
+
+	expired := &protocol.Message{ID: 1, Path: "/foo", Body: []byte("expired"), Expires: time.Now().Add(-time.Minute).Unix()}
+	valid := &protocol.Message{ID: 2, Path: "/foo", Body: []byte("valid"), Expires: time.Now().Add(time.Minute).Unix()}
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- 2
+			r.MessageC <- &store.FetchedMessage{ID: expired.ID, Message: expired.Bytes()}
+			r.MessageC <- &store.FetchedMessage{ID: valid.ID, Message: valid.Bytes()}
+			close(r.MessageC)
+		}()
+	})
+
+	fetchHasTerminated := make(chan bool)
+	go func() {
+		rec.fetchOnlyLoop()
+		fetchHasTerminated <- true
+	}()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_FETCH_START+" /foo 2",
+		string(valid.Bytes()),
+		"#"+protocol.SUCCESS_FETCH_END+" /foo",
+	)
+	testutil.ExpectDone(a, fetchHasTerminated)
+	a.Equal(uint64(2), rec.lastSentID)
+}
+
 func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -221,6 +336,16 @@ func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 			maxID:  42,
 			expect: store.FetchRequest{Partition: "foo", Direction: -1, StartID: uint64(42), Count: 10},
 		},
+		{desc: "forward fetch since a date with count",
+			arg:    "/foo since=2015-01-01T11:00:00Z 20",
+			maxID:  -1,
+			expect: store.FetchRequest{Partition: "foo", Direction: 1, StartTime: 1420110000, Count: 20},
+		},
+		{desc: "forward fetch since a timestamp",
+			arg:    "/foo since=1420110000",
+			maxID:  -1,
+			expect: store.FetchRequest{Partition: "foo", Direction: 1, StartTime: 1420110000, Count: math.MaxInt32},
+		},
 	}
 
 	for _, test := range testcases {
@@ -239,6 +364,7 @@ func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 			a.Equal(test.expect.Partition, r.Partition, test.desc)
 			a.Equal(test.expect.Direction, r.Direction, test.desc)
 			a.Equal(test.expect.StartID, r.StartID, test.desc)
+			a.Equal(test.expect.StartTime, r.StartTime, test.desc)
 			a.Equal(test.expect.Count, r.Count, test.desc)
 			done <- true
 		})
@@ -249,6 +375,131 @@ func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 	}
 }
 
+func Test_Receiver_continueAfterLastSent(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, _, _, _, err := aMockedReceiver("/foo since=1420110000")
+	a.NoError(err)
+	a.True(rec.doFetch)
+	a.True(rec.doSubscription)
+
+	// the start time is kept until a message was sent
+	rec.continueAfterLastSent()
+	a.Equal(int64(1420110000), rec.startTime)
+
+	rec.lastSentID = 42
+	rec.continueAfterLastSent()
+	a.Equal(int64(0), rec.startTime)
+	a.Equal(int64(43), rec.startID)
+}
+
+func Test_Receiver_Fetch_With_Wildcard(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_receiver_test")
+	defer os.RemoveAll(dir)
+	fs := filestore.New(dir)
+	defer fs.Stop()
+
+	var partitions []store.MessagePartition
+	for _, name := range []string{"orders", "invoices", "other"} {
+		p, err := fs.Partition(name)
+		a.NoError(err)
+		partitions = append(partitions, p)
+	}
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/*/shipped 0 10")
+	a.NoError(err)
+
+	messages := map[string][]string{
+		"invoices": {
+			"/invoices/shipped,1,,,,1405544146,0",
+			"/invoices/created,2,,,,1405544146,0",
+		},
+		"orders": {
+			"/orders/created,1,,,,1405544146,0",
+			"/orders/shipped,2,,,,1405544146,0",
+		},
+		"other": {},
+	}
+
+	messageStore.EXPECT().Partitions().Return(partitions, nil)
+	messageStore.EXPECT().MaxMessageID(gomock.Any()).Return(uint64(2), nil).Times(3)
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		a.Equal(store.DirectionForward, r.Direction)
+		a.Equal(uint64(0), r.StartID)
+		a.Equal(10, r.Count)
+		go func() {
+			r.StartC <- len(messages[r.Partition])
+			for i, m := range messages[r.Partition] {
+				r.MessageC <- &store.FetchedMessage{ID: uint64(i + 1), Message: []byte(m)}
+			}
+			close(r.MessageC)
+		}()
+	}).Times(3)
+
+	fetchHasTerminated := make(chan bool)
+	go func() {
+		rec.fetchOnlyLoop()
+		fetchHasTerminated <- true
+	}()
+
+	// partitions are fetched in alphabetical order, skipping the topics which are not matching
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_FETCH_START+" /*/shipped 2",
+		"/invoices/shipped,1,,,,1405544146,0",
+		"#"+protocol.SUCCESS_FETCH_END+" /*/shipped",
+		"#"+protocol.SUCCESS_FETCH_START+" /*/shipped 2",
+		"/orders/shipped,2,,,,1405544146,0",
+		"#"+protocol.SUCCESS_FETCH_END+" /*/shipped",
+		"#"+protocol.SUCCESS_FETCH_START+" /*/shipped 0",
+		"#"+protocol.SUCCESS_FETCH_END+" /*/shipped",
+	)
+	testutil.ExpectDone(a, fetchHasTerminated)
+
+	a.Equal(uint64(2), rec.lastSentIDs["invoices"])
+	a.Equal(uint64(2), rec.lastSentIDs["orders"])
+}
+
+func Test_Receiver_doInTx_With_Wildcard(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_receiver_test")
+	defer os.RemoveAll(dir)
+	fs := filestore.New(dir)
+	defer fs.Stop()
+
+	var partitions []store.MessagePartition
+	for _, name := range []string{"orders", "invoices"} {
+		p, err := fs.Partition(name)
+		a.NoError(err)
+		partitions = append(partitions, p)
+	}
+
+	rec, _, _, messageStore, err := aMockedReceiver("/*/shipped")
+	a.NoError(err)
+
+	// the max ids of the partitions are read one at a time, without locking all the partitions
+	messageStore.EXPECT().Partitions().Return(partitions, nil)
+	messageStore.EXPECT().MaxMessageID("invoices").Return(uint64(3), nil)
+	messageStore.EXPECT().MaxMessageID("orders").Return(uint64(5), nil)
+
+	err = rec.doInTx(func(maxMessageIDs map[string]uint64) error {
+		a.Equal(map[string]uint64{"invoices": 3, "orders": 5}, maxMessageIDs)
+		return nil
+	})
+	a.NoError(err)
+}
+
 func Test_Receiver_Fetch_Sends_error_on_failure(t *testing.T) {
 	a := assert.New(t)
 
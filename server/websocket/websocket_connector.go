@@ -9,12 +9,17 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/rs/xid"
 
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// idempotencyKeyHeader is the field of the send command header holding the idempotency key of the message
+const idempotencyKeyHeader = "Idempotency-Key"
+
 var webSocketUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -234,12 +239,12 @@ func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 		"cmd": string(cmd.Bytes()),
 	}).Debug("Sending ")
 
-	if len(cmd.Arg) == 0 {
+	args, options := splitOptions(strings.Fields(cmd.Arg))
+	if len(args) == 0 {
 		ws.sendError(protocol.ERROR_BAD_REQUEST, "send command requires a path argument, but none given")
 		return
 	}
 
-	args := strings.SplitN(cmd.Arg, " ", 2)
 	msg := &protocol.Message{
 		Path:          protocol.Path(args[0]),
 		ApplicationID: ws.applicationID,
@@ -248,9 +253,110 @@ func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 		Body:          cmd.Body,
 	}
 
-	ws.router.HandleMessage(msg)
+	var ttl time.Duration
+	for name, value := range options {
+		switch name {
+		case "ttl":
+			var err error
+			if ttl, err = protocol.ParseTTL(value); err != nil {
+				ws.sendError(protocol.ERROR_BAD_REQUEST, "%v", err)
+				return
+			}
+		case "deliver-at":
+			deliverAt, err := protocol.ParseDeliverAt(value)
+			if err != nil {
+				ws.sendError(protocol.ERROR_BAD_REQUEST, "%v", err)
+				return
+			}
+			msg.DeliverAt = deliverAt
+		case "retain":
+			retain, err := strconv.ParseBool(value)
+			if err != nil {
+				ws.sendError(protocol.ERROR_BAD_REQUEST, "retain has to be true or false, but was %q", value)
+				return
+			}
+			msg.Retain = retain
+		case "compaction-key":
+			if err := protocol.ValidateCompactionKey(value); err != nil {
+				ws.sendError(protocol.ERROR_BAD_REQUEST, "%v", err)
+				return
+			}
+			msg.CompactionKey = value
+		default:
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "unknown option %q", name)
+			return
+		}
+	}
+
+	// the time-to-live of a scheduled message starts at its delivery time, so it is set after the options
+	msg.SetTTL(ttl)
+
+	if key := idempotencyKey(cmd.HeaderJSON); key != "" {
+		if err := protocol.ValidateIdempotencyKey(key); err != nil {
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "%v", err)
+			return
+		}
+		msg.IdempotencyKey = key
+	}
+
+	if err := ws.router.HandleMessage(msg); err != nil {
+		logger.WithError(err).WithField("path", msg.Path).Error("Client error in handleSendCmd")
+		name := protocol.ERROR_SEND
+		if err == router.ErrRateLimitExceeded {
+			name = protocol.ERROR_RATE_LIMITED
+		}
+		if len(args) > 1 {
+			ws.sendError(name, "%s %v", args[1], err)
+		} else {
+			ws.sendError(name, "%v", err)
+		}
+		return
+	}
 
-	ws.sendOK(protocol.SUCCESS_SEND, "")
+	if msg.IdempotencyKey == "" {
+		ws.sendOK(protocol.SUCCESS_SEND, "")
+		return
+	}
+	ws.sendReceipt(msg, args[1:])
+}
+
+// sendReceipt confirms a message published with an idempotency key, with the id of the stored message.
+// For a duplicate message, it is the id of the message published first.
+// A scheduled message has no id until it is delivered, so its receipt has no sequence id and publishing time.
+func (ws *WebSocket) sendReceipt(msg *protocol.Message, args []string) {
+	publisherMessageID := ""
+	if len(args) > 0 {
+		publisherMessageID = args[0]
+	}
+	fields := map[string]interface{}{
+		"path":               msg.Path,
+		"publisherMessageId": publisherMessageID,
+	}
+	if msg.ID != 0 {
+		fields["sequenceId"] = msg.ID
+		fields["messagePublishingTime"] = msg.Time
+	}
+	receipt, err := json.Marshal(fields)
+	if err != nil {
+		logger.WithError(err).Error("Error encoding send receipt")
+		return
+	}
+	n := &protocol.NotificationMessage{
+		Name: protocol.SUCCESS_SEND,
+		Arg:  publisherMessageID,
+		Json: string(receipt),
+	}
+	ws.sendChannel <- n.Bytes()
+}
+
+// idempotencyKey returns the idempotency key of a send command header, or an empty string
+func idempotencyKey(headerJSON string) string {
+	header := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(headerJSON), &header); err != nil {
+		return ""
+	}
+	key, _ := header[idempotencyKeyHeader].(string)
+	return key
 }
 
 func (ws *WebSocket) cleanAndClose() {
@@ -74,6 +74,113 @@ func Test_SendMessage(t *testing.T) {
 	runNewWebSocket(wsconn, routerMock, messageStore, nil)
 }
 
+func Test_SendMessageWithTTL(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	commands := []string{"> /path 42 ttl=60 deliver-at=2030-01-01T00:00:00Z\n\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Do(func(msg *protocol.Message) error {
+			// the time-to-live of the scheduled message starts at its delivery time
+			a.Equal(int64(1893456000+60), msg.Expires)
+			a.Equal(int64(1893456000), msg.DeliverAt)
+			return nil
+		})
+	wsconn.EXPECT().Send([]byte("#send"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func Test_SendRetainedMessage(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	commands := []string{"> /device/123/state retain=true compaction-key=device-123\n\non"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/device/123/state", message: "on"}).
+		Do(func(msg *protocol.Message) error {
+			a.True(msg.Retain)
+			a.Equal("device-123", msg.CompactionKey)
+			return nil
+		})
+	wsconn.EXPECT().Send([]byte("#send"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func Test_SendMessageRejected(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path 42\n\nHello, this is a test", "> /path\n\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Return(&router.MessageRejectedError{Reason: "spam"}).Times(2)
+	wsconn.EXPECT().Send([]byte("!error-send 42 Message rejected: spam"))
+	wsconn.EXPECT().Send([]byte("!error-send Message rejected: spam"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func Test_SendMessageRateLimited(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path 42\n\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Return(router.ErrRateLimitExceeded)
+	wsconn.EXPECT().Send([]byte("!error-rate-limited 42 Publish rate limit exceeded."))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func Test_SendMessageWithIdempotencyKey(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path 42\n{\"Idempotency-Key\": \"order-42\"}\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Do(func(msg *protocol.Message) error {
+			assert.Equal(t, "order-42", msg.IdempotencyKey)
+			msg.ID, msg.Time = 17, 1420110000
+			return nil
+		})
+	wsconn.EXPECT().Send([]byte("#send 42\n" +
+		`{"messagePublishingTime":1420110000,"path":"/path","publisherMessageId":"42","sequenceId":17}`))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func Test_SendScheduledMessageWithIdempotencyKey(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path 42\n{\"Idempotency-Key\": \"order-42\"}\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	// the scheduled message has no id until it is delivered
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Return(nil)
+	wsconn.EXPECT().Send([]byte("#send 42\n" +
+		`{"path":"/path","publisherMessageId":"42"}`))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+	time.Sleep(10 * time.Millisecond)
+}
+
 func Test_AnIncomingMessageIsDelivered(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -125,7 +232,7 @@ func Test_BadCommands(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
 
-	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo"}
+	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo", "> /foo ttl=soon", "> /foo deliver-at=tomorrow", "> /foo retain=maybe", "> /foo 42 color=red"}
 	wsconn, routerMock, messageStore := createDefaultMocks(badRequests)
 
 	counter := 0
@@ -0,0 +1,10 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                    = metrics.NS("websocket")
+	mTotalExpiredMessages = ns.NewInt("total_expired_messages")
+)